@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/copier"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/export"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// stringList collects a repeatable flag
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ", ") }
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "Path to configuration file")
+	sourceConnection := flag.String("source-connection", "", "Source connection ID (default: the first one)")
+	sourceVHost := flag.String("source-vhost", "", "Source vhost (default: the connection's vhost)")
+	sourceStream := flag.String("source-stream", "", "Stream to copy from")
+	targetConnection := flag.String("target-connection", "", "Target connection ID (default: the source connection)")
+	targetVHost := flag.String("target-vhost", "", "Target vhost (default: the connection's vhost)")
+	targetStream := flag.String("target-stream", "", "Stream to copy to (default: the source stream name)")
+	offset := flag.Uint64("offset", 0, "First source offset to copy")
+	endOffset := flag.Int64("end-offset", -1, "Last source offset to copy (-1 = end of stream)")
+	from := flag.String("from", "", "Only copy messages stored at or after this RFC3339 time")
+	to := flag.String("to", "", "Only copy messages stored before this RFC3339 time")
+	checkpointPath := flag.String("checkpoint", "", "Checkpoint file; an existing checkpoint is resumed")
+	producerName := flag.String("producer-name", "", "Producer name for deduplication on the target (default: generated and stored in the checkpoint)")
+	var filters, transforms stringList
+	flag.Var(&filters, "filter", "Only copy matching messages: <field>=<value>, <field>!=<value> or <field>~<text> (repeatable)")
+	flag.Var(&transforms, "transform", "Modify copied messages: <field>=<value> sets app.<key>, annotations.<key> or properties.<name>, -<field> removes it (repeatable)")
+	flag.Parse()
+
+	if *sourceStream == "" {
+		log.Fatal("-source-stream is required")
+	}
+	if *targetStream == "" {
+		*targetStream = *sourceStream
+	}
+	if *targetConnection == "" {
+		*targetConnection = *sourceConnection
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	source, err := endpoint(cfg, *sourceConnection, *sourceVHost, *sourceStream)
+	if err != nil {
+		log.Fatal(err)
+	}
+	target, err := endpoint(cfg, *targetConnection, *targetVHost, *targetStream)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if source.String() == target.String() {
+		log.Fatal("Source and target are the same stream")
+	}
+
+	opts := copier.Options{Offset: *offset, ProducerName: *producerName}
+	if *endOffset >= 0 {
+		end := uint64(*endOffset)
+		opts.EndOffset = &end
+	}
+	if opts.Filters, err = export.ParseFilters(filters); err != nil {
+		log.Fatalf("Invalid -filter: %v", err)
+	}
+	if opts.Transforms, err = copier.ParseTransforms(transforms); err != nil {
+		log.Fatalf("Invalid -transform: %v", err)
+	}
+	if opts.From, err = parseTime(*from); err != nil {
+		log.Fatalf("Invalid -from: %v", err)
+	}
+	if opts.To, err = parseTime(*to); err != nil {
+		log.Fatalf("Invalid -to: %v", err)
+	}
+
+	if *checkpointPath != "" {
+		if opts.Resume, err = copier.LoadCheckpoint(*checkpointPath); err != nil {
+			log.Fatal(err)
+		}
+		if opts.Resume != nil {
+			log.Printf("Resuming from offset %d (%d messages already copied)", opts.Resume.NextOffset, opts.Resume.Copied)
+		}
+		opts.Checkpoint = func(c copier.Checkpoint) error {
+			return c.Save(*checkpointPath)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	opts.Progress = func(p copier.Progress) {
+		fmt.Fprintf(os.Stderr, "\rscanned %d, copied %d, confirmed %d, at offset %d", p.Scanned, p.Copied, p.Confirmed, p.Offset)
+	}
+
+	result, err := copier.Run(ctx, source, target, opts)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		if *checkpointPath != "" {
+			log.Fatalf("Copy failed, re-run to resume from %s: %v", *checkpointPath, err)
+		}
+		log.Fatalf("Copy failed: %v", err)
+	}
+
+	log.Printf("Copied %d of %d scanned messages from %s to %s in %s (%s)",
+		result.Copied, result.Scanned, source, target, time.Since(start).Round(time.Millisecond), result.EndReason)
+}
+
+func endpoint(cfg *config.Config, connectionID, vhost, streamName string) (copier.Endpoint, error) {
+	connCfg, ok := cfg.FindConnection(connectionID)
+	if !ok {
+		return copier.Endpoint{}, fmt.Errorf("connection not found: %s", connectionID)
+	}
+	conn := rabbitmq.NewConnection(connCfg)
+	if vhost == "" {
+		vhost = conn.DefaultVHost()
+	}
+	return copier.Endpoint{Conn: conn, VHost: vhost, Stream: streamName}, nil
+}
+
+func parseTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/export"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// stringList collects a repeatable flag
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ", ") }
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "Path to configuration file")
+	connectionID := flag.String("connection", "", "Connection ID from the configuration file (default: the first one)")
+	vhost := flag.String("vhost", "", "VHost of the stream (default: the connection's vhost)")
+	streamName := flag.String("stream", "", "Stream to export")
+	format := flag.String("format", export.FormatNDJSON, "Output format: ndjson, csv or parquet")
+	columns := flag.String("columns", "", "Comma separated fields for csv and parquet, e.g. offset,properties.message_id,body.customer.id")
+	offset := flag.Uint64("offset", 0, "First offset to export")
+	endOffset := flag.Int64("end-offset", -1, "Last offset to export (-1 = end of stream)")
+	from := flag.String("from", "", "Only export messages stored at or after this RFC3339 time")
+	to := flag.String("to", "", "Only export messages stored before this RFC3339 time")
+	limit := flag.Int("limit", 0, "Maximum number of messages to export (0 = unlimited)")
+	output := flag.String("out", "", "Output file (default: stdout)")
+	var filters stringList
+	flag.Var(&filters, "filter", "Only export matching messages: <field>=<value>, <field>!=<value> or <field>~<text> (repeatable)")
+	flag.Parse()
+
+	if *streamName == "" {
+		log.Fatal("-stream is required")
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	connCfg, ok := cfg.FindConnection(*connectionID)
+	if !ok {
+		log.Fatalf("Connection not found: %s", *connectionID)
+	}
+	conn := rabbitmq.NewConnection(connCfg)
+	if *vhost == "" {
+		*vhost = conn.DefaultVHost()
+	}
+
+	cols, err := export.ParseColumns(*columns)
+	if err != nil {
+		log.Fatalf("Invalid -columns: %v", err)
+	}
+
+	opts := export.Options{Offset: *offset, Limit: *limit}
+	if *endOffset >= 0 {
+		end := uint64(*endOffset)
+		opts.EndOffset = &end
+	}
+	if opts.Filters, err = export.ParseFilters(filters); err != nil {
+		log.Fatalf("Invalid -filter: %v", err)
+	}
+	if opts.From, err = parseTime(*from); err != nil {
+		log.Fatalf("Invalid -from: %v", err)
+	}
+	if opts.To, err = parseTime(*to); err != nil {
+		log.Fatalf("Invalid -to: %v", err)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			log.Fatalf("Failed to create output file: %v", err)
+		}
+		defer out.Close()
+	}
+
+	writer, err := export.NewWriter(*format, out, cols)
+	if err != nil {
+		log.Fatalf("Failed to create writer: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Progress goes to stderr so stdout can be piped
+	start := time.Now()
+	opts.Progress = func(p export.Progress) {
+		fmt.Fprintf(os.Stderr, "\rscanned %d, exported %d, at offset %d", p.Scanned, p.Written, p.Offset)
+	}
+
+	result, err := export.Run(ctx, conn, *vhost, *streamName, opts, writer)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+
+	log.Printf("Exported %d of %d scanned messages from %s/%s in %s (%s)",
+		result.Written, result.Scanned, *vhost, *streamName, time.Since(start).Round(time.Millisecond), result.EndReason)
+}
+
+func parseTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
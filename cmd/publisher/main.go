@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -10,6 +11,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
 	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/stream"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
 )
 
 type Message struct {
@@ -32,40 +35,31 @@ func main() {
 	log.Printf("Connecting to %s:%d", *host, *port)
 	log.Printf("Publishing at %d msg/sec", *rate)
 
-	// Create environment
-	env, err := stream.NewEnvironment(
-		stream.NewEnvironmentOptions().
-			SetHost(*host).
-			SetPort(*port).
-			SetUser(*user).
-			SetPassword(*password).
-			SetVHost(*vhost),
-	)
+	// The test publisher always writes, whatever the connection's read_only default
+	readOnly := false
+	conn := rabbitmq.NewConnection(config.ConnectionConfig{
+		ID:         "publisher",
+		Host:       *host,
+		StreamPort: *port,
+		Username:   *user,
+		Password:   *password,
+		VHost:      *vhost,
+		ReadOnly:   &readOnly,
+	})
+
+	// Create the stream if it doesn't exist
+	created, err := conn.EnsureStream(*vhost, *streamName, &stream.StreamOptions{
+		MaxLengthBytes: stream.ByteCapacity{}.GB(2),
+	})
 	if err != nil {
-		log.Fatalf("Failed to create environment: %v", err)
+		log.Fatalf("Failed to create stream: %v", err)
 	}
-	defer env.Close()
-
-	// Check if stream exists, create if it doesn't
-	exists, err := env.StreamExists(*streamName)
-	if err != nil {
-		log.Fatalf("Failed to check stream existence: %v", err)
-	}
-
-	if !exists {
-		log.Printf("Creating stream '%s'", *streamName)
-		err = env.DeclareStream(*streamName,
-			&stream.StreamOptions{
-				MaxLengthBytes: stream.ByteCapacity{}.GB(2),
-			},
-		)
-		if err != nil {
-			log.Fatalf("Failed to create stream: %v", err)
-		}
+	if created {
+		log.Printf("Created stream '%s'", *streamName)
 	}
 
 	// Create producer
-	producer, err := env.NewProducer(*streamName, nil)
+	producer, err := conn.NewPublisher(*vhost, *streamName, rabbitmq.PublisherOptions{})
 	if err != nil {
 		log.Fatalf("Failed to create producer: %v", err)
 	}
@@ -99,7 +93,7 @@ func main() {
 		timestamp := time.Now()
 		subject := fmt.Sprintf("test-message-%d", counter)
 
-		amqpMsg := amqp.NewMessage(payload)
+		amqpMsg := &amqp.Message{Data: [][]byte{payload}}
 		amqpMsg.Properties = &amqp.MessageProperties{
 			MessageID:    &messageID,
 			ContentType:  contentType,
@@ -113,7 +107,7 @@ func main() {
 		}
 
 		// Send message
-		err = producer.Send(amqpMsg)
+		err = producer.Send(amqpMsg, -1)
 		if err != nil {
 			log.Printf("Failed to send message: %v", err)
 			continue
@@ -128,6 +122,13 @@ func main() {
 		}
 	}
 
+	// Wait for the broker to confirm what was sent
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := producer.Wait(ctx); err != nil {
+		log.Printf("Not all messages were confirmed: %v", err)
+	}
+
 	log.Println("Publisher stopped")
 }
 
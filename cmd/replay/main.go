@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/replay"
+)
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "Path to configuration file")
+	connectionID := flag.String("connection", "", "Target connection ID from the configuration file (default: the first one)")
+	vhost := flag.String("vhost", "", "VHost of the target stream (default: the connection's vhost)")
+	streamName := flag.String("stream", "", "Stream to publish to")
+	input := flag.String("in", "", "NDJSON export to replay (default: stdin)")
+	fromOffset := flag.Int64("from-offset", -1, "First source offset to replay (-1 = start of the export)")
+	toOffset := flag.Int64("to-offset", -1, "Last source offset to replay (-1 = end of the export)")
+	from := flag.String("from", "", "Only replay messages stored (or else created) at or after this RFC3339 time")
+	to := flag.String("to", "", "Only replay messages stored (or else created) before this RFC3339 time")
+	rate := flag.Float64("rate", 0, "Maximum messages per second (0 = unlimited)")
+	originalPace := flag.Bool("original-pace", false, "Reproduce the gaps between the original messages")
+	speed := flag.Float64("speed", 1, "Speed multiplier for -original-pace, e.g. 10 replays ten times faster")
+	producerName := flag.String("producer-name", "", "Producer name for deduplication; re-running with the same name skips already published messages")
+	flag.Parse()
+
+	if *streamName == "" {
+		log.Fatal("-stream is required")
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	connCfg, ok := cfg.FindConnection(*connectionID)
+	if !ok {
+		log.Fatalf("Connection not found: %s", *connectionID)
+	}
+	conn := rabbitmq.NewConnection(connCfg)
+	if *vhost == "" {
+		*vhost = conn.DefaultVHost()
+	}
+
+	opts := replay.Options{
+		Rate:         *rate,
+		OriginalPace: *originalPace,
+		Speed:        *speed,
+		ProducerName: *producerName,
+	}
+	if *fromOffset >= 0 {
+		offset := uint64(*fromOffset)
+		opts.FromOffset = &offset
+	}
+	if *toOffset >= 0 {
+		offset := uint64(*toOffset)
+		opts.ToOffset = &offset
+	}
+	if opts.From, err = parseTime(*from); err != nil {
+		log.Fatalf("Invalid -from: %v", err)
+	}
+	if opts.To, err = parseTime(*to); err != nil {
+		log.Fatalf("Invalid -to: %v", err)
+	}
+
+	var in io.Reader = os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			log.Fatalf("Failed to open input file: %v", err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	opts.Progress = func(p replay.Progress) {
+		fmt.Fprintf(os.Stderr, "\rread %d, published %d, skipped %d, at offset %d", p.Read, p.Published, p.Skipped, p.Offset)
+	}
+
+	result, err := replay.Run(ctx, in, conn, *vhost, *streamName, opts)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		log.Fatalf("Replay failed: %v", err)
+	}
+
+	log.Printf("Replayed %d messages into %s/%s in %s (%d confirmed, %d skipped as duplicates)",
+		result.Published, *vhost, *streamName, time.Since(start).Round(time.Millisecond), result.Confirmed, result.Skipped)
+}
+
+func parseTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -10,13 +10,22 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/alerts"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/audit"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
 	"github.com/zaeem.arshad/rmq-stream-viewer/internal/api"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/certs"
 	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/copier"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/metrics"
 	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/redact"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/stats"
 )
 
 //go:embed static
@@ -49,46 +58,191 @@ func main() {
 	// Create HTTP handler
 	handler := api.NewHandler(manager)
 
+	// Keep copy jobs across restarts when a file is configured
+	copies, err := copier.LoadJobs(cfg.Copies, manager.GetConnection)
+	if err != nil {
+		log.Fatalf("Failed to load copy jobs: %v", err)
+	}
+	handler.SetCopyJobs(copies)
+	if cfg.Copies.File != "" {
+		log.Printf("Keeping copy jobs in %s", cfg.Copies.File)
+	}
+
+	// Sample stream stats in the background when enabled
+	collectorCtx, stopCollector := context.WithCancel(context.Background())
+	collectorDone := make(chan struct{})
+	if cfg.Stats.Interval > 0 {
+		collector, err := stats.NewCollector(stats.NewManagerSource(manager), cfg.Stats)
+		if err != nil {
+			log.Fatalf("Failed to start stats collector: %v", err)
+		}
+		handler.SetStatsCollector(collector)
+		go func() {
+			defer close(collectorDone)
+			collector.Run(collectorCtx)
+		}()
+		log.Printf("Collecting stream stats every %s, keeping %s", cfg.Stats.Interval, cfg.Stats.Retention)
+	} else {
+		close(collectorDone)
+	}
+
+	// Require logins when any authentication method is configured
+	if cfg.Server.Auth.Enabled() {
+		authenticator, err := auth.New(cfg.Server.Auth)
+		if err != nil {
+			log.Fatalf("Failed to set up authentication: %v", err)
+		}
+		if cfg.Server.Auth.Broker != nil {
+			authenticator.SetBroker(manager)
+		}
+		handler.SetAuthenticator(authenticator)
+		log.Printf("Authentication enabled: %s", strings.Join(authenticator.Methods(), ", "))
+	} else {
+		log.Println("Warning: authentication is disabled, anyone who can reach the server can read messages")
+	}
+
+	// Mask sensitive message content for users without the unmask permission
+	if len(cfg.Redaction.Rules) > 0 {
+		redactor, err := redact.New(cfg.Redaction)
+		if err != nil {
+			log.Fatalf("Failed to set up redaction: %v", err)
+		}
+		handler.SetRedactor(redactor)
+		log.Printf("Redacting messages with %d rules", len(cfg.Redaction.Rules))
+	}
+
+	// Record reads, exports and changes in the audit log when a file is configured
+	auditCtx, stopAudit := context.WithCancel(context.Background())
+	auditDone := make(chan struct{})
+	if cfg.Audit.File != "" {
+		var open func() (audit.Sender, error)
+		if target := cfg.Audit.Stream; target != nil {
+			conn, err := manager.GetConnection(target.Connection)
+			if err != nil {
+				log.Fatalf("Failed to set up the audit stream: %v", err)
+			}
+			open = func() (audit.Sender, error) {
+				return conn.NewPublisher(target.VHost, target.Name, rabbitmq.PublisherOptions{})
+			}
+		}
+		auditLog, err := audit.NewLogger(cfg.Audit, open)
+		if err != nil {
+			log.Fatalf("Failed to open the audit log: %v", err)
+		}
+		defer auditLog.Close()
+		handler.SetAuditLog(auditLog)
+		go func() {
+			defer close(auditDone)
+			auditLog.Run(auditCtx)
+		}()
+		log.Printf("Writing the audit log to %s", cfg.Audit.File)
+	} else {
+		close(auditDone)
+	}
+
+	// Evaluate alert rules in the background when any are configured
+	alertsCtx, stopAlerts := context.WithCancel(context.Background())
+	alertsDone := make(chan struct{})
+	if len(cfg.Alerts.Rules) > 0 {
+		engine, err := alerts.NewEngine(alerts.NewManagerSource(manager), cfg.Alerts)
+		if err != nil {
+			log.Fatalf("Failed to start alerting: %v", err)
+		}
+		handler.SetAlertEngine(engine)
+		go func() {
+			defer close(alertsDone)
+			engine.Run(alertsCtx)
+		}()
+		log.Printf("Evaluating %d alert rules every %s", len(cfg.Alerts.Rules), cfg.Alerts.Interval)
+	} else {
+		close(alertsDone)
+	}
+
+	// Report stream gauges on /metrics, from the collector's samples when it runs
+	metrics.RegisterStreams(handler.StreamSnapshots, 20*time.Second)
+
 	// Setup router
 	router := mux.NewRouter()
 
-	// Register API routes
-	handler.RegisterRoutes(router)
+	// Register API routes, under the base path when one is configured
+	basePath := cfg.Server.BasePath
+	routes := router
+	if basePath != "" {
+		routes = router.PathPrefix(basePath).Subrouter()
+		log.Printf("Serving under %s", basePath)
+	}
+	handler.RegisterRoutes(routes)
 
 	// Serve static files (React frontend)
 	// Try serving from web/dist for development
+	var frontend fs.FS
 	if _, err := os.Stat("web/dist"); err == nil {
-		router.PathPrefix("/").Handler(http.FileServer(http.Dir("web/dist")))
+		frontend = os.DirFS("web/dist")
 		log.Println("Serving frontend from web/dist")
-	} else {
+	} else if staticFS, err := fs.Sub(staticFiles, "static"); err == nil {
 		// Try embedded files
-		staticFS, err := fs.Sub(staticFiles, "static")
-		if err == nil {
-			router.PathPrefix("/").Handler(http.FileServer(http.FS(staticFS)))
-			log.Println("Serving embedded frontend")
-		} else {
-			log.Println("Warning: No frontend files found. API-only mode.")
+		frontend = staticFS
+		log.Println("Serving embedded frontend")
+	} else {
+		log.Println("Warning: No frontend files found. API-only mode.")
+	}
+	if frontend != nil {
+		files := http.StripPrefix(basePath, api.FrontendHandler(frontend))
+		if basePath != "" {
+			router.Path(basePath).Handler(files)
 		}
+		router.PathPrefix(basePath + "/").Handler(files)
 	}
 
 	// Apply middleware
 	router.Use(api.LoggingMiddleware)
-	router.Use(api.CORSMiddleware)
+	router.Use(api.MetricsMiddleware)
+
+	// CORS wraps the whole router so that preflights reach it whatever the
+	// methods of the route
+	var root http.Handler = router
+	root = api.CORSMiddleware(cfg.Server.CORS)(root)
+	root = api.SecurityHeadersMiddleware(cfg.Server.Headers)(root)
+	root = api.ForwardedMiddleware(cfg.Server)(root)
 
 	// Create server
 	addr := fmt.Sprintf(":%d", cfg.Server.Port)
 	srv := &http.Server{
 		Addr:         addr,
-		Handler:      router,
+		Handler:      root,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Serve HTTPS when configured, reloading the certificate when it changes
+	tlsCtx, stopTLS := context.WithCancel(context.Background())
+	tlsDone := make(chan struct{})
+	if cfg.Server.TLS != nil {
+		reloader, err := certs.NewReloader(*cfg.Server.TLS)
+		if err != nil {
+			log.Fatalf("Failed to set up TLS: %v", err)
+		}
+		srv.TLSConfig = reloader.TLSConfig()
+		go func() {
+			defer close(tlsDone)
+			reloader.Run(tlsCtx)
+		}()
+	} else {
+		close(tlsDone)
+	}
+
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Starting server on %s", addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if srv.TLSConfig != nil {
+			log.Printf("Starting server on %s with TLS (client certificates: %s)", addr, cfg.Server.TLS.ClientAuth)
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("Starting server on %s", addr)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
@@ -108,6 +262,16 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	// Let the collector save its samples
+	stopCollector()
+	<-collectorDone
+	stopAlerts()
+	<-alertsDone
+	stopAudit()
+	<-auditDone
+	stopTLS()
+	<-tlsDone
+
 	log.Println("Server stopped")
 }
 
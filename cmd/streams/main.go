@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/plan"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+const usage = `Usage: streams <plan|apply> [flags]
+
+  plan   show the changes needed to match the stream definitions
+  apply  make those changes
+
+Flags:
+`
+
+func main() {
+	flags := flag.NewFlagSet("streams", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	configPath := flags.String("config", "config.yaml", "Path to configuration file")
+	connectionID := flags.String("connection", "", "Connection ID for definitions without one (default: the first one)")
+	definitions := flags.String("f", "streams.yaml", "Path to the stream definitions file")
+	allowDestructive := flags.Bool("allow-destructive", false, "Allow apply to recreate or delete streams, dropping their messages")
+
+	if len(os.Args) < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	command := os.Args[1]
+	if command != "plan" && command != "apply" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	flags.Parse(os.Args[2:])
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	spec, err := plan.Load(*definitions)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defaultConnection, ok := cfg.FindConnection(*connectionID)
+	if !ok {
+		log.Fatalf("Connection not found: %s", *connectionID)
+	}
+	connect := func(id string) (plan.Broker, error) {
+		connCfg, ok := cfg.FindConnection(id)
+		if !ok {
+			return nil, fmt.Errorf("connection not found: %s", id)
+		}
+		return rabbitmq.NewConnection(connCfg), nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	p, err := plan.Compute(ctx, spec, defaultConnection.ID, connect)
+	if err != nil {
+		log.Fatalf("Failed to compute plan: %v", err)
+	}
+
+	if len(p.Changes) == 0 {
+		fmt.Println("No changes: the streams match the definitions.")
+		return
+	}
+	for _, change := range p.Changes {
+		fmt.Println(change)
+	}
+	fmt.Printf("\n%d change(s), %d destructive, %d conflicting\n", len(p.Changes), p.Destructive, p.Conflicts)
+	fmt.Println(p.Note)
+
+	if command == "plan" {
+		return
+	}
+
+	if err := plan.Apply(ctx, p, *allowDestructive); err != nil {
+		if errors.Is(err, plan.ErrDestructive) {
+			log.Fatalf("%v; re-run with -allow-destructive to apply them", err)
+		}
+		log.Fatalf("Apply failed: %v", err)
+	}
+	fmt.Println("Applied.")
+}
@@ -0,0 +1,466 @@
+// Package alerts evaluates the configured alert rules against the streams,
+// consumers and connections of the viewer, tracks each alert through pending,
+// firing and resolved, and notifies webhooks when alerts fire and resolve.
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// resolvedRetention is how long resolved alerts stay listed
+const resolvedRetention = time.Hour
+
+// State is where an alert is in its lifecycle
+type State string
+
+const (
+	// StatePending alerts match their rule but haven't held for the rule's duration yet
+	StatePending State = "pending"
+	// StateFiring alerts have been notified
+	StateFiring State = "firing"
+	// StateResolved alerts fired and no longer match
+	StateResolved State = "resolved"
+)
+
+// Alert is one rule matching one connection, stream or consumer
+type Alert struct {
+	Rule   string            `json:"rule"`
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+	State  State             `json:"state"`
+	// Value is the lag, unchanged last offset, offset jump, or 1 for an unreachable connection
+	Value     int64  `json:"value"`
+	Threshold int64  `json:"threshold,omitempty"`
+	Message   string `json:"message"`
+	// ActiveAt is when the condition started to hold
+	ActiveAt   time.Time  `json:"active_at"`
+	FiredAt    *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// key identifies an alert by its rule and labels
+func (a *Alert) key() string {
+	names := make([]string, 0, len(a.Labels))
+	for name := range a.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(a.Rule)
+	for _, name := range names {
+		fmt.Fprintf(&b, "\x00%s=%s", name, a.Labels[name])
+	}
+	return b.String()
+}
+
+// Source reads what the rules watch
+type Source interface {
+	// Connections returns the IDs of the configured connections that can be
+	// watched without a user's credentials
+	Connections() []string
+	// ListStreams lists a connection's streams; an error means the connection is unreachable
+	ListStreams(ctx context.Context, connection string) ([]rabbitmq.Stream, error)
+	StreamStats(ctx context.Context, stream rabbitmq.Stream) (*rabbitmq.StreamStats, error)
+	ListConsumers(ctx context.Context, connection, vhost string) ([]rabbitmq.StreamConsumer, error)
+}
+
+// managerSource reads through the connections of a manager
+type managerSource struct {
+	manager *rabbitmq.Manager
+}
+
+// NewManagerSource returns a Source watching the manager's connections
+func NewManagerSource(manager *rabbitmq.Manager) Source {
+	return managerSource{manager: manager}
+}
+
+func (s managerSource) Connections() []string {
+	var ids []string
+	for _, cfg := range s.manager.ListConnections() {
+		if cfg.HasServiceAccount() {
+			ids = append(ids, cfg.ID)
+		}
+	}
+	return ids
+}
+
+func (s managerSource) ListStreams(ctx context.Context, connection string) ([]rabbitmq.Stream, error) {
+	conn, err := s.manager.GetConnection(connection)
+	if err != nil {
+		return nil, err
+	}
+	return conn.ListStreams(ctx)
+}
+
+func (s managerSource) StreamStats(ctx context.Context, stream rabbitmq.Stream) (*rabbitmq.StreamStats, error) {
+	conn, err := s.manager.GetConnection(stream.ConnectionID)
+	if err != nil {
+		return nil, err
+	}
+	return conn.GetStreamStatsForVHost(ctx, stream.VHost, stream.Name)
+}
+
+func (s managerSource) ListConsumers(ctx context.Context, connection, vhost string) ([]rabbitmq.StreamConsumer, error) {
+	conn, err := s.manager.GetConnection(connection)
+	if err != nil {
+		return nil, err
+	}
+	return conn.ListConsumers(ctx, vhost)
+}
+
+// offsets is a stream's offset range at the previous evaluation
+type offsets struct {
+	first, last uint64
+}
+
+// Engine evaluates the rules at an interval
+type Engine struct {
+	source   Source
+	cfg      config.AlertsConfig
+	webhooks map[string]*webhook
+	now      func() time.Time
+
+	mu      sync.RWMutex
+	alerts  map[string]*Alert
+	offsets map[rabbitmq.Stream]offsets
+}
+
+// NewEngine creates an engine for the configured rules, parsing the webhook templates
+func NewEngine(source Source, cfg config.AlertsConfig) (*Engine, error) {
+	e := &Engine{
+		source:   source,
+		cfg:      cfg,
+		webhooks: make(map[string]*webhook),
+		now:      time.Now,
+		alerts:   make(map[string]*Alert),
+		offsets:  make(map[rabbitmq.Stream]offsets),
+	}
+	for _, hook := range cfg.Webhooks {
+		w, err := newWebhook(hook)
+		if err != nil {
+			return nil, err
+		}
+		e.webhooks[hook.Name] = w
+	}
+	return e, nil
+}
+
+// Interval returns the time between evaluations
+func (e *Engine) Interval() time.Duration {
+	return e.cfg.Interval
+}
+
+// Run evaluates the rules until ctx is done
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.Evaluate(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("alerts: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// round collects what one evaluation observed
+type round struct {
+	active map[string]*Alert
+	// evaluated holds the rule/connection pairs whose reads all succeeded;
+	// alerts outside them keep their state rather than resolve
+	evaluated map[string]bool
+	errs      []string
+}
+
+func scope(rule, connection string) string {
+	return rule + "\x00" + connection
+}
+
+func (r *round) observe(rule config.AlertRule, labels map[string]string, value int64, message string) {
+	a := &Alert{Rule: rule.Name, Type: rule.Type, Labels: labels, Value: value, Message: message}
+	if rule.Type != config.AlertConnectionUnreachable {
+		a.Threshold = rule.Threshold
+	}
+	r.active[a.key()] = a
+}
+
+func (r *round) fail(rule config.AlertRule, connection string, err error) {
+	delete(r.evaluated, scope(rule.Name, connection))
+	r.errs = append(r.errs, fmt.Sprintf("rule %s on %s: %v", rule.Name, connection, err))
+}
+
+// Evaluate checks every rule once, moves alerts between states and notifies
+// the webhooks of alerts that fired or resolved. Failed reads are returned
+// after the rest of the rules are evaluated.
+func (e *Engine) Evaluate(ctx context.Context) error {
+	r := &round{active: make(map[string]*Alert), evaluated: make(map[string]bool)}
+	current := make(map[rabbitmq.Stream]offsets)
+
+	for _, connection := range e.source.Connections() {
+		var rules []config.AlertRule
+		for _, rule := range e.cfg.Rules {
+			if rule.Connection == "" || rule.Connection == connection {
+				rules = append(rules, rule)
+				r.evaluated[scope(rule.Name, connection)] = true
+			}
+		}
+		if len(rules) == 0 {
+			continue
+		}
+
+		streams, err := e.source.ListStreams(ctx, connection)
+		for _, rule := range rules {
+			if rule.Type == config.AlertConnectionUnreachable && err != nil {
+				r.observe(rule, map[string]string{"connection": connection}, 1,
+					fmt.Sprintf("connection %s is unreachable: %v", connection, err))
+			} else if err != nil {
+				r.fail(rule, connection, err)
+			}
+		}
+		if err != nil {
+			continue
+		}
+
+		e.evaluateStreams(ctx, r, rules, connection, streams, current)
+	}
+
+	e.mu.Lock()
+	for stream, o := range current {
+		e.offsets[stream] = o
+	}
+	notifications := e.transition(r)
+	e.mu.Unlock()
+
+	e.notify(ctx, notifications)
+
+	if len(r.errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(r.errs, "; "))
+	}
+	return nil
+}
+
+// evaluateStreams checks the stream and consumer rules of a reachable
+// connection, reading each stream's stats and each vhost's consumers once
+func (e *Engine) evaluateStreams(ctx context.Context, r *round, rules []config.AlertRule, connection string, streams []rabbitmq.Stream, current map[rabbitmq.Stream]offsets) {
+	type statsResult struct {
+		stats *rabbitmq.StreamStats
+		err   error
+	}
+	type consumersResult struct {
+		consumers []rabbitmq.StreamConsumer
+		err       error
+	}
+	statsCache := make(map[rabbitmq.Stream]statsResult)
+	consumersCache := make(map[string]consumersResult)
+
+	e.mu.RLock()
+	previous := make(map[rabbitmq.Stream]offsets, len(streams))
+	for _, stream := range streams {
+		if o, ok := e.offsets[stream]; ok {
+			previous[stream] = o
+		}
+	}
+	e.mu.RUnlock()
+
+	for _, rule := range rules {
+		if rule.Type == config.AlertConnectionUnreachable {
+			continue
+		}
+		selector := rule.Selector()
+
+		for _, stream := range streams {
+			if !selector.Matches(connection, stream.VHost, stream.Name) {
+				continue
+			}
+			labels := func() map[string]string {
+				return map[string]string{"connection": connection, "vhost": stream.VHost, "stream": stream.Name}
+			}
+
+			if rule.Type == config.AlertConsumerLag {
+				result, ok := consumersCache[stream.VHost]
+				if !ok {
+					result.consumers, result.err = e.source.ListConsumers(ctx, connection, stream.VHost)
+					consumersCache[stream.VHost] = result
+				}
+				if result.err != nil {
+					r.fail(rule, connection, result.err)
+					continue
+				}
+				for name, lag := range consumerLag(result.consumers, stream.Name, rule.Consumer) {
+					if lag > rule.Threshold {
+						l := labels()
+						l["consumer"] = name
+						r.observe(rule, l, lag, fmt.Sprintf("consumer %s on %s lags %d messages behind", name, stream.Name, lag))
+					}
+				}
+				continue
+			}
+
+			result, ok := statsCache[stream]
+			if !ok {
+				result.stats, result.err = e.source.StreamStats(ctx, stream)
+				statsCache[stream] = result
+				if result.err == nil {
+					current[stream] = offsets{first: result.stats.FirstOffset, last: result.stats.LastOffset}
+				}
+			}
+			if result.err != nil {
+				r.fail(rule, connection, result.err)
+				continue
+			}
+			prev, seen := previous[stream]
+			if !seen {
+				continue
+			}
+
+			switch rule.Type {
+			case config.AlertStreamStalled:
+				if result.stats.LastOffset == prev.last {
+					r.observe(rule, labels(), int64(prev.last),
+						fmt.Sprintf("stream %s stopped growing at offset %d", stream.Name, prev.last))
+				}
+			case config.AlertTruncation:
+				if jump := int64(result.stats.FirstOffset) - int64(prev.first); jump >= rule.Threshold {
+					r.observe(rule, labels(), jump,
+						fmt.Sprintf("retention truncated %d offsets from %s, first offset is now %d", jump, stream.Name, result.stats.FirstOffset))
+				}
+			}
+		}
+	}
+}
+
+// consumerLag returns the lag of the named consumers of a stream matching pattern
+func consumerLag(consumers []rabbitmq.StreamConsumer, stream, pattern string) map[string]int64 {
+	var matching []rabbitmq.StreamConsumer
+	for _, c := range consumers {
+		if c.Stream != stream {
+			continue
+		}
+		if pattern != "" {
+			if matched, _ := path.Match(pattern, c.Properties["name"]); !matched {
+				continue
+			}
+		}
+		matching = append(matching, c)
+	}
+	return rabbitmq.ConsumerLags(matching)
+}
+
+// transition moves alerts between states after a round and returns the
+// alerts to notify; e.mu must be held
+func (e *Engine) transition(r *round) []Alert {
+	now := e.now()
+	rules := make(map[string]config.AlertRule, len(e.cfg.Rules))
+	for _, rule := range e.cfg.Rules {
+		rules[rule.Name] = rule
+	}
+
+	var notifications []Alert
+	for key, observed := range r.active {
+		a, ok := e.alerts[key]
+		if !ok || a.State == StateResolved {
+			a = observed
+			a.State = StatePending
+			a.ActiveAt = now
+			e.alerts[key] = a
+		}
+		a.Value, a.Message = observed.Value, observed.Message
+
+		// Truncations are events, so they fire as soon as they're seen
+		rule := rules[a.Rule]
+		if a.State == StatePending && (now.Sub(a.ActiveAt) >= rule.For || rule.Type == config.AlertTruncation) {
+			firedAt := now
+			a.State, a.FiredAt = StateFiring, &firedAt
+			notifications = append(notifications, *a)
+		}
+	}
+
+	for key, a := range e.alerts {
+		if _, active := r.active[key]; active {
+			continue
+		}
+		if a.State == StateResolved {
+			if now.Sub(*a.ResolvedAt) > resolvedRetention {
+				delete(e.alerts, key)
+			}
+			continue
+		}
+		if !r.evaluated[scope(a.Rule, a.Labels["connection"])] {
+			continue
+		}
+		if a.State == StatePending {
+			delete(e.alerts, key)
+			continue
+		}
+		resolvedAt := now
+		a.State, a.ResolvedAt = StateResolved, &resolvedAt
+		notifications = append(notifications, *a)
+	}
+	return notifications
+}
+
+// Alerts returns the pending, firing and recently resolved alerts, firing first
+func (e *Engine) Alerts() []Alert {
+	e.mu.RLock()
+	alerts := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		alerts = append(alerts, *a)
+	}
+	e.mu.RUnlock()
+
+	order := map[State]int{StateFiring: 0, StatePending: 1, StateResolved: 2}
+	sort.Slice(alerts, func(i, j int) bool {
+		if order[alerts[i].State] != order[alerts[j].State] {
+			return order[alerts[i].State] < order[alerts[j].State]
+		}
+		return alerts[i].key() < alerts[j].key()
+	})
+	return alerts
+}
+
+// notify sends each alert to its rule's webhooks, logging failed deliveries
+func (e *Engine) notify(ctx context.Context, alerts []Alert) {
+	for _, a := range alerts {
+		for _, hook := range e.webhooksFor(a.Rule) {
+			if err := hook.send(ctx, a); err != nil {
+				log.Printf("alerts: failed to notify %s of %s: %v", hook.name, a.Rule, err)
+			}
+		}
+	}
+}
+
+func (e *Engine) webhooksFor(ruleName string) []*webhook {
+	for _, rule := range e.cfg.Rules {
+		if rule.Name != ruleName {
+			continue
+		}
+		if len(rule.Webhooks) == 0 {
+			hooks := make([]*webhook, 0, len(e.cfg.Webhooks))
+			for _, hook := range e.cfg.Webhooks {
+				hooks = append(hooks, e.webhooks[hook.Name])
+			}
+			return hooks
+		}
+		hooks := make([]*webhook, 0, len(rule.Webhooks))
+		for _, name := range rule.Webhooks {
+			hooks = append(hooks, e.webhooks[name])
+		}
+		return hooks
+	}
+	return nil
+}
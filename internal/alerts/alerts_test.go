@@ -0,0 +1,325 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// fakeSource serves one connection whose streams and consumers tests update between evaluations
+type fakeSource struct {
+	unreachable error
+	statsErr    error
+	stats       map[string]*rabbitmq.StreamStats
+	consumers   []rabbitmq.StreamConsumer
+}
+
+func (f *fakeSource) Connections() []string { return []string{"dev"} }
+
+func (f *fakeSource) ListStreams(_ context.Context, connection string) ([]rabbitmq.Stream, error) {
+	if f.unreachable != nil {
+		return nil, f.unreachable
+	}
+	var streams []rabbitmq.Stream
+	for name := range f.stats {
+		streams = append(streams, rabbitmq.Stream{Name: name, ConnectionID: connection, VHost: "/"})
+	}
+	return streams, nil
+}
+
+func (f *fakeSource) StreamStats(_ context.Context, stream rabbitmq.Stream) (*rabbitmq.StreamStats, error) {
+	if f.statsErr != nil {
+		return nil, f.statsErr
+	}
+	copied := *f.stats[stream.Name]
+	return &copied, nil
+}
+
+func (f *fakeSource) ListConsumers(context.Context, string, string) ([]rabbitmq.StreamConsumer, error) {
+	return f.consumers, nil
+}
+
+// receiver records the bodies posted to it
+type receiver struct {
+	mu     sync.Mutex
+	bodies []map[string]interface{}
+	header http.Header
+}
+
+func newReceiver(t *testing.T) (*receiver, string) {
+	rec := &receiver{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("webhook body is not JSON: %v", err)
+		}
+		rec.mu.Lock()
+		rec.bodies = append(rec.bodies, body)
+		rec.header = r.Header.Clone()
+		rec.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return rec, server.URL
+}
+
+func (rec *receiver) take() []map[string]interface{} {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	bodies := rec.bodies
+	rec.bodies = nil
+	return bodies
+}
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newTestEngine(t *testing.T, source Source, cfg config.AlertsConfig) (*Engine, *clock) {
+	t.Helper()
+	full := config.Config{
+		Server:      config.ServerConfig{Port: 8080},
+		Connections: []config.ConnectionConfig{{ID: "dev", Host: "localhost", Port: 5672, HTTPPort: 15672, Username: "guest"}},
+		Alerts:      cfg,
+	}
+	if err := full.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEngine(source, full.Alerts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := &clock{t: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)}
+	e.now = clk.now
+	return e, clk
+}
+
+func TestEngine_ConsumerLag(t *testing.T) {
+	rec, url := newReceiver(t)
+	source := &fakeSource{
+		stats: map[string]*rabbitmq.StreamStats{"orders": {}},
+		consumers: []rabbitmq.StreamConsumer{
+			{Stream: "orders", OffsetLag: 150_000, Properties: map[string]string{"name": "billing"}},
+			{Stream: "orders", OffsetLag: 500_000}, // anonymous consumers aren't tracked
+		},
+	}
+	e, clk := newTestEngine(t, source, config.AlertsConfig{
+		Rules: []config.AlertRule{{
+			Name: "billing-lag", Type: config.AlertConsumerLag, Stream: "orders", Consumer: "billing",
+			Threshold: 100_000, For: 5 * time.Minute,
+		}},
+		Webhooks: []config.WebhookConfig{{
+			Name:     "chat",
+			URL:      url,
+			Headers:  map[string]string{"X-Token": "secret"},
+			Template: `{"text": {{ printf "[%s] %s" .State .Message | json }}, "consumer": {{ json .Labels.consumer }}}`,
+		}},
+	})
+	ctx := context.Background()
+
+	if err := e.Evaluate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	alerts := e.Alerts()
+	if len(alerts) != 1 || alerts[0].State != StatePending || alerts[0].Value != 150_000 {
+		t.Fatalf("expected a pending alert, got %+v", alerts)
+	}
+	if bodies := rec.take(); len(bodies) != 0 {
+		t.Errorf("expected no notification while pending, got %v", bodies)
+	}
+
+	clk.t = clk.t.Add(5 * time.Minute)
+	if err := e.Evaluate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if alerts := e.Alerts(); alerts[0].State != StateFiring || alerts[0].FiredAt == nil {
+		t.Fatalf("expected the alert to fire after 5m, got %+v", alerts)
+	}
+	bodies := rec.take()
+	if len(bodies) != 1 || bodies[0]["text"] != "[firing] consumer billing on orders lags 150000 messages behind" || bodies[0]["consumer"] != "billing" {
+		t.Errorf("unexpected notification: %v", bodies)
+	}
+	if rec.header.Get("X-Token") != "secret" || rec.header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected the configured headers, got %v", rec.header)
+	}
+
+	// Still firing: no repeated notification
+	clk.t = clk.t.Add(time.Minute)
+	e.Evaluate(ctx)
+	if bodies := rec.take(); len(bodies) != 0 {
+		t.Errorf("expected no repeated notification, got %v", bodies)
+	}
+
+	source.consumers[0].OffsetLag = 10
+	clk.t = clk.t.Add(time.Minute)
+	e.Evaluate(ctx)
+	if alerts := e.Alerts(); len(alerts) != 1 || alerts[0].State != StateResolved || alerts[0].ResolvedAt == nil {
+		t.Fatalf("expected the alert to resolve, got %+v", alerts)
+	}
+	if bodies := rec.take(); len(bodies) != 1 || bodies[0]["text"] != "[resolved] consumer billing on orders lags 150000 messages behind" {
+		t.Errorf("unexpected notification: %v", bodies)
+	}
+
+	clk.t = clk.t.Add(resolvedRetention + time.Minute)
+	e.Evaluate(ctx)
+	if alerts := e.Alerts(); len(alerts) != 0 {
+		t.Errorf("expected resolved alerts to expire, got %+v", alerts)
+	}
+}
+
+func TestEngine_StalledAndTruncated(t *testing.T) {
+	rec, url := newReceiver(t)
+	source := &fakeSource{stats: map[string]*rabbitmq.StreamStats{
+		"orders": {FirstOffset: 0, LastOffset: 100},
+	}}
+	e, clk := newTestEngine(t, source, config.AlertsConfig{
+		Rules: []config.AlertRule{
+			{Name: "stalled", Type: config.AlertStreamStalled, For: 10 * time.Minute},
+			{Name: "truncated", Type: config.AlertTruncation},
+		},
+		Webhooks: []config.WebhookConfig{{Name: "all", URL: url}},
+	})
+	ctx := context.Background()
+
+	// The first evaluation only records offsets
+	e.Evaluate(ctx)
+	clk.t = clk.t.Add(time.Minute)
+	source.stats["orders"] = &rabbitmq.StreamStats{FirstOffset: 0, LastOffset: 200}
+	e.Evaluate(ctx)
+	if alerts := e.Alerts(); len(alerts) != 0 {
+		t.Fatalf("expected no alerts while the stream grows, got %+v", alerts)
+	}
+
+	// Growth stops and retention removes a segment: the truncation fires at once
+	clk.t = clk.t.Add(time.Minute)
+	source.stats["orders"] = &rabbitmq.StreamStats{FirstOffset: 50, LastOffset: 200}
+	e.Evaluate(ctx)
+	alerts := e.Alerts()
+	if len(alerts) != 2 {
+		t.Fatalf("expected a stalled and a truncation alert, got %+v", alerts)
+	}
+	if alerts[0].Rule != "truncated" || alerts[0].State != StateFiring || alerts[0].Value != 50 {
+		t.Errorf("expected the truncation to fire, got %+v", alerts[0])
+	}
+	if alerts[1].Rule != "stalled" || alerts[1].State != StatePending {
+		t.Errorf("expected the stall to be pending, got %+v", alerts[1])
+	}
+	bodies := rec.take()
+	if len(bodies) != 1 || bodies[0]["rule"] != "truncated" || bodies[0]["state"] != "firing" {
+		t.Errorf("expected the alert as the default payload, got %v", bodies)
+	}
+
+	clk.t = clk.t.Add(10 * time.Minute)
+	e.Evaluate(ctx)
+	for _, a := range e.Alerts() {
+		want := map[string]State{"stalled": StateFiring, "truncated": StateResolved}[a.Rule]
+		if a.State != want {
+			t.Errorf("expected %s to be %s, got %s", a.Rule, want, a.State)
+		}
+	}
+}
+
+func TestEngine_TruncationAfterFailedRead(t *testing.T) {
+	source := &fakeSource{stats: map[string]*rabbitmq.StreamStats{"orders": {FirstOffset: 500, LastOffset: 900}}}
+	e, clk := newTestEngine(t, source, config.AlertsConfig{
+		Rules: []config.AlertRule{{Name: "truncated", Type: config.AlertTruncation}},
+	})
+	ctx := context.Background()
+
+	e.Evaluate(ctx)
+
+	// A round whose offsets can't be read keeps the previous offsets
+	clk.t = clk.t.Add(time.Minute)
+	source.statsErr = errors.New("failed to read offsets: connection reset")
+	if err := e.Evaluate(ctx); err == nil {
+		t.Error("expected the failed read to be reported")
+	}
+
+	clk.t = clk.t.Add(time.Minute)
+	source.statsErr = nil
+	source.stats["orders"] = &rabbitmq.StreamStats{FirstOffset: 500, LastOffset: 950}
+	e.Evaluate(ctx)
+	if alerts := e.Alerts(); len(alerts) != 0 {
+		t.Errorf("expected no truncation after a failed read, got %+v", alerts)
+	}
+}
+
+func TestEngine_ConnectionUnreachable(t *testing.T) {
+	source := &fakeSource{
+		stats:     map[string]*rabbitmq.StreamStats{"orders": {}},
+		consumers: []rabbitmq.StreamConsumer{{Stream: "orders", OffsetLag: 500, Properties: map[string]string{"name": "billing"}}},
+	}
+	e, clk := newTestEngine(t, source, config.AlertsConfig{
+		Rules: []config.AlertRule{
+			{Name: "down", Type: config.AlertConnectionUnreachable, Connection: "dev"},
+			{Name: "lag", Type: config.AlertConsumerLag, Threshold: 100},
+		},
+	})
+	ctx := context.Background()
+
+	e.Evaluate(ctx)
+	if alerts := e.Alerts(); len(alerts) != 1 || alerts[0].Rule != "lag" || alerts[0].State != StateFiring {
+		t.Fatalf("expected the lag alert to fire, got %+v", alerts)
+	}
+
+	// While the connection is down, its stream alerts keep their state
+	source.unreachable = errors.New("connection refused")
+	clk.t = clk.t.Add(time.Minute)
+	if err := e.Evaluate(ctx); err == nil {
+		t.Error("expected the failed reads to be reported")
+	}
+	alerts := e.Alerts()
+	if len(alerts) != 2 {
+		t.Fatalf("expected two firing alerts, got %+v", alerts)
+	}
+	for _, a := range alerts {
+		if a.State != StateFiring {
+			t.Errorf("expected %s to be firing, got %s", a.Rule, a.State)
+		}
+	}
+
+	source.unreachable = nil
+	source.consumers = nil
+	clk.t = clk.t.Add(time.Minute)
+	if err := e.Evaluate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range e.Alerts() {
+		if a.State != StateResolved {
+			t.Errorf("expected %s to resolve, got %s", a.Rule, a.State)
+		}
+	}
+}
+
+func TestWebhook_InvalidTemplate(t *testing.T) {
+	if _, err := NewEngine(&fakeSource{}, config.AlertsConfig{
+		Webhooks: []config.WebhookConfig{{Name: "bad", URL: "http://localhost", Template: "{{ .Rule "}},
+	}); err == nil {
+		t.Error("expected a template parse error")
+	}
+
+	w, err := newWebhook(config.WebhookConfig{Name: "text", URL: "http://localhost", Template: "rule {{ .Rule }}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.payload(Alert{Rule: "lag"}); err == nil {
+		t.Error("expected a template rendering invalid JSON to fail")
+	}
+
+	// Labels that an alert type doesn't have render as null
+	w, err = newWebhook(config.WebhookConfig{Name: "chat", URL: "http://localhost", Template: `{"stream": {{ json .Labels.stream }}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := w.payload(Alert{Rule: "down", Labels: map[string]string{"connection": "dev"}})
+	if err != nil || string(body) != `{"stream": null}` {
+		t.Errorf("unexpected payload %s: %v", body, err)
+	}
+}
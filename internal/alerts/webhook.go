@@ -0,0 +1,88 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+)
+
+// webhook posts alerts as JSON, rendered through the configured template when there is one
+type webhook struct {
+	name     string
+	cfg      config.WebhookConfig
+	template *template.Template
+	client   *http.Client
+}
+
+// templateFuncs are available to webhook templates; json quotes a value so
+// that messages and labels can be embedded safely
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func newWebhook(cfg config.WebhookConfig) (*webhook, error) {
+	w := &webhook{
+		name:   cfg.Name,
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+	if cfg.Template != "" {
+		tmpl, err := template.New(cfg.Name).Funcs(templateFuncs).Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: invalid template: %w", cfg.Name, err)
+		}
+		w.template = tmpl
+	}
+	return w, nil
+}
+
+// payload renders the body for an alert
+func (w *webhook) payload(a Alert) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(a)
+	}
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, a); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template rendered invalid JSON: %s", buf.String())
+	}
+	return buf.Bytes(), nil
+}
+
+func (w *webhook) send(ctx context.Context, a Alert) error {
+	body, err := w.payload(a)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook returned %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
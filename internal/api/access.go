@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/copier"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// allowed reports whether the user of a request holds a permission on a
+// resource; everything is allowed when authentication is off
+func (h *Handler) allowed(r *http.Request, perm string, res auth.Resource) bool {
+	if h.auth == nil {
+		return true
+	}
+	return h.auth.Allowed(auth.UserFrom(r.Context()), perm, res)
+}
+
+// authorize answers 403 unless the user of a request holds a permission on a resource
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, perm string, res auth.Resource) bool {
+	if h.allowed(r, perm, res) {
+		return true
+	}
+	respondError(w, http.StatusForbidden, "Permission denied", fmt.Errorf("%s permission required on %s", perm, res))
+	return false
+}
+
+// require wraps a route so that it is only served to users holding a
+// permission on the stream, or super stream, the route addresses
+func (h *Handler) require(perm string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.authorize(w, r, perm, h.routeResource(r)) {
+			next(w, r)
+		}
+	}
+}
+
+// requireEverywhere wraps a route reporting on every stream, such as /metrics
+func (h *Handler) requireEverywhere(perm string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.auth != nil && !h.auth.AllowedEverywhere(auth.UserFrom(r.Context()), perm) {
+			respondError(w, http.StatusForbidden, "Permission denied", fmt.Errorf("%s permission required on every stream", perm))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeResource returns the stream a route addresses. Shorthand routes
+// without a vhost address the connection's default vhost.
+func (h *Handler) routeResource(r *http.Request) auth.Resource {
+	vars := mux.Vars(r)
+	res := auth.Resource{Connection: vars["connection_id"], VHost: vars["vhost"], Stream: vars["stream_name"]}
+	if res.Stream == "" {
+		res.Stream = vars["name"]
+	}
+	if res.VHost == "" {
+		if conn, err := h.manager.GetConnection(res.Connection); err == nil {
+			res.VHost = conn.DefaultVHost()
+		}
+	}
+	return res
+}
+
+// endpointResource returns the resource of a copy job endpoint
+func endpointResource(e copier.Endpoint) auth.Resource {
+	return auth.Resource{Connection: e.Conn.ID, VHost: e.VHost, Stream: e.Stream}
+}
+
+// listableStreams keeps the streams the user of a request may list
+func (h *Handler) listableStreams(r *http.Request, streams []rabbitmq.Stream) []rabbitmq.Stream {
+	if h.auth == nil {
+		return streams
+	}
+	allowed := []rabbitmq.Stream{}
+	for _, s := range streams {
+		if h.allowed(r, config.PermList, auth.Resource{Connection: s.ConnectionID, VHost: s.VHost, Stream: s.Name}) {
+			allowed = append(allowed, s)
+		}
+	}
+	return allowed
+}
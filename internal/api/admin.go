@@ -0,0 +1,210 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// declareStreamRequest is the body of POST /streams/{connection_id}/{vhost}
+type declareStreamRequest struct {
+	Name string `json:"name"`
+	rabbitmq.StreamSettings
+}
+
+// declareSuperStreamRequest is the body of POST /superstreams/{connection_id}/{vhost}
+type declareSuperStreamRequest struct {
+	Name string `json:"name"`
+	rabbitmq.SuperStreamSettings
+}
+
+// GetStreamSettings returns the settings a stream was declared with
+func (h *Handler) GetStreamSettings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	conn, err := h.connection(r, vars["connection_id"])
+	if err != nil {
+		respondConnectionError(w, err)
+		return
+	}
+
+	settings, err := conn.GetStreamSettings(r.Context(), vars["vhost"], vars["stream_name"])
+	if err != nil {
+		respondAdminError(w, "Failed to get stream settings", err)
+		return
+	}
+	respondJSON(w, http.StatusOK, settings)
+}
+
+// DeclareStream creates a stream with the requested settings
+func (h *Handler) DeclareStream(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	vhost := vars["vhost"]
+
+	var req declareStreamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if req.Name == "" {
+		respondError(w, http.StatusBadRequest, "Invalid request body", errors.New("name is required"))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid stream settings", err)
+		return
+	}
+	auditResource(r, auth.Resource{Connection: vars["connection_id"], VHost: vhost, Stream: req.Name})
+	if !h.authorize(w, r, config.PermAdmin, auth.Resource{Connection: vars["connection_id"], VHost: vhost, Stream: req.Name}) {
+		return
+	}
+
+	conn, ok := h.writableConnection(w, r, vars["connection_id"])
+	if !ok {
+		return
+	}
+
+	if err := conn.DeclareStream(r.Context(), vhost, req.Name, req.StreamSettings); err != nil {
+		respondAdminError(w, "Failed to declare stream", err)
+		return
+	}
+
+	log.Printf("declared stream %s/%s/%s with %+v", conn.ID, vhost, req.Name, req.StreamSettings)
+	respondJSON(w, http.StatusCreated, rabbitmq.Stream{Name: req.Name, ConnectionID: conn.ID, VHost: vhost})
+}
+
+// DeleteStream deletes a stream. The confirm query parameter must repeat the
+// stream name, so a stream is never deleted by a mistyped or replayed URL.
+func (h *Handler) DeleteStream(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	vhost := vars["vhost"]
+	streamName := vars["stream_name"]
+
+	if !confirmDeletion(w, r, streamName) {
+		return
+	}
+	conn, ok := h.writableConnection(w, r, vars["connection_id"])
+	if !ok {
+		return
+	}
+
+	if err := conn.DeleteStream(r.Context(), vhost, streamName); err != nil {
+		respondAdminError(w, "Failed to delete stream", err)
+		return
+	}
+
+	log.Printf("deleted stream %s/%s/%s", conn.ID, vhost, streamName)
+	respondJSON(w, http.StatusOK, map[string]string{"deleted": streamName})
+}
+
+// GetSuperStream returns a super stream's partitions
+func (h *Handler) GetSuperStream(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	conn, err := h.connection(r, vars["connection_id"])
+	if err != nil {
+		respondConnectionError(w, err)
+		return
+	}
+
+	superStream, err := conn.GetSuperStream(r.Context(), vars["vhost"], vars["name"])
+	if err != nil {
+		respondAdminError(w, "Failed to get super stream", err)
+		return
+	}
+	respondJSON(w, http.StatusOK, superStream)
+}
+
+// DeclareSuperStream creates a super stream and its partition streams
+func (h *Handler) DeclareSuperStream(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	vhost := vars["vhost"]
+
+	var req declareSuperStreamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if req.Name == "" {
+		respondError(w, http.StatusBadRequest, "Invalid request body", errors.New("name is required"))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid super stream settings", err)
+		return
+	}
+	auditResource(r, auth.Resource{Connection: vars["connection_id"], VHost: vhost, Stream: req.Name})
+	if !h.authorize(w, r, config.PermAdmin, auth.Resource{Connection: vars["connection_id"], VHost: vhost, Stream: req.Name}) {
+		return
+	}
+
+	conn, ok := h.writableConnection(w, r, vars["connection_id"])
+	if !ok {
+		return
+	}
+
+	superStream, err := conn.DeclareSuperStream(r.Context(), vhost, req.Name, req.SuperStreamSettings)
+	if err != nil {
+		respondAdminError(w, "Failed to declare super stream", err)
+		return
+	}
+
+	log.Printf("declared super stream %s/%s/%s with %d partitions", conn.ID, vhost, req.Name, len(superStream.Partitions))
+	respondJSON(w, http.StatusCreated, superStream)
+}
+
+// DeleteSuperStream deletes a super stream and its partitions. The confirm
+// query parameter must repeat the super stream name.
+func (h *Handler) DeleteSuperStream(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	vhost := vars["vhost"]
+	name := vars["name"]
+
+	if !confirmDeletion(w, r, name) {
+		return
+	}
+	conn, ok := h.writableConnection(w, r, vars["connection_id"])
+	if !ok {
+		return
+	}
+
+	if err := conn.DeleteSuperStream(r.Context(), vhost, name); err != nil {
+		respondAdminError(w, "Failed to delete super stream", err)
+		return
+	}
+
+	log.Printf("deleted super stream %s/%s/%s", conn.ID, vhost, name)
+	respondJSON(w, http.StatusOK, map[string]string{"deleted": name})
+}
+
+// confirmDeletion checks that the confirm query parameter repeats name
+func confirmDeletion(w http.ResponseWriter, r *http.Request, name string) bool {
+	confirm := r.URL.Query().Get("confirm")
+	if confirm != name {
+		respondError(w, http.StatusBadRequest, "Deletion not confirmed",
+			fmt.Errorf("set confirm=%s to delete %s", name, name))
+		return false
+	}
+	return true
+}
+
+// respondAdminError maps stream administration errors to status codes
+func respondAdminError(w http.ResponseWriter, message string, err error) {
+	switch {
+	case errors.Is(err, rabbitmq.ErrReadOnly):
+		respondError(w, http.StatusForbidden, message, err)
+	case errors.Is(err, rabbitmq.ErrStreamNotFound):
+		respondError(w, http.StatusNotFound, message, err)
+	case errors.Is(err, rabbitmq.ErrStreamExists):
+		respondError(w, http.StatusConflict, message, err)
+	default:
+		respondError(w, http.StatusInternalServerError, message, err)
+	}
+}
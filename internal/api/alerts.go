@@ -0,0 +1,46 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/alerts"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+)
+
+// SetAlertEngine enables the alerts endpoint with the engine's alerts
+func (h *Handler) SetAlertEngine(engine *alerts.Engine) {
+	h.alerts = engine
+}
+
+// ListAlerts returns the pending, firing and recently resolved alerts on
+// streams the user may list, optionally only those in the state given by the
+// state parameter
+func (h *Handler) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	if h.alerts == nil {
+		respondError(w, http.StatusServiceUnavailable, "Alerting disabled",
+			errors.New("add alerts.rules to the configuration to evaluate alerts"))
+		return
+	}
+
+	state := alerts.State(r.URL.Query().Get("state"))
+	switch state {
+	case "", alerts.StatePending, alerts.StateFiring, alerts.StateResolved:
+	default:
+		respondError(w, http.StatusBadRequest, "Invalid state parameter",
+			errors.New("state must be pending, firing or resolved"))
+		return
+	}
+
+	list := []alerts.Alert{}
+	for _, a := range h.alerts.Alerts() {
+		visible := h.allowed(r, config.PermList, auth.Resource{
+			Connection: a.Labels["connection"], VHost: a.Labels["vhost"], Stream: a.Labels["stream"],
+		})
+		if visible && (state == "" || a.State == state) {
+			list = append(list, a)
+		}
+	}
+	respondJSON(w, http.StatusOK, list)
+}
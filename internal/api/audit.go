@@ -0,0 +1,203 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/audit"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
+)
+
+// Audited actions
+const (
+	actionReadMessages       = "read_messages"
+	actionReadMessage        = "read_message"
+	actionExport             = "export"
+	actionPublish            = "publish"
+	actionRepublish          = "republish"
+	actionReplay             = "replay"
+	actionDeclareStream      = "declare_stream"
+	actionDeleteStream       = "delete_stream"
+	actionDeclareSuperStream = "declare_super_stream"
+	actionDeleteSuperStream  = "delete_super_stream"
+	actionApplyPlan          = "apply_plan"
+	actionStartCopy          = "start_copy"
+	actionCancelCopy         = "cancel_copy"
+	actionResumeCopy         = "resume_copy"
+	actionLogin              = "login"
+	actionLogout             = "logout"
+	actionQueryAudit         = "query_audit"
+	actionCreateToken        = "create_token"
+	actionRevokeToken        = "revoke_token"
+)
+
+// maxAuditLimit caps the events GET /api/audit returns
+const maxAuditLimit = 10000
+
+// maxAuditErrorBytes caps how much of an error response is kept for the audit event
+const maxAuditErrorBytes = 4096
+
+// SetAuditLog records the audited requests in an audit log
+func (h *Handler) SetAuditLog(logger *audit.Logger) {
+	h.audit = logger
+}
+
+// audited wraps a route so that every request to it, allowed or not, is
+// recorded in the audit log once it has been served
+func (h *Handler) audited(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.audit == nil {
+			next(w, r)
+			return
+		}
+
+		start := time.Now()
+		res := h.routeResource(r)
+		event := &audit.Event{
+			Time:       start.UTC(),
+			RemoteAddr: r.RemoteAddr,
+			Action:     action,
+			Method:     r.Method,
+			Path:       r.URL.EscapedPath(),
+			Connection: res.Connection,
+			VHost:      res.VHost,
+			Stream:     res.Stream,
+		}
+		if user := auth.UserFrom(r.Context()); user != nil {
+			event.User, event.Token = user.Name, user.TokenID
+		}
+		if query := r.URL.Query(); len(query) > 0 {
+			event.Params = query
+		}
+
+		wrapped := &auditWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next(wrapped, r.WithContext(audit.WithEvent(r.Context(), event)))
+
+		event.Status = wrapped.statusCode
+		event.Outcome = audit.OutcomeOf(wrapped.statusCode)
+		if event.Error == "" && wrapped.statusCode >= 400 {
+			event.Error = errorMessage(wrapped.body.Bytes())
+		}
+		if event.Error != "" && event.Outcome == audit.OutcomeSuccess {
+			event.Outcome = audit.OutcomeFailure
+		}
+		event.DurationMS = time.Since(start).Milliseconds()
+		if err := h.audit.Log(*event); err != nil {
+			log.Printf("audit: %v", err)
+		}
+	}
+}
+
+// auditResource records the stream a request acts on when the route doesn't
+// name it, such as a stream declared from the request body
+func auditResource(r *http.Request, res auth.Resource) {
+	if e := audit.FromContext(r.Context()); e != nil {
+		e.Connection, e.VHost, e.Stream = res.Connection, res.VHost, res.Stream
+	}
+}
+
+// auditFailure records an error reported after the response status was sent
+func auditFailure(r *http.Request, err error) {
+	if e := audit.FromContext(r.Context()); e != nil {
+		e.Error = err.Error()
+	}
+}
+
+// errorMessage extracts the message of an error response written by respondError
+func errorMessage(body []byte) string {
+	var resp struct {
+		Error   string `json:"error"`
+		Details string `json:"details"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Error == "" {
+		return string(bytes.TrimSpace(body))
+	}
+	if resp.Details == "" {
+		return resp.Error
+	}
+	return resp.Error + ": " + resp.Details
+}
+
+// auditWriter captures the status of a response and the start of error bodies
+type auditWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (aw *auditWriter) WriteHeader(code int) {
+	aw.statusCode = code
+	aw.ResponseWriter.WriteHeader(code)
+}
+
+func (aw *auditWriter) Write(data []byte) (int, error) {
+	if aw.statusCode >= 400 && aw.body.Len() < maxAuditErrorBytes {
+		aw.body.Write(data[:min(len(data), maxAuditErrorBytes-aw.body.Len())])
+	}
+	return aw.ResponseWriter.Write(data)
+}
+
+// Flush lets streaming handlers flush through the wrapper
+func (aw *auditWriter) Flush() {
+	if f, ok := aw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (aw *auditWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
+
+// GetAuditLog returns the audit events matching the user, action, connection,
+// vhost, stream, outcome, since and until query parameters, newest first
+func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	if h.audit == nil {
+		respondError(w, http.StatusServiceUnavailable, "Audit log disabled", errors.New("no audit file is configured"))
+		return
+	}
+
+	query := r.URL.Query()
+	q := audit.Query{
+		User:       query.Get("user"),
+		Action:     query.Get("action"),
+		Connection: query.Get("connection"),
+		VHost:      query.Get("vhost"),
+		Stream:     query.Get("stream"),
+		Outcome:    query.Get("outcome"),
+	}
+	for _, p := range []struct {
+		name  string
+		value *time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		if value := query.Get(p.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				respondParamError(w, &paramError{p.name, err})
+				return
+			}
+			*p.value = t
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxAuditLimit {
+			respondError(w, http.StatusBadRequest, "Invalid limit parameter", fmt.Errorf("limit must be between 1 and %d", maxAuditLimit))
+			return
+		}
+		q.Limit = limit
+	}
+
+	events, err := h.audit.Query(q)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to read audit log", err)
+		return
+	}
+	respondJSON(w, http.StatusOK, events)
+}
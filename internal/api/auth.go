@@ -0,0 +1,200 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/audit"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// loginRequest is the body of POST /auth/login. Token is an OAuth 2 access
+// token for the broker, sent instead of a username and password.
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Token    string `json:"token"`
+}
+
+// sessionResponse describes the caller's login and how to log in
+type sessionResponse struct {
+	Enabled bool       `json:"enabled"`
+	Methods []string   `json:"methods,omitempty"`
+	User    *auth.User `json:"user"`
+	// Permissions are those the user holds on at least one stream
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// SetAuthenticator requires authentication on the API and /metrics
+func (h *Handler) SetAuthenticator(authenticator *auth.Authenticator) {
+	h.auth = authenticator
+}
+
+// authenticate rejects requests without valid credentials and adds the user
+// to the context of the others; it passes everything when auth is off
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.auth == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := h.auth.Authenticate(r)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidToken) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			} else if h.auth.BasicEnabled() {
+				w.Header().Set("WWW-Authenticate", `Basic realm="rmq-stream-viewer", charset="UTF-8"`)
+			}
+			respondError(w, http.StatusUnauthorized, "Authentication required", err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), user)))
+	})
+}
+
+// GetSession returns the logged-in user, if any, and the login methods
+func (h *Handler) GetSession(w http.ResponseWriter, r *http.Request) {
+	if h.auth == nil {
+		respondJSON(w, http.StatusOK, sessionResponse{})
+		return
+	}
+
+	response := sessionResponse{Enabled: true, Methods: h.auth.Methods()}
+	if user, err := h.auth.Authenticate(r); err == nil {
+		response.User = user
+		response.Permissions = h.auth.Permissions(user, auth.Resource{})
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// Login checks a static user's password, or RabbitMQ credentials when broker
+// logins are enabled, and sets the session cookie
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	if h.auth == nil {
+		respondError(w, http.StatusNotFound, "Authentication disabled", errors.New("no authentication is configured"))
+		return
+	}
+
+	var req loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	e := audit.FromContext(r.Context())
+	if e != nil {
+		e.User = req.Username
+	}
+
+	var user *auth.User
+	var err error
+	if req.Token != "" {
+		user, err = h.auth.LoginToken(r.Context(), w, req.Token)
+	} else {
+		user, err = h.auth.Login(r.Context(), w, req.Username, req.Password)
+	}
+	if err != nil {
+		log.Printf("failed login for %q from %s", req.Username, r.RemoteAddr)
+		respondError(w, http.StatusUnauthorized, "Login failed", err)
+		return
+	}
+	if e != nil {
+		e.User = user.Name
+	}
+	respondJSON(w, http.StatusOK, sessionResponse{
+		Enabled:     true,
+		Methods:     h.auth.Methods(),
+		User:        user,
+		Permissions: h.auth.Permissions(user, auth.Resource{}),
+	})
+}
+
+// Logout clears the session cookie
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	if h.auth != nil {
+		h.auth.Logout(w, r)
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"status": "logged out"})
+}
+
+// StartOIDCLogin redirects to the OIDC provider; redirect names the viewer
+// page to return to
+func (h *Handler) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.auth == nil {
+		respondError(w, http.StatusNotFound, "Authentication disabled", auth.ErrOIDCDisabled)
+		return
+	}
+
+	redirect := r.URL.Query().Get("redirect")
+	if redirect == "" {
+		redirect = basePath(r) + "/"
+	}
+	target, err := h.auth.StartOIDC(r.Context(), w, redirect)
+	if errors.Is(err, auth.ErrOIDCDisabled) {
+		respondError(w, http.StatusNotFound, "OIDC login disabled", err)
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusBadGateway, "OIDC provider unavailable", err)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// FinishOIDCLogin handles the provider's callback and returns to the viewer
+func (h *Handler) FinishOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.auth == nil {
+		respondError(w, http.StatusNotFound, "Authentication disabled", auth.ErrOIDCDisabled)
+		return
+	}
+	// The authorization code and state are credentials, keep them out of the audit log
+	e := audit.FromContext(r.Context())
+	if e != nil {
+		e.Params = nil
+	}
+
+	user, redirect, err := h.auth.FinishOIDC(r.Context(), w, r)
+	if errors.Is(err, auth.ErrOIDCDisabled) {
+		respondError(w, http.StatusNotFound, "OIDC login disabled", err)
+		return
+	}
+	if err != nil {
+		log.Printf("failed OIDC login from %s: %v", r.RemoteAddr, err)
+		respondError(w, http.StatusUnauthorized, "Login failed", err)
+		return
+	}
+	log.Printf("%s logged in with OIDC", user.Name)
+	if e != nil {
+		e.User = user.Name
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// brokerSession returns the broker session of the user of a request, if any
+func brokerSession(r *http.Request) string {
+	if user := auth.UserFrom(r.Context()); user != nil {
+		return user.BrokerSession
+	}
+	return ""
+}
+
+// connection returns the connection the calls of a request go through, which
+// acts as the user on connections with credentials: user
+func (h *Handler) connection(r *http.Request, id string) (*rabbitmq.Connection, error) {
+	return h.manager.ConnectionFor(brokerSession(r), id)
+}
+
+// respondConnectionError answers a request for a connection the user can't use
+func respondConnectionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, rabbitmq.ErrNoSession):
+		respondError(w, http.StatusForbidden, "RabbitMQ login required", err)
+	case rabbitmq.IsAccessDenied(err):
+		respondError(w, http.StatusForbidden, "Permission denied", err)
+	default:
+		respondError(w, http.StatusNotFound, "Connection not found", err)
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ListStreamPublishers returns the producers connected to a stream
+func (h *Handler) ListStreamPublishers(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	conn, err := h.connection(r, vars["connection_id"])
+	if err != nil {
+		respondConnectionError(w, err)
+		return
+	}
+
+	publishers, err := conn.ListStreamPublishers(r.Context(), vars["vhost"], vars["stream_name"])
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list publishers", err)
+		return
+	}
+	respondJSON(w, http.StatusOK, publishers)
+}
+
+// ListStreamConsumers returns the subscriptions reading from a stream
+func (h *Handler) ListStreamConsumers(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	conn, err := h.connection(r, vars["connection_id"])
+	if err != nil {
+		respondConnectionError(w, err)
+		return
+	}
+
+	consumers, err := conn.ListStreamConsumers(r.Context(), vars["vhost"], vars["stream_name"])
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to list consumers", err)
+		return
+	}
+	respondJSON(w, http.StatusOK, consumers)
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/audit"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/copier"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/export"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// copyEndpoint is a stream in a copy request; an empty vhost uses the connection's vhost
+type copyEndpoint struct {
+	ConnectionID string `json:"connection_id"`
+	VHost        string `json:"vhost"`
+	Stream       string `json:"stream"`
+}
+
+// copyRequest is the body of POST /api/copies
+type copyRequest struct {
+	Source       copyEndpoint `json:"source"`
+	Target       copyEndpoint `json:"target"`
+	Offset       uint64       `json:"offset"`
+	EndOffset    *uint64      `json:"end_offset,omitempty"`
+	From         *time.Time   `json:"from,omitempty"`
+	To           *time.Time   `json:"to,omitempty"`
+	Filters      []string     `json:"filters,omitempty"`
+	Transforms   []string     `json:"transforms,omitempty"`
+	ProducerName string       `json:"producer_name,omitempty"`
+}
+
+// SetCopyJobs replaces the in-memory copy jobs, e.g. with jobs saved to a file
+func (h *Handler) SetCopyJobs(jobs *copier.Jobs) {
+	h.copies = jobs
+}
+
+// StartCopy starts copying a range of one stream into another in the background
+func (h *Handler) StartCopy(w http.ResponseWriter, r *http.Request) {
+	var req copyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	opts, err := req.options()
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid copy request", err)
+		return
+	}
+
+	auditResource(r, auth.Resource{Connection: req.Source.ConnectionID, VHost: req.Source.VHost, Stream: req.Source.Stream})
+	source, err := h.copyEndpoint(r, req.Source)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Source connection not found", err)
+		return
+	}
+	auditResource(r, endpointResource(source))
+	target, err := h.copyEndpoint(r, req.Target)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Target connection not found", err)
+		return
+	}
+	if !h.authorizeCopy(w, r, source, target) {
+		return
+	}
+	if source.String() == target.String() {
+		respondError(w, http.StatusBadRequest, "Invalid copy request", errors.New("source and target are the same stream"))
+		return
+	}
+
+	status := h.copies.Start(source, target, opts)
+	log.Printf("copy %s: started from %s to %s", status.ID, status.Source, status.Target)
+	audit.Set(r.Context(), "copy", status.ID)
+	audit.Set(r.Context(), "target", status.Target)
+	respondJSON(w, http.StatusAccepted, status)
+}
+
+// ListCopies returns the copy jobs whose source the user may read the metadata of
+func (h *Handler) ListCopies(w http.ResponseWriter, r *http.Request) {
+	statuses := []copier.JobStatus{}
+	for _, status := range h.copies.List() {
+		source, _ := status.Endpoints()
+		if h.allowed(r, config.PermReadMetadata, endpointResource(source)) {
+			statuses = append(statuses, status)
+		}
+	}
+	respondJSON(w, http.StatusOK, statuses)
+}
+
+// GetCopy returns the progress of a copy job
+func (h *Handler) GetCopy(w http.ResponseWriter, r *http.Request) {
+	status, err := h.copies.Get(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Copy not found", err)
+		return
+	}
+	source, _ := status.Endpoints()
+	if !h.authorize(w, r, config.PermReadMetadata, endpointResource(source)) {
+		return
+	}
+	respondJSON(w, http.StatusOK, status)
+}
+
+// CancelCopy stops a running copy job
+func (h *Handler) CancelCopy(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeCopyTarget(w, r) {
+		return
+	}
+	status, err := h.copies.Cancel(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Copy not found", err)
+		return
+	}
+	respondJSON(w, http.StatusOK, status)
+}
+
+// ResumeCopy restarts a stopped copy job from its last checkpoint. The user
+// needs the same permissions as for starting it.
+func (h *Handler) ResumeCopy(w http.ResponseWriter, r *http.Request) {
+	status, err := h.copies.Get(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Copy not found", err)
+		return
+	}
+	source, target := status.Endpoints()
+	if !h.authorizeCopy(w, r, source, target) {
+		return
+	}
+
+	// Copy with the connections of the user resuming, not those of the user
+	// who started the job or the shared ones it was loaded with
+	source, err = h.rebindCopyEndpoint(r, source)
+	if err != nil {
+		respondConnectionError(w, err)
+		return
+	}
+	target, err = h.rebindCopyEndpoint(r, target)
+	if err != nil {
+		respondConnectionError(w, err)
+		return
+	}
+	status, err = h.copies.Resume(status.ID, source, target)
+	switch {
+	case errors.Is(err, copier.ErrJobNotFound):
+		respondError(w, http.StatusNotFound, "Copy not found", err)
+		return
+	case errors.Is(err, copier.ErrJobRunning):
+		respondError(w, http.StatusConflict, "Copy is still running", err)
+		return
+	}
+	respondJSON(w, http.StatusAccepted, status)
+}
+
+// authorizeCopy answers 403 unless the user may read the whole source
+// unredacted and publish to the target, and the target isn't read-only
+func (h *Handler) authorizeCopy(w http.ResponseWriter, r *http.Request, source, target copier.Endpoint) bool {
+	if !h.authorize(w, r, config.PermReadPayload, endpointResource(source)) ||
+		!h.authorize(w, r, config.PermPublish, endpointResource(target)) ||
+		!h.authorizeUnredacted(w, r, endpointResource(source)) {
+		return false
+	}
+	if target.Conn.Config.IsReadOnly() {
+		respondError(w, http.StatusForbidden, "Target connection is read-only", rabbitmq.ErrReadOnly)
+		return false
+	}
+	return true
+}
+
+// authorizeCopyTarget answers 404 for unknown jobs and 403 unless the user may
+// publish to the job's target
+func (h *Handler) authorizeCopyTarget(w http.ResponseWriter, r *http.Request) bool {
+	status, err := h.copies.Get(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Copy not found", err)
+		return false
+	}
+	_, target := status.Endpoints()
+	return h.authorize(w, r, config.PermPublish, endpointResource(target))
+}
+
+// copyEndpoint resolves a copy request endpoint to a connection
+func (h *Handler) copyEndpoint(r *http.Request, e copyEndpoint) (copier.Endpoint, error) {
+	conn, err := h.connection(r, e.ConnectionID)
+	if err != nil {
+		return copier.Endpoint{}, err
+	}
+	if e.VHost == "" {
+		e.VHost = conn.DefaultVHost()
+	}
+	return copier.Endpoint{Conn: conn, VHost: e.VHost, Stream: e.Stream}, nil
+}
+
+// rebindCopyEndpoint resolves a job's endpoint to the user's connection
+func (h *Handler) rebindCopyEndpoint(r *http.Request, e copier.Endpoint) (copier.Endpoint, error) {
+	return h.copyEndpoint(r, copyEndpoint{ConnectionID: e.Conn.ID, VHost: e.VHost, Stream: e.Stream})
+}
+
+// options validates the request and converts it to copier options
+func (req copyRequest) options() (copier.Options, error) {
+	var opts copier.Options
+	if req.Source.Stream == "" || req.Target.Stream == "" {
+		return opts, errors.New("source and target streams are required")
+	}
+	if req.EndOffset != nil && *req.EndOffset < req.Offset {
+		return opts, errors.New("end_offset must not be before offset")
+	}
+	if req.From != nil && req.To != nil && !req.To.After(*req.From) {
+		return opts, errors.New("to must be after from")
+	}
+
+	filters, err := export.ParseFilters(req.Filters)
+	if err != nil {
+		return opts, fmt.Errorf("invalid filter: %w", err)
+	}
+	transforms, err := copier.ParseTransforms(req.Transforms)
+	if err != nil {
+		return opts, fmt.Errorf("invalid transform: %w", err)
+	}
+
+	return copier.Options{
+		Offset:       req.Offset,
+		EndOffset:    req.EndOffset,
+		From:         req.From,
+		To:           req.To,
+		Filters:      filters,
+		Transforms:   transforms,
+		ProducerName: req.ProducerName,
+	}, nil
+}
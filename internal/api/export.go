@@ -0,0 +1,90 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/audit"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/export"
+)
+
+// ExportMessages streams a range of a stream as an NDJSON, CSV or Parquet download.
+// The number of exported messages and why the export ended are sent as trailers.
+func (h *Handler) ExportMessages(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	connectionID := vars["connection_id"]
+	vhost := vars["vhost"]
+	streamName := vars["stream_name"]
+
+	format, columns, opts, err := parseExportOptions(r)
+	if err != nil {
+		respondParamError(w, err)
+		return
+	}
+
+	conn, err := h.connection(r, connectionID)
+	if err != nil {
+		respondConnectionError(w, err)
+		return
+	}
+	if vhost == "" {
+		vhost = conn.DefaultVHost()
+	}
+	if err := checkTimeout(conn, opts.IdleTimeout); err != nil {
+		respondParamError(w, err)
+		return
+	}
+	if masker := h.masker(r, auth.Resource{Connection: conn.ID, VHost: vhost, Stream: streamName}); masker != nil {
+		opts.Redact = masker.Apply
+	}
+
+	// Exports outlive the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("export %s/%s: could not clear write deadline: %v", vhost, streamName, err)
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", streamName+"."+format))
+	w.Header().Set("Trailer", "X-Export-Count, X-End-Reason")
+	if opts.EndOffset != nil {
+		w.Header().Set("X-Export-Max-Messages", strconv.FormatUint(*opts.EndOffset-opts.Offset+1, 10))
+	} else if opts.Limit > 0 {
+		w.Header().Set("X-Export-Max-Messages", strconv.Itoa(opts.Limit))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	writer, err := export.NewWriter(format, w, columns)
+	if err != nil {
+		log.Printf("export %s/%s: %v", vhost, streamName, err)
+		auditFailure(r, err)
+		return
+	}
+
+	opts.Progress = func(p export.Progress) {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	result, err := export.Run(r.Context(), conn, vhost, streamName, opts, writer)
+	if err != nil {
+		// Headers are already sent, so the error can only be logged and reported in the trailer
+		log.Printf("export %s/%s failed: %v", vhost, streamName, err)
+		auditFailure(r, err)
+		w.Header().Set("X-End-Reason", "error")
+		return
+	}
+
+	log.Printf("export %s/%s: wrote %d of %d scanned messages (%s)", vhost, streamName, result.Written, result.Scanned, result.EndReason)
+	audit.Set(r.Context(), "format", format)
+	audit.Set(r.Context(), "written", result.Written)
+	audit.Set(r.Context(), "scanned", result.Scanned)
+	audit.Set(r.Context(), "end_reason", result.EndReason)
+	w.Header().Set("X-Export-Count", strconv.Itoa(result.Written))
+	w.Header().Set("X-End-Reason", result.EndReason)
+}
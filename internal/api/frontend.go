@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"io/fs"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+)
+
+// basePathMeta names the meta tag that tells the frontend its base path
+const basePathMeta = "rmq-viewer-base-path"
+
+// rootLinkPattern matches the root-relative script, stylesheet and icon links
+// of index.html, which builds made for the root use
+var rootLinkPattern = regexp.MustCompile(`(\s(?:src|href)=["'])/([^/])`)
+
+type basePathKey struct{}
+
+// basePath returns the path the browser reaches the viewer's root under: the
+// proxy's X-Forwarded-Prefix followed by server.base_path
+func basePath(r *http.Request) string {
+	p, _ := r.Context().Value(basePathKey{}).(string)
+	return p
+}
+
+// ForwardedMiddleware applies the X-Forwarded-Proto, X-Forwarded-Host and
+// X-Forwarded-Prefix headers of requests from trusted proxies, so that
+// handlers see the scheme, host and base path the browser used, and records
+// the base path of the request, to which session cookies are scoped
+func ForwardedMiddleware(cfg config.ServerConfig) func(http.Handler) http.Handler {
+	var trusted []*net.IPNet
+	for _, cidr := range cfg.TrustedProxies {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			trusted = append(trusted, network)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			prefix := ""
+			if fromNetworks(r, trusted) {
+				if proto := strings.ToLower(firstValue(r.Header.Get("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+					r.URL.Scheme = proto
+				}
+				if host := firstValue(r.Header.Get("X-Forwarded-Host")); host != "" {
+					r.Host, r.URL.Host = host, host
+				}
+				if p := strings.TrimRight(firstValue(r.Header.Get("X-Forwarded-Prefix")), "/"); config.ValidBasePath(p) {
+					prefix = p
+				}
+			}
+			ctx := context.WithValue(r.Context(), basePathKey{}, prefix+cfg.BasePath)
+			ctx = auth.WithCookiePath(ctx, prefix+cfg.BasePath)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// firstValue returns the first of the comma-separated values proxies append to
+func firstValue(header string) string {
+	value, _, _ := strings.Cut(header, ",")
+	return strings.TrimSpace(value)
+}
+
+// fromNetworks reports whether a request comes from one of the networks
+func fromNetworks(r *http.Request, networks []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHTTPS reports whether the browser reached the server over HTTPS, directly
+// or through a trusted proxy
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.URL.Scheme == "https"
+}
+
+// FrontendHandler serves the built frontend with the base path of the request
+// injected into index.html, so that one build works under any base path. It
+// expects requests with server.base_path stripped.
+func FrontendHandler(files fs.FS) http.Handler {
+	fileServer := http.FileServer(http.FS(files))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "":
+			// The base path itself; relative asset links need the trailing slash
+			target := basePath(r) + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		case "/", "/index.html":
+			serveIndex(w, r, files)
+		default:
+			fileServer.ServeHTTP(w, r)
+		}
+	})
+}
+
+// serveIndex serves index.html with the base path in a meta tag for the
+// frontend's API calls, and prefixed to its root-relative links
+func serveIndex(w http.ResponseWriter, r *http.Request, files fs.FS) {
+	page, err := fs.ReadFile(files, "index.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	prefix := basePath(r)
+	if prefix != "" {
+		page = rootLinkPattern.ReplaceAll(page, []byte("${1}"+prefix+"/${2}"))
+	}
+	meta := `<meta name="` + basePathMeta + `" content="` + html.EscapeString(prefix) + `">`
+	if i := bytes.Index(page, []byte("</head>")); i >= 0 {
+		page = append(page[:i:i], append([]byte(meta+"\n"), page[i:]...)...)
+	}
+
+	// The page depends on the proxy's headers, so it must not be cached as is
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Vary", "X-Forwarded-Prefix")
+	w.Write(page)
+}
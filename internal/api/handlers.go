@@ -2,65 +2,139 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/alerts"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/audit"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/copier"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/metrics"
 	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/redact"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/stats"
 )
 
 // Handler handles HTTP requests
 type Handler struct {
 	manager *rabbitmq.Manager
+	copies  *copier.Jobs
+	// stats is nil unless the stats collector is enabled
+	stats *stats.Collector
+	// alerts is nil unless alert rules are configured
+	alerts *alerts.Engine
+	// auth is nil unless authentication is configured
+	auth *auth.Authenticator
+	// redactor is nil unless redaction rules are configured
+	redactor *redact.Redactor
+	// audit is nil unless an audit file is configured
+	audit *audit.Logger
 }
 
 // NewHandler creates a new API handler
 func NewHandler(manager *rabbitmq.Manager) *Handler {
 	return &Handler{
 		manager: manager,
+		copies:  copier.NewJobs(),
 	}
 }
 
 // RegisterRoutes registers all API routes
 func (h *Handler) RegisterRoutes(r *mux.Router) {
 	api := r.PathPrefix("/api").Subrouter()
+	api.Use(h.authenticate)
 
 	api.HandleFunc("/connections", h.ListConnections).Methods("GET")
 	api.HandleFunc("/vhosts", h.ListVHosts).Methods("GET")
 	api.HandleFunc("/streams", h.ListStreams).Methods("GET")
-	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}/stats", h.GetStreamStats).Methods("GET")
-	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}/messages", h.GetMessages).Methods("GET")
+	api.HandleFunc("/streams/{connection_id}/{vhost}", h.audited(actionDeclareStream, h.DeclareStream)).Methods("POST")
+	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}", h.audited(actionDeleteStream, h.require(config.PermAdmin, h.DeleteStream))).Methods("DELETE")
+	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}/settings", h.require(config.PermReadMetadata, h.GetStreamSettings)).Methods("GET")
+	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}/stats", h.require(config.PermReadMetadata, h.GetStreamStats)).Methods("GET")
+	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}/describe", h.require(config.PermReadMetadata, h.DescribeStream)).Methods("GET")
+	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}/rates", h.require(config.PermReadMetadata, h.GetStreamRates)).Methods("GET")
+	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}/publishers", h.require(config.PermReadMetadata, h.ListStreamPublishers)).Methods("GET")
+	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}/consumers", h.require(config.PermReadMetadata, h.ListStreamConsumers)).Methods("GET")
+	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}/messages", h.audited(actionReadMessages, h.require(config.PermReadPayload, h.GetMessages))).Methods("GET")
+	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}/messages", h.audited(actionPublish, h.require(config.PermPublish, h.PublishMessages))).Methods("POST")
+	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}/messages/{offset}", h.audited(actionReadMessage, h.require(config.PermReadPayload, h.GetMessage))).Methods("GET")
+	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}/messages/{offset}/republish", h.audited(actionRepublish, h.require(config.PermReadPayload, h.RepublishMessage))).Methods("POST")
+	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}/export", h.audited(actionExport, h.require(config.PermReadPayload, h.ExportMessages))).Methods("GET")
+	api.HandleFunc("/streams/{connection_id}/{vhost}/{stream_name}/replay", h.audited(actionReplay, h.require(config.PermPublish, h.ReplayMessages))).Methods("POST")
+	api.HandleFunc("/superstreams/{connection_id}/{vhost}", h.audited(actionDeclareSuperStream, h.DeclareSuperStream)).Methods("POST")
+	api.HandleFunc("/superstreams/{connection_id}/{vhost}/{name}", h.require(config.PermReadMetadata, h.GetSuperStream)).Methods("GET")
+	api.HandleFunc("/superstreams/{connection_id}/{vhost}/{name}", h.audited(actionDeleteSuperStream, h.require(config.PermAdmin, h.DeleteSuperStream))).Methods("DELETE")
+	api.HandleFunc("/plan", h.PlanStreams).Methods("POST")
+	api.HandleFunc("/apply", h.audited(actionApplyPlan, h.ApplyStreams)).Methods("POST")
+	api.HandleFunc("/copies", h.ListCopies).Methods("GET")
+	api.HandleFunc("/copies", h.audited(actionStartCopy, h.StartCopy)).Methods("POST")
+	api.HandleFunc("/copies/{id}", h.GetCopy).Methods("GET")
+	api.HandleFunc("/copies/{id}", h.audited(actionCancelCopy, h.CancelCopy)).Methods("DELETE")
+	api.HandleFunc("/copies/{id}/resume", h.audited(actionResumeCopy, h.ResumeCopy)).Methods("POST")
+	api.HandleFunc("/alerts", h.ListAlerts).Methods("GET")
+	api.HandleFunc("/tokens", h.ListTokens).Methods("GET")
+	api.HandleFunc("/tokens", h.audited(actionCreateToken, h.CreateToken)).Methods("POST")
+	api.HandleFunc("/tokens/{id}", h.audited(actionRevokeToken, h.RevokeToken)).Methods("DELETE")
+	api.Handle("/audit", h.audited(actionQueryAudit, h.requireEverywhere(config.PermAdmin, http.HandlerFunc(h.GetAuditLog)).ServeHTTP)).Methods("GET")
+
+	// Login and session, open to everyone
+	r.HandleFunc("/auth/session", h.GetSession).Methods("GET")
+	r.HandleFunc("/auth/login", h.audited(actionLogin, h.Login)).Methods("POST")
+	r.HandleFunc("/auth/logout", h.audited(actionLogout, h.Logout)).Methods("POST")
+	r.HandleFunc("/auth/oidc/login", h.StartOIDCLogin).Methods("GET")
+	r.HandleFunc("/auth/oidc/callback", h.audited(actionLogin, h.FinishOIDCLogin)).Methods("GET")
 
 	// Health check
 	r.HandleFunc("/health", h.Health).Methods("GET")
+	r.Handle("/metrics", h.authenticate(h.requireEverywhere(config.PermReadMetadata, metrics.Handler()))).Methods("GET")
 }
 
-// ListConnections returns all configured connections
+// ListConnections returns the configured connections the user may list
 func (h *Handler) ListConnections(w http.ResponseWriter, r *http.Request) {
-	connections := h.manager.ListConnections()
+	connections := []config.ConnectionConfig{}
+	for _, c := range h.manager.ListConnections() {
+		if h.allowed(r, config.PermList, auth.Resource{Connection: c.ID}) {
+			connections = append(connections, c)
+		}
+	}
 	respondJSON(w, http.StatusOK, connections)
 }
 
-// ListVHosts returns all vhosts across all connections
+// ListVHosts returns the vhosts and streams the user may list across all connections
 func (h *Handler) ListVHosts(w http.ResponseWriter, r *http.Request) {
-	vhosts, err := h.manager.ListVHosts(r.Context())
+	vhosts, err := h.manager.ListVHostsFor(r.Context(), brokerSession(r))
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to list vhosts", err)
 		return
 	}
 
+	if h.auth != nil {
+		allowed := []rabbitmq.VHost{}
+		for _, vhost := range vhosts {
+			if h.allowed(r, config.PermList, auth.Resource{Connection: vhost.ConnectionID, VHost: vhost.Name}) {
+				vhost.Streams = h.listableStreams(r, vhost.Streams)
+				allowed = append(allowed, vhost)
+			}
+		}
+		vhosts = allowed
+	}
 	respondJSON(w, http.StatusOK, vhosts)
 }
 
-// ListStreams returns all streams across all connections
+// ListStreams returns the streams the user may list across all connections
 func (h *Handler) ListStreams(w http.ResponseWriter, r *http.Request) {
-	streams, err := h.manager.ListStreams(r.Context())
+	streams, err := h.manager.ListStreamsFor(r.Context(), brokerSession(r))
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to list streams", err)
 		return
 	}
 
-	respondJSON(w, http.StatusOK, streams)
+	respondJSON(w, http.StatusOK, h.listableStreams(r, streams))
 }
 
 // GetStreamStats returns statistics for a specific stream
@@ -70,9 +144,9 @@ func (h *Handler) GetStreamStats(w http.ResponseWriter, r *http.Request) {
 	vhost := vars["vhost"]
 	streamName := vars["stream_name"]
 
-	conn, err := h.manager.GetConnection(connectionID)
+	conn, err := h.connection(r, connectionID)
 	if err != nil {
-		respondError(w, http.StatusNotFound, "Connection not found", err)
+		respondConnectionError(w, err)
 		return
 	}
 
@@ -85,52 +159,128 @@ func (h *Handler) GetStreamStats(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, stats)
 }
 
-// GetMessages returns messages from a stream
+// DescribeStream returns a stream's arguments, policies, replicas, footprint and rates
+func (h *Handler) DescribeStream(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	conn, err := h.connection(r, vars["connection_id"])
+	if err != nil {
+		respondConnectionError(w, err)
+		return
+	}
+
+	description, err := conn.DescribeStream(r.Context(), vars["vhost"], vars["stream_name"])
+	if err != nil {
+		respondAdminError(w, "Failed to describe stream", err)
+		return
+	}
+	respondJSON(w, http.StatusOK, description)
+}
+
+// GetMessages returns messages from a stream. Pages are addressed either by
+// offset and direction or by a cursor returned from a previous page. With
+// stream=ndjson or stream=json the range is streamed instead of paged.
 func (h *Handler) GetMessages(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	connectionID := vars["connection_id"]
 	vhost := vars["vhost"]
 	streamName := vars["stream_name"]
 
-	// Parse query parameters
-	offsetStr := r.URL.Query().Get("offset")
-	limitStr := r.URL.Query().Get("limit")
+	// Streaming reads parse their own parameters, limit is not capped for them
+	format := r.URL.Query().Get("stream")
 
-	offset := uint64(0)
-	if offsetStr != "" {
-		parsed, err := strconv.ParseUint(offsetStr, 10, 64)
-		if err != nil {
-			respondError(w, http.StatusBadRequest, "Invalid offset parameter", err)
-			return
-		}
-		offset = parsed
+	var opts rabbitmq.ReadOptions
+	var streamOpts rabbitmq.StreamOptions
+	var err error
+	if format == "" {
+		opts, err = parseReadOptions(r)
+	} else {
+		streamOpts, err = parseStreamOptions(r)
 	}
-
-	limit := 10
-	if limitStr != "" {
-		parsed, err := strconv.Atoi(limitStr)
-		if err != nil {
-			respondError(w, http.StatusBadRequest, "Invalid limit parameter", err)
-			return
-		}
-		limit = parsed
+	if err != nil {
+		respondParamError(w, err)
+		return
 	}
 
-	conn, err := h.manager.GetConnection(connectionID)
+	conn, err := h.connection(r, connectionID)
 	if err != nil {
-		respondError(w, http.StatusNotFound, "Connection not found", err)
+		respondConnectionError(w, err)
+		return
+	}
+	if err := checkTimeout(conn, max(opts.IdleTimeout, streamOpts.IdleTimeout)); err != nil {
+		respondParamError(w, err)
 		return
 	}
 
-	messages, err := conn.ReadMessagesFromVHost(r.Context(), vhost, streamName, offset, limit)
+	if format != "" {
+		h.streamMessages(w, r, conn, vhost, streamName, format, streamOpts)
+		return
+	}
+
+	messages, err := conn.ReadMessagesWithOptions(r.Context(), vhost, streamName, opts)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to read messages", err)
 		return
 	}
+	if masker := h.masker(r, auth.Resource{Connection: conn.ID, VHost: vhost, Stream: streamName}); masker != nil {
+		for i, msg := range messages.Messages {
+			messages.Messages[i] = masker.Apply(msg)
+		}
+	}
+	audit.Set(r.Context(), "messages", len(messages.Messages))
+	if len(messages.Messages) > 0 {
+		audit.Set(r.Context(), "start_offset", messages.StartOffset)
+		audit.Set(r.Context(), "end_offset", messages.EndOffset)
+	}
 
 	respondJSON(w, http.StatusOK, messages)
 }
 
+// GetMessage returns the single message stored at an offset. Clients sending
+// Accept: application/octet-stream receive the raw message body instead of JSON.
+func (h *Handler) GetMessage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	connectionID := vars["connection_id"]
+	vhost := vars["vhost"]
+	streamName := vars["stream_name"]
+
+	offset, err := strconv.ParseUint(vars["offset"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid offset parameter", err)
+		return
+	}
+
+	conn, err := h.connection(r, connectionID)
+	if err != nil {
+		respondConnectionError(w, err)
+		return
+	}
+
+	message, err := conn.ReadMessageFromVHost(r.Context(), vhost, streamName, offset)
+	if errors.Is(err, rabbitmq.ErrMessageNotFound) {
+		respondError(w, http.StatusNotFound, "Message not found", err)
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to read message", err)
+		return
+	}
+	if masker := h.masker(r, auth.Resource{Connection: conn.ID, VHost: vhost, Stream: streamName}); masker != nil {
+		masked := masker.Apply(*message)
+		message = &masked
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/octet-stream") {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%d.bin", streamName, offset)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(message.Data)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, message)
+}
+
 // Health returns the health status of the service
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
@@ -147,6 +297,10 @@ func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 
 // respondError writes an error response
 func respondError(w http.ResponseWriter, status int, message string, err error) {
+	// The broker refusing a call is a permission error, not a server error
+	if status >= 500 && rabbitmq.IsAccessDenied(err) {
+		status = http.StatusForbidden
+	}
 	response := map[string]string{
 		"error":   message,
 		"details": err.Error(),
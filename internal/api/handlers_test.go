@@ -1,14 +1,30 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/alerts"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/audit"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
 	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/copier"
 	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/redact"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/stats"
 )
 
 func TestHealth(t *testing.T) {
@@ -49,6 +65,7 @@ func TestListConnections(t *testing.T) {
 			Port:     5672,
 			HTTPPort: 15672,
 			Username: "guest",
+			Password: "s3cret",
 		},
 	}
 
@@ -70,6 +87,10 @@ func TestListConnections(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 
+	if strings.Contains(rr.Body.String(), "s3cret") {
+		t.Errorf("expected the password to be left out: %s", rr.Body.String())
+	}
+
 	var response []config.ConnectionConfig
 	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
 		t.Errorf("failed to decode response: %v", err)
@@ -88,7 +109,7 @@ func TestGetMessages_InvalidOffset(t *testing.T) {
 	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
 	handler := NewHandler(manager)
 
-	req, err := http.NewRequest("GET", "/api/streams/conn1/stream1/messages?offset=invalid", nil)
+	req, err := http.NewRequest("GET", "/api/streams/conn1/vhost1/stream1/messages?offset=invalid", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -108,7 +129,242 @@ func TestGetMessages_ConnectionNotFound(t *testing.T) {
 	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
 	handler := NewHandler(manager)
 
-	req, err := http.NewRequest("GET", "/api/streams/nonexistent/stream1/messages", nil)
+	req, err := http.NewRequest("GET", "/api/streams/nonexistent/vhost1/stream1/messages", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestGetMessage_InvalidOffset(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+
+	req, err := http.NewRequest("GET", "/api/streams/conn1/vhost1/stream1/messages/invalid", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestGetMessage_ConnectionNotFound(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+
+	req, err := http.NewRequest("GET", "/api/streams/nonexistent/vhost1/stream1/messages/42", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestGetMessages_InvalidTimeout(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+
+	for _, timeout := range []string{"soon", "-1s", "0s"} {
+		req, err := http.NewRequest("GET", "/api/streams/conn1/vhost1/stream1/messages?timeout="+timeout, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		handler.RegisterRoutes(router)
+
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("timeout=%s: handler returned wrong status code: got %v want %v", timeout, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	conn := rabbitmq.NewConnection(config.ConnectionConfig{ID: "conn1", MaxReadTimeout: 10 * time.Second})
+
+	for timeout, ok := range map[time.Duration]bool{0: true, 10 * time.Second: true, 11 * time.Second: false, time.Hour: false} {
+		err := checkTimeout(conn, timeout)
+		if (err == nil) != ok {
+			t.Errorf("timeout=%v: expected ok=%v, got %v", timeout, ok, err)
+		}
+		if _, isParamError := err.(*paramError); err != nil && !isParamError {
+			t.Errorf("timeout=%v: expected a parameter error, got %T", timeout, err)
+		}
+	}
+
+	// Connections without a configured maximum use the default
+	if err := checkTimeout(rabbitmq.NewConnection(config.ConnectionConfig{ID: "conn2"}), 2*config.DefaultMaxReadTimeout); err == nil {
+		t.Error("Expected the default max_read_timeout to apply")
+	}
+}
+
+func TestGetMessages_InvalidCursor(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+
+	for _, query := range []string{"cursor=bm90LWpzb24", "direction=sideways"} {
+		req, err := http.NewRequest("GET", "/api/streams/conn1/vhost1/stream1/messages?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		handler.RegisterRoutes(router)
+
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", query, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetMessages_InvalidStreamParameters(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+
+	for _, query := range []string{"stream=xml", "stream=ndjson&limit=-1", "stream=ndjson&offset=10&end_offset=5"} {
+		req, err := http.NewRequest("GET", "/api/streams/conn1/vhost1/stream1/messages?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		handler.RegisterRoutes(router)
+
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", query, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestExportMessages_InvalidParameters(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+
+	queries := []string{
+		"format=xml",
+		"columns=nonsense",
+		"filter=no-operator",
+		"from=yesterday",
+		"from=2025-10-16T10:00:00Z&to=2025-10-16T09:00:00Z",
+	}
+	for _, query := range queries {
+		req, err := http.NewRequest("GET", "/api/streams/conn1/vhost1/stream1/export?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		handler.RegisterRoutes(router)
+
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", query, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestReplayMessages_InvalidParameters(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+
+	queries := []string{
+		"pace=slow",
+		"speed=0",
+		"rate=-1",
+		"from_offset=x",
+		"from_offset=10&to_offset=5",
+		"from=2025-10-16T10:00:00Z&to=2025-10-16T09:00:00Z",
+	}
+	for _, query := range queries {
+		req, err := http.NewRequest("POST", "/api/streams/conn1/vhost1/stream1/replay?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		handler.RegisterRoutes(router)
+
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", query, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestStartCopy_InvalidRequests(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`not json`, http.StatusBadRequest},
+		{`{"source": {"connection_id": "prod"}, "target": {"connection_id": "staging", "stream": "orders"}}`, http.StatusBadRequest},
+		{`{"source": {"connection_id": "prod", "stream": "orders"}, "target": {"connection_id": "staging", "stream": "orders"}, "transforms": ["body.id=1"]}`, http.StatusBadRequest},
+		{`{"source": {"connection_id": "prod", "stream": "orders"}, "target": {"connection_id": "staging", "stream": "orders"}}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("POST", "/api/copies", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router := mux.NewRouter()
+		handler.RegisterRoutes(router)
+
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != tt.want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.body, status, tt.want)
+		}
+	}
+}
+
+func TestGetCopy_NotFound(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+
+	req, err := http.NewRequest("GET", "/api/copies/missing", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -124,3 +380,1069 @@ func TestGetMessages_ConnectionNotFound(t *testing.T) {
 	}
 }
 
+func TestResumeCopy_Authorization(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "copies.json")
+	saved := `[{"status": {"id": "c1", "source": "prod/main/orders", "target": "dev/main/orders", "status": "cancelled"},
+		"source": {"connection": "prod", "vhost": "main", "stream": "orders"},
+		"target": {"connection": "dev", "vhost": "main", "stream": "orders"}}]`
+	if err := os.WriteFile(file, []byte(saved), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := copier.LoadJobs(config.CopiesConfig{File: file, Retention: time.Hour}, func(id string) (*rabbitmq.Connection, error) {
+		return rabbitmq.NewConnection(config.ConnectionConfig{ID: id}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewHandler(rabbitmq.NewManager([]config.ConnectionConfig{{ID: "prod"}, {ID: "dev"}}))
+	handler.SetCopyJobs(jobs)
+	// bcrypt hash of "secret" at the minimum cost
+	hash := "$2a$04$dgT92aQQd0frCqNE3fHEJerf5FcFilHyxYjQd7BW9zMiixpxpkAcK"
+	authenticator, err := auth.New(config.AuthConfig{
+		Users: []config.UserConfig{
+			{Username: "bob", PasswordHash: hash},
+			{Username: "alice", PasswordHash: hash},
+		},
+		Basic:   true,
+		Session: config.SessionConfig{Secret: "test-secret", TTL: time.Hour},
+		Access: []config.AccessRule{
+			{Users: []string{"bob", "alice"}, Permissions: []string{config.PermPublish}, Connection: "dev"},
+			{Users: []string{"bob"}, Permissions: []string{config.PermReadMetadata}, Connection: "prod"},
+			{Users: []string{"alice"}, Permissions: []string{config.PermReadPayload}, Connection: "prod"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetAuthenticator(authenticator)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	resume := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/copies/c1/resume", nil)
+		req.SetBasicAuth(user, "secret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Publishing to the target is not enough, the source must be readable
+	if rr := resume("bob"); rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), config.PermReadPayload) {
+		t.Errorf("expected bob to need read-payload on the source, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := resume("alice"); rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "read-only") {
+		t.Errorf("expected the read-only target to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if status, _ := jobs.Get("c1"); status.Status != copier.StatusCancelled {
+		t.Errorf("expected the job to stay cancelled, got %s", status.Status)
+	}
+}
+
+func TestPublishMessages_ConnectionNotFound(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+
+	req, err := http.NewRequest("POST", "/api/streams/nonexistent/vhost1/stream1/messages", strings.NewReader(`{"body": "hello"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestDecodePublishRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		count       int
+	}{
+		{"single object", "application/json", `{"body": "hello"}`, 1},
+		{"array", "application/json", `[{"body": "a"}, {"body": {"id": 1}}]`, 2},
+		{"ndjson", "application/x-ndjson", "{\"body\": \"a\"}\n\n{\"body\": \"b\"}\n{\"body\": \"c\"}", 3},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+
+		messages, err := decodePublishRequest(httptest.NewRecorder(), req)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if len(messages) != tt.count {
+			t.Errorf("%s: expected %d messages, got %d", tt.name, tt.count, len(messages))
+		}
+	}
+
+	for _, body := range []string{``, `[]`, `{"body": "a", "body_base64": "YQ=="}`, `{"body": `} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		if _, err := decodePublishRequest(httptest.NewRecorder(), req); err == nil {
+			t.Errorf("expected an error for body %q", body)
+		}
+	}
+}
+
+func TestPublishMessage_AMQPMessage(t *testing.T) {
+	var req publishMessage
+	err := decodeJSON([]byte(`{
+		"body": {"order_id": 7},
+		"properties": {"message_id": "m-1", "subject": "orders.created"},
+		"application_properties": {"retries": 3, "tenant": "acme"},
+		"message_annotations": {"x-origin": "manual"}
+	}`), &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := req.amqpMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.GetData()) != `{"order_id": 7}` {
+		t.Errorf("unexpected body: %s", msg.GetData())
+	}
+	if msg.Properties.ContentType != "application/json" || msg.Properties.Subject != "orders.created" || msg.Properties.MessageID != "m-1" {
+		t.Errorf("unexpected properties: %+v", msg.Properties)
+	}
+	if msg.ApplicationProperties["retries"] != int64(3) {
+		t.Errorf("expected retries to be published as an integer, got %T", msg.ApplicationProperties["retries"])
+	}
+	if msg.Annotations["x-origin"] != "manual" {
+		t.Errorf("unexpected annotations: %v", msg.Annotations)
+	}
+
+	text := publishMessage{Body: []byte(`"plain text"`), ContentType: "text/plain"}
+	msg, err = text.amqpMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.GetData()) != "plain text" || msg.Properties.ContentType != "text/plain" {
+		t.Errorf("unexpected text message: %q %+v", msg.GetData(), msg.Properties)
+	}
+}
+
+func TestJSONArrayWriter(t *testing.T) {
+	rr := httptest.NewRecorder()
+	sw := &jsonArrayWriter{w: rr}
+
+	sw.begin()
+	for _, offset := range []uint64{1, 2} {
+		if err := sw.write(rabbitmq.Message{Offset: offset, Data: []byte("x")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sw.end(rabbitmq.EndReasonEndOfStream, 2)
+
+	var response struct {
+		Messages  []rabbitmq.Message `json:"messages"`
+		EndReason string             `json:"end_reason"`
+		Count     int                `json:"count"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("streamed JSON is invalid: %v", err)
+	}
+	if len(response.Messages) != 2 || response.Messages[1].Offset != 2 {
+		t.Errorf("unexpected messages: %+v", response.Messages)
+	}
+	if response.EndReason != rabbitmq.EndReasonEndOfStream || response.Count != 2 {
+		t.Errorf("unexpected trailer fields: %q %d", response.EndReason, response.Count)
+	}
+}
+
+func TestRepublishMessage_InvalidRequests(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{"invalid offset", "/api/streams/conn1/vhost1/stream1/messages/abc/republish", "", http.StatusBadRequest},
+		{"invalid body", "/api/streams/conn1/vhost1/stream1/messages/5/republish", "{", http.StatusBadRequest},
+		{"unknown connection", "/api/streams/nonexistent/vhost1/stream1/messages/5/republish", `{"message": {"body": "edited"}}`, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestRepublishedMessage(t *testing.T) {
+	source := rabbitmq.NewMessage(42, &amqp.Message{
+		Data:                  [][]byte{[]byte(`{"order_id": 7}`)},
+		Properties:            &amqp.MessageProperties{ContentType: "application/json", Subject: "orders.created"},
+		ApplicationProperties: map[string]interface{}{"tenant": "acme"},
+	})
+
+	var edits publishMessage
+	if err := decodeJSON([]byte(`{"body": {"order_id": 8}}`), &edits); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := republishedMessage(source, &edits, "conn1/vhost1/orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.GetData()) != `{"order_id": 8}` {
+		t.Errorf("unexpected body: %s", msg.GetData())
+	}
+	if msg.Properties == nil || msg.Properties.Subject != "orders.created" || msg.Properties.ContentType != "application/json" {
+		t.Errorf("expected unedited properties to be kept, got %+v", msg.Properties)
+	}
+	if msg.ApplicationProperties["tenant"] != "acme" {
+		t.Errorf("expected unedited application properties to be kept, got %v", msg.ApplicationProperties)
+	}
+	if msg.Annotations[rabbitmq.SourceStreamAnnotation] != "conn1/vhost1/orders" || msg.Annotations[rabbitmq.SourceOffsetAnnotation] != int64(42) {
+		t.Errorf("unexpected source annotations: %v", msg.Annotations)
+	}
+
+	msg, err = republishedMessage(source, nil, "conn1/vhost1/orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.GetData()) != `{"order_id": 7}` {
+		t.Errorf("expected the source body without edits, got %s", msg.GetData())
+	}
+}
+
+func TestStreamAdmin_InvalidRequests(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"declare without name", "POST", "/api/streams/conn1/vhost1", `{"max_age": "7D"}`, http.StatusBadRequest},
+		{"declare with invalid max_age", "POST", "/api/streams/conn1/vhost1", `{"name": "orders", "max_age": "7 days"}`, http.StatusBadRequest},
+		{"declare with invalid size", "POST", "/api/streams/conn1/vhost1", `{"name": "orders", "max_length_bytes": "lots"}`, http.StatusBadRequest},
+		{"declare on unknown connection", "POST", "/api/streams/nonexistent/vhost1", `{"name": "orders", "max_length_bytes": "20gb"}`, http.StatusNotFound},
+		{"delete without confirmation", "DELETE", "/api/streams/conn1/vhost1/orders", "", http.StatusBadRequest},
+		{"delete with wrong confirmation", "DELETE", "/api/streams/conn1/vhost1/orders?confirm=order", "", http.StatusBadRequest},
+		{"delete on unknown connection", "DELETE", "/api/streams/nonexistent/vhost1/orders?confirm=orders", "", http.StatusNotFound},
+		{"super stream without partitions", "POST", "/api/superstreams/conn1/vhost1", `{"name": "invoices"}`, http.StatusBadRequest},
+		{"super stream with partitions and keys", "POST", "/api/superstreams/conn1/vhost1", `{"name": "invoices", "partitions": 3, "binding_keys": ["eu"]}`, http.StatusBadRequest},
+		{"delete super stream without confirmation", "DELETE", "/api/superstreams/conn1/vhost1/invoices", "", http.StatusBadRequest},
+		{"plan with invalid yaml", "POST", "/api/plan", "streams: [", http.StatusBadRequest},
+		{"plan with invalid settings", "POST", "/api/plan", "streams:\n  - name: orders\n    max_age: a week\n", http.StatusBadRequest},
+		{"plan on unknown connection", "POST", "/api/plan?connection=nonexistent", "streams:\n  - name: orders\n", http.StatusNotFound},
+		{"apply with invalid allow_destructive", "POST", "/api/apply?allow_destructive=maybe", "streams: []\n", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("handler returned wrong status code: got %v want %v (%s)", rr.Code, tt.status, rr.Body.String())
+			}
+		})
+	}
+}
+
+// noStreams is a stats source without streams
+type noStreams struct{}
+
+func (noStreams) ListStreams(context.Context) ([]rabbitmq.Stream, error) { return nil, nil }
+
+func (noStreams) StreamStats(context.Context, rabbitmq.Stream) (*rabbitmq.StreamStats, error) {
+	return nil, nil
+}
+
+func TestGetStreamRates(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := get("/api/streams/dev/vhost1/orders/rates"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without a collector, got %d", rr.Code)
+	}
+
+	collector, err := stats.NewCollector(noStreams{}, config.StatsConfig{
+		Interval:  10 * time.Second,
+		Retention: time.Hour,
+		Streams:   []config.StreamSelector{{Name: "orders"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetStatsCollector(collector)
+
+	if rr := get("/api/streams/dev/vhost1/invoices/rates"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a stream that isn't sampled, got %d", rr.Code)
+	}
+	if rr := get("/api/streams/dev/vhost1/orders/rates?window=-5m"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid window, got %d", rr.Code)
+	}
+
+	rr := get("/api/streams/dev/vhost1/orders/rates?window=30s&window=15m")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Name     string        `json:"name"`
+		Interval string        `json:"interval"`
+		Windows  []stats.Rates `json:"windows"`
+	}
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.Name != "orders" || response.Interval != "10s" || len(response.Windows) != 2 || response.Windows[1].Window != "15m0s" {
+		t.Errorf("unexpected response: %+v", response)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+	router.Use(MetricsMiddleware)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/streams/dev/vhost1/orders/rates", nil))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+
+	// Requests are labelled with the route template, not the stream
+	expected := `rmq_viewer_http_request_duration_seconds_count{code="503",method="GET",route="/api/streams/{connection_id}/{vhost}/{stream_name}/rates"}`
+	if !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("expected %s in:\n%s", expected, rr.Body.String())
+	}
+}
+
+// validServer fills in the defaults of server settings
+func validServer(t *testing.T, server config.ServerConfig) config.ServerConfig {
+	t.Helper()
+	server.Port = 8080
+	full := config.Config{
+		Server:      server,
+		Connections: []config.ConnectionConfig{{ID: "dev", Host: "localhost", Port: 5672, HTTPPort: 15672, Username: "guest"}},
+	}
+	if err := full.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return full.Server
+}
+
+func TestCORS(t *testing.T) {
+	handler := NewHandler(rabbitmq.NewManager([]config.ConnectionConfig{}))
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	// Without allowed origins no CORS headers are sent
+	open := CORSMiddleware(validServer(t, config.ServerConfig{}).CORS)(router)
+	req := httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	rr := httptest.NewRecorder()
+	open.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected no CORS headers by default, got %d %v", rr.Code, rr.Header())
+	}
+
+	server := validServer(t, config.ServerConfig{CORS: config.CORSConfig{
+		AllowedOrigins:   []string{"https://tools.example", "https://*.internal.example"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}})
+	cors := CORSMiddleware(server.CORS)(router)
+
+	for origin, allowed := range map[string]bool{
+		"https://tools.example":        true,
+		"https://ops.internal.example": true,
+		"https://evil.example":         false,
+		"http://tools.example":         false,
+	} {
+		req := httptest.NewRequest("GET", "/health", nil)
+		req.Header.Set("Origin", origin)
+		rr := httptest.NewRecorder()
+		cors.ServeHTTP(rr, req)
+		got := rr.Header().Get("Access-Control-Allow-Origin")
+		if allowed && (got != origin || rr.Header().Get("Access-Control-Allow-Credentials") != "true") {
+			t.Errorf("%s: expected the origin to be allowed with credentials, got %v", origin, rr.Header())
+		}
+		if !allowed && got != "" {
+			t.Errorf("%s: expected no Access-Control-Allow-Origin, got %q", origin, got)
+		}
+		if rr.Code != http.StatusOK || rr.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s: expected the request to be served with Vary: Origin, got %d %v", origin, rr.Code, rr.Header())
+		}
+	}
+
+	preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", "/api/streams", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		rr := httptest.NewRecorder()
+		cors.ServeHTTP(rr, req)
+		return rr
+	}
+	// /api/streams has no OPTIONS route; the preflight is answered before the router
+	rr = preflight("https://tools.example", "DELETE", "content-type, authorization")
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Methods") != "GET, POST, PUT, DELETE" ||
+		rr.Header().Get("Access-Control-Allow-Headers") != "Content-Type, Authorization" || rr.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("expected the preflight to be allowed, got %d %v", rr.Code, rr.Header())
+	}
+	for name, rr := range map[string]*httptest.ResponseRecorder{
+		"origin": preflight("https://evil.example", "GET", ""),
+		"method": preflight("https://tools.example", "PATCH", ""),
+		"header": preflight("https://tools.example", "GET", "X-Custom"),
+	} {
+		if rr.Code != http.StatusForbidden || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s: expected the preflight to be refused, got %d %v", name, rr.Code, rr.Header())
+		}
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	handler := SecurityHeadersMiddleware(validServer(t, config.ServerConfig{}).Headers)(ok)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if csp := rr.Header().Get("Content-Security-Policy"); csp != config.DefaultCSP+"; frame-ancestors 'none'" {
+		t.Errorf("unexpected Content-Security-Policy %q", csp)
+	}
+	if rr.Header().Get("X-Frame-Options") != "DENY" || rr.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("expected frame and content type protections, got %v", rr.Header())
+	}
+	if hsts := rr.Header().Get("Strict-Transport-Security"); hsts != "" {
+		t.Errorf("expected no HSTS over plain HTTP, got %q", hsts)
+	}
+
+	req := httptest.NewRequest("GET", "https://viewer.example/", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if hsts := rr.Header().Get("Strict-Transport-Security"); hsts != "max-age=31536000" {
+		t.Errorf("expected HSTS over HTTPS, got %q", hsts)
+	}
+
+	handler = SecurityHeadersMiddleware(validServer(t, config.ServerConfig{Headers: config.SecurityHeadersConfig{
+		FrameAncestors: []string{"'self'", "https://portal.example"},
+		HSTSMaxAge:     -1,
+	}}).Headers)(ok)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if csp := rr.Header().Get("Content-Security-Policy"); !strings.HasSuffix(csp, "; frame-ancestors 'self' https://portal.example") {
+		t.Errorf("expected the configured frame ancestors, got %q", csp)
+	}
+	if rr.Header().Get("X-Frame-Options") != "" || rr.Header().Get("Strict-Transport-Security") != "" {
+		t.Errorf("expected no X-Frame-Options for several ancestors and no HSTS when disabled, got %v", rr.Header())
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	server := validServer(t, config.ServerConfig{BasePath: "/rmq/"})
+	handler := NewHandler(rabbitmq.NewManager([]config.ConnectionConfig{}))
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router.PathPrefix(server.BasePath).Subrouter())
+	frontend := http.StripPrefix(server.BasePath, FrontendHandler(fstest.MapFS{
+		"index.html": {Data: []byte(`<html><head><link rel="icon" href="/vite.svg"><script type="module" src="/assets/index.js"></script></head><body></body></html>`)},
+		"assets/index.js": {Data: []byte("console.log('viewer')")},
+	}))
+	router.Path(server.BasePath).Handler(frontend)
+	router.PathPrefix(server.BasePath + "/").Handler(frontend)
+	root := ForwardedMiddleware(server)(SecurityHeadersMiddleware(server.Headers)(router))
+
+	get := func(target, remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.RemoteAddr = remoteAddr
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		root.ServeHTTP(rr, req)
+		return rr
+	}
+	const direct, proxy = "203.0.113.5:40000", "127.0.0.1:40000"
+
+	if rr := get("/rmq/health", direct, nil); rr.Code != http.StatusOK {
+		t.Errorf("expected /rmq/health to be served, got %d", rr.Code)
+	}
+	if rr := get("/health", direct, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected routes outside the base path to be missing, got %d", rr.Code)
+	}
+	if rr := get("/rmq/assets/index.js", direct, nil); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "viewer") {
+		t.Errorf("expected the asset under the base path, got %d", rr.Code)
+	}
+	if rr := get("/rmq?stream=orders", direct, nil); rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/rmq/?stream=orders" {
+		t.Errorf("expected a redirect to /rmq/, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	rr := get("/rmq/", direct, nil)
+	page := rr.Body.String()
+	if rr.Code != http.StatusOK || !strings.Contains(page, `<meta name="rmq-viewer-base-path" content="/rmq">`) {
+		t.Fatalf("expected the base path in index.html, got %d %s", rr.Code, page)
+	}
+	if !strings.Contains(page, `src="/rmq/assets/index.js"`) || !strings.Contains(page, `href="/rmq/vite.svg"`) {
+		t.Errorf("expected prefixed links in index.html, got %s", page)
+	}
+
+	// A proxy serving the viewer under its own prefix
+	forwarded := map[string]string{"X-Forwarded-Prefix": "/tools/", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "tools.example"}
+	rr = get("/rmq/", proxy, forwarded)
+	if !strings.Contains(rr.Body.String(), `content="/tools/rmq"`) || !strings.Contains(rr.Body.String(), `src="/tools/rmq/assets/index.js"`) {
+		t.Errorf("expected the forwarded prefix in index.html, got %s", rr.Body.String())
+	}
+	if rr.Header().Get("Strict-Transport-Security") == "" {
+		t.Error("expected HSTS for a request the proxy received over HTTPS")
+	}
+	if rr := get("/rmq", proxy, forwarded); rr.Header().Get("Location") != "/tools/rmq/" {
+		t.Errorf("expected a redirect under the forwarded prefix, got %q", rr.Header().Get("Location"))
+	}
+
+	for _, remoteAddr := range []string{direct, "10.0.0.2:40000"} {
+		rr = get("/rmq/", remoteAddr, forwarded)
+		if !strings.Contains(rr.Body.String(), `content="/rmq"`) || rr.Header().Get("Strict-Transport-Security") != "" {
+			t.Errorf("%s: expected forwarded headers from untrusted addresses to be ignored, got %s", remoteAddr, rr.Body.String())
+		}
+	}
+	rr = get("/rmq/", proxy, map[string]string{"X-Forwarded-Prefix": `/x"><script>alert(1)</script>`})
+	if strings.Contains(rr.Body.String(), "<script>alert") || !strings.Contains(rr.Body.String(), `content="/rmq"`) {
+		t.Errorf("expected an invalid prefix to be ignored, got %s", rr.Body.String())
+	}
+}
+
+func TestListAlerts(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	if rr := get("/api/alerts"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without alert rules, got %d", rr.Code)
+	}
+
+	engine, err := alerts.NewEngine(alerts.NewManagerSource(manager), config.AlertsConfig{Interval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetAlertEngine(engine)
+
+	if rr := get("/api/alerts?state=broken"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid state, got %d", rr.Code)
+	}
+	rr := get("/api/alerts?state=firing")
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("expected an empty list, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{})
+	handler := NewHandler(manager)
+	authenticator, err := auth.New(config.AuthConfig{
+		// bcrypt hash of "secret" at the minimum cost
+		Users:   []config.UserConfig{{Username: "alice", PasswordHash: "$2a$04$dgT92aQQd0frCqNE3fHEJerf5FcFilHyxYjQd7BW9zMiixpxpkAcK"}},
+		Basic:   true,
+		Session: config.SessionConfig{Secret: "test-secret", TTL: time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetAuthenticator(authenticator)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, path := range []string{"/api/connections", "/metrics"} {
+		rr := serve(httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusUnauthorized || !strings.HasPrefix(rr.Header().Get("WWW-Authenticate"), "Basic") {
+			t.Errorf("%s: expected a basic auth challenge, got %d %v", path, rr.Code, rr.Header())
+		}
+	}
+	if rr := serve(httptest.NewRequest("GET", "/health", nil)); rr.Code != http.StatusOK {
+		t.Errorf("expected /health to stay open, got %d", rr.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/api/connections", nil)
+	req.SetBasicAuth("alice", "secret")
+	if rr := serve(req); rr.Code != http.StatusOK {
+		t.Errorf("expected basic credentials to be accepted, got %d", rr.Code)
+	}
+
+	if rr := serve(httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username":"alice","password":"wrong"}`))); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a failed login, got %d", rr.Code)
+	}
+	login := serve(httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username":"alice","password":"secret"}`)))
+	if login.Code != http.StatusOK {
+		t.Fatalf("expected the login to succeed, got %d: %s", login.Code, login.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/auth/session", nil)
+	for _, cookie := range login.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	var session struct {
+		Enabled bool       `json:"enabled"`
+		Methods []string   `json:"methods"`
+		User    *auth.User `json:"user"`
+	}
+	json.NewDecoder(serve(req).Body).Decode(&session)
+	if !session.Enabled || session.User == nil || session.User.Name != "alice" || len(session.Methods) != 2 {
+		t.Errorf("unexpected session: %+v", session)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{{ID: "prod"}, {ID: "dev"}})
+	handler := NewHandler(manager)
+	// bcrypt hash of "secret" at the minimum cost
+	hash := "$2a$04$dgT92aQQd0frCqNE3fHEJerf5FcFilHyxYjQd7BW9zMiixpxpkAcK"
+	authenticator, err := auth.New(config.AuthConfig{
+		Users: []config.UserConfig{
+			{Username: "sam", PasswordHash: hash, Groups: []string{"support"}},
+			{Username: "alice", PasswordHash: hash},
+		},
+		Basic:   true,
+		Session: config.SessionConfig{Secret: "test-secret", TTL: time.Hour},
+		Access: []config.AccessRule{
+			{Groups: []string{"support"}, Permissions: []string{config.PermReadMetadata}, Connection: "prod"},
+			{Users: []string{"alice"}, Permissions: []string{config.PermReadPayload}, Stream: "orders*"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetAuthenticator(authenticator)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	serve := func(user, method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.SetBasicAuth(user, "secret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	var connections []config.ConnectionConfig
+	json.NewDecoder(serve("sam", "GET", "/api/connections").Body).Decode(&connections)
+	if len(connections) != 1 || connections[0].ID != "prod" {
+		t.Errorf("expected support to list only prod, got %+v", connections)
+	}
+
+	streams := []rabbitmq.Stream{
+		{Name: "orders", ConnectionID: "prod", VHost: "main"},
+		{Name: "payments", ConnectionID: "prod", VHost: "main"},
+		{Name: "orders", ConnectionID: "dev", VHost: "/"},
+	}
+	listable := func(user *auth.User) string {
+		req := httptest.NewRequest("GET", "/api/streams", nil)
+		var names []string
+		for _, s := range handler.listableStreams(req.WithContext(auth.WithUser(req.Context(), user)), streams) {
+			names = append(names, s.ConnectionID+"/"+s.Name)
+		}
+		return strings.Join(names, ",")
+	}
+	if names := listable(&auth.User{Name: "sam", Groups: []string{"support"}}); names != "prod/orders,prod/payments" {
+		t.Errorf("expected support to list the prod streams, got %s", names)
+	}
+	if names := listable(&auth.User{Name: "alice"}); names != "prod/orders,dev/orders" {
+		t.Errorf("expected alice to list the orders streams, got %s", names)
+	}
+
+	// Support staff see metadata but never payloads
+	if rr := serve("sam", "GET", "/api/streams/prod/main/orders/settings"); rr.Code == http.StatusForbidden {
+		t.Errorf("expected support to read stream settings, got %d", rr.Code)
+	}
+	for _, path := range []string{
+		"/api/streams/prod/main/orders/messages",
+		"/api/streams/prod/main/orders/messages/0",
+		"/api/streams/prod/main/orders/export",
+	} {
+		if rr := serve("sam", "GET", path); rr.Code != http.StatusForbidden {
+			t.Errorf("%s: expected support to be denied payloads, got %d", path, rr.Code)
+		}
+	}
+	if rr := serve("sam", "DELETE", "/api/streams/prod/main/orders?confirm=orders"); rr.Code != http.StatusForbidden {
+		t.Errorf("expected support to be denied deleting streams, got %d", rr.Code)
+	}
+
+	// Read-payload covers matching streams only
+	if rr := serve("alice", "GET", "/api/streams/prod/main/orders/messages?offset=bad"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected alice to reach the orders messages, got %d", rr.Code)
+	}
+	if rr := serve("alice", "GET", "/api/streams/prod/main/payments/messages"); rr.Code != http.StatusForbidden {
+		t.Errorf("expected alice to be denied payments messages, got %d", rr.Code)
+	}
+
+	// /metrics reports on every stream, which the scoped rules don't cover
+	if rr := serve("sam", "GET", "/metrics"); rr.Code != http.StatusForbidden {
+		t.Errorf("expected /metrics to be denied, got %d", rr.Code)
+	}
+
+	var session struct {
+		Permissions []string `json:"permissions"`
+	}
+	json.NewDecoder(serve("sam", "GET", "/auth/session").Body).Decode(&session)
+	if strings.Join(session.Permissions, ",") != "list,read-metadata" {
+		t.Errorf("unexpected session permissions: %v", session.Permissions)
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	handler := NewHandler(rabbitmq.NewManager([]config.ConnectionConfig{}))
+	redactor, err := redact.New(config.RedactionConfig{Rules: []config.RedactionRule{
+		{Name: "pii", Stream: "orders*", JSONPaths: []string{"$.customer.email"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetRedactor(redactor)
+
+	orders := auth.Resource{Connection: "prod", VHost: "/", Stream: "orders"}
+	request := func(user *auth.User) *http.Request {
+		req := httptest.NewRequest("GET", "/api/streams/prod/%2F/orders/messages", nil)
+		return req.WithContext(auth.WithUser(req.Context(), user))
+	}
+
+	// Without authentication nobody may unmask
+	if handler.masker(request(nil), orders) == nil {
+		t.Error("expected messages to be masked without authentication")
+	}
+	if handler.masker(request(nil), auth.Resource{Connection: "prod", VHost: "/", Stream: "payments"}) != nil {
+		t.Error("expected streams without rules to be shown in full")
+	}
+
+	authenticator, err := auth.New(config.AuthConfig{
+		Proxy: &config.ProxyConfig{TrustedProxies: []string{"10.0.0.0/8"}},
+		Access: []config.AccessRule{
+			{Users: []string{"*"}, Permissions: []string{config.PermAdmin}},
+			{Groups: []string{"privacy"}, Permissions: []string{config.PermUnmask}, Stream: "orders*"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetAuthenticator(authenticator)
+
+	if handler.masker(request(&auth.User{Name: "root"}), orders) == nil {
+		t.Error("expected admin not to imply unmask")
+	}
+	if handler.masker(request(&auth.User{Name: "dpo", Groups: []string{"privacy"}}), orders) != nil {
+		t.Error("expected the unmask permission to bypass redaction")
+	}
+
+	rr := httptest.NewRecorder()
+	if handler.authorizeUnredacted(rr, request(&auth.User{Name: "root"}), orders) || rr.Code != http.StatusForbidden {
+		t.Errorf("expected copying redacted messages to be denied, got %d", rr.Code)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	handler := NewHandler(rabbitmq.NewManager([]config.ConnectionConfig{{ID: "prod"}}))
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/audit", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without an audit file, got %d", rr.Code)
+	}
+
+	auditLog, err := audit.NewLogger(config.AuditConfig{File: filepath.Join(t.TempDir(), "audit.log"), MaxSizeMB: 1, MaxFiles: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+	handler.SetAuditLog(auditLog)
+
+	// bcrypt hash of "secret" at the minimum cost
+	hash := "$2a$04$dgT92aQQd0frCqNE3fHEJerf5FcFilHyxYjQd7BW9zMiixpxpkAcK"
+	authenticator, err := auth.New(config.AuthConfig{
+		Users: []config.UserConfig{
+			{Username: "root", PasswordHash: hash},
+			{Username: "alice", PasswordHash: hash},
+		},
+		Basic:   true,
+		Session: config.SessionConfig{Secret: "test-secret", TTL: time.Hour},
+		Access: []config.AccessRule{
+			{Users: []string{"root"}, Permissions: []string{config.PermAdmin}},
+			{Users: []string{"alice"}, Permissions: []string{config.PermReadPayload}, Stream: "orders*"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetAuthenticator(authenticator)
+
+	serve := func(user, method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.SetBasicAuth(user, "secret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	serve("alice", "GET", "/api/streams/prod/main/payments/messages?offset=5")
+	serve("alice", "GET", "/api/streams/prod/main/orders/messages?offset=5&limit=abc")
+	serve("alice", "GET", "/api/streams/prod/main/orders/stats")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username":"alice","password":"wrong"}`)))
+
+	if rr := serve("alice", "GET", "/api/audit"); rr.Code != http.StatusForbidden {
+		t.Errorf("expected the audit log to be restricted to admins, got %d", rr.Code)
+	}
+
+	rr = serve("root", "GET", "/api/audit?user=alice")
+	var events []audit.Event
+	if err := json.NewDecoder(rr.Body).Decode(&events); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d: %v", rr.Code, err)
+	}
+	want := []struct{ action, stream, outcome string }{
+		{actionQueryAudit, "", audit.OutcomeDenied},
+		{actionLogin, "", audit.OutcomeDenied},
+		{actionReadMessages, "orders", audit.OutcomeFailure},
+		{actionReadMessages, "payments", audit.OutcomeDenied},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), events)
+	}
+	for i, w := range want {
+		if e := events[i]; e.Action != w.action || e.Stream != w.stream || e.Outcome != w.outcome || e.User != "alice" {
+			t.Errorf("event %d: expected %+v, got %+v", i, w, e)
+		}
+	}
+	if e := events[2]; e.Params.Get("limit") != "abc" || e.Status != http.StatusBadRequest || !strings.Contains(e.Error, "Invalid limit parameter") {
+		t.Errorf("expected the parameters and error of the failed read, got %+v", e)
+	}
+	if e := events[3]; e.Connection != "prod" || e.VHost != "main" || e.Params.Get("offset") != "5" {
+		t.Errorf("expected the denied read's stream and range, got %+v", e)
+	}
+
+	rr = serve("root", "GET", "/api/audit?since=yesterday")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid since to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	handler := NewHandler(rabbitmq.NewManager([]config.ConnectionConfig{{ID: "prod"}, {ID: "dev"}}))
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	// bcrypt hash of "secret" at the minimum cost
+	hash := "$2a$04$dgT92aQQd0frCqNE3fHEJerf5FcFilHyxYjQd7BW9zMiixpxpkAcK"
+	authenticator, err := auth.New(config.AuthConfig{
+		Users: []config.UserConfig{
+			{Username: "alice", PasswordHash: hash},
+			{Username: "bob", PasswordHash: hash},
+		},
+		Basic:   true,
+		Session: config.SessionConfig{Secret: "test-secret", TTL: time.Hour},
+		Tokens:  &config.TokensConfig{File: filepath.Join(t.TempDir(), "tokens.json")},
+		Access:  []config.AccessRule{{Users: []string{"*"}, Permissions: []string{config.PermReadMetadata}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetAuthenticator(authenticator)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	basic := func(user, method, path, body string) *http.Request {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.SetBasicAuth(user, "secret")
+		return req
+	}
+	bearer := func(secret, method, path string) *http.Request {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		return req
+	}
+
+	rr := serve(basic("alice", "POST", "/api/tokens", `{"name":"ci","permissions":["list"],"connections":["prod"]}`))
+	var created createTokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil || rr.Code != http.StatusCreated || created.Secret == "" {
+		t.Fatalf("expected the token to be created, got %d: %v", rr.Code, err)
+	}
+
+	var connections []config.ConnectionConfig
+	rr = serve(bearer(created.Secret, "GET", "/api/connections"))
+	json.NewDecoder(rr.Body).Decode(&connections)
+	if rr.Code != http.StatusOK || len(connections) != 1 || connections[0].ID != "prod" {
+		t.Errorf("expected the token to list its connection only, got %d %+v", rr.Code, connections)
+	}
+	req := bearer(created.Secret, "POST", "/api/tokens")
+	req.Body = io.NopCloser(strings.NewReader(`{"name":"nested","permissions":["list"]}`))
+	if rr := serve(req); rr.Code != http.StatusForbidden {
+		t.Errorf("expected tokens not to create tokens, got %d", rr.Code)
+	}
+	rr = serve(bearer("rsv_unknown", "GET", "/api/connections"))
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Header().Get("WWW-Authenticate"), "invalid_token") {
+		t.Errorf("expected an unknown token to be refused, got %d %q", rr.Code, rr.Header().Get("WWW-Authenticate"))
+	}
+
+	var tokens []auth.Token
+	json.NewDecoder(serve(basic("bob", "GET", "/api/tokens", "")).Body).Decode(&tokens)
+	if len(tokens) != 0 {
+		t.Errorf("expected bob not to see alice's tokens, got %+v", tokens)
+	}
+	if rr := serve(basic("bob", "DELETE", "/api/tokens/"+created.Token.ID, "")); rr.Code != http.StatusNotFound {
+		t.Errorf("expected bob not to revoke alice's token, got %d", rr.Code)
+	}
+	json.NewDecoder(serve(basic("alice", "GET", "/api/tokens", "")).Body).Decode(&tokens)
+	if len(tokens) != 1 || tokens[0].LastUsedAt == nil {
+		t.Errorf("expected alice's token with its last use, got %+v", tokens)
+	}
+	if rr := serve(basic("alice", "DELETE", "/api/tokens/"+created.Token.ID, "")); rr.Code != http.StatusOK {
+		t.Errorf("expected alice to revoke her token, got %d", rr.Code)
+	}
+	if rr := serve(bearer(created.Secret, "GET", "/api/connections")); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the revoked token to be refused, got %d", rr.Code)
+	}
+}
+
+func TestBrokerCredentials(t *testing.T) {
+	// The management API knows bob, who may only read the "/" vhost
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "bob" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.EscapedPath() {
+		case "/api/whoami":
+			w.Write([]byte(`{"name":"bob","tags":["monitoring"]}`))
+		case "/api/vhosts":
+			w.Write([]byte(`[{"name":"/"}]`))
+		case "/api/queues/%2F":
+			w.Write([]byte(`[{"name":"orders","vhost":"/","type":"stream"}]`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	manager := rabbitmq.NewManager([]config.ConnectionConfig{{ID: "prod", Host: u.Hostname(), HTTPPort: port, Credentials: config.CredentialsUser}})
+	if err := manager.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewHandler(manager)
+	router := mux.NewRouter()
+	handler.RegisterRoutes(router)
+
+	authenticator, err := auth.New(config.AuthConfig{
+		Users:   []config.UserConfig{{Username: "alice", PasswordHash: "$2a$04$dgT92aQQd0frCqNE3fHEJerf5FcFilHyxYjQd7BW9zMiixpxpkAcK"}},
+		Basic:   true,
+		Broker:  &config.BrokerAuthConfig{},
+		Session: config.SessionConfig{Secret: "test-secret", TTL: time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator.SetBroker(manager)
+	handler.SetAuthenticator(authenticator)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Users without a broker login can't use the connection
+	req := httptest.NewRequest("GET", "/api/streams/prod/main/orders/stats", nil)
+	req.SetBasicAuth("alice", "secret")
+	if rr := serve(req); rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without a broker login, got %d: %s", rr.Code, rr.Body.String())
+	}
+	req = httptest.NewRequest("GET", "/api/streams", nil)
+	req.SetBasicAuth("alice", "secret")
+	if rr := serve(req); rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("expected no streams without a broker login, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	login := serve(httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username":"bob","password":"wrong"}`)))
+	if login.Code != http.StatusUnauthorized {
+		t.Errorf("expected rejected broker credentials to fail, got %d", login.Code)
+	}
+	login = serve(httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"username":"bob","password":"secret"}`)))
+	if login.Code != http.StatusOK {
+		t.Fatalf("expected bob to log in, got %d: %s", login.Code, login.Body.String())
+	}
+	withSession := func(method, path string) *http.Request {
+		req := httptest.NewRequest(method, path, nil)
+		for _, cookie := range login.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+
+	// Bob's calls act as bob, and the broker's refusals are permission errors
+	var streams []rabbitmq.Stream
+	rr := serve(withSession("GET", "/api/streams"))
+	if err := json.NewDecoder(rr.Body).Decode(&streams); err != nil || len(streams) != 1 || streams[0].Name != "orders" {
+		t.Errorf("expected bob to see orders, got %d: %v", rr.Code, streams)
+	}
+	if rr := serve(withSession("GET", "/api/streams/prod/secret/payments/describe")); rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a vhost the broker denies, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Logging out ends the broker session
+	serve(withSession("POST", "/auth/logout"))
+	if rr := serve(withSession("GET", "/api/streams")); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 after logout, got %d", rr.Code)
+	}
+}
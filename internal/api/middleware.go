@@ -3,7 +3,14 @@ package api
 import (
 	"log"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/metrics"
 )
 
 // LoggingMiddleware logs HTTP requests
@@ -26,20 +33,168 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// CORSMiddleware adds CORS headers
-func CORSMiddleware(next http.Handler) http.Handler {
+// MetricsMiddleware observes request latencies by route template, so that
+// stream names don't multiply the series
+func MetricsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		next.ServeHTTP(wrapped, r)
+
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		metrics.HTTPRequestDuration.
+			WithLabelValues(r.Method, route, strconv.Itoa(wrapped.statusCode)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+// CORSMiddleware answers preflight requests and adds CORS headers to the
+// responses to allowed origins. It wraps the whole router so that preflights
+// to routes without an OPTIONS method are answered too. Requests are passed
+// on untouched when no origins are allowed.
+func CORSMiddleware(cfg config.CORSConfig) func(http.Handler) http.Handler {
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	anyHeader := false
+	allowedHeaders := make(map[string]bool)
+	for _, header := range cfg.AllowedHeaders {
+		anyHeader = anyHeader || header == "*"
+		allowedHeaders[http.CanonicalHeaderKey(header)] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		if len(cfg.AllowedOrigins) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			w.Header().Add("Vary", "Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			allowed := originAllowed(cfg.AllowedOrigins, origin)
+			if !preflight {
+				if allowed {
+					setAllowOrigin(w, cfg, origin)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			method := r.Header.Get("Access-Control-Request-Method")
+			if !allowed || !contains(cfg.AllowedMethods, method) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			requested := r.Header.Get("Access-Control-Request-Headers")
+			for _, header := range strings.Split(requested, ",") {
+				header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+				if header != "" && !anyHeader && !allowedHeaders[header] {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+			}
+
+			setAllowOrigin(w, cfg, origin)
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			if anyHeader && requested != "" {
+				w.Header().Set("Access-Control-Allow-Headers", requested)
+			} else {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			if cfg.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// originAllowed matches an origin against the allowed origins and patterns
+func originAllowed(allowed []string, origin string) bool {
+	for _, pattern := range allowed {
+		if pattern == "*" || strings.EqualFold(pattern, origin) {
+			return true
+		}
+		if matched, _ := path.Match(pattern, origin); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func setAllowOrigin(w http.ResponseWriter, cfg config.CORSConfig, origin string) {
+	if contains(cfg.AllowedOrigins, "*") {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if cfg.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
+	}
+	return false
+}
 
-		next.ServeHTTP(w, r)
-	})
+// SecurityHeadersMiddleware adds the Content-Security-Policy, frame and
+// content type protections to every response, and HSTS to HTTPS responses,
+// including those a trusted proxy forwarded from HTTPS
+func SecurityHeadersMiddleware(cfg config.SecurityHeadersConfig) func(http.Handler) http.Handler {
+	csp := strings.TrimRight(strings.TrimSpace(cfg.ContentSecurityPolicy), ";") +
+		"; frame-ancestors " + strings.Join(cfg.FrameAncestors, " ")
+
+	// Browsers without frame-ancestors support fall back to X-Frame-Options,
+	// which only knows these two
+	frameOptions := ""
+	if len(cfg.FrameAncestors) == 1 {
+		switch cfg.FrameAncestors[0] {
+		case "'none'":
+			frameOptions = "DENY"
+		case "'self'":
+			frameOptions = "SAMEORIGIN"
+		}
+	}
+
+	hsts := ""
+	if cfg.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(cfg.HSTSMaxAge.Seconds()))
+		if cfg.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("Content-Security-Policy", csp)
+			if frameOptions != "" {
+				h.Set("X-Frame-Options", frameOptions)
+			}
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("Referrer-Policy", "same-origin")
+			if hsts != "" && isHTTPS(r) {
+				h.Set("Strict-Transport-Security", hsts)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 type responseWriter struct {
@@ -52,3 +207,14 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush lets streaming handlers flush through the wrapper
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
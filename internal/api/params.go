@@ -0,0 +1,294 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/export"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/replay"
+)
+
+// paramError reports an invalid query parameter
+type paramError struct {
+	param string
+	err   error
+}
+
+func (e *paramError) Error() string {
+	return fmt.Sprintf("invalid %s parameter: %v", e.param, e.err)
+}
+
+// respondParamError writes a 400 response for a paramError
+func respondParamError(w http.ResponseWriter, err error) {
+	if pe, ok := err.(*paramError); ok {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid %s parameter", pe.param), pe.err)
+		return
+	}
+	respondError(w, http.StatusBadRequest, "Invalid request", err)
+}
+
+// checkTimeout rejects a requested read timeout above the connection's max_read_timeout
+func checkTimeout(conn *rabbitmq.Connection, timeout time.Duration) error {
+	if limit := conn.MaxReadTimeout(); timeout > limit {
+		return &paramError{"timeout", fmt.Errorf("timeout must not exceed %v", limit)}
+	}
+	return nil
+}
+
+// parseReadOptions parses the offset, limit, direction, cursor and timeout query parameters
+func parseReadOptions(r *http.Request) (rabbitmq.ReadOptions, error) {
+	query := r.URL.Query()
+	opts := rabbitmq.ReadOptions{Limit: 10}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil {
+			return opts, &paramError{"limit", err}
+		}
+		opts.Limit = parsed
+	}
+
+	if timeoutStr := query.Get("timeout"); timeoutStr != "" {
+		parsed, err := time.ParseDuration(timeoutStr)
+		if err == nil && parsed <= 0 {
+			err = fmt.Errorf("timeout must be positive")
+		}
+		if err != nil {
+			return opts, &paramError{"timeout", err}
+		}
+		opts.IdleTimeout = parsed
+	}
+
+	// A cursor fully determines where the page starts
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		cursor, err := rabbitmq.DecodeCursor(cursorStr)
+		if err != nil {
+			return opts, &paramError{"cursor", err}
+		}
+		opts.Offset = cursor.Offset
+		opts.Backward = cursor.Backward
+		return opts, nil
+	}
+
+	switch direction := query.Get("direction"); direction {
+	case "", "forward":
+	case "backward":
+		opts.Backward = true
+	default:
+		return opts, &paramError{"direction", fmt.Errorf("unknown direction %q", direction)}
+	}
+
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		parsed, err := strconv.ParseUint(offsetStr, 10, 64)
+		if err != nil {
+			return opts, &paramError{"offset", err}
+		}
+		opts.Offset = parsed
+	} else if opts.Backward {
+		// Without an offset a backward read returns the newest messages
+		opts.Offset = rabbitmq.OffsetEnd
+	}
+
+	return opts, nil
+}
+
+// parseStreamOptions parses the query parameters of a streaming read.
+// Unlike batch reads, limit defaults to the whole stream.
+func parseStreamOptions(r *http.Request) (rabbitmq.StreamOptions, error) {
+	switch format := r.URL.Query().Get("stream"); format {
+	case streamFormatNDJSON, streamFormatJSON:
+	default:
+		return rabbitmq.StreamOptions{}, &paramError{"stream", fmt.Errorf("unknown format %q", format)}
+	}
+
+	return parseRangeOptions(r)
+}
+
+// parseRangeOptions parses the offset, cursor, limit, end_offset and timeout query parameters
+func parseRangeOptions(r *http.Request) (rabbitmq.StreamOptions, error) {
+	query := r.URL.Query()
+	var opts rabbitmq.StreamOptions
+
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		parsed, err := strconv.ParseUint(offsetStr, 10, 64)
+		if err != nil {
+			return opts, &paramError{"offset", err}
+		}
+		opts.Offset = parsed
+	}
+
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		cursor, err := rabbitmq.DecodeCursor(cursorStr)
+		if err == nil && cursor.Backward {
+			err = fmt.Errorf("backward cursors cannot be streamed")
+		}
+		if err != nil {
+			return opts, &paramError{"cursor", err}
+		}
+		opts.Offset = cursor.Offset
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err == nil && parsed < 0 {
+			err = fmt.Errorf("limit must not be negative")
+		}
+		if err != nil {
+			return opts, &paramError{"limit", err}
+		}
+		opts.Count = parsed
+	}
+
+	if endStr := query.Get("end_offset"); endStr != "" {
+		parsed, err := strconv.ParseUint(endStr, 10, 64)
+		if err == nil && parsed < opts.Offset {
+			err = fmt.Errorf("end_offset must not be before offset")
+		}
+		if err != nil {
+			return opts, &paramError{"end_offset", err}
+		}
+		opts.EndOffset = &parsed
+	}
+
+	if timeoutStr := query.Get("timeout"); timeoutStr != "" {
+		parsed, err := time.ParseDuration(timeoutStr)
+		if err == nil && parsed <= 0 {
+			err = fmt.Errorf("timeout must be positive")
+		}
+		if err != nil {
+			return opts, &paramError{"timeout", err}
+		}
+		opts.IdleTimeout = parsed
+	}
+
+	return opts, nil
+}
+
+// parseExportOptions parses the format, columns, filter, range, limit and timeout
+// query parameters of an export
+func parseExportOptions(r *http.Request) (string, []string, export.Options, error) {
+	query := r.URL.Query()
+	var opts export.Options
+
+	format := query.Get("format")
+	switch format {
+	case "":
+		format = export.FormatNDJSON
+	case export.FormatNDJSON, export.FormatCSV, export.FormatParquet:
+	default:
+		return "", nil, opts, &paramError{"format", fmt.Errorf("unknown format %q", format)}
+	}
+
+	columns, err := export.ParseColumns(query.Get("columns"))
+	if err != nil {
+		return "", nil, opts, &paramError{"columns", err}
+	}
+
+	if opts.Filters, err = export.ParseFilters(query["filter"]); err != nil {
+		return "", nil, opts, &paramError{"filter", err}
+	}
+
+	// Offsets, limit and timeout follow the streaming read parameters
+	streamOpts, err := parseRangeOptions(r)
+	if err != nil {
+		return "", nil, opts, err
+	}
+	opts.Offset = streamOpts.Offset
+	opts.EndOffset = streamOpts.EndOffset
+	opts.Limit = streamOpts.Count
+	opts.IdleTimeout = streamOpts.IdleTimeout
+
+	for _, param := range []string{"from", "to"} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return "", nil, opts, &paramError{param, err}
+		}
+		if param == "from" {
+			opts.From = &t
+		} else {
+			opts.To = &t
+		}
+	}
+	if opts.From != nil && opts.To != nil && !opts.To.After(*opts.From) {
+		return "", nil, opts, &paramError{"to", fmt.Errorf("to must be after from")}
+	}
+
+	return format, columns, opts, nil
+}
+
+// parseReplayOptions parses the sub-range, pacing and deduplication query parameters of a replay
+func parseReplayOptions(r *http.Request) (replay.Options, error) {
+	query := r.URL.Query()
+	opts := replay.Options{ProducerName: query.Get("producer_name")}
+
+	for _, param := range []string{"from_offset", "to_offset"} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		offset, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return opts, &paramError{param, err}
+		}
+		if param == "from_offset" {
+			opts.FromOffset = &offset
+		} else {
+			opts.ToOffset = &offset
+		}
+	}
+
+	for _, param := range []string{"from", "to"} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return opts, &paramError{param, err}
+		}
+		if param == "from" {
+			opts.From = &t
+		} else {
+			opts.To = &t
+		}
+	}
+
+	for _, param := range []string{"rate", "speed"} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err == nil && parsed <= 0 {
+			err = fmt.Errorf("%s must be positive", param)
+		}
+		if err != nil {
+			return opts, &paramError{param, err}
+		}
+		if param == "rate" {
+			opts.Rate = parsed
+		} else {
+			opts.Speed = parsed
+		}
+	}
+
+	switch pace := query.Get("pace"); pace {
+	case "", "fast":
+	case "original":
+		opts.OriginalPace = true
+	default:
+		return opts, &paramError{"pace", fmt.Errorf("unknown pace %q", pace)}
+	}
+
+	if err := opts.Validate(); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/audit"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/plan"
+)
+
+// maxPlanBodyBytes limits the size of a stream definitions file
+const maxPlanBodyBytes = 1 << 20
+
+// applyResponse is the body returned by POST /apply
+type applyResponse struct {
+	*plan.Plan
+	Applied bool `json:"applied"`
+}
+
+// PlanStreams compares the YAML stream definitions in the request body with
+// the broker and returns the changes apply would make
+func (h *Handler) PlanStreams(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.computePlan(w, r)
+	if !ok || !h.authorizePlan(w, r, p, config.PermReadMetadata) {
+		return
+	}
+	respondJSON(w, http.StatusOK, p)
+}
+
+// ApplyStreams computes the plan for the request body and applies it.
+// Destructive changes are refused unless allow_destructive=true is set.
+func (h *Handler) ApplyStreams(w http.ResponseWriter, r *http.Request) {
+	allowDestructive := false
+	if value := r.URL.Query().Get("allow_destructive"); value != "" {
+		var err error
+		if allowDestructive, err = strconv.ParseBool(value); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid allow_destructive parameter", err)
+			return
+		}
+	}
+
+	p, ok := h.computePlan(w, r)
+	if !ok || !h.authorizePlan(w, r, p, config.PermAdmin) {
+		return
+	}
+
+	if err := plan.Apply(r.Context(), p, allowDestructive); err != nil {
+		switch {
+		case errors.Is(err, plan.ErrDestructive), errors.Is(err, plan.ErrConflict):
+			respondError(w, http.StatusConflict, "Plan not applied", err)
+		default:
+			respondAdminError(w, "Failed to apply plan", err)
+		}
+		return
+	}
+
+	applied := make([]string, 0, len(p.Changes))
+	for _, change := range p.Changes {
+		log.Printf("applied: %s %s %s/%s/%s", change.Action, change.Kind, change.Connection, change.VHost, change.Name)
+		applied = append(applied, fmt.Sprintf("%s %s %s/%s/%s", change.Action, change.Kind, change.Connection, change.VHost, change.Name))
+	}
+	audit.Set(r.Context(), "changes", applied)
+	respondJSON(w, http.StatusOK, applyResponse{Plan: p, Applied: true})
+}
+
+// authorizePlan answers 403 unless the user holds a permission on every
+// stream and super stream the plan changes
+func (h *Handler) authorizePlan(w http.ResponseWriter, r *http.Request, p *plan.Plan, perm string) bool {
+	for _, change := range p.Changes {
+		if !h.authorize(w, r, perm, auth.Resource{Connection: change.Connection, VHost: change.VHost, Stream: change.Name}) {
+			return false
+		}
+	}
+	return true
+}
+
+// computePlan parses the request body and compares it with the broker.
+// Entries without a connection use the connection query parameter, or the
+// first configured connection.
+func (h *Handler) computePlan(w http.ResponseWriter, r *http.Request) (*plan.Plan, bool) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPlanBodyBytes))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Failed to read request body", err)
+		return nil, false
+	}
+	spec, err := plan.Parse(data)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid stream definitions", err)
+		return nil, false
+	}
+
+	defaultConnection := r.URL.Query().Get("connection")
+	if defaultConnection == "" {
+		if connections := h.manager.ListConnections(); len(connections) > 0 {
+			defaultConnection = connections[0].ID
+		}
+	}
+
+	var unknown error
+	p, err := plan.Compute(r.Context(), spec, defaultConnection, func(id string) (plan.Broker, error) {
+		conn, err := h.connection(r, id)
+		if err != nil {
+			unknown = err
+			return nil, err
+		}
+		return conn, nil
+	})
+	switch {
+	case err == nil:
+		return p, true
+	case unknown != nil:
+		respondConnectionError(w, unknown)
+	default:
+		respondError(w, http.StatusInternalServerError, "Failed to compute plan", err)
+	}
+	return nil, false
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/audit"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// maxPublishBodyBytes caps the size of a publish request
+const maxPublishBodyBytes = 32 << 20
+
+// maxPublishBatch caps how many messages one publish request may contain
+const maxPublishBatch = 10000
+
+// publishMessage is one message in a publish request
+type publishMessage struct {
+	// Body is sent as is when it is a JSON string and as its JSON encoding otherwise
+	Body json.RawMessage `json:"body"`
+	// BodyBase64 carries a binary body instead of Body
+	BodyBase64            []byte                       `json:"body_base64"`
+	ContentType           string                       `json:"content_type"`
+	Header                *rabbitmq.ExportedHeader     `json:"header"`
+	Properties            *rabbitmq.ExportedProperties `json:"properties"`
+	ApplicationProperties map[string]interface{}       `json:"application_properties"`
+	MessageAnnotations    map[string]interface{}       `json:"message_annotations"`
+}
+
+// publishResponse reports the offsets assigned to the published messages, in
+// request order. Offsets is null when the messages were confirmed but their
+// offsets could not be found, with the reason in OffsetsError.
+type publishResponse struct {
+	Count        int      `json:"count"`
+	Offsets      []uint64 `json:"offsets"`
+	OffsetsError string   `json:"offsets_error,omitempty"`
+}
+
+// PublishMessages publishes one message, a JSON array of messages or an
+// NDJSON upload to a stream and waits for the broker to confirm them
+func (h *Handler) PublishMessages(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	connectionID := vars["connection_id"]
+	vhost := vars["vhost"]
+	streamName := vars["stream_name"]
+
+	conn, ok := h.writableConnection(w, r, connectionID)
+	if !ok {
+		return
+	}
+	if vhost == "" {
+		vhost = conn.DefaultVHost()
+	}
+
+	messages, err := decodePublishRequest(w, r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid messages", err)
+		return
+	}
+
+	offsets, err := conn.PublishMessages(r.Context(), vhost, streamName, messages)
+	if errors.Is(err, rabbitmq.ErrOffsetsUnknown) {
+		// The messages are stored, so the publish must not look failed
+		log.Printf("publish %s/%s: %d messages: %v", vhost, streamName, len(messages), err)
+		audit.Set(r.Context(), "messages", len(messages))
+		respondJSON(w, http.StatusCreated, publishResponse{Count: len(messages), OffsetsError: err.Error()})
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to publish messages", err)
+		return
+	}
+
+	log.Printf("publish %s/%s: %d messages at offsets %d-%d", vhost, streamName, len(offsets), offsets[0], offsets[len(offsets)-1])
+	audit.Set(r.Context(), "messages", len(offsets))
+	audit.Set(r.Context(), "start_offset", offsets[0])
+	audit.Set(r.Context(), "end_offset", offsets[len(offsets)-1])
+	respondJSON(w, http.StatusCreated, publishResponse{Count: len(offsets), Offsets: offsets})
+}
+
+// writableConnection looks up a connection that may be published to and
+// writes a 404 or 403 response when there is none
+func (h *Handler) writableConnection(w http.ResponseWriter, r *http.Request, connectionID string) (*rabbitmq.Connection, bool) {
+	conn, err := h.connection(r, connectionID)
+	if err != nil {
+		respondConnectionError(w, err)
+		return nil, false
+	}
+	if conn.Config.IsReadOnly() {
+		respondError(w, http.StatusForbidden, "Connection is read-only", rabbitmq.ErrReadOnly)
+		return nil, false
+	}
+	return conn, true
+}
+
+// decodePublishRequest reads the messages of a publish request: NDJSON when
+// the content type says so, otherwise a JSON object or array
+func decodePublishRequest(w http.ResponseWriter, r *http.Request) ([]*amqp.Message, error) {
+	body := http.MaxBytesReader(w, r.Body, maxPublishBodyBytes)
+
+	var requests []publishMessage
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-ndjson" {
+		reader := bufio.NewReader(body)
+		for line := 1; ; line++ {
+			data, err := reader.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			if len(bytes.TrimSpace(data)) > 0 {
+				var req publishMessage
+				if decodeErr := decodeJSON(data, &req); decodeErr != nil {
+					return nil, fmt.Errorf("line %d: %w", line, decodeErr)
+				}
+				requests = append(requests, req)
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+	} else {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		data = bytes.TrimSpace(data)
+		if bytes.HasPrefix(data, []byte("[")) {
+			err = decodeJSON(data, &requests)
+		} else {
+			var req publishMessage
+			err = decodeJSON(data, &req)
+			requests = append(requests, req)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(requests) == 0 {
+		return nil, errors.New("no messages to publish")
+	}
+	if len(requests) > maxPublishBatch {
+		return nil, fmt.Errorf("at most %d messages can be published at once", maxPublishBatch)
+	}
+
+	messages := make([]*amqp.Message, len(requests))
+	for i, req := range requests {
+		msg, err := req.amqpMessage()
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		messages[i] = msg
+	}
+	return messages, nil
+}
+
+// decodeJSON decodes keeping integers distinct from floats, so application
+// properties are published with the type they were written with
+func decodeJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// amqpMessage builds the AMQP message to publish
+func (m publishMessage) amqpMessage() (*amqp.Message, error) {
+	var exported rabbitmq.ExportedMessage
+	if err := m.applyTo(&exported); err != nil {
+		return nil, err
+	}
+	if exported.Body == nil {
+		exported.Body = []byte{}
+	}
+	return exported.AMQPMessage(), nil
+}
+
+// applyTo replaces the body and each section of exported that the request
+// sets, leaving the others untouched
+func (m publishMessage) applyTo(exported *rabbitmq.ExportedMessage) error {
+	if m.Header != nil {
+		exported.Header = m.Header
+	}
+	if m.Properties != nil {
+		exported.Properties = m.Properties
+	}
+	if m.ApplicationProperties != nil {
+		exported.ApplicationProperties = m.ApplicationProperties
+	}
+	if m.MessageAnnotations != nil {
+		exported.MessageAnnotations = m.MessageAnnotations
+	}
+
+	hasBody := len(m.Body) > 0 && string(m.Body) != "null"
+	switch {
+	case hasBody && m.BodyBase64 != nil:
+		return errors.New("set either body or body_base64")
+	case m.BodyBase64 != nil:
+		exported.Body = m.BodyBase64
+	case hasBody && strings.HasPrefix(string(m.Body), `"`):
+		var text string
+		if err := json.Unmarshal(m.Body, &text); err != nil {
+			return err
+		}
+		exported.Body = []byte(text)
+	case hasBody:
+		exported.Body = m.Body
+		if m.ContentType == "" && (exported.Properties == nil || exported.Properties.ContentType == "") {
+			m.ContentType = "application/json"
+		}
+	}
+
+	if m.ContentType != "" {
+		if exported.Properties == nil {
+			exported.Properties = &rabbitmq.ExportedProperties{}
+		}
+		exported.Properties.ContentType = m.ContentType
+	}
+	return nil
+}
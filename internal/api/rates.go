@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/stats"
+)
+
+// defaultRateWindows are reported when no window parameter is given
+var defaultRateWindows = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+
+// ratesResponse is the body returned by GET .../rates
+type ratesResponse struct {
+	stats.StreamKey
+	Interval string         `json:"interval"`
+	Windows  []stats.Rates  `json:"windows"`
+	Samples  []stats.Sample `json:"samples,omitempty"`
+}
+
+// SetStatsCollector enables the rates endpoint with the collector's samples
+func (h *Handler) SetStatsCollector(collector *stats.Collector) {
+	h.stats = collector
+}
+
+// GetStreamRates returns a stream's publish, growth and truncation rates
+// over the windows given as repeated window parameters (default 1m, 5m and
+// 1h). samples=true adds the samples of the longest window.
+func (h *Handler) GetStreamRates(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if h.stats == nil {
+		respondError(w, http.StatusServiceUnavailable, "Stats collector disabled",
+			errors.New("set stats.interval in the configuration to collect stream stats"))
+		return
+	}
+
+	key := stats.StreamKey{Connection: vars["connection_id"], VHost: vars["vhost"], Name: vars["stream_name"]}
+	if !h.stats.Selected(key) {
+		respondError(w, http.StatusNotFound, "Stream not sampled",
+			fmt.Errorf("%s is not selected by stats.streams", key))
+		return
+	}
+
+	windows := defaultRateWindows
+	if values := r.URL.Query()["window"]; len(values) > 0 {
+		windows = nil
+		for _, value := range values {
+			window, err := time.ParseDuration(value)
+			if err != nil || window <= 0 {
+				respondError(w, http.StatusBadRequest, "Invalid window parameter",
+					fmt.Errorf("window must be a positive duration such as 5m, got %q", value))
+				return
+			}
+			windows = append(windows, window)
+		}
+	}
+
+	response := ratesResponse{StreamKey: key, Interval: h.stats.Interval().String()}
+	longest := windows[0]
+	for _, window := range windows {
+		response.Windows = append(response.Windows, h.stats.Rates(key, window))
+		if window > longest {
+			longest = window
+		}
+	}
+	if r.URL.Query().Get("samples") == "true" {
+		response.Samples = h.stats.Samples(key, longest)
+	}
+	respondJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/redact"
+)
+
+// SetRedactor masks messages with the redactor's rules before they are returned
+func (h *Handler) SetRedactor(redactor *redact.Redactor) {
+	h.redactor = redactor
+}
+
+// masker returns how the messages of a stream are masked for the user of a
+// request. It is nil when no rule applies or the user may unmask the stream.
+func (h *Handler) masker(r *http.Request, res auth.Resource) *redact.Masker {
+	m := h.redactor.For(res.Connection, res.VHost, res.Stream)
+	if m == nil || h.unmasked(r, res) {
+		return nil
+	}
+	return m
+}
+
+// authorizeUnredacted answers 403 when a stream's messages are masked for the
+// user of a request. Republishes and copies write whole messages to streams
+// the rules may not cover, so they need unmask on a redacted source.
+func (h *Handler) authorizeUnredacted(w http.ResponseWriter, r *http.Request, res auth.Resource) bool {
+	if h.masker(r, res) == nil {
+		return true
+	}
+	respondError(w, http.StatusForbidden, "Permission denied",
+		fmt.Errorf("messages of %s are redacted, %s permission required to copy them", res, config.PermUnmask))
+	return false
+}
+
+// unmasked reports whether the user of a request may see a stream's messages
+// unredacted. Unlike other permissions it is never implied: without
+// authentication nobody holds it.
+func (h *Handler) unmasked(r *http.Request, res auth.Resource) bool {
+	return h.auth != nil && h.auth.Allowed(auth.UserFrom(r.Context()), config.PermUnmask, res)
+}
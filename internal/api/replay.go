@@ -0,0 +1,58 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/audit"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/replay"
+)
+
+// ReplayMessages republishes the NDJSON export in the request body into a stream
+// and responds once the broker has confirmed every message
+func (h *Handler) ReplayMessages(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	connectionID := vars["connection_id"]
+	vhost := vars["vhost"]
+	streamName := vars["stream_name"]
+
+	opts, err := parseReplayOptions(r)
+	if err != nil {
+		respondParamError(w, err)
+		return
+	}
+
+	conn, ok := h.writableConnection(w, r, connectionID)
+	if !ok {
+		return
+	}
+	if vhost == "" {
+		vhost = conn.DefaultVHost()
+	}
+
+	// Large or paced replays outlive the server's timeouts
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		log.Printf("replay %s/%s: could not clear read deadline: %v", vhost, streamName, err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("replay %s/%s: could not clear write deadline: %v", vhost, streamName, err)
+	}
+
+	result, err := replay.Run(r.Context(), r.Body, conn, vhost, streamName, opts)
+	if err != nil {
+		if result != nil {
+			log.Printf("replay %s/%s failed after %d published messages: %v", vhost, streamName, result.Published, err)
+		}
+		respondError(w, http.StatusInternalServerError, "Replay failed", err)
+		return
+	}
+
+	log.Printf("replay %s/%s: published %d of %d messages, %d skipped as duplicates", vhost, streamName, result.Published, result.Read, result.Skipped)
+	audit.Set(r.Context(), "read", result.Read)
+	audit.Set(r.Context(), "published", result.Published)
+	audit.Set(r.Context(), "skipped", result.Skipped)
+	respondJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/audit"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// republishRequest is the body of POST .../messages/{offset}/republish
+type republishRequest struct {
+	// Target defaults to the source stream; empty fields default to the source's
+	Target copyEndpoint `json:"target"`
+	// Message holds the edits; only the body and sections it sets replace the source's
+	Message *publishMessage `json:"message"`
+}
+
+// republishResponse reports where the republished message was written
+type republishResponse struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// Offset is null when the copy was confirmed but its offset could not be
+	// found, with the reason in OffsetError
+	Offset      *uint64 `json:"offset"`
+	OffsetError string  `json:"offset_error,omitempty"`
+}
+
+// RepublishMessage publishes an edited copy of the message at an offset to
+// the same or another stream. The copy keeps every section of the source
+// that was not edited and is annotated with the source stream and offset.
+func (h *Handler) RepublishMessage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	connectionID := vars["connection_id"]
+	vhost := vars["vhost"]
+	streamName := vars["stream_name"]
+
+	offset, err := strconv.ParseUint(vars["offset"], 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid offset parameter", err)
+		return
+	}
+
+	var req republishRequest
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPublishBodyBytes))
+	if err == nil && len(data) > 0 {
+		err = decodeJSON(data, &req)
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	conn, err := h.connection(r, connectionID)
+	if err != nil {
+		respondConnectionError(w, err)
+		return
+	}
+
+	target := req.Target
+	if target.ConnectionID == "" {
+		target.ConnectionID = connectionID
+	}
+	if target.Stream == "" {
+		target.Stream = streamName
+		if target.VHost == "" && target.ConnectionID == connectionID {
+			target.VHost = vhost
+		}
+	}
+	targetConn, ok := h.writableConnection(w, r, target.ConnectionID)
+	if !ok {
+		return
+	}
+	if target.VHost == "" {
+		target.VHost = targetConn.DefaultVHost()
+	}
+	if !h.authorize(w, r, config.PermPublish, auth.Resource{Connection: target.ConnectionID, VHost: target.VHost, Stream: target.Stream}) ||
+		!h.authorizeUnredacted(w, r, auth.Resource{Connection: connectionID, VHost: vhost, Stream: streamName}) {
+		return
+	}
+
+	source, err := conn.ReadMessageFromVHost(r.Context(), vhost, streamName, offset)
+	if errors.Is(err, rabbitmq.ErrMessageNotFound) {
+		respondError(w, http.StatusNotFound, "Message not found", err)
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to read message", err)
+		return
+	}
+
+	sourceRef := fmt.Sprintf("%s/%s/%s", connectionID, vhost, streamName)
+	msg, err := republishedMessage(*source, req.Message, sourceRef)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid message", err)
+		return
+	}
+
+	offsets, err := targetConn.PublishMessages(r.Context(), target.VHost, target.Stream, []*amqp.Message{msg})
+	if err != nil && !errors.Is(err, rabbitmq.ErrOffsetsUnknown) {
+		respondError(w, http.StatusInternalServerError, "Failed to republish message", err)
+		return
+	}
+
+	resp := republishResponse{
+		Source: fmt.Sprintf("%s@%d", sourceRef, offset),
+		Target: fmt.Sprintf("%s/%s/%s", target.ConnectionID, target.VHost, target.Stream),
+	}
+	audit.Set(r.Context(), "target", resp.Target)
+	if err != nil {
+		// The copy is stored, so the republish must not look failed
+		resp.OffsetError = err.Error()
+		log.Printf("republish %s: written to %s: %v", resp.Source, resp.Target, err)
+	} else {
+		resp.Offset = &offsets[0]
+		log.Printf("republish %s: written to %s at offset %d", resp.Source, resp.Target, offsets[0])
+		audit.Set(r.Context(), "target_offset", offsets[0])
+	}
+	respondJSON(w, http.StatusCreated, resp)
+}
+
+// republishedMessage applies the edits to a copy of the source message and
+// links it back to the source
+func republishedMessage(source rabbitmq.Message, edits *publishMessage, sourceRef string) (*amqp.Message, error) {
+	exported := source.Export()
+	if edits != nil {
+		if err := edits.applyTo(&exported); err != nil {
+			return nil, err
+		}
+	}
+
+	if exported.MessageAnnotations == nil {
+		exported.MessageAnnotations = make(map[string]interface{})
+	}
+	exported.MessageAnnotations[rabbitmq.SourceStreamAnnotation] = sourceRef
+	exported.MessageAnnotations[rabbitmq.SourceOffsetAnnotation] = json.Number(strconv.FormatUint(source.Offset, 10))
+
+	return exported.AMQPMessage(), nil
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/audit"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// Streaming response formats selected with the stream query parameter
+const (
+	streamFormatNDJSON = "ndjson"
+	streamFormatJSON   = "json"
+)
+
+// streamMessages writes messages to the response as they are consumed, so ranges
+// of any size are served with constant memory. NDJSON writes one message per line
+// and reports the end reason in the X-End-Reason trailer; JSON writes a single
+// object whose messages array is produced incrementally.
+func (h *Handler) streamMessages(w http.ResponseWriter, r *http.Request, conn *rabbitmq.Connection, vhost, streamName, format string, opts rabbitmq.StreamOptions) {
+	var sw streamWriter
+	switch format {
+	case streamFormatNDJSON:
+		sw = &ndjsonWriter{w: w}
+	case streamFormatJSON:
+		sw = &jsonArrayWriter{w: w}
+	}
+
+	// Large ranges outlive the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("streaming %s/%s: could not clear write deadline: %v", vhost, streamName, err)
+	}
+
+	w.Header().Set("Trailer", "X-End-Reason, X-Message-Count")
+	sw.begin()
+
+	masker := h.masker(r, auth.Resource{Connection: conn.ID, VHost: vhost, Stream: streamName})
+	count := 0
+	var first, last uint64
+	reason, err := conn.StreamMessages(r.Context(), vhost, streamName, opts, func(msg rabbitmq.Message) error {
+		if err := sw.write(masker.Apply(msg)); err != nil {
+			return err
+		}
+		if count == 0 {
+			first = msg.Offset
+		}
+		last = msg.Offset
+		count++
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// Headers are already sent, so the error can only be logged and reported in the trailer
+		log.Printf("streaming %s/%s stopped after %d messages: %v", vhost, streamName, count, err)
+		auditFailure(r, err)
+		reason = "error"
+	}
+	audit.Set(r.Context(), "messages", count)
+	audit.Set(r.Context(), "end_reason", reason)
+	if count > 0 {
+		audit.Set(r.Context(), "start_offset", first)
+		audit.Set(r.Context(), "end_offset", last)
+	}
+
+	sw.end(reason, count)
+	w.Header().Set("X-End-Reason", reason)
+	w.Header().Set("X-Message-Count", strconv.Itoa(count))
+}
+
+// streamWriter encodes a streamed sequence of messages
+type streamWriter interface {
+	begin()
+	write(msg rabbitmq.Message) error
+	end(reason string, count int)
+}
+
+// ndjsonWriter writes one JSON-encoded message per line
+type ndjsonWriter struct {
+	w http.ResponseWriter
+}
+
+func (n *ndjsonWriter) begin() {
+	n.w.Header().Set("Content-Type", "application/x-ndjson")
+	n.w.WriteHeader(http.StatusOK)
+}
+
+func (n *ndjsonWriter) write(msg rabbitmq.Message) error {
+	return json.NewEncoder(n.w).Encode(msg)
+}
+
+func (n *ndjsonWriter) end(reason string, count int) {}
+
+// jsonArrayWriter writes {"messages":[...],"end_reason":...,"count":...} incrementally
+type jsonArrayWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (j *jsonArrayWriter) begin() {
+	j.w.Header().Set("Content-Type", "application/json")
+	j.w.WriteHeader(http.StatusOK)
+	io.WriteString(j.w, `{"messages":[`)
+}
+
+func (j *jsonArrayWriter) write(msg rabbitmq.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if j.written {
+		if _, err := io.WriteString(j.w, ","); err != nil {
+			return err
+		}
+	}
+	j.written = true
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonArrayWriter) end(reason string, count int) {
+	fmt.Fprintf(j.w, `],"end_reason":%q,"count":%d}`+"\n", reason, count)
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/audit"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/auth"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+)
+
+// createTokenResponse carries the secret of a new token, which is never shown again
+type createTokenResponse struct {
+	Token  auth.Token `json:"token"`
+	Secret string     `json:"secret"`
+}
+
+// tokensEnabled answers 503 unless API tokens are enabled
+func (h *Handler) tokensEnabled(w http.ResponseWriter) bool {
+	if h.auth == nil || !h.auth.TokensEnabled() {
+		respondError(w, http.StatusServiceUnavailable, "API tokens disabled", auth.ErrTokensDisabled)
+		return false
+	}
+	return true
+}
+
+// managesAllTokens reports whether the user of a request may see and revoke
+// every token rather than only their personal ones
+func (h *Handler) managesAllTokens(r *http.Request) bool {
+	return h.auth.AllowedEverywhere(auth.UserFrom(r.Context()), config.PermAdmin)
+}
+
+// ListTokens returns the user's personal tokens, or every token to admins
+func (h *Handler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	if !h.tokensEnabled(w) {
+		return
+	}
+	user := auth.UserFrom(r.Context())
+	all := h.managesAllTokens(r)
+
+	tokens := []auth.Token{}
+	for _, t := range h.auth.Tokens() {
+		if all || (t.Kind == auth.TokenPersonal && t.Owner == user.Name) {
+			tokens = append(tokens, t)
+		}
+	}
+	respondJSON(w, http.StatusOK, tokens)
+}
+
+// CreateToken creates a personal token for the user, or a service token, and
+// returns its secret once
+func (h *Handler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	if !h.tokensEnabled(w) {
+		return
+	}
+
+	var req auth.TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	token, secret, err := h.auth.CreateToken(auth.UserFrom(r.Context()), req)
+	switch {
+	case errors.Is(err, auth.ErrInvalidTokenRequest):
+		respondError(w, http.StatusBadRequest, "Invalid token request", err)
+		return
+	case errors.Is(err, auth.ErrTokenForbidden):
+		respondError(w, http.StatusForbidden, "Permission denied", err)
+		return
+	case errors.Is(err, auth.ErrUnauthenticated):
+		respondError(w, http.StatusUnauthorized, "Authentication required", err)
+		return
+	case errors.Is(err, auth.ErrTokenExists):
+		respondError(w, http.StatusConflict, "Token already exists", err)
+		return
+	case err != nil:
+		respondError(w, http.StatusInternalServerError, "Failed to create token", err)
+		return
+	}
+
+	audit.Set(r.Context(), "token_id", token.ID)
+	audit.Set(r.Context(), "token_name", token.Name)
+	audit.Set(r.Context(), "kind", token.Kind)
+	audit.Set(r.Context(), "permissions", token.Permissions)
+	respondJSON(w, http.StatusCreated, createTokenResponse{Token: token, Secret: secret})
+}
+
+// RevokeToken deletes a token. Users may revoke their personal tokens, admins any token.
+func (h *Handler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	if !h.tokensEnabled(w) {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	token, err := h.auth.Token(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Token not found", err)
+		return
+	}
+	user := auth.UserFrom(r.Context())
+	if !h.managesAllTokens(r) && (token.Kind != auth.TokenPersonal || token.Owner != user.Name) {
+		// Other users' tokens are reported as missing, like unknown ones
+		respondError(w, http.StatusNotFound, "Token not found", auth.ErrTokenNotFound)
+		return
+	}
+
+	if err := h.auth.RevokeToken(id); err != nil {
+		if errors.Is(err, auth.ErrTokenNotFound) {
+			respondError(w, http.StatusNotFound, "Token not found", err)
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to revoke token", err)
+		return
+	}
+	audit.Set(r.Context(), "token_name", token.Name)
+	respondJSON(w, http.StatusOK, token)
+}
@@ -0,0 +1,403 @@
+// Package audit keeps an append-only trail of who read, searched, exported
+// and changed what through the viewer. Events are written as JSON lines to a
+// local file that rotates by size, can be queried back, and are optionally
+// published to a RabbitMQ stream as well.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+)
+
+// Outcomes of audited requests
+const (
+	OutcomeSuccess = "success"
+	// OutcomeDenied requests were refused for lack of authentication or permission
+	OutcomeDenied  = "denied"
+	OutcomeFailure = "failure"
+)
+
+// DefaultLimit is how many events a query without a limit returns
+const DefaultLimit = 100
+
+// streamQueueSize bounds the events waiting to be published to the audit
+// stream; the file stays complete when the stream falls behind
+const streamQueueSize = 1024
+
+// Event is one audited request
+type Event struct {
+	Time time.Time `json:"time"`
+	User string    `json:"user,omitempty"`
+	// Token is the API token the request authenticated with, if any
+	Token      string `json:"token,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	Action     string `json:"action"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Connection string `json:"connection,omitempty"`
+	VHost      string `json:"vhost,omitempty"`
+	Stream     string `json:"stream,omitempty"`
+	// Params are the query parameters of the request
+	Params url.Values `json:"params,omitempty"`
+	// Details are added by the handler, such as the offsets a read returned
+	Details    map[string]interface{} `json:"details,omitempty"`
+	Status     int                    `json:"status"`
+	Outcome    string                 `json:"outcome"`
+	Error      string                 `json:"error,omitempty"`
+	DurationMS int64                  `json:"duration_ms"`
+}
+
+// OutcomeOf classifies an HTTP status
+func OutcomeOf(status int) string {
+	switch {
+	case status == 401 || status == 403:
+		return OutcomeDenied
+	case status >= 400:
+		return OutcomeFailure
+	default:
+		return OutcomeSuccess
+	}
+}
+
+type eventKey struct{}
+
+// WithEvent returns a context carrying the event being recorded for a request
+func WithEvent(ctx context.Context, e *Event) context.Context {
+	return context.WithValue(ctx, eventKey{}, e)
+}
+
+// FromContext returns the event being recorded for a request, or nil when the
+// request isn't audited
+func FromContext(ctx context.Context) *Event {
+	e, _ := ctx.Value(eventKey{}).(*Event)
+	return e
+}
+
+// Set adds a detail to the event of an audited request
+func Set(ctx context.Context, key string, value interface{}) {
+	e := FromContext(ctx)
+	if e == nil {
+		return
+	}
+	if e.Details == nil {
+		e.Details = make(map[string]interface{})
+	}
+	e.Details[key] = value
+}
+
+// Sender publishes audit events to the audit stream; *rabbitmq.Publisher is one
+type Sender interface {
+	Send(msg *amqp.Message, publishingID int64) error
+	Close() error
+}
+
+// Logger writes audit events
+type Logger struct {
+	cfg config.AuditConfig
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	// open and events are nil unless events are published to a stream
+	open   func() (Sender, error)
+	events chan Event
+}
+
+// NewLogger opens the audit file for appending. open connects to the audit
+// stream and may be nil when events are only written to the file.
+func NewLogger(cfg config.AuditConfig, open func() (Sender, error)) (*Logger, error) {
+	l := &Logger{cfg: cfg, open: open}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	if open != nil {
+		l.events = make(chan Event, streamQueueSize)
+	}
+	return l, nil
+}
+
+func (l *Logger) openFile() error {
+	f, err := os.OpenFile(l.cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	l.file, l.size = f, info.Size()
+	return nil
+}
+
+// Log records an event, rotating the file when it's full
+func (l *Logger) Log(e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+	if l.size > 0 && l.size+int64(len(line)) > int64(l.cfg.MaxSizeMB)<<20 {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+
+	if l.events != nil {
+		select {
+		case l.events <- e:
+		default:
+			log.Printf("audit: stream queue full, event only written to %s", l.cfg.File)
+		}
+	}
+	return nil
+}
+
+// rotate moves the file to file.1, shifting older files up and dropping the
+// one beyond MaxFiles
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	l.file = nil
+
+	os.Remove(rotatedName(l.cfg.File, l.cfg.MaxFiles))
+	for i := l.cfg.MaxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(rotatedName(l.cfg.File, i), rotatedName(l.cfg.File, i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+	if err := os.Rename(l.cfg.File, rotatedName(l.cfg.File, 1)); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	return l.openFile()
+}
+
+func rotatedName(file string, i int) string {
+	return file + "." + strconv.Itoa(i)
+}
+
+// Run publishes logged events to the audit stream until ctx is done. It
+// returns at once when no stream is configured.
+func (l *Logger) Run(ctx context.Context) {
+	if l.events == nil {
+		return
+	}
+
+	var sender Sender
+	defer func() {
+		if sender != nil {
+			sender.Close()
+		}
+	}()
+	for {
+		var e Event
+		select {
+		case <-ctx.Done():
+			return
+		case e = <-l.events:
+		}
+
+		if sender == nil {
+			var err error
+			if sender, err = l.open(); err != nil {
+				log.Printf("audit: failed to open the audit stream: %v", err)
+				sender = nil
+				continue
+			}
+		}
+		if err := sender.Send(eventMessage(e), -1); err != nil {
+			log.Printf("audit: failed to publish to the audit stream: %v", err)
+			sender.Close()
+			sender = nil
+		}
+	}
+}
+
+// eventMessage encodes an event as a stream message; the action, user and
+// outcome are application properties so consumers can filter on them
+func eventMessage(e Event) *amqp.Message {
+	data, _ := json.Marshal(e)
+	return &amqp.Message{
+		Data:       [][]byte{data},
+		Properties: &amqp.MessageProperties{ContentType: "application/json"},
+		ApplicationProperties: map[string]interface{}{
+			"action":  e.Action,
+			"user":    e.User,
+			"outcome": e.Outcome,
+		},
+	}
+}
+
+// Close closes the audit file
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Query selects audit events. Empty fields match anything.
+type Query struct {
+	User       string
+	Action     string
+	Connection string
+	VHost      string
+	Stream     string
+	Outcome    string
+	Since      time.Time
+	Until      time.Time
+	// Limit caps the events returned, newest first (default: 100)
+	Limit int
+}
+
+func (q Query) matches(e Event) bool {
+	for _, f := range []struct{ want, got string }{
+		{q.User, e.User}, {q.Action, e.Action}, {q.Connection, e.Connection},
+		{q.VHost, e.VHost}, {q.Stream, e.Stream}, {q.Outcome, e.Outcome},
+	} {
+		if f.want != "" && f.want != f.got {
+			return false
+		}
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.Time.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Query returns the matching events of the current and rotated files, newest first
+func (l *Logger) Query(q Query) ([]Event, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+
+	files, err := l.openFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.file.Close()
+		}
+	}()
+
+	events := []Event{}
+	for _, f := range files {
+		matched, err := readEvents(f.name, f.reader, q, q.Limit-len(events))
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, matched...)
+		if len(events) >= q.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+// openFile is an audit file opened for a query
+type openFile struct {
+	name   string
+	file   *os.File
+	reader io.Reader
+}
+
+// openFiles opens the current and rotated files, newest first. Only opening
+// them holds the lock, so that they are one generation of files; reading
+// doesn't block Log, since open files stay readable when rotation renames or
+// removes them. The current file is read up to its size when it was opened.
+func (l *Logger) openFiles() ([]openFile, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var files []openFile
+	for i := 0; i <= l.cfg.MaxFiles; i++ {
+		name := l.cfg.File
+		if i > 0 {
+			name = rotatedName(l.cfg.File, i)
+		}
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			for _, opened := range files {
+				opened.file.Close()
+			}
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		var reader io.Reader = f
+		if i == 0 && l.file != nil {
+			reader = io.LimitReader(f, l.size)
+		}
+		files = append(files, openFile{name: name, file: f, reader: reader})
+	}
+	return files, nil
+}
+
+// readEvents returns the newest limit matching events of a file, newest first.
+// Lines that don't parse are skipped.
+func readEvents(file string, r io.Reader, q Query, limit int) ([]Event, error) {
+	var matched []Event
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || !q.matches(e) {
+			continue
+		}
+		matched = append(matched, e)
+		// Keep the newest ones only
+		if len(matched) >= 2*limit {
+			matched = append(matched[:0], matched[len(matched)-limit:]...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	if len(matched) > limit {
+		matched = matched[len(matched)-limit:]
+	}
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	return matched, nil
+}
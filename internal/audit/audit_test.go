@@ -0,0 +1,198 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+)
+
+func newTestLogger(t *testing.T, open func() (Sender, error)) *Logger {
+	t.Helper()
+	l, err := NewLogger(config.AuditConfig{File: filepath.Join(t.TempDir(), "audit.log"), MaxSizeMB: 1, MaxFiles: 2}, open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestQuery(t *testing.T) {
+	l := newTestLogger(t, nil)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, e := range []Event{
+		{User: "alice", Action: "read_messages", Stream: "orders", Outcome: OutcomeSuccess},
+		{User: "bob", Action: "export", Stream: "orders", Outcome: OutcomeSuccess},
+		{User: "alice", Action: "delete_stream", Stream: "payments", Outcome: OutcomeDenied},
+		{User: "alice", Action: "read_messages", Stream: "payments", Outcome: OutcomeSuccess},
+	} {
+		e.Time = start.Add(time.Duration(i) * time.Minute)
+		if err := l.Log(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"all, newest first", Query{}, []string{"read_messages", "delete_stream", "export", "read_messages"}},
+		{"by user", Query{User: "alice"}, []string{"read_messages", "delete_stream", "read_messages"}},
+		{"by action and stream", Query{Action: "read_messages", Stream: "orders"}, []string{"read_messages"}},
+		{"by outcome", Query{Outcome: OutcomeDenied}, []string{"delete_stream"}},
+		{"by time", Query{Since: start.Add(time.Minute), Until: start.Add(2 * time.Minute)}, []string{"delete_stream", "export"}},
+		{"limited", Query{Limit: 2}, []string{"read_messages", "delete_stream"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := l.Query(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actions []string
+			for _, e := range events {
+				actions = append(actions, e.Action)
+			}
+			if strings.Join(actions, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("expected %v, got %v", tt.want, actions)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	l := newTestLogger(t, nil)
+
+	// Each event takes a third of the 1MB limit, so every file holds two
+	padding := strings.Repeat("x", 350<<10)
+	for i := 0; i < 10; i++ {
+		if err := l.Log(Event{Action: "export", Details: map[string]interface{}{"i": i, "padding": padding}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{l.cfg.File, l.cfg.File + ".1", l.cfg.File + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 1<<20 {
+			t.Errorf("%s: expected at most 1MB, got %d bytes", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(l.cfg.File + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected files beyond max_files to be removed, got %v", err)
+	}
+
+	// Queries read the rotated files too, newest first
+	events, err := l.Query(Query{Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 5 {
+		t.Fatalf("expected 5 events, got %d", len(events))
+	}
+	for i, e := range events {
+		if got := e.Details["i"].(float64); int(got) != 9-i {
+			t.Errorf("event %d: expected i=%d, got %v", i, 9-i, got)
+		}
+	}
+}
+
+func TestQuery_DuringRotation(t *testing.T) {
+	l := newTestLogger(t, nil)
+	padding := strings.Repeat("x", 350<<10)
+	for i := 0; i < 4; i++ {
+		if err := l.Log(Event{Action: "export", Details: map[string]interface{}{"i": i, "padding": padding}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Files opened for a query are read as they were, however much is
+	// logged and rotated meanwhile
+	files, err := l.openFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 4; i < 10; i++ {
+		if err := l.Log(Event{Action: "export", Details: map[string]interface{}{"i": i, "padding": padding}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []int
+	for _, f := range files {
+		events, err := readEvents(f.name, f.reader, Query{}, DefaultLimit)
+		f.file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range events {
+			got = append(got, int(e.Details["i"].(float64)))
+		}
+	}
+	if len(got) != 4 || got[0] != 3 || got[3] != 0 {
+		t.Errorf("expected events 3 to 0, got %v", got)
+	}
+}
+
+type fakeSender struct {
+	mu   sync.Mutex
+	sent []*amqp.Message
+}
+
+func (f *fakeSender) Send(msg *amqp.Message, publishingID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fakeSender) Close() error { return nil }
+
+func (f *fakeSender) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.sent)
+}
+
+func TestRun_PublishesToStream(t *testing.T) {
+	sender := &fakeSender{}
+	l := newTestLogger(t, func() (Sender, error) { return sender, nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Run(ctx)
+	}()
+
+	l.Log(Event{User: "alice", Action: "publish", Outcome: OutcomeSuccess})
+	deadline := time.Now().Add(2 * time.Second)
+	for sender.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if sender.count() != 1 {
+		t.Fatalf("expected one published event, got %d", sender.count())
+	}
+	msg := sender.sent[0]
+	var e Event
+	if err := json.Unmarshal(msg.GetData(), &e); err != nil || e.User != "alice" {
+		t.Errorf("unexpected message body %s: %v", msg.GetData(), err)
+	}
+	if msg.ApplicationProperties["action"] != "publish" {
+		t.Errorf("expected the action as an application property, got %v", msg.ApplicationProperties)
+	}
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+)
+
+// Resource is what a request acts on. Empty fields stand for anything within
+// the others: a connection may be listed when any of its streams may be.
+type Resource struct {
+	Connection string
+	VHost      string
+	Stream     string
+}
+
+func (r Resource) String() string {
+	field := func(s string) string {
+		if s == "" {
+			return "*"
+		}
+		return s
+	}
+	return fmt.Sprintf("%s/%s/%s", field(r.Connection), field(r.VHost), field(r.Stream))
+}
+
+// Allowed reports whether a user holds a permission on a resource. Without
+// access rules every authenticated user holds every permission but unmask.
+// Users of personal tokens hold what both the rules and the token's scope
+// grant, and users of service tokens what the scope grants.
+func (a *Authenticator) Allowed(user *User, perm string, res Resource) bool {
+	if user == nil {
+		return false
+	}
+	if user.token != nil {
+		if !tokenAllows(user.token, perm, res) {
+			return false
+		}
+		if user.token.Kind == TokenService {
+			return true
+		}
+	}
+	if len(a.cfg.Access) == 0 {
+		return perm != config.PermUnmask
+	}
+	for _, rule := range a.cfg.Access {
+		if appliesTo(rule, user) && grants(rule, perm) &&
+			matches(rule.Connection, res.Connection) && matches(rule.VHost, res.VHost) && matches(rule.Stream, res.Stream) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedEverywhere reports whether a user holds a permission on every stream
+// of every connection, as needed for endpoints reporting on all of them
+func (a *Authenticator) AllowedEverywhere(user *User, perm string) bool {
+	if user == nil {
+		return false
+	}
+	if user.token != nil {
+		if len(user.token.Connections) > 0 || !tokenAllows(user.token, perm, Resource{}) {
+			return false
+		}
+		if user.token.Kind == TokenService {
+			return true
+		}
+	}
+	if len(a.cfg.Access) == 0 {
+		return perm != config.PermUnmask
+	}
+	for _, rule := range a.cfg.Access {
+		if appliesTo(rule, user) && grants(rule, perm) &&
+			unrestricted(rule.Connection) && unrestricted(rule.VHost) && unrestricted(rule.Stream) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions lists the permissions a user holds on a resource
+func (a *Authenticator) Permissions(user *User, res Resource) []string {
+	var perms []string
+	for _, perm := range []string{config.PermList, config.PermReadMetadata, config.PermReadPayload, config.PermPublish, config.PermAdmin, config.PermUnmask} {
+		if a.Allowed(user, perm, res) {
+			perms = append(perms, perm)
+		}
+	}
+	return perms
+}
+
+func appliesTo(rule config.AccessRule, user *User) bool {
+	for _, name := range rule.Users {
+		if name == "*" || name == user.Name {
+			return true
+		}
+	}
+	for _, group := range rule.Groups {
+		for _, g := range user.Groups {
+			if group == g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// grants reports whether a rule grants a permission. Admin grants all of them
+// but unmask, which must be granted explicitly, and the others grant list.
+func grants(rule config.AccessRule, perm string) bool {
+	for _, p := range rule.Permissions {
+		switch {
+		case p == perm:
+			return true
+		case p == config.PermUnmask:
+		case perm == config.PermList, p == config.PermAdmin && perm != config.PermUnmask:
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether a rule pattern matches a resource field; empty
+// fields stand for anything
+func matches(pattern, value string) bool {
+	return value == "" || config.MatchPattern(pattern, value)
+}
+
+func unrestricted(pattern string) bool {
+	return pattern == "" || pattern == "*"
+}
@@ -0,0 +1,300 @@
+// Package auth authenticates viewer users: static accounts logging in with a
+// password or HTTP basic auth, OpenID Connect logins, users named by a
+// trusted reverse proxy or their TLS client certificate, and users logging
+// in with their own RabbitMQ credentials. Logins are kept in signed session
+// cookies.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// Authentication methods, as reported in User.Method
+const (
+	MethodPassword = "password"
+	MethodBasic    = "basic"
+	MethodOIDC     = "oidc"
+	MethodProxy    = "proxy"
+	// MethodBroker users logged in with their RabbitMQ credentials
+	MethodBroker = "broker"
+	// MethodClientCert users presented a TLS client certificate
+	MethodClientCert = "client_cert"
+)
+
+var (
+	// ErrUnauthenticated is returned when a request carries no credentials
+	ErrUnauthenticated = errors.New("authentication required")
+	// ErrInvalidCredentials is returned for an unknown user or a wrong password
+	ErrInvalidCredentials = errors.New("invalid username or password")
+)
+
+// dummyHash is compared against when a username is unknown, so that unknown
+// and known users take as long to reject
+var dummyHash = []byte("$2a$10$Qf/UyYwwAuewcZ1bE2/WiOAG/.uvZurq2Q.W.rV/w9IHsGfETwpQC")
+
+// User is an authenticated user
+type User struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups,omitempty"`
+	// Method is how the user authenticated: password, basic, oidc, proxy,
+	// broker, client_cert or token
+	Method string `json:"method"`
+	// TokenID is the API token the user authenticated with, if any
+	TokenID string `json:"token_id,omitempty"`
+	// BrokerSession is the session of broker connections acting with the
+	// user's own RabbitMQ credentials, if any
+	BrokerSession string `json:"-"`
+	// token scopes what the user may do when authenticated with a token
+	token *Token
+}
+
+type contextKey struct{}
+
+// WithUser returns a context carrying the user
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// UserFrom returns the user of a request, or nil when authentication is off
+func UserFrom(ctx context.Context) *User {
+	user, _ := ctx.Value(contextKey{}).(*User)
+	return user
+}
+
+// Authenticator checks the credentials of requests against the configured methods
+type Authenticator struct {
+	cfg      config.AuthConfig
+	users    map[string]config.UserConfig
+	trusted  []*net.IPNet
+	sessions *signer
+	oidc     *oidcLogin
+	// tokens is nil unless API tokens are enabled
+	tokens *tokenStore
+	// broker is nil unless users log in with their RabbitMQ credentials
+	broker Broker
+	now    func() time.Time
+
+	mu sync.Mutex
+	// revoked holds the IDs of sessions that logged out with their expiry
+	revoked map[string]int64
+}
+
+// New creates an authenticator for the configured methods
+func New(cfg config.AuthConfig) (*Authenticator, error) {
+	secret := []byte(cfg.Session.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		log.Println("auth: no session secret configured, sessions end when the server restarts")
+	}
+
+	a := &Authenticator{
+		cfg:      cfg,
+		users:    make(map[string]config.UserConfig),
+		sessions: &signer{secret: secret},
+		now:      time.Now,
+		revoked:  make(map[string]int64),
+	}
+	for _, user := range cfg.Users {
+		a.users[user.Username] = user
+	}
+	if cfg.Proxy != nil {
+		for _, cidr := range cfg.Proxy.TrustedProxies {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %s: %w", cidr, err)
+			}
+			a.trusted = append(a.trusted, network)
+		}
+	}
+	if cfg.OIDC != nil {
+		a.oidc = &oidcLogin{cfg: *cfg.OIDC}
+	}
+	if cfg.Tokens != nil {
+		store, err := openTokenStore(*cfg.Tokens)
+		if err != nil {
+			return nil, err
+		}
+		a.tokens = store
+	}
+	return a, nil
+}
+
+// Methods lists the enabled authentication methods
+func (a *Authenticator) Methods() []string {
+	var methods []string
+	if len(a.users) > 0 {
+		methods = append(methods, MethodPassword)
+	}
+	if a.cfg.Basic {
+		methods = append(methods, MethodBasic)
+	}
+	if a.oidc != nil {
+		methods = append(methods, MethodOIDC)
+	}
+	if a.cfg.Proxy != nil {
+		methods = append(methods, MethodProxy)
+	}
+	if a.broker != nil {
+		methods = append(methods, MethodBroker)
+	}
+	if a.cfg.ClientCert != nil {
+		methods = append(methods, MethodClientCert)
+	}
+	if a.tokens != nil {
+		methods = append(methods, MethodToken)
+	}
+	return methods
+}
+
+// BasicEnabled reports whether HTTP basic credentials are accepted
+func (a *Authenticator) BasicEnabled() bool {
+	return a.cfg.Basic
+}
+
+// Authenticate returns the user of a request from its bearer token, session
+// cookie, basic credentials, client certificate or proxy headers, in that order
+func (a *Authenticator) Authenticate(r *http.Request) (*User, error) {
+	if secret, ok := bearerToken(r); ok && a.tokens != nil {
+		return a.tokenUser(r, secret)
+	}
+
+	if user, ok := a.sessionUser(r); ok {
+		return user, nil
+	}
+
+	if username, password, ok := r.BasicAuth(); ok && a.cfg.Basic {
+		user, err := a.checkPassword(username, password)
+		if err != nil {
+			return nil, err
+		}
+		user.Method = MethodBasic
+		return user, nil
+	}
+
+	if user, ok := a.certUser(r); ok {
+		return user, nil
+	}
+
+	if user, ok := a.proxyUser(r); ok {
+		return user, nil
+	}
+	return nil, ErrUnauthenticated
+}
+
+// Login checks a static user's password, or the RabbitMQ credentials of other
+// users when broker logins are enabled, and starts a session
+func (a *Authenticator) Login(ctx context.Context, w http.ResponseWriter, username, password string) (*User, error) {
+	user, err := a.checkPassword(username, password)
+	if _, static := a.users[username]; err != nil && !static && a.broker != nil {
+		return a.loginBroker(ctx, w, rabbitmq.Credentials{Username: username, Password: password})
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.Method = MethodPassword
+	if err := a.startSession(ctx, w, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Logout ends the session of the request, closing its broker session. The
+// session is revoked, so a copy of the cookie doesn't keep it alive.
+func (a *Authenticator) Logout(w http.ResponseWriter, r *http.Request) {
+	if s, ok := a.session(r); ok {
+		if s.Broker != "" && a.broker != nil {
+			a.broker.CloseSession(s.Broker)
+		}
+		a.revoke(s)
+	}
+	a.clearCookie(r.Context(), w, sessionCookie)
+}
+
+func (a *Authenticator) checkPassword(username, password string) (*User, error) {
+	account, ok := a.users[username]
+	hash := dummyHash
+	if ok {
+		hash = []byte(account.PasswordHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return &User{Name: account.Username, Groups: account.Groups}, nil
+}
+
+// proxyUser trusts the proxy headers of requests from a trusted proxy
+func (a *Authenticator) proxyUser(r *http.Request) (*User, bool) {
+	proxy := a.cfg.Proxy
+	if proxy == nil {
+		return nil, false
+	}
+	name := strings.TrimSpace(r.Header.Get(proxy.UserHeader))
+	if name == "" || !a.fromTrustedProxy(r) {
+		return nil, false
+	}
+
+	user := &User{Name: name, Method: MethodProxy}
+	for _, group := range strings.Split(r.Header.Get(proxy.GroupsHeader), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			user.Groups = append(user.Groups, group)
+		}
+	}
+	return user, true
+}
+
+// certUser names the user after the verified client certificate of the
+// connection; its organizational units are the groups
+func (a *Authenticator) certUser(r *http.Request) (*User, bool) {
+	cert := a.cfg.ClientCert
+	if cert == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+
+	name := leaf.Subject.CommonName
+	if cert.Username == config.CertUsernameEmail {
+		name = ""
+		if len(leaf.EmailAddresses) > 0 {
+			name = leaf.EmailAddresses[0]
+		}
+	}
+	if name == "" {
+		return nil, false
+	}
+	groups := append([]string(nil), leaf.Subject.OrganizationalUnit...)
+	return &User{Name: name, Groups: groups, Method: MethodClientCert}, true
+}
+
+func (a *Authenticator) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range a.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
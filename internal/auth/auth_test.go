@@ -0,0 +1,668 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// secretHash is the bcrypt hash of "secret" at the minimum cost
+const secretHash = "$2a$04$dgT92aQQd0frCqNE3fHEJerf5FcFilHyxYjQd7BW9zMiixpxpkAcK"
+
+func newTestAuthenticator(t *testing.T, cfg config.AuthConfig) *Authenticator {
+	t.Helper()
+	full := config.Config{
+		Server:      config.ServerConfig{Port: 8080, Auth: cfg},
+		Connections: []config.ConnectionConfig{{ID: "dev", Host: "localhost", Port: 5672, HTTPPort: 15672, Username: "guest"}},
+	}
+	if err := full.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(full.Server.Auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// cookiesOf returns a request carrying the cookies set on a response
+func cookiesOf(rr *httptest.ResponseRecorder, target string) *http.Request {
+	req := httptest.NewRequest("GET", target, nil)
+	for _, cookie := range rr.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
+func TestAuthenticate_StaticUsers(t *testing.T) {
+	a := newTestAuthenticator(t, config.AuthConfig{
+		Users:   []config.UserConfig{{Username: "alice", PasswordHash: secretHash, Groups: []string{"ops"}}},
+		Basic:   true,
+		Session: config.SessionConfig{Secret: "test-secret", TTL: time.Hour},
+	})
+
+	req := httptest.NewRequest("GET", "/api/streams", nil)
+	if _, err := a.Authenticate(req); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected ErrUnauthenticated without credentials, got %v", err)
+	}
+
+	req.SetBasicAuth("alice", "secret")
+	user, err := a.Authenticate(req)
+	if err != nil || user.Name != "alice" || user.Method != MethodBasic || len(user.Groups) != 1 {
+		t.Errorf("expected alice through basic auth, got %+v, %v", user, err)
+	}
+	req.SetBasicAuth("alice", "wrong")
+	if _, err := a.Authenticate(req); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials for a wrong password, got %v", err)
+	}
+	req.SetBasicAuth("mallory", "secret")
+	if _, err := a.Authenticate(req); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected ErrInvalidCredentials for an unknown user, got %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	if _, err := a.Login(context.Background(), rr, "alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected the login to fail, got %v", err)
+	}
+	rr = httptest.NewRecorder()
+	if _, err := a.Login(context.Background(), rr, "alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	cookie := rr.Result().Cookies()[0]
+	if cookie.Name != sessionCookie || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.Path != "/" {
+		t.Errorf("unexpected session cookie: %+v", cookie)
+	}
+
+	// Under a base path the cookie is scoped to it
+	scoped := httptest.NewRecorder()
+	if _, err := a.Login(WithCookiePath(context.Background(), "/tools/rmq"), scoped, "alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if path := scoped.Result().Cookies()[0].Path; path != "/tools/rmq" {
+		t.Errorf("expected the cookie scoped to the base path, got %q", path)
+	}
+
+	user, err = a.Authenticate(cookiesOf(rr, "/api/streams"))
+	if err != nil || user.Name != "alice" || user.Method != MethodPassword {
+		t.Errorf("expected the session to authenticate alice, got %+v, %v", user, err)
+	}
+
+	// Edited cookies are rejected
+	forged := httptest.NewRequest("GET", "/api/streams", nil)
+	payload, mac, _ := strings.Cut(cookie.Value, ".")
+	data, _ := base64.RawURLEncoding.DecodeString(payload)
+	data = []byte(strings.Replace(string(data), "alice", "admin", 1))
+	forged.AddCookie(&http.Cookie{Name: sessionCookie, Value: base64.RawURLEncoding.EncodeToString(data) + "." + mac})
+	if _, err := a.Authenticate(forged); err == nil {
+		t.Error("expected an edited session cookie to be rejected")
+	}
+
+	// Sessions end after the TTL
+	a.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := a.Authenticate(cookiesOf(rr, "/api/streams")); err == nil {
+		t.Error("expected an expired session to be rejected")
+	}
+}
+
+func TestSessionRevocation(t *testing.T) {
+	a := newTestAuthenticator(t, config.AuthConfig{
+		Users:   []config.UserConfig{{Username: "alice", PasswordHash: secretHash, Groups: []string{"ops"}}},
+		Session: config.SessionConfig{Secret: "test-secret", TTL: time.Hour},
+	})
+	login := func() *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		if _, err := a.Login(context.Background(), rr, "alice", "secret"); err != nil {
+			t.Fatal(err)
+		}
+		return rr
+	}
+	if rr := login(); rr.Result().Cookies()[0].Secure {
+		t.Error("expected plain cookies without server.tls")
+	}
+
+	// A copy of the cookie doesn't outlive the logout
+	first, second := login(), login()
+	a.Logout(httptest.NewRecorder(), cookiesOf(first, "/api/logout"))
+	if _, err := a.Authenticate(cookiesOf(first, "/api/streams")); err == nil {
+		t.Error("expected the logged out session to be rejected")
+	}
+	if _, err := a.Authenticate(cookiesOf(second, "/api/streams")); err != nil {
+		t.Errorf("expected the other session to remain, got %v", err)
+	}
+
+	// Changed groups apply to existing sessions
+	a.users["alice"] = config.UserConfig{Username: "alice", PasswordHash: secretHash, Groups: []string{"admins"}}
+	if user, err := a.Authenticate(cookiesOf(second, "/api/streams")); err != nil || len(user.Groups) != 1 || user.Groups[0] != "admins" {
+		t.Errorf("expected the current groups, got %+v, %v", user, err)
+	}
+
+	// A changed password or a removed user ends the sessions
+	a.users["alice"] = config.UserConfig{Username: "alice", PasswordHash: "$2a$04$changed", Groups: []string{"admins"}}
+	if _, err := a.Authenticate(cookiesOf(second, "/api/streams")); err == nil {
+		t.Error("expected the session to end with the password change")
+	}
+	a.users["alice"] = config.UserConfig{Username: "alice", PasswordHash: secretHash}
+	third := login()
+	delete(a.users, "alice")
+	if _, err := a.Authenticate(cookiesOf(third, "/api/streams")); err == nil {
+		t.Error("expected the session of a removed user to be rejected")
+	}
+
+	// Cookies are secure by default when the server serves TLS
+	full := config.Config{
+		Server:      config.ServerConfig{Port: 8443, TLS: &config.TLSConfig{CertFile: "tls.crt", KeyFile: "tls.key"}},
+		Connections: []config.ConnectionConfig{{ID: "dev", Host: "localhost", Port: 5672, HTTPPort: 15672, Username: "guest"}},
+	}
+	if err := full.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !full.Server.Auth.Session.SecureCookies() {
+		t.Error("expected secure cookies with server.tls")
+	}
+}
+
+func TestAuthenticate_Proxy(t *testing.T) {
+	a := newTestAuthenticator(t, config.AuthConfig{
+		Proxy: &config.ProxyConfig{TrustedProxies: []string{"10.0.0.0/8"}},
+	})
+
+	req := httptest.NewRequest("GET", "/api/streams", nil)
+	req.RemoteAddr = "10.1.2.3:40000"
+	req.Header.Set("X-Forwarded-User", "bob")
+	req.Header.Set("X-Forwarded-Groups", "ops, readers")
+	user, err := a.Authenticate(req)
+	if err != nil || user.Name != "bob" || user.Method != MethodProxy || len(user.Groups) != 2 || user.Groups[1] != "readers" {
+		t.Errorf("expected bob from the trusted proxy, got %+v, %v", user, err)
+	}
+
+	req.RemoteAddr = "192.168.1.10:40000"
+	if _, err := a.Authenticate(req); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected the header to be ignored from an untrusted address, got %v", err)
+	}
+}
+
+func TestAuthenticate_ClientCert(t *testing.T) {
+	full := config.Config{
+		Server: config.ServerConfig{
+			Port: 8443,
+			TLS:  &config.TLSConfig{CertFile: "tls.crt", KeyFile: "tls.key", ClientCAFile: "clients.pem"},
+			Auth: config.AuthConfig{ClientCert: &config.ClientCertAuthConfig{}},
+		},
+		Connections: []config.ConnectionConfig{{ID: "dev", Host: "localhost", Port: 5672, HTTPPort: 15672, Username: "guest"}},
+	}
+	if err := full.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(full.Server.Auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "carol", OrganizationalUnit: []string{"ops", "readers"}},
+		EmailAddresses: []string{"carol@example.com"},
+	}
+	req := httptest.NewRequest("GET", "/api/streams", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+	if _, err := a.Authenticate(req); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected an unverified certificate to be ignored, got %v", err)
+	}
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{leaf}}
+	user, err := a.Authenticate(req)
+	if err != nil || user.Name != "carol" || user.Method != MethodClientCert || len(user.Groups) != 2 || user.Groups[0] != "ops" {
+		t.Errorf("expected carol from the client certificate, got %+v, %v", user, err)
+	}
+
+	a.cfg.ClientCert.Username = config.CertUsernameEmail
+	if user, err := a.Authenticate(req); err != nil || user.Name != "carol@example.com" {
+		t.Errorf("expected the certificate's email address as the username, got %+v, %v", user, err)
+	}
+}
+
+// mockProvider is a minimal OIDC provider issuing ID tokens for any code
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// nonce and challenge are those of the login in progress
+	nonce, challenge string
+	claims           map[string]interface{}
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{t: t, key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != p.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":   p.server.URL,
+		"aud":   "viewer",
+		"sub":   "user-1",
+		"nonce": p.nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: p.key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test"))
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	payload, _ := json.Marshal(claims)
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	idToken, _ := jws.CompactSerialize()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newMockProvider(t)
+	provider.claims = map[string]interface{}{"preferred_username": "carol", "groups": []string{"ops", "payments"}}
+	a := newTestAuthenticator(t, config.AuthConfig{
+		OIDC: &config.OIDCConfig{
+			Issuer:      provider.server.URL,
+			ClientID:    "viewer",
+			RedirectURL: "http://viewer.test/auth/oidc/callback",
+		},
+	})
+	ctx := context.Background()
+
+	login := func(redirect string) (*httptest.ResponseRecorder, url.Values) {
+		rr := httptest.NewRecorder()
+		target, err := a.StartOIDC(ctx, rr, redirect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, _ := url.Parse(target)
+		query := u.Query()
+		if !strings.HasPrefix(target, provider.server.URL+"/authorize") || query.Get("code_challenge_method") != "S256" {
+			t.Fatalf("unexpected authorization URL: %s", target)
+		}
+		provider.nonce, provider.challenge = query.Get("nonce"), query.Get("code_challenge")
+		return rr, query
+	}
+
+	rr, query := login("/?stream=orders")
+	callback := cookiesOf(rr, "/auth/oidc/callback?code=abc&state="+url.QueryEscape(query.Get("state")))
+	rr = httptest.NewRecorder()
+	user, redirect, err := a.FinishOIDC(ctx, rr, callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "carol" || user.Method != MethodOIDC || len(user.Groups) != 2 || redirect != "/?stream=orders" {
+		t.Errorf("unexpected login: %+v to %s", user, redirect)
+	}
+	if user, err := a.Authenticate(cookiesOf(rr, "/api/streams")); err != nil || user.Name != "carol" {
+		t.Errorf("expected the session to authenticate carol, got %+v, %v", user, err)
+	}
+
+	// A callback whose state doesn't match the login is refused
+	rr, _ = login("https://evil.example.com")
+	if _, _, err := a.FinishOIDC(ctx, httptest.NewRecorder(), cookiesOf(rr, "/auth/oidc/callback?code=abc&state=forged")); err == nil {
+		t.Error("expected a state mismatch")
+	}
+
+	// Redirects leaving the viewer are replaced
+	rr, query = login("//evil.example.com")
+	_, redirect, err = a.FinishOIDC(ctx, httptest.NewRecorder(), cookiesOf(rr, "/auth/oidc/callback?code=abc&state="+url.QueryEscape(query.Get("state"))))
+	if err != nil || redirect != "/" {
+		t.Errorf("expected the redirect to stay on the viewer, got %q, %v", redirect, err)
+	}
+
+	// Tokens for another nonce are refused
+	rr, query = login("/")
+	provider.nonce = "replayed"
+	if _, _, err := a.FinishOIDC(ctx, httptest.NewRecorder(), cookiesOf(rr, "/auth/oidc/callback?code=abc&state="+url.QueryEscape(query.Get("state")))); err == nil {
+		t.Error("expected a nonce mismatch")
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	a := newTestAuthenticator(t, config.AuthConfig{
+		Proxy: &config.ProxyConfig{TrustedProxies: []string{"10.0.0.0/8"}},
+		Access: []config.AccessRule{
+			{Groups: []string{"support"}, Permissions: []string{config.PermReadMetadata}, Connection: "prod"},
+			{Users: []string{"alice"}, Permissions: []string{config.PermReadPayload, config.PermPublish}, VHost: "*", Stream: "orders-*"},
+			{Users: []string{"*"}, Permissions: []string{config.PermList}, Connection: "dev"},
+			{Groups: []string{"platform"}, Permissions: []string{config.PermAdmin}},
+			{Groups: []string{"privacy"}, Permissions: []string{config.PermUnmask}, Stream: "orders-*"},
+		},
+	})
+	support := &User{Name: "sam", Groups: []string{"support"}}
+	alice := &User{Name: "alice"}
+	admin := &User{Name: "root", Groups: []string{"platform"}}
+	privacy := &User{Name: "dpo", Groups: []string{"privacy"}}
+	orders := Resource{Connection: "prod", VHost: "/", Stream: "orders-eu"}
+	payments := Resource{Connection: "prod", VHost: "/", Stream: "payments"}
+
+	cases := []struct {
+		user    *User
+		perm    string
+		res     Resource
+		allowed bool
+	}{
+		{support, config.PermReadMetadata, orders, true},
+		{support, config.PermList, payments, true},
+		{support, config.PermReadPayload, orders, false},
+		{support, config.PermReadMetadata, Resource{Connection: "staging", VHost: "/", Stream: "orders-eu"}, false},
+		{alice, config.PermReadPayload, orders, true},
+		{alice, config.PermList, orders, true},
+		{alice, config.PermReadMetadata, orders, false},
+		{alice, config.PermPublish, payments, false},
+		// Empty fields stand for anything within the others
+		{alice, config.PermList, Resource{Connection: "prod"}, true},
+		{alice, config.PermList, Resource{Connection: "dev", VHost: "/", Stream: "payments"}, true},
+		{support, config.PermList, Resource{Connection: "staging"}, false},
+		{admin, config.PermPublish, payments, true},
+		// Unmask is only granted explicitly, and grants nothing else
+		{admin, config.PermUnmask, orders, false},
+		{privacy, config.PermUnmask, orders, true},
+		{privacy, config.PermUnmask, payments, false},
+		{privacy, config.PermReadPayload, orders, false},
+		{nil, config.PermList, orders, false},
+	}
+	for _, c := range cases {
+		name := "<nil>"
+		if c.user != nil {
+			name = c.user.Name
+		}
+		if got := a.Allowed(c.user, c.perm, c.res); got != c.allowed {
+			t.Errorf("%s %s on %s: expected %v, got %v", name, c.perm, c.res, c.allowed, got)
+		}
+	}
+
+	if a.AllowedEverywhere(support, config.PermReadMetadata) || !a.AllowedEverywhere(admin, config.PermReadMetadata) {
+		t.Error("expected only unscoped rules to grant a permission everywhere")
+	}
+
+	// Without rules every authenticated user may do anything
+	open := newTestAuthenticator(t, config.AuthConfig{Proxy: &config.ProxyConfig{TrustedProxies: []string{"10.0.0.0/8"}}})
+	if !open.Allowed(alice, config.PermAdmin, orders) || open.Allowed(alice, config.PermUnmask, orders) || open.Allowed(nil, config.PermList, orders) {
+		t.Error("expected authenticated users to hold every permission but unmask without rules")
+	}
+}
+
+func TestTokens(t *testing.T) {
+	cfg := config.AuthConfig{
+		Users: []config.UserConfig{
+			{Username: "alice", PasswordHash: secretHash},
+			{Username: "root", PasswordHash: secretHash, Groups: []string{"platform"}},
+		},
+		Tokens: &config.TokensConfig{File: filepath.Join(t.TempDir(), "tokens.json"), MaxTTL: 30 * 24 * time.Hour},
+		Access: []config.AccessRule{
+			{Users: []string{"alice"}, Permissions: []string{config.PermReadPayload}, Stream: "orders*"},
+			{Groups: []string{"platform"}, Permissions: []string{config.PermAdmin}},
+		},
+	}
+	a := newTestAuthenticator(t, cfg)
+	alice := &User{Name: "alice", Method: MethodPassword}
+	root := &User{Name: "root", Groups: []string{"platform"}, Method: MethodPassword}
+
+	bearer := func(secret string) *http.Request {
+		req := httptest.NewRequest("GET", "/api/streams", nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		return req
+	}
+
+	// Personal tokens act as their owner, within their scope
+	token, secret, err := a.CreateToken(alice, TokenRequest{Name: "ci", Permissions: []string{config.PermReadMetadata, config.PermReadPayload}, Connections: []string{"prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, tokenPrefix) || token.Owner != "alice" || token.ExpiresAt == nil {
+		t.Errorf("unexpected token %+v with secret %s", token, secret)
+	}
+	user, err := a.Authenticate(bearer(secret))
+	if err != nil || user.Name != "alice" || user.Method != MethodToken || user.TokenID != token.ID {
+		t.Fatalf("expected the token to authenticate alice, got %+v, %v", user, err)
+	}
+	orders := Resource{Connection: "prod", VHost: "/", Stream: "orders"}
+	if !a.Allowed(user, config.PermReadPayload, orders) {
+		t.Error("expected the token to read payloads alice may read")
+	}
+	if a.Allowed(user, config.PermReadPayload, Resource{Connection: "dev", VHost: "/", Stream: "orders"}) {
+		t.Error("expected the token to be limited to its connections")
+	}
+	if a.Allowed(user, config.PermReadMetadata, Resource{Connection: "prod", VHost: "/", Stream: "payments"}) {
+		t.Error("expected the token not to exceed alice's own permissions")
+	}
+	if _, _, err := a.CreateToken(user, TokenRequest{Name: "nested", Permissions: []string{config.PermList}}); !errors.Is(err, ErrTokenForbidden) {
+		t.Errorf("expected tokens not to create tokens, got %v", err)
+	}
+
+	// Service tokens hold exactly their scope and need an admin to create them
+	if _, _, err := a.CreateToken(alice, TokenRequest{Name: "deploy", Kind: TokenService, Permissions: []string{config.PermPublish}}); !errors.Is(err, ErrTokenForbidden) {
+		t.Errorf("expected only admins to create service tokens, got %v", err)
+	}
+	if _, _, err := a.CreateToken(root, TokenRequest{Name: "deploy", Kind: TokenService, Permissions: []string{config.PermUnmask}}); !errors.Is(err, ErrTokenForbidden) {
+		t.Errorf("expected service tokens not to get permissions their creator lacks, got %v", err)
+	}
+	service, serviceSecret, err := a.CreateToken(root, TokenRequest{Name: "deploy", Kind: TokenService, Permissions: []string{config.PermPublish}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = a.Authenticate(bearer(serviceSecret))
+	if err != nil || user.Name != "deploy" || !a.Allowed(user, config.PermPublish, orders) || a.Allowed(user, config.PermReadPayload, orders) {
+		t.Errorf("expected the service token to publish only, got %+v, %v", user, err)
+	}
+
+	invalid := []TokenRequest{
+		{Permissions: []string{config.PermList}},
+		{Name: "x"},
+		{Name: "x", Permissions: []string{"write"}},
+		{Name: "x", Kind: "robot", Permissions: []string{config.PermList}},
+		{Name: "x", Permissions: []string{config.PermList}, ExpiresAt: timePtr(time.Now().Add(-time.Hour))},
+		{Name: "x", Permissions: []string{config.PermList}, ExpiresAt: timePtr(time.Now().Add(90 * 24 * time.Hour))},
+	}
+	for _, req := range invalid {
+		if _, _, err := a.CreateToken(alice, req); !errors.Is(err, ErrInvalidTokenRequest) {
+			t.Errorf("%+v: expected ErrInvalidTokenRequest, got %v", req, err)
+		}
+	}
+	if _, _, err := a.CreateToken(alice, TokenRequest{Name: "ci", Permissions: []string{config.PermList}}); !errors.Is(err, ErrTokenExists) {
+		t.Errorf("expected duplicate names to be rejected, got %v", err)
+	}
+
+	// Tokens survive restarts with their last use, and are stored hashed
+	data, err := os.ReadFile(cfg.Tokens.File)
+	if err != nil || strings.Contains(string(data), secret) {
+		t.Fatalf("expected the token file to hold hashes only: %v", err)
+	}
+	restarted := newTestAuthenticator(t, cfg)
+	stored, err := restarted.Token(token.ID)
+	if err != nil || stored.LastUsedAt == nil {
+		t.Errorf("expected the token and its last use to be loaded, got %+v, %v", stored, err)
+	}
+	if _, err := restarted.Authenticate(bearer(secret)); err != nil {
+		t.Errorf("expected the token to be accepted after a restart, got %v", err)
+	}
+
+	// Tokens of static users follow their account: removed users lose their
+	// tokens and changed groups apply at once
+	rootToken, rootSecret, err := a.CreateToken(root, TokenRequest{Name: "ops", Permissions: []string{config.PermAdmin}})
+	if err != nil || rootToken.OwnerMethod != MethodPassword {
+		t.Fatalf("expected the owner's login method to be recorded, got %+v, %v", rootToken, err)
+	}
+	changed := cfg
+	changed.Users = []config.UserConfig{{Username: "root", PasswordHash: secretHash}}
+	reconfigured := newTestAuthenticator(t, changed)
+	if _, err := reconfigured.Authenticate(bearer(secret)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected the token of a removed user to be refused, got %v", err)
+	}
+	user, err = reconfigured.Authenticate(bearer(rootSecret))
+	if err != nil || len(user.Groups) != 0 || reconfigured.Allowed(user, config.PermAdmin, orders) {
+		t.Errorf("expected the token to lose the groups root left, got %+v, %v", user, err)
+	}
+
+	// Expired and revoked tokens are refused
+	restarted.now = func() time.Time { return time.Now().Add(31 * 24 * time.Hour) }
+	if _, err := restarted.Authenticate(bearer(secret)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected an expired token to be refused, got %v", err)
+	}
+	if err := a.RevokeToken(service.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(bearer(serviceSecret)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected a revoked token to be refused, got %v", err)
+	}
+	if err := a.RevokeToken(service.ID); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("expected ErrTokenNotFound, got %v", err)
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+// fakeBroker accepts bob's password and the token "token"
+type fakeBroker struct {
+	sessions map[string]*rabbitmq.Session
+}
+
+func (b *fakeBroker) OpenSession(ctx context.Context, creds rabbitmq.Credentials, ttl time.Duration) (*rabbitmq.Session, error) {
+	var s *rabbitmq.Session
+	switch {
+	case creds.Username == "bob" && creds.Password == "secret":
+		s = &rabbitmq.Session{User: "bob", Tags: []string{"monitoring"}}
+	case creds.Token == "token":
+		s = &rabbitmq.Session{User: "svc"}
+	default:
+		return nil, rabbitmq.ErrCredentialsRejected
+	}
+	s.ID = "session-" + s.User
+	s.Expires = time.Now().Add(ttl)
+	b.sessions[s.ID] = s
+	return s, nil
+}
+
+func (b *fakeBroker) Session(id string) (*rabbitmq.Session, bool) {
+	s, ok := b.sessions[id]
+	return s, ok
+}
+
+func (b *fakeBroker) CloseSession(id string) {
+	delete(b.sessions, id)
+}
+
+func TestBrokerLogin(t *testing.T) {
+	cfg := config.Config{
+		Server: config.ServerConfig{Port: 8080, Auth: config.AuthConfig{
+			Users:   []config.UserConfig{{Username: "alice", PasswordHash: secretHash}},
+			Broker:  &config.BrokerAuthConfig{},
+			Session: config.SessionConfig{Secret: "test-secret", TTL: time.Hour},
+		}},
+		Connections: []config.ConnectionConfig{{ID: "prod", Host: "localhost", Port: 5672, HTTPPort: 15672, Credentials: config.CredentialsUser}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(cfg.Server.Auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := &fakeBroker{sessions: make(map[string]*rabbitmq.Session)}
+	a.SetBroker(broker)
+	ctx := context.Background()
+
+	// Static accounts don't fall back to the broker
+	rr := httptest.NewRecorder()
+	if user, err := a.Login(ctx, rr, "alice", "secret"); err != nil || user.Method != MethodPassword || user.BrokerSession != "" {
+		t.Errorf("expected alice to log in with her password, got %+v, %v", user, err)
+	}
+	if _, err := a.Login(ctx, httptest.NewRecorder(), "bob", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected rejected broker credentials to fail, got %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	user, err := a.Login(ctx, rr, "bob", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "bob" || user.Method != MethodBroker || user.Groups[0] != "monitoring" || user.BrokerSession != "session-bob" {
+		t.Errorf("expected bob logged in through the broker, got %+v", user)
+	}
+	user, err = a.Authenticate(cookiesOf(rr, "/api/streams"))
+	if err != nil || user.BrokerSession != "session-bob" {
+		t.Errorf("expected the session to carry the broker session, got %+v, %v", user, err)
+	}
+
+	// Logging out closes the broker session, and the cookie stops working with it
+	a.Logout(httptest.NewRecorder(), cookiesOf(rr, "/auth/logout"))
+	if _, ok := broker.sessions["session-bob"]; ok {
+		t.Error("expected logout to close the broker session")
+	}
+	if _, err := a.Authenticate(cookiesOf(rr, "/api/streams")); err == nil {
+		t.Error("expected a session without its broker session to be rejected")
+	}
+
+	if user, err := a.LoginToken(ctx, httptest.NewRecorder(), "token"); err != nil || user.Name != "svc" {
+		t.Errorf("expected a token login as svc, got %+v, %v", user, err)
+	}
+	if _, err := a.LoginToken(ctx, httptest.NewRecorder(), "forged"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected a rejected token to fail, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// ErrBrokerDisabled is returned for broker logins when they aren't enabled
+var ErrBrokerDisabled = errors.New("login with RabbitMQ credentials is not enabled")
+
+// Broker checks users' own RabbitMQ credentials and keeps the sessions of
+// connections acting with them; *rabbitmq.Manager is one
+type Broker interface {
+	OpenSession(ctx context.Context, creds rabbitmq.Credentials, ttl time.Duration) (*rabbitmq.Session, error)
+	Session(id string) (*rabbitmq.Session, bool)
+	CloseSession(id string)
+}
+
+// SetBroker lets users log in with their RabbitMQ credentials, which the
+// broker's connections with credentials: user then act with
+func (a *Authenticator) SetBroker(broker Broker) {
+	a.broker = broker
+}
+
+// LoginToken checks an OAuth 2 access token with the broker's OAuth 2 plugin
+// and starts a session acting with it
+func (a *Authenticator) LoginToken(ctx context.Context, w http.ResponseWriter, token string) (*User, error) {
+	if token == "" {
+		return nil, ErrInvalidCredentials
+	}
+	return a.loginBroker(ctx, w, rabbitmq.Credentials{Token: token})
+}
+
+// loginBroker opens a broker session for the credentials and starts a
+// session for the user the broker knows them as. Broker tags are the groups.
+func (a *Authenticator) loginBroker(ctx context.Context, w http.ResponseWriter, creds rabbitmq.Credentials) (*User, error) {
+	if a.broker == nil {
+		return nil, ErrBrokerDisabled
+	}
+	session, err := a.broker.OpenSession(ctx, creds, a.cfg.Session.TTL)
+	if errors.Is(err, rabbitmq.ErrCredentialsRejected) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check credentials with the broker: %w", err)
+	}
+
+	user := &User{Name: session.User, Groups: session.Tags, Method: MethodBroker, BrokerSession: session.ID}
+	if err := a.startSession(ctx, w, user); err != nil {
+		a.broker.CloseSession(session.ID)
+		return nil, err
+	}
+	return user, nil
+}
+
+// brokerTokenSession opens a broker session acting with the access token of
+// an OIDC login, lasting until the token expires at the latest
+func (a *Authenticator) brokerTokenSession(ctx context.Context, accessToken string, expiry time.Time) (string, error) {
+	ttl := a.cfg.Session.TTL
+	if !expiry.IsZero() && expiry.Sub(a.now()) < ttl {
+		ttl = expiry.Sub(a.now())
+	}
+	session, err := a.broker.OpenSession(ctx, rabbitmq.Credentials{Token: accessToken}, ttl)
+	if err != nil {
+		return "", fmt.Errorf("the broker refused the access token: %w", err)
+	}
+	return session.ID, nil
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+)
+
+// oidcLoginTimeout bounds the time between redirecting to the provider and its callback
+const oidcLoginTimeout = 10 * time.Minute
+
+// ErrOIDCDisabled is returned for OIDC logins when no provider is configured
+var ErrOIDCDisabled = errors.New("OIDC login is not configured")
+
+// oidcLogin runs the authorization code flow. The provider is discovered on
+// the first login, so the viewer starts while the provider is unreachable.
+type oidcLogin struct {
+	cfg config.OIDCConfig
+
+	mu       sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// oidcState is the content of the cookie kept during a login
+type oidcState struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Redirect string `json:"r"`
+	Expires  int64  `json:"e"`
+}
+
+func (o *oidcLogin) init(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.oauth2 != nil {
+		return o.oauth2, o.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, o.cfg.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+	o.oauth2 = &oauth2.Config{
+		ClientID:     o.cfg.ClientID,
+		ClientSecret: o.cfg.ClientSecret,
+		RedirectURL:  o.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{oidc.ScopeOpenID}, o.cfg.Scopes...),
+	}
+	o.verifier = provider.Verifier(&oidc.Config{ClientID: o.cfg.ClientID})
+	return o.oauth2, o.verifier, nil
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// safeRedirect keeps post-login redirects on the viewer
+func safeRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return "/"
+	}
+	return redirect
+}
+
+// StartOIDC returns the provider URL to send the user to, remembering the
+// state of the login and where to return afterwards in a cookie
+func (a *Authenticator) StartOIDC(ctx context.Context, w http.ResponseWriter, redirect string) (string, error) {
+	if a.oidc == nil {
+		return "", ErrOIDCDisabled
+	}
+	cfg, _, err := a.oidc.init(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	state := oidcState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: oauth2.GenerateVerifier(),
+		Redirect: safeRedirect(redirect),
+		Expires:  a.now().Add(oidcLoginTimeout).Unix(),
+	}
+	value, err := a.sessions.sign(state)
+	if err != nil {
+		return "", err
+	}
+	a.setCookie(ctx, w, oidcCookie, value, a.now().Add(oidcLoginTimeout))
+	return cfg.AuthCodeURL(state.State, oidc.Nonce(state.Nonce), oauth2.S256ChallengeOption(state.Verifier)), nil
+}
+
+// FinishOIDC handles the provider's callback: it exchanges the code, verifies
+// the ID token and starts a session. It returns where to send the user.
+func (a *Authenticator) FinishOIDC(ctx context.Context, w http.ResponseWriter, r *http.Request) (*User, string, error) {
+	if a.oidc == nil {
+		return nil, "", ErrOIDCDisabled
+	}
+	cfg, verifier, err := a.oidc.init(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		return nil, "", errors.New("login expired, start again")
+	}
+	a.clearCookie(ctx, w, oidcCookie)
+	var state oidcState
+	if err := a.sessions.verify(cookie.Value, &state); err != nil || a.now().Unix() >= state.Expires {
+		return nil, "", errors.New("login expired, start again")
+	}
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		return nil, "", fmt.Errorf("provider refused the login: %s %s", e, query.Get("error_description"))
+	}
+	if query.Get("state") != state.State {
+		return nil, "", errors.New("login state mismatch")
+	}
+
+	token, err := cfg.Exchange(ctx, query.Get("code"), oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", errors.New("provider returned no ID token")
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid ID token: %w", err)
+	}
+	if idToken.Nonce != state.Nonce {
+		return nil, "", errors.New("ID token nonce mismatch")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, "", err
+	}
+	user := &User{Name: a.oidc.username(claims, idToken.Subject), Groups: stringsClaim(claims[a.oidc.cfg.GroupsClaim]), Method: MethodOIDC}
+	if a.broker != nil && a.cfg.Broker != nil && a.cfg.Broker.OIDCToken {
+		if user.BrokerSession, err = a.brokerTokenSession(ctx, token.AccessToken, token.Expiry); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := a.startSession(ctx, w, user); err != nil {
+		return nil, "", err
+	}
+	return user, state.Redirect, nil
+}
+
+func (o *oidcLogin) username(claims map[string]interface{}, subject string) string {
+	names := []string{"preferred_username", "email"}
+	if o.cfg.UsernameClaim != "" {
+		names = []string{o.cfg.UsernameClaim}
+	}
+	for _, name := range names {
+		if value, ok := claims[name].(string); ok && value != "" {
+			return value
+		}
+	}
+	return subject
+}
+
+// stringsClaim reads a claim holding a list of strings or a single string
+func stringsClaim(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
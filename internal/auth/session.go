@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+)
+
+const (
+	sessionCookie = "rmq_viewer_session"
+	oidcCookie    = "rmq_viewer_oidc"
+)
+
+var errInvalidCookie = errors.New("invalid cookie")
+
+type cookiePathKey struct{}
+
+// WithCookiePath returns a context whose cookies are scoped to the path the
+// browser reaches the viewer under, such as its base path
+func WithCookiePath(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, cookiePathKey{}, path)
+}
+
+// cookiePath returns the path cookies set during a request are scoped to
+func cookiePath(ctx context.Context) string {
+	if path, _ := ctx.Value(cookiePathKey{}).(string); path != "" {
+		return path
+	}
+	return "/"
+}
+
+// signer encodes values as JSON with an HMAC, so cookies can't be forged or edited
+type signer struct {
+	secret []byte
+}
+
+func (s *signer) mac(payload string) string {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (s *signer) sign(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + s.mac(payload), nil
+}
+
+func (s *signer) verify(value string, v interface{}) error {
+	payload, mac, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(s.mac(payload))) {
+		return errInvalidCookie
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return errInvalidCookie
+	}
+	return json.Unmarshal(data, v)
+}
+
+// session is the content of the session cookie
+type session struct {
+	// ID identifies the session so that logging out revokes it
+	ID      string   `json:"i,omitempty"`
+	User    string   `json:"u"`
+	Groups  []string `json:"g,omitempty"`
+	Method  string   `json:"m"`
+	Expires int64    `json:"e"`
+	// Broker is the user's broker session, which ends on restart
+	Broker string `json:"b,omitempty"`
+	// Password stamps the static user's password hash, so that changing
+	// the password ends the user's sessions
+	Password string `json:"p,omitempty"`
+}
+
+func (a *Authenticator) startSession(ctx context.Context, w http.ResponseWriter, user *User) error {
+	expires := a.now().Add(a.cfg.Session.TTL)
+	s := session{ID: randomString(), User: user.Name, Groups: user.Groups, Method: user.Method, Expires: expires.Unix(), Broker: user.BrokerSession}
+	if user.Method == MethodPassword {
+		s.Password = a.passwordStamp(a.users[user.Name])
+	}
+	value, err := a.sessions.sign(s)
+	if err != nil {
+		return err
+	}
+	a.setCookie(ctx, w, sessionCookie, value, expires)
+	return nil
+}
+
+// passwordStamp identifies a static user's password hash without revealing it
+func (a *Authenticator) passwordStamp(account config.UserConfig) string {
+	return a.sessions.mac(account.PasswordHash)[:16]
+}
+
+// session returns the valid session of a request
+func (a *Authenticator) session(r *http.Request) (*session, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, false
+	}
+	var s session
+	if err := a.sessions.verify(cookie.Value, &s); err != nil || a.now().Unix() >= s.Expires {
+		return nil, false
+	}
+	a.mu.Lock()
+	_, revoked := a.revoked[s.ID]
+	a.mu.Unlock()
+	if revoked {
+		return nil, false
+	}
+	return &s, true
+}
+
+// revoke ends a session before it expires. Revocations are kept until the
+// session would have expired, in memory, so they end on restart.
+func (a *Authenticator) revoke(s *session) {
+	if s.ID == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now().Unix()
+	for id, expires := range a.revoked {
+		if now >= expires {
+			delete(a.revoked, id)
+		}
+	}
+	a.revoked[s.ID] = s.Expires
+}
+
+func (a *Authenticator) sessionUser(r *http.Request) (*User, bool) {
+	s, ok := a.session(r)
+	if !ok {
+		return nil, false
+	}
+	// Static users are looked up again, so removing a user or changing their
+	// password ends their sessions and changed groups apply at once
+	if s.Method == MethodPassword {
+		account, ok := a.users[s.User]
+		if !ok || s.Password != a.passwordStamp(account) {
+			return nil, false
+		}
+		s.Groups = account.Groups
+	}
+	// Logins with broker credentials last as long as their broker session
+	if s.Broker != "" {
+		if a.broker == nil {
+			return nil, false
+		}
+		if _, ok := a.broker.Session(s.Broker); !ok {
+			return nil, false
+		}
+	}
+	return &User{Name: s.User, Groups: s.Groups, Method: s.Method, BrokerSession: s.Broker}, true
+}
+
+func (a *Authenticator) setCookie(ctx context.Context, w http.ResponseWriter, name, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     cookiePath(ctx),
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   a.cfg.Session.SecureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (a *Authenticator) clearCookie(ctx context.Context, w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     cookiePath(ctx),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   a.cfg.Session.SecureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
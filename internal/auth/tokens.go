@@ -0,0 +1,379 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+)
+
+// MethodToken is how users authenticated with an API token are reported
+const MethodToken = "token"
+
+// Token kinds
+const (
+	// TokenPersonal tokens act as the user who created them, within their scope
+	TokenPersonal = "personal"
+	// TokenService tokens belong to no user and hold exactly their scope
+	TokenService = "service"
+)
+
+// tokenPrefix marks API tokens, so that leaked ones are easy to search for
+const tokenPrefix = "rsv_"
+
+// lastUsedPersistInterval is how stale the last use saved to the token file may get
+const lastUsedPersistInterval = time.Minute
+
+var (
+	// ErrTokensDisabled is returned when no token file is configured
+	ErrTokensDisabled = errors.New("API tokens are not enabled")
+	// ErrTokenNotFound is returned for an unknown token ID
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrInvalidToken is returned for an unknown, expired or malformed bearer token
+	ErrInvalidToken = errors.New("invalid or expired token")
+	// ErrInvalidTokenRequest is returned for a token that can't be created as requested
+	ErrInvalidTokenRequest = errors.New("invalid token request")
+	// ErrTokenExists is returned when the user already has a token of that name
+	ErrTokenExists = errors.New("a token of that name already exists")
+	// ErrTokenForbidden is returned when the user may not create the token
+	ErrTokenForbidden = errors.New("not allowed to create this token")
+)
+
+// Token is an API token. The secret is only returned when the token is
+// created; the store keeps its SHA-256 hash.
+type Token struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// Owner and Groups are those of the user a personal token acts as
+	Owner  string   `json:"owner,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	// OwnerMethod is how the owner authenticated when creating the token;
+	// tokens of static users follow their account
+	OwnerMethod string `json:"owner_method,omitempty"`
+	// Permissions and Connections scope the token; it may act on every
+	// connection when Connections is empty
+	Permissions  []string   `json:"permissions"`
+	Connections  []string   `json:"connections,omitempty"`
+	CreatedBy    string     `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+	LastUsedFrom string     `json:"last_used_from,omitempty"`
+}
+
+// storedToken is a token as written to the token file
+type storedToken struct {
+	Token
+	Hash string `json:"hash"`
+	// savedUse is the last use written to the file
+	savedUse time.Time
+}
+
+// tokenStore keeps the tokens in memory and in the token file
+type tokenStore struct {
+	cfg config.TokensConfig
+
+	mu     sync.Mutex
+	tokens map[string]*storedToken // by hash
+}
+
+func openTokenStore(cfg config.TokensConfig) (*tokenStore, error) {
+	s := &tokenStore{cfg: cfg, tokens: make(map[string]*storedToken)}
+	data, err := os.ReadFile(cfg.File)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	var stored []*storedToken
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse token file: %w", err)
+	}
+	for _, t := range stored {
+		if t.LastUsedAt != nil {
+			t.savedUse = *t.LastUsedAt
+		}
+		s.tokens[t.Hash] = t
+	}
+	return s, nil
+}
+
+// save atomically writes the tokens to the token file; callers hold s.mu
+func (s *tokenStore) save() error {
+	stored := make([]*storedToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		stored = append(stored, t)
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.cfg.File), filepath.Base(s.cfg.File)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.cfg.File)
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokensEnabled reports whether API tokens are accepted
+func (a *Authenticator) TokensEnabled() bool {
+	return a.tokens != nil
+}
+
+// TokenRequest describes a token to create
+type TokenRequest struct {
+	Name        string   `json:"name"`
+	Kind        string   `json:"kind"`
+	Permissions []string `json:"permissions"`
+	Connections []string `json:"connections"`
+	// ExpiresAt defaults to max_ttl from now when max_ttl is configured
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateToken creates a token for a user and returns it with its secret.
+// Personal tokens act as the user; service tokens act as themselves.
+func (a *Authenticator) CreateToken(user *User, req TokenRequest) (Token, string, error) {
+	if a.tokens == nil {
+		return Token{}, "", ErrTokensDisabled
+	}
+	if user == nil {
+		return Token{}, "", ErrUnauthenticated
+	}
+	if user.token != nil {
+		return Token{}, "", fmt.Errorf("%w: tokens can't create tokens", ErrTokenForbidden)
+	}
+
+	now := a.now().UTC()
+	token := Token{
+		ID:          uuid.NewString(),
+		Name:        strings.TrimSpace(req.Name),
+		Kind:        req.Kind,
+		Permissions: req.Permissions,
+		Connections: req.Connections,
+		CreatedBy:   user.Name,
+		CreatedAt:   now,
+		ExpiresAt:   req.ExpiresAt,
+	}
+	if token.Kind == "" {
+		token.Kind = TokenPersonal
+	}
+	if token.Kind == TokenPersonal {
+		token.Owner, token.Groups, token.OwnerMethod = user.Name, user.Groups, user.Method
+		if staticLogin(user.Method) {
+			account, ok := a.users[user.Name]
+			if !ok {
+				return Token{}, "", ErrUnauthenticated
+			}
+			token.Groups = account.Groups
+		}
+	}
+	if err := a.validateToken(&token, now); err != nil {
+		return Token{}, "", fmt.Errorf("%w: %v", ErrInvalidTokenRequest, err)
+	}
+	// Service tokens act for nobody, so only admins may create them and only
+	// with permissions they hold on every stream
+	if token.Kind == TokenService {
+		for _, perm := range append([]string{config.PermAdmin}, token.Permissions...) {
+			if !a.AllowedEverywhere(user, perm) {
+				return Token{}, "", fmt.Errorf("%w: service tokens need %s on every stream", ErrTokenForbidden, perm)
+			}
+		}
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return Token{}, "", err
+	}
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	s := a.tokens
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.Owner == token.Owner && t.Name == token.Name {
+			return Token{}, "", fmt.Errorf("%w: %s", ErrTokenExists, token.Name)
+		}
+	}
+	hash := hashToken(secret)
+	s.tokens[hash] = &storedToken{Token: token, Hash: hash}
+	if err := s.save(); err != nil {
+		delete(s.tokens, hash)
+		return Token{}, "", err
+	}
+	return token, secret, nil
+}
+
+// validateToken checks a new token and fills in its expiry
+func (a *Authenticator) validateToken(t *Token, now time.Time) error {
+	if t.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if t.Kind != TokenPersonal && t.Kind != TokenService {
+		return fmt.Errorf("kind must be %s or %s", TokenPersonal, TokenService)
+	}
+	if len(t.Permissions) == 0 {
+		return fmt.Errorf("permissions are required")
+	}
+	for _, perm := range t.Permissions {
+		switch perm {
+		case config.PermList, config.PermReadMetadata, config.PermReadPayload, config.PermPublish, config.PermAdmin, config.PermUnmask:
+		default:
+			return fmt.Errorf("unknown permission '%s'", perm)
+		}
+	}
+
+	maxTTL := a.cfg.Tokens.MaxTTL
+	switch {
+	case t.ExpiresAt == nil && maxTTL > 0:
+		expires := now.Add(maxTTL)
+		t.ExpiresAt = &expires
+	case t.ExpiresAt != nil && !t.ExpiresAt.After(now):
+		return fmt.Errorf("expires_at must be in the future")
+	case t.ExpiresAt != nil && maxTTL > 0 && t.ExpiresAt.After(now.Add(maxTTL)):
+		return fmt.Errorf("expires_at must be within %s", maxTTL)
+	}
+	return nil
+}
+
+// Tokens lists the tokens, by creation time
+func (a *Authenticator) Tokens() []Token {
+	if a.tokens == nil {
+		return nil
+	}
+	s := a.tokens
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := make([]Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		tokens = append(tokens, t.Token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens
+}
+
+// Token returns a token by ID
+func (a *Authenticator) Token(id string) (Token, error) {
+	for _, t := range a.Tokens() {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return Token{}, ErrTokenNotFound
+}
+
+// RevokeToken deletes a token, which stops being accepted at once
+func (a *Authenticator) RevokeToken(id string) error {
+	if a.tokens == nil {
+		return ErrTokensDisabled
+	}
+	s := a.tokens
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, t := range s.tokens {
+		if t.ID == id {
+			delete(s.tokens, hash)
+			if err := s.save(); err != nil {
+				s.tokens[hash] = t
+				return err
+			}
+			return nil
+		}
+	}
+	return ErrTokenNotFound
+}
+
+// tokenUser returns the user of a bearer token and records its use
+func (a *Authenticator) tokenUser(r *http.Request, secret string) (*User, error) {
+	s := a.tokens
+	now := a.now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[hashToken(secret)]
+	if !ok || (t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)) {
+		return nil, ErrInvalidToken
+	}
+	// Static owners are looked up again, like their sessions, so removing a
+	// user ends their tokens and changed groups apply at once
+	if t.Kind == TokenPersonal && staticLogin(t.OwnerMethod) {
+		account, ok := a.users[t.Owner]
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+		t.Groups = account.Groups
+	}
+
+	t.LastUsedAt, t.LastUsedFrom = &now, r.RemoteAddr
+	if now.Sub(t.savedUse) >= lastUsedPersistInterval {
+		if err := s.save(); err == nil {
+			t.savedUse = now
+		}
+	}
+
+	user := &User{Name: t.Name, Method: MethodToken, TokenID: t.ID, token: &t.Token}
+	if t.Kind == TokenPersonal {
+		user.Name, user.Groups = t.Owner, t.Groups
+	}
+	return user, nil
+}
+
+// staticLogin reports whether users of an authentication method are static accounts
+func staticLogin(method string) bool {
+	return method == MethodPassword || method == MethodBasic
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// tokenAllows reports whether a token's scope covers a permission on a resource
+func tokenAllows(t *Token, perm string, res Resource) bool {
+	if !grants(config.AccessRule{Permissions: t.Permissions}, perm) {
+		return false
+	}
+	if len(t.Connections) == 0 || res.Connection == "" {
+		return true
+	}
+	for _, c := range t.Connections {
+		if c == res.Connection {
+			return true
+		}
+	}
+	return false
+}
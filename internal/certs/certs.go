@@ -0,0 +1,166 @@
+// Package certs serves the server's TLS certificate and client CAs, reloading
+// them when their files change so that renewed certificates are picked up
+// without a restart.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+)
+
+// fileStamp identifies a version of a file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Reloader holds the current certificate and client CAs of the TLS settings
+type Reloader struct {
+	cfg        config.TLSConfig
+	clientAuth tls.ClientAuthType
+	minVersion uint16
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	stamps    map[string]fileStamp
+}
+
+// NewReloader loads the certificate, key and client CAs, failing when any of
+// them can't be loaded
+func NewReloader(cfg config.TLSConfig) (*Reloader, error) {
+	r := &Reloader{cfg: cfg, clientAuth: tls.NoClientCert, minVersion: tls.VersionTLS12}
+	switch cfg.ClientAuth {
+	case config.ClientAuthVerifyIfGiven:
+		r.clientAuth = tls.VerifyClientCertIfGiven
+	case config.ClientAuthRequire:
+		r.clientAuth = tls.RequireAndVerifyClientCert
+	}
+	if cfg.MinVersion == "1.3" {
+		r.minVersion = tls.VersionTLS13
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns the server TLS configuration. Every handshake uses the
+// certificate and client CAs loaded last.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: r.minVersion,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return &tls.Config{
+				Certificates: []tls.Certificate{*r.cert},
+				ClientAuth:   r.clientAuth,
+				ClientCAs:    r.clientCAs,
+				MinVersion:   r.minVersion,
+				NextProtos:   []string{"h2", "http/1.1"},
+			}, nil
+		},
+	}
+}
+
+// Run checks the files for changes every reload interval until ctx is done.
+// A change that fails to load is logged and the previous files are kept.
+func (r *Reloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !r.changed() {
+			continue
+		}
+		if err := r.reload(); err != nil {
+			log.Printf("tls: %v, keeping the previous certificate", err)
+			continue
+		}
+		log.Printf("tls: reloaded %s", r.cfg.CertFile)
+	}
+}
+
+// files are the files the settings load
+func (r *Reloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+// stamp returns the current versions of the files
+func (r *Reloader) stamp() (map[string]fileStamp, error) {
+	stamps := make(map[string]fileStamp)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		stamps[file] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+// changed reports whether any file changed since it was last loaded. Missing
+// files, such as ones being replaced, count as unchanged until they're back.
+func (r *Reloader) changed() bool {
+	stamps, err := r.stamp()
+	if err != nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for file, stamp := range stamps {
+		if r.stamps[file] != stamp {
+			return true
+		}
+	}
+	return false
+}
+
+// reload loads the files and swaps them in
+func (r *Reloader) reload() error {
+	// Stamp first so that a file changing while it's read is loaded again
+	stamps, err := r.stamp()
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client CAs: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("failed to load client CAs: no certificate in %s", r.cfg.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.clientCAs, r.stamps = &cert, clientCAs, stamps
+	return nil
+}
@@ -0,0 +1,212 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+)
+
+// issue creates a certificate for name signed by parent, or self-signed when
+// parent is nil, returning it with its key
+func issue(t *testing.T, name string, isCA bool, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         isCA,
+
+		BasicConstraintsValid: true,
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeCert writes a certificate and its key as PEM files
+func writeCert(t *testing.T, cert tls.Certificate, certFile, keyFile string) {
+	t.Helper()
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake connects to a TLS listener and returns the server's certificate
+func handshake(t *testing.T, addr string, roots *x509.CertPool, client *tls.Certificate) (*x509.Certificate, error) {
+	t.Helper()
+	cfg := &tls.Config{RootCAs: roots, ServerName: "viewer"}
+	if client != nil {
+		cfg.Certificates = []tls.Certificate{*client}
+	}
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// Client certificates are checked after the client's handshake completes,
+	// so read to learn whether the server accepted it
+	conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); err != nil && !isTimeout(err) {
+		return nil, err
+	}
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// serve accepts TLS connections with the reloader's config until the test ends
+func serve(t *testing.T, r *Reloader) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", r.TLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+				conn.Read(make([]byte, 1))
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.TLSConfig{
+		CertFile:       filepath.Join(dir, "tls.crt"),
+		KeyFile:        filepath.Join(dir, "tls.key"),
+		ReloadInterval: time.Minute,
+	}
+	ca := issue(t, "ca", true, nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	first := issue(t, "viewer", false, &ca)
+	writeCert(t, first, cfg.CertFile, cfg.KeyFile)
+
+	r, err := NewReloader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, r)
+	got, err := handshake(t, addr, roots, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SerialNumber.Cmp(first.Leaf.SerialNumber) != 0 {
+		t.Fatal("server should present the first certificate")
+	}
+	if r.changed() {
+		t.Error("unchanged files should not be reported as changed")
+	}
+
+	// A renewed certificate is served once reloaded
+	renewed := issue(t, "viewer", false, &ca)
+	writeCert(t, renewed, cfg.CertFile, cfg.KeyFile)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(cfg.CertFile, later, later)
+	if !r.changed() {
+		t.Fatal("renewed certificate should be reported as changed")
+	}
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := handshake(t, addr, roots, nil); got == nil || got.SerialNumber.Cmp(renewed.Leaf.SerialNumber) != 0 {
+		t.Error("server should present the renewed certificate")
+	}
+
+	// A broken certificate is refused and the renewed one kept
+	os.WriteFile(cfg.CertFile, []byte("not a certificate"), 0o600)
+	if err := r.reload(); err == nil {
+		t.Error("broken certificate should fail to load")
+	}
+	if got, _ := handshake(t, addr, roots, nil); got == nil || got.SerialNumber.Cmp(renewed.Leaf.SerialNumber) != 0 {
+		t.Error("server should keep presenting the renewed certificate")
+	}
+
+	if _, err := NewReloader(cfg); err == nil {
+		t.Error("NewReloader should fail on a broken certificate")
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.TLSConfig{
+		CertFile:       filepath.Join(dir, "tls.crt"),
+		KeyFile:        filepath.Join(dir, "tls.key"),
+		ClientCAFile:   filepath.Join(dir, "clients.pem"),
+		ClientAuth:     config.ClientAuthRequire,
+		ReloadInterval: time.Minute,
+	}
+	ca := issue(t, "ca", true, nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	writeCert(t, issue(t, "viewer", false, &ca), cfg.CertFile, cfg.KeyFile)
+
+	clientCA := issue(t, "client-ca", true, nil)
+	os.WriteFile(cfg.ClientCAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCA.Certificate[0]}), 0o600)
+	alice := issue(t, "alice", false, &clientCA)
+	stranger := issue(t, "mallory", false, &ca)
+
+	r, err := NewReloader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, r)
+
+	if _, err := handshake(t, addr, roots, &alice); err != nil {
+		t.Errorf("client certificate from the client CA should be accepted: %v", err)
+	}
+	if _, err := handshake(t, addr, roots, nil); err == nil {
+		t.Error("connection without a client certificate should be refused")
+	}
+	if _, err := handshake(t, addr, roots, &stranger); err == nil {
+		t.Error("client certificate from another CA should be refused")
+	}
+}
@@ -2,20 +2,462 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// DefaultReadTimeout is how long a message read waits for the next message when no read_timeout is configured
+const DefaultReadTimeout = 5 * time.Second
+
+// DefaultMaxReadTimeout is the longest timeout a read may request when no max_read_timeout is configured
+const DefaultMaxReadTimeout = time.Minute
+
+// DefaultStatsRetention is how long collected stream stats are kept when no retention is configured
+const DefaultStatsRetention = 24 * time.Hour
+
+// DefaultAlertInterval is how often alert rules are evaluated when no interval is configured
+const DefaultAlertInterval = 30 * time.Second
+
+// DefaultWebhookTimeout bounds a webhook delivery when no timeout is configured
+const DefaultWebhookTimeout = 10 * time.Second
+
+// DefaultRedactionMask replaces redacted values when no mask is configured
+const DefaultRedactionMask = "[REDACTED]"
+
+// DefaultAuditMaxSizeMB is the size the audit log rotates at when no max_size_mb is configured
+const DefaultAuditMaxSizeMB = 100
+
+// DefaultAuditMaxFiles is how many rotated audit logs are kept when no max_files is configured
+const DefaultAuditMaxFiles = 10
+
 // Config represents the application configuration
 type Config struct {
 	Server      ServerConfig      `yaml:"server"`
 	Connections []ConnectionConfig `yaml:"connections"`
+	Stats       StatsConfig        `yaml:"stats"`
+	Alerts      AlertsConfig       `yaml:"alerts"`
+	Redaction   RedactionConfig    `yaml:"redaction"`
+	Audit       AuditConfig        `yaml:"audit"`
+	Copies      CopiesConfig       `yaml:"copies"`
 }
 
+// DefaultSessionTTL is how long a login session lasts when no TTL is configured
+const DefaultSessionTTL = 12 * time.Hour
+
+// DefaultTLSReloadInterval is how often the TLS certificate files are checked
+// for changes when no reload_interval is configured
+const DefaultTLSReloadInterval = time.Minute
+
+// DefaultCSP is the Content-Security-Policy of every response when none is
+// configured. The frontend loads only its own scripts and styles; React sets
+// styles through the DOM, which style-src doesn't restrict.
+const DefaultCSP = "default-src 'self'; script-src 'self'; style-src 'self'; img-src 'self' data:; " +
+	"connect-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'"
+
+// DefaultHSTSMaxAge is the max-age of the Strict-Transport-Security header of
+// HTTPS responses when none is configured
+const DefaultHSTSMaxAge = 365 * 24 * time.Hour
+
 // ServerConfig holds server-specific settings
 type ServerConfig struct {
-	Port int `yaml:"port"`
+	Port int        `yaml:"port"`
+	Auth AuthConfig `yaml:"auth"`
+	// TLS serves HTTPS instead of plain HTTP
+	TLS *TLSConfig `yaml:"tls"`
+	// CORS lets frontends served from other origins call the API. No CORS
+	// headers are sent when no origins are allowed.
+	CORS CORSConfig `yaml:"cors"`
+	// Headers are the security headers added to every response
+	Headers SecurityHeadersConfig `yaml:"headers"`
+	// BasePath serves every route and the frontend under a path such as
+	// /rmq, for hosting the viewer next to other tools on one host
+	BasePath string `yaml:"base_path"`
+	// TrustedProxies are the CIDRs whose X-Forwarded-Proto, X-Forwarded-Host
+	// and X-Forwarded-Prefix headers are honoured (default: loopback and
+	// private networks)
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// DefaultTrustedProxies are the networks forwarded headers are honoured from
+// when no trusted_proxies are configured: only a proxy on the same host
+var DefaultTrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+
+// basePathPattern matches the path segments a base path may consist of
+var basePathPattern = regexp.MustCompile(`^(/[A-Za-z0-9._~-]+)+$`)
+
+// ValidBasePath reports whether a base path, from the configuration or an
+// X-Forwarded-Prefix header, is safe to prefix routes and page links with.
+// The empty path is the root.
+func ValidBasePath(p string) bool {
+	return p == "" || basePathPattern.MatchString(p)
+}
+
+// TLS client authentication modes
+const (
+	// ClientAuthNone asks for no client certificate
+	ClientAuthNone = "none"
+	// ClientAuthVerifyIfGiven verifies client certificates when clients send one
+	ClientAuthVerifyIfGiven = "verify_if_given"
+	// ClientAuthRequire refuses connections without a valid client certificate
+	ClientAuthRequire = "require"
+)
+
+// TLSConfig configures HTTPS. The certificate, key and client CA files are
+// reloaded when they change, so renewed certificates are picked up without
+// a restart.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile holds the PEM CAs client certificates are verified against
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientAuth is none, verify_if_given or require (default: require with
+	// a client_ca_file, none without)
+	ClientAuth string `yaml:"client_auth"`
+	// MinVersion is the oldest TLS version accepted, 1.2 or 1.3 (default: 1.2)
+	MinVersion string `yaml:"min_version"`
+	// ReloadInterval is how often the files are checked for changes (default: 1m)
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+// CORSConfig selects the cross-origin requests browsers may make
+type CORSConfig struct {
+	// AllowedOrigins are origins such as https://tools.example, or patterns
+	// such as https://*.example; "*" allows any origin
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// AllowedMethods default to GET, POST, PUT and DELETE
+	AllowedMethods []string `yaml:"allowed_methods"`
+	// AllowedHeaders default to Content-Type and Authorization; "*" allows any
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	// AllowCredentials lets requests carry cookies and HTTP authentication.
+	// It can't be combined with the origin "*".
+	AllowCredentials bool `yaml:"allow_credentials"`
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// SecurityHeadersConfig configures the security headers of every response
+type SecurityHeadersConfig struct {
+	// ContentSecurityPolicy replaces the default policy, without its
+	// frame-ancestors directive, which FrameAncestors sets
+	ContentSecurityPolicy string `yaml:"content_security_policy"`
+	// FrameAncestors are the sources that may embed the viewer in a frame
+	// (default: 'none')
+	FrameAncestors []string `yaml:"frame_ancestors"`
+	// HSTSMaxAge is the Strict-Transport-Security max-age of HTTPS responses
+	// (default: 8760h); a negative value sends no HSTS header
+	HSTSMaxAge time.Duration `yaml:"hsts_max_age"`
+	// HSTSIncludeSubdomains extends HSTS to every subdomain
+	HSTSIncludeSubdomains bool `yaml:"hsts_include_subdomains"`
+}
+
+// AuthConfig selects how users authenticate. Authentication is off when no
+// users, OIDC provider, proxy, broker or client certificate logins are
+// configured; /health always stays open.
+type AuthConfig struct {
+	// Users are static accounts that log in through the login form, and with
+	// HTTP basic auth when Basic is set
+	Users []UserConfig `yaml:"users"`
+	Basic bool         `yaml:"basic"`
+	OIDC  *OIDCConfig  `yaml:"oidc"`
+	Proxy *ProxyConfig `yaml:"proxy"`
+	// Session configures the cookie issued by the login form and OIDC logins
+	Session SessionConfig `yaml:"session"`
+	// Access grants permissions to users and groups. Authenticated users may
+	// do anything when it is empty; otherwise what no rule grants is denied.
+	Access []AccessRule `yaml:"access"`
+	// Tokens enables API tokens sent as Authorization: Bearer
+	Tokens *TokensConfig `yaml:"tokens"`
+	// Broker lets users log in with their own RabbitMQ credentials, which the
+	// connections with credentials: user then act with
+	Broker *BrokerAuthConfig `yaml:"broker"`
+	// ClientCert authenticates users by the TLS client certificate they
+	// present, verified against server.tls.client_ca_file
+	ClientCert *ClientCertAuthConfig `yaml:"client_cert"`
+}
+
+// Client certificate fields naming the user
+const (
+	CertUsernameCN    = "cn"
+	CertUsernameEmail = "email"
+)
+
+// ClientCertAuthConfig names users after their client certificate. The
+// certificate's organizational units are their groups.
+type ClientCertAuthConfig struct {
+	// Username is the certificate field naming the user: cn, the subject's
+	// common name, or email, its first email address (default: cn)
+	Username string `yaml:"username"`
+}
+
+// BrokerAuthConfig configures logins with RabbitMQ credentials. Users log in
+// with a RabbitMQ username and password, or an OAuth 2 access token accepted
+// by the broker's OAuth 2 plugin, which are checked against the connections
+// with credentials: user.
+type BrokerAuthConfig struct {
+	// OIDCToken uses the access token of OIDC logins as the user's broker
+	// credentials, for brokers whose OAuth 2 plugin trusts the same provider
+	OIDCToken bool `yaml:"oidc_token"`
+}
+
+// TokensConfig configures the API tokens users create for scripts and CI jobs
+type TokensConfig struct {
+	// File stores the tokens, hashed
+	File string `yaml:"file"`
+	// MaxTTL caps how long tokens are valid; tokens may be created without an
+	// expiry when it is 0
+	MaxTTL time.Duration `yaml:"max_ttl"`
+}
+
+// Enabled reports whether any authentication method is configured
+func (a *AuthConfig) Enabled() bool {
+	return len(a.Users) > 0 || a.OIDC != nil || a.Proxy != nil || a.Broker != nil || a.ClientCert != nil
+}
+
+// UserConfig is a static account
+type UserConfig struct {
+	Username string `yaml:"username"`
+	// PasswordHash is a bcrypt hash, e.g. from htpasswd -nbBC 10 user password
+	PasswordHash string   `yaml:"password_hash"`
+	Groups       []string `yaml:"groups"`
+}
+
+// OIDCConfig configures login with an OpenID Connect provider through the
+// authorization code flow
+type OIDCConfig struct {
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// RedirectURL is the viewer's callback, e.g. https://viewer.example.com/auth/oidc/callback
+	RedirectURL string `yaml:"redirect_url"`
+	// Scopes requested besides openid (default: profile, email)
+	Scopes []string `yaml:"scopes"`
+	// UsernameClaim names the user (default: preferred_username, then email, then sub)
+	UsernameClaim string `yaml:"username_claim"`
+	// GroupsClaim lists the user's groups (default: groups)
+	GroupsClaim string `yaml:"groups_claim"`
+}
+
+// ProxyConfig trusts the user named by a reverse proxy that authenticated it
+type ProxyConfig struct {
+	// UserHeader carries the username (default: X-Forwarded-User)
+	UserHeader string `yaml:"user_header"`
+	// GroupsHeader carries comma-separated groups (default: X-Forwarded-Groups)
+	GroupsHeader string `yaml:"groups_header"`
+	// TrustedProxies are the CIDRs the headers are accepted from
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// SessionConfig configures the session cookie
+type SessionConfig struct {
+	// Secret signs session cookies; a random secret is generated when empty,
+	// so sessions end on restart and aren't shared between replicas
+	Secret string `yaml:"secret"`
+	// TTL is how long a session lasts (default: 12h)
+	TTL time.Duration `yaml:"ttl"`
+	// Secure restricts the cookie to HTTPS (default: true when server.tls is set)
+	Secure *bool `yaml:"secure"`
+}
+
+// SecureCookies reports whether session cookies are restricted to HTTPS
+func (s SessionConfig) SecureCookies() bool {
+	return s.Secure != nil && *s.Secure
+}
+
+// Permissions granted by access rules
+const (
+	// PermList shows streams in the connection, vhost and stream listings
+	PermList = "list"
+	// PermReadMetadata reads stream stats, settings, rates and clients
+	PermReadMetadata = "read-metadata"
+	// PermReadPayload reads and exports messages
+	PermReadPayload = "read-payload"
+	// PermPublish publishes, republishes, replays and copies into streams
+	PermPublish = "publish"
+	// PermAdmin declares and deletes streams and grants every other permission but unmask
+	PermAdmin = "admin"
+	// PermUnmask shows messages without redaction; it is only granted explicitly
+	PermUnmask = "unmask"
+)
+
+// AccessRule grants permissions on matching streams to users and groups.
+// Every permission but unmask also grants list on the streams it applies to.
+type AccessRule struct {
+	// Users names the users the rule applies to; "*" matches every user
+	Users  []string `yaml:"users"`
+	Groups []string `yaml:"groups"`
+	// Permissions are list, read-metadata, read-payload, publish, admin and unmask
+	Permissions []string `yaml:"permissions"`
+	// Connection, VHost and Stream are path.Match patterns selecting the
+	// streams the rule applies to; empty fields and "*" match anything
+	Connection string `yaml:"connection"`
+	VHost      string `yaml:"vhost"`
+	Stream     string `yaml:"stream"`
+}
+
+// MatchPattern reports whether a rule pattern matches a connection, vhost or
+// stream name. Patterns use path.Match; empty patterns and a lone "*" match
+// anything, including the vhost "/" that path.Match's * would not.
+func MatchPattern(pattern, value string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
+
+// RedactionConfig masks sensitive message content before it leaves the server
+type RedactionConfig struct {
+	// Mask replaces redacted values (default: [REDACTED])
+	Mask  string          `yaml:"mask"`
+	Rules []RedactionRule `yaml:"rules"`
+}
+
+// RedactionRule masks parts of the messages of matching streams
+type RedactionRule struct {
+	Name string `yaml:"name"`
+	// Connection, VHost and Stream are patterns as in access rules
+	Connection string `yaml:"connection"`
+	VHost      string `yaml:"vhost"`
+	Stream     string `yaml:"stream"`
+	// JSONPaths mask fields of JSON bodies, e.g. $.customer.email, $.items[*].card or $..email
+	JSONPaths []string `yaml:"json_paths"`
+	// AppProperties masks the values of application properties; keys may be patterns
+	AppProperties []string `yaml:"app_properties"`
+	// Patterns mask regular expression matches in text bodies and JSON string values
+	Patterns []string `yaml:"patterns"`
+}
+
+// AuditConfig records who read, searched, exported and changed what. Auditing
+// is off when no file is configured.
+type AuditConfig struct {
+	// File receives the audit trail as JSON lines
+	File string `yaml:"file"`
+	// MaxSizeMB rotates the file once it reaches this size (default: 100)
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// MaxFiles is how many rotated files are kept (default: 10)
+	MaxFiles int `yaml:"max_files"`
+	// Stream, when set, also publishes every event to a RabbitMQ stream
+	Stream *AuditStreamConfig `yaml:"stream"`
+}
+
+// AuditStreamConfig is the stream audit events are published to. The stream
+// must exist and the connection must not be read-only.
+type AuditStreamConfig struct {
+	Connection string `yaml:"connection"`
+	VHost      string `yaml:"vhost"`
+	Name       string `yaml:"name"`
+}
+
+// DefaultCopyRetention is how long finished copy jobs are kept when no
+// retention is configured
+const DefaultCopyRetention = 24 * time.Hour
+
+// CopiesConfig controls how the server keeps copy jobs
+type CopiesConfig struct {
+	// File persists jobs and their checkpoints, so stopped copies can be
+	// resumed after a restart, when set
+	File string `yaml:"file"`
+	// Retention is how long finished jobs are kept (default: 24h)
+	Retention time.Duration `yaml:"retention"`
+}
+
+// StatsConfig controls the background collector that samples stream stats
+type StatsConfig struct {
+	// Interval between samples; the collector is disabled when it is 0
+	Interval time.Duration `yaml:"interval"`
+	// Retention is how long samples are kept (default: 24h)
+	Retention time.Duration `yaml:"retention"`
+	// File persists samples across restarts when set
+	File string `yaml:"file"`
+	// Streams limits sampling to matching streams; every stream is sampled when empty
+	Streams []StreamSelector `yaml:"streams"`
+}
+
+// StreamSelector matches streams by connection, vhost and name. Empty fields
+// match anything; Name may be a path.Match pattern such as "orders-*".
+type StreamSelector struct {
+	Connection string `yaml:"connection"`
+	VHost      string `yaml:"vhost"`
+	Name       string `yaml:"name"`
+}
+
+// Matches reports whether the selector matches a stream
+func (s StreamSelector) Matches(connection, vhost, name string) bool {
+	if s.Connection != "" && s.Connection != connection {
+		return false
+	}
+	if s.VHost != "" && s.VHost != vhost {
+		return false
+	}
+	if s.Name == "" {
+		return true
+	}
+	matched, _ := path.Match(s.Name, name)
+	return matched
+}
+
+// Alert rule types
+const (
+	// AlertConsumerLag fires when a named consumer lags more than the threshold
+	AlertConsumerLag = "consumer_lag"
+	// AlertStreamStalled fires when a stream's last offset stops advancing
+	AlertStreamStalled = "stream_stalled"
+	// AlertTruncation fires when retention moves a stream's first offset
+	AlertTruncation = "truncation"
+	// AlertConnectionUnreachable fires when a connection's management API can't be reached
+	AlertConnectionUnreachable = "connection_unreachable"
+)
+
+// AlertsConfig holds the alert rules and the webhooks they notify
+type AlertsConfig struct {
+	// Interval between evaluations (default: 30s); alerting is disabled without rules
+	Interval time.Duration   `yaml:"interval"`
+	Rules    []AlertRule     `yaml:"rules"`
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
+
+// AlertRule is a condition checked on every evaluation
+type AlertRule struct {
+	Name string `yaml:"name" json:"name"`
+	Type string `yaml:"type" json:"type"`
+	// Connection, VHost and Stream select what the rule watches. Empty fields
+	// match anything; Stream may be a path.Match pattern.
+	Connection string `yaml:"connection" json:"connection,omitempty"`
+	VHost      string `yaml:"vhost" json:"vhost,omitempty"`
+	Stream     string `yaml:"stream" json:"stream,omitempty"`
+	// Consumer selects the named consumers of a consumer_lag rule; may be a pattern
+	Consumer string `yaml:"consumer" json:"consumer,omitempty"`
+	// Threshold is the lag a consumer_lag rule fires above, and the smallest
+	// first offset jump a truncation rule reports (default: 1)
+	Threshold int64 `yaml:"threshold" json:"threshold,omitempty"`
+	// For is how long the condition must hold before the alert fires
+	For time.Duration `yaml:"for" json:"for,omitempty"`
+	// Webhooks names the webhooks to notify; every webhook is notified when empty
+	Webhooks []string `yaml:"webhooks" json:"webhooks,omitempty"`
+}
+
+// Selector returns the streams the rule watches
+func (r AlertRule) Selector() StreamSelector {
+	return StreamSelector{Connection: r.Connection, VHost: r.VHost, Name: r.Stream}
+}
+
+// WebhookConfig is an HTTP endpoint notified when alerts fire and resolve
+type WebhookConfig struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	// Template is a Go text/template rendering the JSON body from the alert;
+	// the alert itself is sent when empty
+	Template string `yaml:"template"`
+	// Timeout bounds a delivery (default: 10s)
+	Timeout time.Duration `yaml:"timeout"`
 }
 
 // ConnectionConfig represents a RabbitMQ connection
@@ -26,9 +468,44 @@ type ConnectionConfig struct {
 	Port       int    `yaml:"port" json:"port"`
 	VHost      string `yaml:"vhost" json:"vhost"`
 	Username   string `yaml:"username" json:"username"`
-	Password   string `yaml:"password" json:"password"`
+	Password   string `yaml:"password" json:"-"`
 	HTTPPort   int    `yaml:"http_port" json:"http_port"`     // For management API
 	StreamPort int    `yaml:"stream_port" json:"stream_port"` // For stream protocol (default: 5552)
+	// ReadTimeout is the idle timeout for message reads (default: 5s)
+	ReadTimeout time.Duration `yaml:"read_timeout" json:"-"`
+	// MaxReadTimeout caps the timeout a read may request (default: 1m, at least read_timeout)
+	MaxReadTimeout time.Duration `yaml:"max_read_timeout" json:"-"`
+	// ReadOnly disables publishing through the viewer (default: true)
+	ReadOnly *bool `yaml:"read_only" json:"read_only"`
+	// Credentials selects whose credentials API calls use: the configured
+	// username and password (service, the default), or those of the user
+	// logged in with their RabbitMQ credentials (user). With user, username
+	// and password are optional and only used by the stats collector, alerts
+	// and the audit stream.
+	Credentials string `yaml:"credentials" json:"credentials,omitempty"`
+}
+
+// Connection credentials modes
+const (
+	CredentialsService = "service"
+	CredentialsUser    = "user"
+)
+
+// UserCredentials reports whether API calls to the connection use the
+// credentials of the user logged in
+func (c *ConnectionConfig) UserCredentials() bool {
+	return c.Credentials == CredentialsUser
+}
+
+// HasServiceAccount reports whether a username is configured for the
+// connection's background work
+func (c *ConnectionConfig) HasServiceAccount() bool {
+	return c.Username != ""
+}
+
+// IsReadOnly reports whether publishing to the connection is disabled
+func (c *ConnectionConfig) IsReadOnly() bool {
+	return c.ReadOnly == nil || *c.ReadOnly
 }
 
 // AMQPURL returns the AMQP connection URL
@@ -70,12 +547,19 @@ func (c *Config) Validate() error {
 	if c.Server.Port <= 0 {
 		return fmt.Errorf("server port must be positive")
 	}
+	if err := c.Server.Auth.validate(); err != nil {
+		return err
+	}
+	if err := c.Server.validate(); err != nil {
+		return err
+	}
 
 	if len(c.Connections) == 0 {
 		return fmt.Errorf("at least one connection must be configured")
 	}
 
 	seen := make(map[string]bool)
+	userCredentials := false
 	for i, conn := range c.Connections {
 		if conn.ID == "" {
 			return fmt.Errorf("connection %d: ID is required", i)
@@ -94,7 +578,19 @@ func (c *Config) Validate() error {
 		if conn.HTTPPort <= 0 {
 			return fmt.Errorf("connection '%s': http_port must be positive", conn.ID)
 		}
-		if conn.Username == "" {
+		switch conn.Credentials {
+		case "":
+			c.Connections[i].Credentials = CredentialsService
+		case CredentialsService, CredentialsUser:
+		default:
+			return fmt.Errorf("connection '%s': credentials must be %s or %s", conn.ID, CredentialsService, CredentialsUser)
+		}
+		if conn.Credentials == CredentialsUser {
+			userCredentials = true
+			if c.Server.Auth.Broker == nil {
+				return fmt.Errorf("connection '%s': credentials: user needs auth.broker", conn.ID)
+			}
+		} else if conn.Username == "" {
 			return fmt.Errorf("connection '%s': username is required", conn.ID)
 		}
 
@@ -102,8 +598,440 @@ func (c *Config) Validate() error {
 		if c.Connections[i].StreamPort <= 0 {
 			c.Connections[i].StreamPort = 5552
 		}
+
+		if conn.ReadTimeout < 0 {
+			return fmt.Errorf("connection '%s': read_timeout must not be negative", conn.ID)
+		}
+		if conn.ReadTimeout == 0 {
+			c.Connections[i].ReadTimeout = DefaultReadTimeout
+		}
+		if conn.MaxReadTimeout < 0 {
+			return fmt.Errorf("connection '%s': max_read_timeout must not be negative", conn.ID)
+		}
+		if conn.MaxReadTimeout == 0 {
+			c.Connections[i].MaxReadTimeout = DefaultMaxReadTimeout
+			if c.Connections[i].ReadTimeout > DefaultMaxReadTimeout {
+				c.Connections[i].MaxReadTimeout = c.Connections[i].ReadTimeout
+			}
+		} else if conn.MaxReadTimeout < c.Connections[i].ReadTimeout {
+			return fmt.Errorf("connection '%s': max_read_timeout must not be below read_timeout", conn.ID)
+		}
+
+		if conn.ReadOnly == nil {
+			readOnly := true
+			c.Connections[i].ReadOnly = &readOnly
+		}
+	}
+	if c.Server.Auth.Broker != nil && !userCredentials {
+		return fmt.Errorf("auth: broker needs a connection with credentials: user")
+	}
+
+	if c.Stats.Interval < 0 {
+		return fmt.Errorf("stats: interval must not be negative")
+	}
+	if c.Stats.Retention < 0 {
+		return fmt.Errorf("stats: retention must not be negative")
+	}
+	if c.Stats.Retention == 0 {
+		c.Stats.Retention = DefaultStatsRetention
+	}
+	for i, sel := range c.Stats.Streams {
+		if _, err := path.Match(sel.Name, ""); err != nil {
+			return fmt.Errorf("stats: streams[%d]: invalid name pattern '%s'", i, sel.Name)
+		}
+	}
+
+	if c.Copies.Retention < 0 {
+		return fmt.Errorf("copies: retention must not be negative")
+	}
+	if c.Copies.Retention == 0 {
+		c.Copies.Retention = DefaultCopyRetention
+	}
+
+	if err := c.Alerts.validate(); err != nil {
+		return err
+	}
+	if err := c.Redaction.validate(); err != nil {
+		return err
+	}
+	return c.Audit.validate(c.Connections)
+}
+
+// validate checks the audit log settings and fills in their defaults
+func (a *AuditConfig) validate(connections []ConnectionConfig) error {
+	if a.MaxSizeMB < 0 {
+		return fmt.Errorf("audit: max_size_mb must not be negative")
+	}
+	if a.MaxSizeMB == 0 {
+		a.MaxSizeMB = DefaultAuditMaxSizeMB
+	}
+	if a.MaxFiles < 0 {
+		return fmt.Errorf("audit: max_files must not be negative")
+	}
+	if a.MaxFiles == 0 {
+		a.MaxFiles = DefaultAuditMaxFiles
+	}
+
+	if a.Stream == nil {
+		return nil
+	}
+	if a.File == "" {
+		return fmt.Errorf("audit: stream requires file")
+	}
+	if a.Stream.Name == "" {
+		return fmt.Errorf("audit: stream: name is required")
+	}
+	for i := range connections {
+		if connections[i].ID != a.Stream.Connection {
+			continue
+		}
+		if connections[i].IsReadOnly() {
+			return fmt.Errorf("audit: stream: connection '%s' is read-only", a.Stream.Connection)
+		}
+		if !connections[i].HasServiceAccount() {
+			return fmt.Errorf("audit: stream: connection '%s' has no username to publish with", a.Stream.Connection)
+		}
+		if a.Stream.VHost == "" {
+			a.Stream.VHost = connections[i].VHost
+		}
+		if a.Stream.VHost == "" {
+			a.Stream.VHost = "/"
+		}
+		return nil
+	}
+	return fmt.Errorf("audit: stream: unknown connection '%s'", a.Stream.Connection)
+}
+
+// validate checks the base path, forwarded headers, TLS, CORS and security
+// header settings and fills in their defaults
+func (s *ServerConfig) validate() error {
+	s.BasePath = strings.TrimRight(s.BasePath, "/")
+	if !ValidBasePath(s.BasePath) {
+		return fmt.Errorf("server: base_path must be a path such as /rmq, got '%s'", s.BasePath)
+	}
+	if len(s.TrustedProxies) == 0 {
+		s.TrustedProxies = append([]string(nil), DefaultTrustedProxies...)
+	}
+	if s.Auth.Session.Secure == nil {
+		secure := s.TLS != nil
+		s.Auth.Session.Secure = &secure
+	}
+	for _, cidr := range s.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("server: invalid trusted proxy '%s'", cidr)
+		}
+	}
+
+	if t := s.TLS; t != nil {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return fmt.Errorf("tls: cert_file and key_file are required")
+		}
+		switch t.ClientAuth {
+		case "":
+			t.ClientAuth = ClientAuthNone
+			if t.ClientCAFile != "" {
+				t.ClientAuth = ClientAuthRequire
+			}
+		case ClientAuthNone:
+		case ClientAuthVerifyIfGiven, ClientAuthRequire:
+			if t.ClientCAFile == "" {
+				return fmt.Errorf("tls: client_auth %s needs client_ca_file", t.ClientAuth)
+			}
+		default:
+			return fmt.Errorf("tls: client_auth must be %s, %s or %s", ClientAuthNone, ClientAuthVerifyIfGiven, ClientAuthRequire)
+		}
+		switch t.MinVersion {
+		case "":
+			t.MinVersion = "1.2"
+		case "1.2", "1.3":
+		default:
+			return fmt.Errorf("tls: min_version must be 1.2 or 1.3")
+		}
+		if t.ReloadInterval < 0 {
+			return fmt.Errorf("tls: reload_interval must not be negative")
+		}
+		if t.ReloadInterval == 0 {
+			t.ReloadInterval = DefaultTLSReloadInterval
+		}
+	}
+	if s.Auth.ClientCert != nil && (s.TLS == nil || s.TLS.ClientAuth == ClientAuthNone) {
+		return fmt.Errorf("auth: client_cert needs tls.client_ca_file")
+	}
+
+	c := &s.CORS
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return fmt.Errorf("cors: allow_credentials can't be combined with the origin \"*\"")
+			}
+			continue
+		}
+		if _, err := path.Match(origin, ""); err != nil || !strings.Contains(origin, "://") {
+			return fmt.Errorf("cors: invalid origin '%s', expected e.g. https://tools.example", origin)
+		}
+	}
+	if len(c.AllowedMethods) == 0 {
+		c.AllowedMethods = []string{"GET", "POST", "PUT", "DELETE"}
+	}
+	for i, method := range c.AllowedMethods {
+		c.AllowedMethods[i] = strings.ToUpper(method)
+	}
+	if len(c.AllowedHeaders) == 0 {
+		c.AllowedHeaders = []string{"Content-Type", "Authorization"}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("cors: max_age must not be negative")
 	}
 
+	h := &s.Headers
+	if h.ContentSecurityPolicy == "" {
+		h.ContentSecurityPolicy = DefaultCSP
+	}
+	if strings.Contains(h.ContentSecurityPolicy, "frame-ancestors") {
+		return fmt.Errorf("headers: set frame-ancestors with frame_ancestors rather than in content_security_policy")
+	}
+	if len(h.FrameAncestors) == 0 {
+		h.FrameAncestors = []string{"'none'"}
+	}
+	if h.HSTSMaxAge == 0 {
+		h.HSTSMaxAge = DefaultHSTSMaxAge
+	}
 	return nil
 }
 
+// validate checks the authentication methods and fills in their defaults
+func (a *AuthConfig) validate() error {
+	users := make(map[string]bool)
+	for i, user := range a.Users {
+		if user.Username == "" {
+			return fmt.Errorf("auth: users[%d]: username is required", i)
+		}
+		if users[user.Username] {
+			return fmt.Errorf("auth: users[%d]: duplicate username '%s'", i, user.Username)
+		}
+		users[user.Username] = true
+		if !strings.HasPrefix(user.PasswordHash, "$2") {
+			return fmt.Errorf("auth: user '%s': password_hash must be a bcrypt hash", user.Username)
+		}
+	}
+	if a.Basic && len(a.Users) == 0 {
+		return fmt.Errorf("auth: basic needs at least one user")
+	}
+
+	if o := a.OIDC; o != nil {
+		if o.Issuer == "" || o.ClientID == "" || o.RedirectURL == "" {
+			return fmt.Errorf("auth: oidc: issuer, client_id and redirect_url are required")
+		}
+		if len(o.Scopes) == 0 {
+			o.Scopes = []string{"profile", "email"}
+		}
+		if o.GroupsClaim == "" {
+			o.GroupsClaim = "groups"
+		}
+	}
+
+	if p := a.Proxy; p != nil {
+		if len(p.TrustedProxies) == 0 {
+			return fmt.Errorf("auth: proxy: trusted_proxies is required")
+		}
+		for _, cidr := range p.TrustedProxies {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("auth: proxy: invalid trusted proxy '%s'", cidr)
+			}
+		}
+		if p.UserHeader == "" {
+			p.UserHeader = "X-Forwarded-User"
+		}
+		if p.GroupsHeader == "" {
+			p.GroupsHeader = "X-Forwarded-Groups"
+		}
+	}
+
+	if a.Session.TTL < 0 {
+		return fmt.Errorf("auth: session: ttl must not be negative")
+	}
+	if a.Session.TTL == 0 {
+		a.Session.TTL = DefaultSessionTTL
+	}
+
+	if b := a.Broker; b != nil && b.OIDCToken && a.OIDC == nil {
+		return fmt.Errorf("auth: broker: oidc_token needs oidc")
+	}
+
+	if c := a.ClientCert; c != nil {
+		switch c.Username {
+		case "":
+			c.Username = CertUsernameCN
+		case CertUsernameCN, CertUsernameEmail:
+		default:
+			return fmt.Errorf("auth: client_cert: username must be %s or %s", CertUsernameCN, CertUsernameEmail)
+		}
+	}
+
+	if t := a.Tokens; t != nil {
+		if !a.Enabled() {
+			return fmt.Errorf("auth: tokens need users, oidc, proxy, broker or client_cert to identify who creates them")
+		}
+		if t.File == "" {
+			return fmt.Errorf("auth: tokens: file is required")
+		}
+		if t.MaxTTL < 0 {
+			return fmt.Errorf("auth: tokens: max_ttl must not be negative")
+		}
+	}
+
+	if len(a.Access) > 0 && !a.Enabled() {
+		return fmt.Errorf("auth: access rules need users, oidc, proxy, broker or client_cert to identify users")
+	}
+	for i, rule := range a.Access {
+		if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+			return fmt.Errorf("auth: access[%d]: users or groups are required", i)
+		}
+		if len(rule.Permissions) == 0 {
+			return fmt.Errorf("auth: access[%d]: permissions are required", i)
+		}
+		for _, perm := range rule.Permissions {
+			switch perm {
+			case PermList, PermReadMetadata, PermReadPayload, PermPublish, PermAdmin, PermUnmask:
+			default:
+				return fmt.Errorf("auth: access[%d]: unknown permission '%s'", i, perm)
+			}
+		}
+		for _, pattern := range []string{rule.Connection, rule.VHost, rule.Stream} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("auth: access[%d]: invalid pattern '%s'", i, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks the redaction rules and fills in the default mask. JSON paths
+// are parsed by the redact package when the rules are compiled.
+func (r *RedactionConfig) validate() error {
+	if r.Mask == "" {
+		r.Mask = DefaultRedactionMask
+	}
+
+	names := make(map[string]bool)
+	for i, rule := range r.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("redaction: rules[%d]: name is required", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("redaction: rules[%d]: duplicate name '%s'", i, rule.Name)
+		}
+		names[rule.Name] = true
+
+		if len(rule.JSONPaths) == 0 && len(rule.AppProperties) == 0 && len(rule.Patterns) == 0 {
+			return fmt.Errorf("redaction: rule '%s': json_paths, app_properties or patterns are required", rule.Name)
+		}
+		for _, p := range rule.JSONPaths {
+			if !strings.HasPrefix(p, "$") {
+				return fmt.Errorf("redaction: rule '%s': json path '%s' must start with $", rule.Name, p)
+			}
+		}
+		for _, pattern := range append([]string{rule.Connection, rule.VHost, rule.Stream}, rule.AppProperties...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("redaction: rule '%s': invalid pattern '%s'", rule.Name, pattern)
+			}
+		}
+		for _, pattern := range rule.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("redaction: rule '%s': invalid regular expression '%s': %w", rule.Name, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks the alert rules and webhooks and fills in their defaults
+func (a *AlertsConfig) validate() error {
+	if a.Interval < 0 {
+		return fmt.Errorf("alerts: interval must not be negative")
+	}
+	if a.Interval == 0 {
+		a.Interval = DefaultAlertInterval
+	}
+
+	webhooks := make(map[string]bool)
+	for i, hook := range a.Webhooks {
+		if hook.Name == "" {
+			return fmt.Errorf("alerts: webhooks[%d]: name is required", i)
+		}
+		if webhooks[hook.Name] {
+			return fmt.Errorf("alerts: webhooks[%d]: duplicate name '%s'", i, hook.Name)
+		}
+		webhooks[hook.Name] = true
+
+		u, err := url.Parse(hook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("alerts: webhook '%s': url must be an http or https URL", hook.Name)
+		}
+		if hook.Timeout < 0 {
+			return fmt.Errorf("alerts: webhook '%s': timeout must not be negative", hook.Name)
+		}
+		if hook.Timeout == 0 {
+			a.Webhooks[i].Timeout = DefaultWebhookTimeout
+		}
+	}
+
+	rules := make(map[string]bool)
+	for i, rule := range a.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("alerts: rules[%d]: name is required", i)
+		}
+		if rules[rule.Name] {
+			return fmt.Errorf("alerts: rules[%d]: duplicate name '%s'", i, rule.Name)
+		}
+		rules[rule.Name] = true
+
+		switch rule.Type {
+		case AlertConsumerLag:
+			if rule.Threshold <= 0 {
+				return fmt.Errorf("alerts: rule '%s': threshold must be positive", rule.Name)
+			}
+		case AlertTruncation:
+			if rule.Threshold < 0 {
+				return fmt.Errorf("alerts: rule '%s': threshold must not be negative", rule.Name)
+			}
+			if rule.Threshold == 0 {
+				a.Rules[i].Threshold = 1
+			}
+		case AlertStreamStalled, AlertConnectionUnreachable:
+		default:
+			return fmt.Errorf("alerts: rule '%s': unknown type '%s'", rule.Name, rule.Type)
+		}
+
+		if rule.For < 0 {
+			return fmt.Errorf("alerts: rule '%s': for must not be negative", rule.Name)
+		}
+		for _, pattern := range []string{rule.Stream, rule.Consumer} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("alerts: rule '%s': invalid pattern '%s'", rule.Name, pattern)
+			}
+		}
+		for _, name := range rule.Webhooks {
+			if !webhooks[name] {
+				return fmt.Errorf("alerts: rule '%s': unknown webhook '%s'", rule.Name, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+
+// FindConnection returns the connection with the given ID, or the first
+// connection when id is empty
+func (c *Config) FindConnection(id string) (ConnectionConfig, bool) {
+	if id == "" && len(c.Connections) > 0 {
+		return c.Connections[0], true
+	}
+	for _, conn := range c.Connections {
+		if conn.ID == id {
+			return conn, true
+		}
+	}
+	return ConnectionConfig{}, false
+}
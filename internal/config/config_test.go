@@ -1,9 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -103,6 +106,16 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "negative read timeout",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Connections: []ConnectionConfig{
+					{ID: "conn1", Host: "localhost", Port: 5672, HTTPPort: 15672, Username: "guest", ReadTimeout: -time.Second},
+				},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -137,3 +150,534 @@ func TestAMQPURL(t *testing.T) {
 	}
 }
 
+
+func TestLoad_ReadTimeout(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.yaml")
+
+	configData := `
+server:
+  port: 8080
+connections:
+  - id: fast
+    host: localhost
+    port: 5672
+    username: guest
+    http_port: 15672
+    read_timeout: 750ms
+    max_read_timeout: 10s
+  - id: default
+    host: localhost
+    port: 5672
+    username: guest
+    http_port: 15672
+`
+
+	if err := os.WriteFile(configPath, []byte(configData), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if got := cfg.Connections[0].ReadTimeout; got != 750*time.Millisecond {
+		t.Errorf("Expected read_timeout 750ms, got %v", got)
+	}
+	if got := cfg.Connections[1].ReadTimeout; got != DefaultReadTimeout {
+		t.Errorf("Expected default read_timeout %v, got %v", DefaultReadTimeout, got)
+	}
+	if got := cfg.Connections[0].MaxReadTimeout; got != 10*time.Second {
+		t.Errorf("Expected max_read_timeout 10s, got %v", got)
+	}
+	if got := cfg.Connections[1].MaxReadTimeout; got != DefaultMaxReadTimeout {
+		t.Errorf("Expected default max_read_timeout %v, got %v", DefaultMaxReadTimeout, got)
+	}
+
+	below := strings.Replace(configData, "max_read_timeout: 10s", "max_read_timeout: 500ms", 1)
+	if err := os.WriteFile(configPath, []byte(below), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+	if _, err := Load(configPath); err == nil {
+		t.Error("Expected an error for a max_read_timeout below read_timeout")
+	}
+}
+
+func TestLoad_ReadOnly(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.yaml")
+
+	configData := `
+server:
+  port: 8080
+connections:
+  - id: writable
+    host: localhost
+    port: 5672
+    username: guest
+    http_port: 15672
+    read_only: false
+  - id: default
+    host: localhost
+    port: 5672
+    username: guest
+    http_port: 15672
+`
+
+	if err := os.WriteFile(configPath, []byte(configData), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Connections[0].IsReadOnly() {
+		t.Error("Expected read_only: false to allow publishing")
+	}
+	if !cfg.Connections[1].IsReadOnly() {
+		t.Error("Expected connections to be read-only by default")
+	}
+}
+
+func TestFindConnection(t *testing.T) {
+	cfg := &Config{Connections: []ConnectionConfig{{ID: "dev"}, {ID: "prod"}}}
+
+	if conn, ok := cfg.FindConnection(""); !ok || conn.ID != "dev" {
+		t.Errorf("expected the first connection by default, got %q", conn.ID)
+	}
+	if conn, ok := cfg.FindConnection("prod"); !ok || conn.ID != "prod" {
+		t.Errorf("expected prod, got %q", conn.ID)
+	}
+	if _, ok := cfg.FindConnection("missing"); ok {
+		t.Error("expected missing connection not to be found")
+	}
+}
+
+func TestLoad_Stats(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.yaml")
+
+	configData := `
+server:
+  port: 8080
+connections:
+  - id: dev
+    host: localhost
+    port: 5672
+    username: guest
+    http_port: 15672
+stats:
+  interval: 30s
+  file: stats.json
+  streams:
+    - connection: dev
+      name: orders-*
+`
+
+	if err := os.WriteFile(configPath, []byte(configData), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Stats.Interval != 30*time.Second || cfg.Stats.File != "stats.json" {
+		t.Errorf("Unexpected stats config: %+v", cfg.Stats)
+	}
+	if cfg.Stats.Retention != DefaultStatsRetention {
+		t.Errorf("Expected default retention %v, got %v", DefaultStatsRetention, cfg.Stats.Retention)
+	}
+
+	sel := cfg.Stats.Streams[0]
+	if !sel.Matches("dev", "/", "orders-eu") {
+		t.Error("Expected selector to match orders-eu on dev")
+	}
+	if sel.Matches("prod", "/", "orders-eu") || sel.Matches("dev", "/", "invoices") {
+		t.Error("Expected selector to reject other connections and names")
+	}
+}
+
+func TestLoad_Alerts(t *testing.T) {
+	base := `
+server:
+  port: 8080
+connections:
+  - id: dev
+    host: localhost
+    port: 5672
+    username: guest
+    http_port: 15672
+`
+	load := func(alerts string) (*Config, error) {
+		configPath := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(configPath, []byte(base+alerts), 0644); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+		return Load(configPath)
+	}
+
+	cfg, err := load(`
+alerts:
+  rules:
+    - name: billing-lag
+      type: consumer_lag
+      stream: orders
+      consumer: billing
+      threshold: 100000
+      for: 5m
+      webhooks: [chat]
+    - name: truncated
+      type: truncation
+  webhooks:
+    - name: chat
+      url: https://hooks.example.com/alerts
+`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Alerts.Interval != DefaultAlertInterval || cfg.Alerts.Webhooks[0].Timeout != DefaultWebhookTimeout {
+		t.Errorf("Expected default interval and timeout, got %+v", cfg.Alerts)
+	}
+	rule := cfg.Alerts.Rules[0]
+	if rule.For != 5*time.Minute || rule.Threshold != 100000 || !rule.Selector().Matches("dev", "/", "orders") {
+		t.Errorf("Unexpected rule: %+v", rule)
+	}
+	if cfg.Alerts.Rules[1].Threshold != 1 {
+		t.Errorf("Expected truncation threshold to default to 1, got %d", cfg.Alerts.Rules[1].Threshold)
+	}
+
+	invalid := map[string]string{
+		"unknown type":      "alerts:\n  rules:\n    - name: a\n      type: disk_full\n",
+		"missing threshold": "alerts:\n  rules:\n    - name: a\n      type: consumer_lag\n",
+		"duplicate rule":    "alerts:\n  rules:\n    - name: a\n      type: stream_stalled\n    - name: a\n      type: stream_stalled\n",
+		"unknown webhook":   "alerts:\n  rules:\n    - name: a\n      type: stream_stalled\n      webhooks: [chat]\n",
+		"invalid url":       "alerts:\n  webhooks:\n    - name: chat\n      url: hooks.example.com\n",
+	}
+	for name, alerts := range invalid {
+		if _, err := load(alerts); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestLoad_Auth(t *testing.T) {
+	base := `
+connections:
+  - id: dev
+    host: localhost
+    port: 5672
+    username: guest
+    http_port: 15672
+server:
+  port: 8080
+`
+	load := func(auth string) (*Config, error) {
+		configPath := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(configPath, []byte(base+auth), 0644); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+		return Load(configPath)
+	}
+
+	cfg, err := load(`  auth:
+    users:
+      - username: alice
+        password_hash: "$2a$10$Qf/UyYwwAuewcZ1bE2/WiOAG/.uvZurq2Q.W.rV/w9IHsGfETwpQC"
+    basic: true
+    oidc:
+      issuer: https://login.example.com
+      client_id: viewer
+      redirect_url: https://viewer.example.com/auth/oidc/callback
+    proxy:
+      trusted_proxies: [10.0.0.0/8]
+    access:
+      - groups: [support]
+        permissions: [read-metadata]
+        connection: prod
+`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	a := cfg.Server.Auth
+	if !a.Enabled() || a.Session.TTL != DefaultSessionTTL {
+		t.Errorf("Expected auth enabled with the default session TTL, got %+v", a)
+	}
+	if a.OIDC.GroupsClaim != "groups" || len(a.OIDC.Scopes) != 2 || a.Proxy.UserHeader != "X-Forwarded-User" {
+		t.Errorf("Expected OIDC and proxy defaults, got %+v %+v", a.OIDC, a.Proxy)
+	}
+	if len(a.Access) != 1 || a.Access[0].Permissions[0] != PermReadMetadata || a.Access[0].Connection != "prod" {
+		t.Errorf("Expected the access rule, got %+v", a.Access)
+	}
+
+	cfg, err = load("")
+	if err != nil || cfg.Server.Auth.Enabled() {
+		t.Errorf("Expected auth to be off by default, got %v", err)
+	}
+
+	invalid := map[string]string{
+		"plain password":   "  auth:\n    users:\n      - username: alice\n        password_hash: secret\n",
+		"basic no users":   "  auth:\n    basic: true\n",
+		"oidc no client":   "  auth:\n    oidc:\n      issuer: https://login.example.com\n",
+		"proxy no trusted": "  auth:\n    proxy:\n      user_header: X-User\n",
+		"proxy bad cidr":   "  auth:\n    proxy:\n      trusted_proxies: [10.0.0.1]\n",
+		"access no auth":   "  auth:\n    access:\n      - users: [alice]\n        permissions: [list]\n",
+		"access no users":  "  auth:\n    proxy:\n      trusted_proxies: [10.0.0.0/8]\n    access:\n      - permissions: [list]\n",
+		"access bad perm":  "  auth:\n    proxy:\n      trusted_proxies: [10.0.0.0/8]\n    access:\n      - users: ['*']\n        permissions: [write]\n",
+		"access bad glob":  "  auth:\n    proxy:\n      trusted_proxies: [10.0.0.0/8]\n    access:\n      - users: ['*']\n        permissions: [list]\n        stream: '['\n",
+		"tokens no auth":   "  auth:\n    tokens:\n      file: tokens.json\n",
+		"tokens no file":   "  auth:\n    proxy:\n      trusted_proxies: [10.0.0.0/8]\n    tokens:\n      max_ttl: 720h\n",
+	}
+	for name, auth := range invalid {
+		if _, err := load(auth); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestLoad_Redaction(t *testing.T) {
+	base := `
+connections:
+  - id: dev
+    host: localhost
+    port: 5672
+    username: guest
+    http_port: 15672
+server:
+  port: 8080
+`
+	load := func(redaction string) (*Config, error) {
+		configPath := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(configPath, []byte(base+redaction), 0644); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+		return Load(configPath)
+	}
+
+	cfg, err := load(`redaction:
+  rules:
+    - name: pii
+      stream: "orders*"
+      json_paths: [$.customer.email]
+      app_properties: [customer_*]
+      patterns: ['\d{4}-\d{4}-\d{4}-\d{4}']
+`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Redaction.Mask != DefaultRedactionMask || len(cfg.Redaction.Rules) != 1 || cfg.Redaction.Rules[0].JSONPaths[0] != "$.customer.email" {
+		t.Errorf("Expected the redaction rule with the default mask, got %+v", cfg.Redaction)
+	}
+
+	invalid := map[string]string{
+		"no name":      "redaction:\n  rules:\n    - json_paths: [$.a]\n",
+		"nothing":      "redaction:\n  rules:\n    - name: empty\n",
+		"bad path":     "redaction:\n  rules:\n    - name: pii\n      json_paths: [customer.email]\n",
+		"bad regexp":   "redaction:\n  rules:\n    - name: pii\n      patterns: ['(']\n",
+		"bad property": "redaction:\n  rules:\n    - name: pii\n      app_properties: ['[']\n",
+	}
+	for name, redaction := range invalid {
+		if _, err := load(redaction); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestLoad_Audit(t *testing.T) {
+	base := `
+connections:
+  - id: dev
+    host: localhost
+    port: 5672
+    username: guest
+    http_port: 15672
+  - id: ops
+    host: localhost
+    port: 5672
+    vhost: compliance
+    username: guest
+    http_port: 15672
+    read_only: false
+server:
+  port: 8080
+`
+	load := func(audit string) (*Config, error) {
+		configPath := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(configPath, []byte(base+audit), 0644); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+		return Load(configPath)
+	}
+
+	cfg, err := load("audit:\n  file: /var/log/viewer/audit.log\n  stream:\n    connection: ops\n    name: viewer-audit\n")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Audit.MaxSizeMB != DefaultAuditMaxSizeMB || cfg.Audit.MaxFiles != DefaultAuditMaxFiles {
+		t.Errorf("Expected the default rotation settings, got %+v", cfg.Audit)
+	}
+	if cfg.Audit.Stream.VHost != "compliance" {
+		t.Errorf("Expected the audit stream to default to the connection's vhost, got %q", cfg.Audit.Stream.VHost)
+	}
+
+	invalid := map[string]string{
+		"negative size":        "audit:\n  file: audit.log\n  max_size_mb: -1\n",
+		"stream without file":  "audit:\n  stream:\n    connection: ops\n    name: viewer-audit\n",
+		"stream without name":  "audit:\n  file: audit.log\n  stream:\n    connection: ops\n",
+		"unknown connection":   "audit:\n  file: audit.log\n  stream:\n    connection: prod\n    name: viewer-audit\n",
+		"read-only connection": "audit:\n  file: audit.log\n  stream:\n    connection: dev\n    name: viewer-audit\n",
+	}
+	for name, audit := range invalid {
+		if _, err := load(audit); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestLoad_BrokerCredentials(t *testing.T) {
+	load := func(config string) (*Config, error) {
+		configPath := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+		return Load(configPath)
+	}
+	connections := `
+connections:
+  - id: dev
+    host: localhost
+    port: 5672
+    username: guest
+    http_port: 15672
+  - id: prod
+    host: localhost
+    port: 5672
+    http_port: 15672
+    read_only: false
+    credentials: %s
+server:
+  port: 8080
+`
+
+	cfg, err := load(fmt.Sprintf(connections, "user") + "  auth:\n    broker: {}\n")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Connections[0].Credentials != CredentialsService || cfg.Connections[0].UserCredentials() {
+		t.Errorf("Expected connections to use their service account by default, got %q", cfg.Connections[0].Credentials)
+	}
+	if !cfg.Connections[1].UserCredentials() || cfg.Connections[1].HasServiceAccount() {
+		t.Errorf("Expected prod to use its users' credentials without a service account, got %+v", cfg.Connections[1])
+	}
+	if !cfg.Server.Auth.Enabled() {
+		t.Error("Expected broker logins to enable authentication")
+	}
+
+	invalid := map[string]string{
+		"user without broker":       fmt.Sprintf(connections, "user"),
+		"service without username":  fmt.Sprintf(connections, "service") + "  auth:\n    broker: {}\n",
+		"unknown credentials":       fmt.Sprintf(connections, "shared") + "  auth:\n    broker: {}\n",
+		"oidc_token without oidc":   fmt.Sprintf(connections, "user") + "  auth:\n    broker:\n      oidc_token: true\n",
+		"audit stream without user": fmt.Sprintf(connections, "user") + "  auth:\n    broker: {}\naudit:\n  file: audit.log\n  stream:\n    connection: prod\n    name: audit\n",
+	}
+	for name, config := range invalid {
+		if _, err := load(config); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestLoad_Server(t *testing.T) {
+	load := func(server string) (*Config, error) {
+		configPath := filepath.Join(t.TempDir(), "config.yaml")
+		config := `
+connections:
+  - id: dev
+    host: localhost
+    port: 5672
+    username: guest
+    http_port: 15672
+server:
+  port: 8443
+` + server
+		if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+		return Load(configPath)
+	}
+
+	cfg, err := load("")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Server.TLS != nil || len(cfg.Server.CORS.AllowedOrigins) != 0 {
+		t.Error("Expected plain HTTP without CORS by default")
+	}
+	if cfg.Server.Headers.ContentSecurityPolicy != DefaultCSP || cfg.Server.Headers.HSTSMaxAge != DefaultHSTSMaxAge {
+		t.Errorf("Expected default security headers, got %+v", cfg.Server.Headers)
+	}
+	if len(cfg.Server.Headers.FrameAncestors) != 1 || cfg.Server.Headers.FrameAncestors[0] != "'none'" {
+		t.Errorf("Expected frame-ancestors 'none' by default, got %v", cfg.Server.Headers.FrameAncestors)
+	}
+	if cfg.Server.BasePath != "" || len(cfg.Server.TrustedProxies) != len(DefaultTrustedProxies) {
+		t.Errorf("Expected the root base path and private networks as trusted proxies, got %q %v", cfg.Server.BasePath, cfg.Server.TrustedProxies)
+	}
+
+	cfg, err = load("  base_path: /tools/rmq/\n  trusted_proxies: [10.1.0.0/16]\n")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Server.BasePath != "/tools/rmq" || len(cfg.Server.TrustedProxies) != 1 {
+		t.Errorf("Expected base path /tools/rmq behind 10.1.0.0/16, got %q %v", cfg.Server.BasePath, cfg.Server.TrustedProxies)
+	}
+
+	cfg, err = load(`  tls:
+    cert_file: tls.crt
+    key_file: tls.key
+    client_ca_file: clients.pem
+  cors:
+    allowed_origins: [https://tools.example]
+    allowed_methods: [get]
+    allow_credentials: true
+  auth:
+    client_cert: {}
+`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	tls := cfg.Server.TLS
+	if tls.ClientAuth != ClientAuthRequire || tls.MinVersion != "1.2" || tls.ReloadInterval != DefaultTLSReloadInterval {
+		t.Errorf("Expected TLS defaults, got %+v", tls)
+	}
+	if cfg.Server.CORS.AllowedMethods[0] != "GET" || len(cfg.Server.CORS.AllowedHeaders) != 2 {
+		t.Errorf("Expected normalised CORS methods and default headers, got %+v", cfg.Server.CORS)
+	}
+	if cfg.Server.Auth.ClientCert.Username != CertUsernameCN || !cfg.Server.Auth.Enabled() {
+		t.Errorf("Expected client certificate logins named by CN, got %+v", cfg.Server.Auth.ClientCert)
+	}
+
+	invalid := map[string]string{
+		"tls without key":          "  tls:\n    cert_file: tls.crt\n",
+		"require without CA":       "  tls:\n    cert_file: tls.crt\n    key_file: tls.key\n    client_auth: require\n",
+		"unknown client_auth":      "  tls:\n    cert_file: tls.crt\n    key_file: tls.key\n    client_ca_file: ca.pem\n    client_auth: maybe\n",
+		"old min_version":          "  tls:\n    cert_file: tls.crt\n    key_file: tls.key\n    min_version: \"1.0\"\n",
+		"client_cert without CA":   "  tls:\n    cert_file: tls.crt\n    key_file: tls.key\n  auth:\n    client_cert: {}\n",
+		"client_cert without TLS":  "  auth:\n    client_cert: {}\n",
+		"unknown cert username":    "  tls:\n    cert_file: tls.crt\n    key_file: tls.key\n    client_ca_file: ca.pem\n  auth:\n    client_cert:\n      username: uid\n",
+		"any origin + credentials": "  cors:\n    allowed_origins: [\"*\"]\n    allow_credentials: true\n",
+		"origin without scheme":    "  cors:\n    allowed_origins: [tools.example]\n",
+		"frame-ancestors in csp":   "  headers:\n    content_security_policy: \"default-src 'self'; frame-ancestors 'self'\"\n",
+		"relative base_path":       "  base_path: rmq\n",
+		"base_path with quotes":    "  base_path: '/rmq\"'\n",
+		"invalid trusted proxy":    "  trusted_proxies: [proxy.example]\n",
+	}
+	for name, config := range invalid {
+		if _, err := load(config); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
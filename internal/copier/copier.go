@@ -0,0 +1,291 @@
+// Package copier copies a range of one stream into another stream, possibly
+// on a different connection or vhost. It is shared by the copy API endpoints
+// and the copy command.
+package copier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/export"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// Endpoint is a stream on a connection
+type Endpoint struct {
+	Conn   *rabbitmq.Connection
+	VHost  string
+	Stream string
+}
+
+// String identifies the endpoint in checkpoints and logs
+func (e Endpoint) String() string {
+	return fmt.Sprintf("%s/%s/%s", e.Conn.ID, e.VHost, e.Stream)
+}
+
+// Options selects the source range and how messages are copied
+type Options struct {
+	Offset uint64
+	// EndOffset, when set, is the last source offset copied
+	EndOffset *uint64
+	// From and To select a source time range; they narrow any offset range
+	From *time.Time
+	To   *time.Time
+	// Filters select which source messages are copied
+	Filters []export.Filter
+	// Transforms are applied to each copied message in order
+	Transforms []Transform
+	// ProducerName deduplicates the copy on the target; a random one is
+	// generated when empty and recorded in checkpoints
+	ProducerName string
+	// IdleTimeout bounds how long to wait for the next source message; zero uses the connection's read_timeout
+	IdleTimeout time.Duration
+	// Resume continues a previous copy from its checkpoint
+	Resume *Checkpoint
+	// Progress, when set, is called periodically and once at the end
+	Progress func(Progress)
+	// Checkpoint, when set, is called whenever every copied message before
+	// the checkpoint's NextOffset has been confirmed by the target
+	Checkpoint func(Checkpoint) error
+}
+
+// Progress reports how far a copy has got
+type Progress struct {
+	Scanned   int    `json:"scanned"`
+	Copied    int    `json:"copied"`
+	Confirmed int    `json:"confirmed"`
+	Failed    int    `json:"failed"`
+	Offset    uint64 `json:"offset"`
+}
+
+// Result summarises a finished copy
+type Result struct {
+	Progress
+	EndReason  string     `json:"end_reason"`
+	Checkpoint Checkpoint `json:"checkpoint"`
+}
+
+// Checkpoint is the resume point of a copy. Every source message before
+// NextOffset that passed the filters is confirmed on the target.
+type Checkpoint struct {
+	Source       string    `json:"source"`
+	Target       string    `json:"target"`
+	NextOffset   uint64    `json:"next_offset"`
+	ProducerName string    `json:"producer_name"`
+	Copied       int       `json:"copied"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// LoadCheckpoint reads a checkpoint file; it returns nil when the file does not exist
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+	return &checkpoint, nil
+}
+
+// Save atomically writes the checkpoint to path
+func (c Checkpoint) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(path, data); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// writeFile atomically replaces the file at path with data
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Run copies the selected source range into the target stream. Each message
+// is published with its source offset + 1 as publishing ID under the
+// producer name, so a resumed copy never duplicates what was already copied.
+// Reading doesn't wait for the target's confirms: checkpoints are recorded
+// once the messages before them are confirmed.
+func Run(ctx context.Context, source, target Endpoint, opts Options) (*Result, error) {
+	base := Checkpoint{Source: source.String(), Target: target.String()}
+	if resume := opts.Resume; resume != nil {
+		if resume.Source != base.Source || resume.Target != base.Target {
+			return nil, fmt.Errorf("checkpoint is for a copy from %s to %s", resume.Source, resume.Target)
+		}
+		if resume.NextOffset > opts.Offset {
+			opts.Offset = resume.NextOffset
+		}
+		opts.ProducerName = resume.ProducerName
+		base = *resume
+	}
+	if opts.ProducerName == "" {
+		opts.ProducerName = "copy-" + uuid.NewString()
+	}
+	base.ProducerName = opts.ProducerName
+	if base.NextOffset < opts.Offset {
+		base.NextOffset = opts.Offset
+	}
+
+	// Record the producer name before publishing so a copy that crashes
+	// early resumes with the same deduplication sequence
+	base.UpdatedAt = time.Now()
+	if opts.Checkpoint != nil {
+		if err := opts.Checkpoint(base); err != nil {
+			return nil, err
+		}
+	}
+
+	publisher, err := target.Conn.NewPublisher(target.VHost, target.Stream, rabbitmq.PublisherOptions{Name: opts.ProducerName})
+	if err != nil {
+		return nil, err
+	}
+	defer publisher.Close()
+
+	result := &Result{Checkpoint: base}
+	w := &publishWriter{ctx: ctx, publisher: publisher, transforms: opts.Transforms, result: result, save: opts.Checkpoint}
+
+	exportOpts := export.Options{
+		Offset:      opts.Offset,
+		EndOffset:   opts.EndOffset,
+		From:        opts.From,
+		To:          opts.To,
+		Filters:     opts.Filters,
+		IdleTimeout: opts.IdleTimeout,
+		Progress: func(p export.Progress) {
+			stats := publisher.Stats()
+			result.Progress = Progress{
+				Scanned:   p.Scanned,
+				Copied:    p.Written,
+				Confirmed: stats.Confirmed,
+				Failed:    stats.Failed,
+				Offset:    p.Offset,
+			}
+
+			next := result.Checkpoint
+			if len(w.pending) > 0 {
+				next = w.pending[len(w.pending)-1].checkpoint
+			}
+			if p.Scanned > 0 {
+				next.NextOffset = p.Offset + 1
+			}
+			next.Copied = base.Copied + p.Written
+			w.pending = append(w.pending, pendingCheckpoint{sent: stats.Sent, checkpoint: next})
+			w.settle(stats)
+
+			if opts.Progress != nil {
+				opts.Progress(result.Progress)
+			}
+		},
+	}
+
+	exported, err := export.Run(ctx, source.Conn, source.VHost, source.Stream, exportOpts, w)
+	if err != nil {
+		// Keep what the target confirmed before the copy stopped
+		w.settle(publisher.Stats())
+		return result, err
+	}
+	if w.err != nil {
+		return result, w.err
+	}
+	result.EndReason = exported.EndReason
+	return result, nil
+}
+
+// pendingCheckpoint is a checkpoint waiting for the first sent messages to
+// be confirmed
+type pendingCheckpoint struct {
+	sent       int
+	checkpoint Checkpoint
+}
+
+// publishWriter is an export.Writer that publishes messages to the target
+type publishWriter struct {
+	ctx        context.Context
+	publisher  *rabbitmq.Publisher
+	transforms []Transform
+	// result receives the checkpoints once confirmed, which are also passed to save
+	result  *Result
+	save    func(Checkpoint) error
+	pending []pendingCheckpoint
+	// err stops the copy at the next message, e.g. when a checkpoint could not be saved
+	err error
+}
+
+func (w *publishWriter) Write(msg rabbitmq.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	out := msg.AMQP()
+	if len(w.transforms) > 0 {
+		out = Transformed(out, w.transforms)
+	}
+	return w.publisher.Send(out, int64(msg.Offset)+1)
+}
+
+// settle records the pending checkpoints whose messages were all confirmed.
+// A producer's messages are confirmed in order, so a checkpoint taken after
+// sent messages is safe once that many are confirmed.
+func (w *publishWriter) settle(stats rabbitmq.PublishStats) {
+	if w.err != nil {
+		return
+	}
+	if stats.Failed > 0 {
+		w.err = fmt.Errorf("%d of %d messages were not confirmed", stats.Failed, stats.Sent)
+		return
+	}
+	for len(w.pending) > 0 && w.pending[0].sent <= stats.Confirmed {
+		checkpoint := w.pending[0].checkpoint
+		w.pending = w.pending[1:]
+		checkpoint.UpdatedAt = time.Now()
+		w.result.Checkpoint = checkpoint
+		if w.save != nil {
+			if err := w.save(checkpoint); err != nil {
+				w.err = err
+				return
+			}
+		}
+	}
+}
+
+// Flush returns the error that stops the copy; confirms are settled with
+// each progress report rather than waited for
+func (w *publishWriter) Flush() error {
+	return w.err
+}
+
+// Close waits until the target confirmed every message
+func (w *publishWriter) Close() error {
+	if err := w.publisher.Wait(w.ctx); err != nil {
+		return err
+	}
+	return w.err
+}
@@ -0,0 +1,255 @@
+package copier
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/export"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+func TestTransforms(t *testing.T) {
+	transforms, err := ParseTransforms([]string{
+		"app.copied_from=prod",
+		"-app.secret",
+		"annotations.x-origin=prod/orders",
+		"properties.subject=replayed",
+		"-properties.reply_to",
+	})
+	if err != nil {
+		t.Fatalf("ParseTransforms failed: %v", err)
+	}
+
+	source := &amqp.Message{
+		Properties:            &amqp.MessageProperties{Subject: "orders.created", ReplyTo: "callbacks"},
+		ApplicationProperties: map[string]interface{}{"secret": "s3cr3t", "tenant": "acme"},
+	}
+	msg := Transformed(source, transforms)
+
+	// The source message may be shared with the reader and stays unchanged
+	if source.ApplicationProperties["secret"] != "s3cr3t" || len(source.ApplicationProperties) != 2 ||
+		source.Annotations != nil || source.Properties.Subject != "orders.created" || source.Properties.ReplyTo != "callbacks" {
+		t.Errorf("expected the source message to be unchanged, got %+v", source)
+	}
+
+	if msg.ApplicationProperties["copied_from"] != "prod" || msg.ApplicationProperties["tenant"] != "acme" {
+		t.Errorf("unexpected application properties: %v", msg.ApplicationProperties)
+	}
+	if _, ok := msg.ApplicationProperties["secret"]; ok {
+		t.Error("expected app.secret to be removed")
+	}
+	if msg.Annotations["x-origin"] != "prod/orders" {
+		t.Errorf("unexpected annotations: %v", msg.Annotations)
+	}
+	if msg.Properties.Subject != "replayed" || msg.Properties.ReplyTo != "" {
+		t.Errorf("unexpected properties: %+v", msg.Properties)
+	}
+
+	for _, expr := range []string{"app.key", "body.id=1", "properties.creation_time=now", "-app"} {
+		if _, err := ParseTransform(expr); err == nil {
+			t.Errorf("expected error for transform %q", expr)
+		}
+	}
+}
+
+func TestCheckpoint_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "copy.json")
+
+	missing, err := LoadCheckpoint(path)
+	if err != nil || missing != nil {
+		t.Fatalf("expected no checkpoint, got %v, %v", missing, err)
+	}
+
+	saved := Checkpoint{
+		Source:       "prod/%2F/orders",
+		Target:       "staging/%2F/orders",
+		NextOffset:   1500,
+		ProducerName: "copy-1",
+		Copied:       1200,
+		UpdatedAt:    time.Date(2025, 10, 16, 12, 0, 0, 0, time.UTC),
+	}
+	if err := saved.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("LoadCheckpoint failed: %v", err)
+	}
+	if *loaded != saved {
+		t.Errorf("expected %+v, got %+v", saved, *loaded)
+	}
+}
+
+func TestJobs_NotFound(t *testing.T) {
+	jobs := NewJobs()
+
+	if _, err := jobs.Get("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Get: expected ErrJobNotFound, got %v", err)
+	}
+	if _, err := jobs.Cancel("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Cancel: expected ErrJobNotFound, got %v", err)
+	}
+	if _, err := jobs.Resume("missing", Endpoint{}, Endpoint{}); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Resume: expected ErrJobNotFound, got %v", err)
+	}
+	if len(jobs.List()) != 0 {
+		t.Error("expected no jobs")
+	}
+}
+
+func TestJobs_Resume(t *testing.T) {
+	jobs := NewJobs()
+	endpoint := func(conn string) Endpoint {
+		return Endpoint{Conn: rabbitmq.NewConnection(config.ConnectionConfig{ID: conn}), VHost: "/", Stream: "orders"}
+	}
+	source, target := endpoint("prod"), endpoint("staging")
+	jobs.jobs["c1"] = &job{
+		source: source,
+		target: target,
+		owner:  jobs,
+		status: JobStatus{ID: "c1", Status: StatusCancelled, source: source, target: target},
+	}
+
+	// The job copies with the connections it is resumed with
+	userSource, userTarget := endpoint("prod"), endpoint("staging")
+	status, err := jobs.Resume("c1", userSource, userTarget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, tg := status.Endpoints(); s.Conn != userSource.Conn || tg.Conn != userTarget.Conn {
+		t.Error("expected the job to be rebound to the given connections")
+	}
+	if jb := jobs.jobs["c1"]; jb.source.Conn != userSource.Conn || jb.target.Conn != userTarget.Conn {
+		t.Error("expected the copy to run with the given connections")
+	}
+
+	// The read-only target fails the copy
+	for deadline := time.Now().Add(time.Second); status.Status == StatusRunning && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		status, _ = jobs.Get("c1")
+	}
+	if status.Status != StatusFailed || !strings.Contains(status.Error, "read-only") {
+		t.Errorf("expected the copy to fail on the read-only target, got %s: %s", status.Status, status.Error)
+	}
+}
+
+func TestPublishWriter_Settle(t *testing.T) {
+	var saved []uint64
+	w := &publishWriter{
+		result: &Result{Checkpoint: Checkpoint{NextOffset: 0}},
+		save: func(c Checkpoint) error {
+			saved = append(saved, c.NextOffset)
+			return nil
+		},
+	}
+	w.pending = []pendingCheckpoint{
+		{sent: 1000, checkpoint: Checkpoint{NextOffset: 1000}},
+		{sent: 2000, checkpoint: Checkpoint{NextOffset: 2000}},
+	}
+
+	// Checkpoints are only recorded once the messages before them are confirmed
+	w.settle(rabbitmq.PublishStats{Sent: 2500, Confirmed: 1500})
+	if len(saved) != 1 || saved[0] != 1000 || w.result.Checkpoint.NextOffset != 1000 || len(w.pending) != 1 {
+		t.Errorf("expected the first checkpoint only, got %v (%d pending)", saved, len(w.pending))
+	}
+	w.settle(rabbitmq.PublishStats{Sent: 2500, Confirmed: 2500})
+	if len(saved) != 2 || saved[1] != 2000 || len(w.pending) != 0 {
+		t.Errorf("expected both checkpoints, got %v", saved)
+	}
+
+	// A rejected message stops the copy before any later checkpoint
+	w.pending = append(w.pending, pendingCheckpoint{sent: 3000, checkpoint: Checkpoint{NextOffset: 3000}})
+	w.settle(rabbitmq.PublishStats{Sent: 3000, Confirmed: 2999, Failed: 1})
+	if w.Flush() == nil || len(saved) != 2 {
+		t.Errorf("expected the copy to stop without a checkpoint, got %v, %v", w.err, saved)
+	}
+}
+
+func TestJobs_SaveLoad(t *testing.T) {
+	cfg := config.CopiesConfig{File: filepath.Join(t.TempDir(), "copies.json"), Retention: time.Hour}
+	connection := func(id string) (*rabbitmq.Connection, error) {
+		if id == "gone" {
+			return nil, fmt.Errorf("connection not found: %s", id)
+		}
+		return rabbitmq.NewConnection(config.ConnectionConfig{ID: id}), nil
+	}
+
+	jobs, err := LoadJobs(cfg, connection)
+	if err != nil || len(jobs.List()) != 0 {
+		t.Fatalf("expected no jobs without a file, got %v, %v", jobs, err)
+	}
+
+	now := time.Now()
+	add := func(id, status, sourceConn string, finished *time.Time) {
+		source := Endpoint{Conn: rabbitmq.NewConnection(config.ConnectionConfig{ID: sourceConn}), VHost: "/", Stream: "orders"}
+		target := Endpoint{Conn: rabbitmq.NewConnection(config.ConnectionConfig{ID: "staging"}), VHost: "/", Stream: "orders"}
+		jobs.jobs[id] = &job{
+			source: source,
+			target: target,
+			opts: Options{
+				Offset:     10,
+				Filters:    []export.Filter{{Path: "app.tenant", Operator: "=", Value: "acme"}},
+				Transforms: []Transform{{Path: "app.secret", Delete: true}},
+			},
+			owner: jobs,
+			status: JobStatus{
+				ID:         id,
+				Source:     source.String(),
+				Target:     target.String(),
+				Status:     status,
+				Checkpoint: &Checkpoint{Source: source.String(), Target: target.String(), NextOffset: 500, ProducerName: "copy-" + id},
+				StartedAt:  now.Add(-3 * time.Hour),
+				FinishedAt: finished,
+				source:     source,
+				target:     target,
+			},
+		}
+	}
+	expired := now.Add(-2 * time.Hour)
+	add("running", StatusRunning, "prod", nil)
+	add("expired", StatusCompleted, "prod", &expired)
+	add("orphaned", StatusCancelled, "gone", &now)
+	if err := jobs.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadJobs(cfg, connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := loaded.List()
+	if len(statuses) != 1 || statuses[0].ID != "running" {
+		t.Fatalf("expected only the interrupted job, got %+v", statuses)
+	}
+
+	// A job that was running can be resumed from its checkpoint
+	status := statuses[0]
+	if status.Status != StatusFailed || status.Error != errInterrupted.Error() || status.FinishedAt == nil {
+		t.Errorf("expected the running job to be marked interrupted, got %+v", status)
+	}
+	if status.Checkpoint == nil || status.Checkpoint.NextOffset != 500 || status.Checkpoint.ProducerName != "copy-running" {
+		t.Errorf("expected the checkpoint to be kept, got %+v", status.Checkpoint)
+	}
+	source, target := status.Endpoints()
+	if source.Conn == nil || source.String() != "prod///orders" || target.String() != "staging///orders" {
+		t.Errorf("unexpected endpoints %s, %s", source, target)
+	}
+	jb := loaded.jobs["running"]
+	if jb.opts.Offset != 10 || len(jb.opts.Filters) != 1 || jb.opts.Filters[0].Value != "acme" ||
+		len(jb.opts.Transforms) != 1 || !jb.opts.Transforms[0].Delete {
+		t.Errorf("expected the options to be kept, got %+v", jb.opts)
+	}
+
+	// Finished jobs expire after the retention
+	loaded.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if len(loaded.List()) != 0 {
+		t.Error("expected the finished job to expire")
+	}
+}
@@ -0,0 +1,418 @@
+package copier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/export"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// Job statuses
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// ErrJobNotFound is returned for unknown copy job IDs
+var ErrJobNotFound = errors.New("copy job not found")
+
+// ErrJobRunning is returned when resuming a job that has not stopped
+var ErrJobRunning = errors.New("copy job is still running")
+
+// JobStatus is a snapshot of a copy job
+type JobStatus struct {
+	ID         string      `json:"id"`
+	Source     string      `json:"source"`
+	Target     string      `json:"target"`
+	Status     string      `json:"status"`
+	Progress   Progress    `json:"progress"`
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+	EndReason  string      `json:"end_reason,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+
+	source, target Endpoint
+}
+
+// Endpoints returns the streams the job copies from and to
+func (s JobStatus) Endpoints() (source, target Endpoint) {
+	return s.source, s.target
+}
+
+// errInterrupted is the error of jobs that were running when the server stopped
+var errInterrupted = errors.New("interrupted by a server restart")
+
+// job is a copy running in the background
+type job struct {
+	source Endpoint
+	target Endpoint
+	opts   Options
+	// owner saves the job whenever its checkpoint or status changes
+	owner *Jobs
+
+	mu     sync.Mutex
+	status JobStatus
+	cancel context.CancelFunc
+}
+
+// Jobs runs copies in the background and keeps their status and last
+// checkpoint so stopped copies can be resumed. Finished jobs are dropped
+// after the retention.
+type Jobs struct {
+	cfg config.CopiesConfig
+	now func() time.Time
+
+	mu   sync.RWMutex
+	jobs map[string]*job
+	// saveMu serializes writes of the jobs file
+	saveMu sync.Mutex
+}
+
+// persistedEndpoint is the file format of a job's stream
+type persistedEndpoint struct {
+	Connection string `json:"connection"`
+	VHost      string `json:"vhost"`
+	Stream     string `json:"stream"`
+}
+
+// persistedJob is the file format of a job
+type persistedJob struct {
+	Status     JobStatus         `json:"status"`
+	Source     persistedEndpoint `json:"source"`
+	Target     persistedEndpoint `json:"target"`
+	Offset     uint64            `json:"offset"`
+	EndOffset  *uint64           `json:"end_offset,omitempty"`
+	From       *time.Time        `json:"from,omitempty"`
+	To         *time.Time        `json:"to,omitempty"`
+	Filters    []export.Filter   `json:"filters,omitempty"`
+	Transforms []Transform       `json:"transforms,omitempty"`
+}
+
+// NewJobs creates an empty job registry that keeps jobs in memory
+func NewJobs() *Jobs {
+	return &Jobs{
+		cfg:  config.CopiesConfig{Retention: config.DefaultCopyRetention},
+		now:  time.Now,
+		jobs: make(map[string]*job),
+	}
+}
+
+// LoadJobs creates a job registry saved to cfg.File, loading the jobs saved
+// there when it exists. Jobs that were running are marked failed so that
+// they can be resumed; jobs whose connection is gone are dropped. The
+// connections only identify the streams of loaded jobs until they are resumed.
+func LoadJobs(cfg config.CopiesConfig, connection func(id string) (*rabbitmq.Connection, error)) (*Jobs, error) {
+	j := NewJobs()
+	j.cfg = cfg
+	if cfg.File == "" {
+		return j, nil
+	}
+
+	data, err := os.ReadFile(cfg.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read copies file: %w", err)
+	}
+	var persisted []persistedJob
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return nil, fmt.Errorf("failed to parse copies file %s: %w", cfg.File, err)
+	}
+
+	for _, p := range persisted {
+		source, err := p.Source.endpoint(connection)
+		if err == nil {
+			var target Endpoint
+			target, err = p.Target.endpoint(connection)
+			p.Status.source, p.Status.target = source, target
+		}
+		if err != nil {
+			log.Printf("copy %s: dropped: %v", p.Status.ID, err)
+			continue
+		}
+		if p.Status.Status == StatusRunning {
+			now := j.now()
+			p.Status.Status = StatusFailed
+			p.Status.Error = errInterrupted.Error()
+			p.Status.FinishedAt = &now
+		}
+		j.jobs[p.Status.ID] = &job{
+			source: p.Status.source,
+			target: p.Status.target,
+			opts: Options{
+				Offset:     p.Offset,
+				EndOffset:  p.EndOffset,
+				From:       p.From,
+				To:         p.To,
+				Filters:    p.Filters,
+				Transforms: p.Transforms,
+			},
+			owner:  j,
+			status: p.Status,
+		}
+	}
+	j.prune()
+	return j, nil
+}
+
+func (e persistedEndpoint) endpoint(connection func(id string) (*rabbitmq.Connection, error)) (Endpoint, error) {
+	conn, err := connection(e.Connection)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	return Endpoint{Conn: conn, VHost: e.VHost, Stream: e.Stream}, nil
+}
+
+func persistEndpoint(e Endpoint) persistedEndpoint {
+	return persistedEndpoint{Connection: e.Conn.ID, VHost: e.VHost, Stream: e.Stream}
+}
+
+// Save atomically writes every job to the configured file; it does nothing
+// without one
+func (j *Jobs) Save() error {
+	if j.cfg.File == "" {
+		return nil
+	}
+
+	j.saveMu.Lock()
+	defer j.saveMu.Unlock()
+
+	j.mu.RLock()
+	persisted := make([]persistedJob, 0, len(j.jobs))
+	for _, jb := range j.jobs {
+		jb.mu.Lock()
+		persisted = append(persisted, persistedJob{
+			Status:     jb.status,
+			Source:     persistEndpoint(jb.source),
+			Target:     persistEndpoint(jb.target),
+			Offset:     jb.opts.Offset,
+			EndOffset:  jb.opts.EndOffset,
+			From:       jb.opts.From,
+			To:         jb.opts.To,
+			Filters:    jb.opts.Filters,
+			Transforms: jb.opts.Transforms,
+		})
+		jb.mu.Unlock()
+	}
+	j.mu.RUnlock()
+
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(j.cfg.File, data); err != nil {
+		return fmt.Errorf("failed to write copies file: %w", err)
+	}
+	return nil
+}
+
+// prune drops the jobs that finished longer than the retention ago
+func (j *Jobs) prune() {
+	cutoff := j.now().Add(-j.cfg.Retention)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for id, jb := range j.jobs {
+		jb.mu.Lock()
+		finished := jb.status.FinishedAt
+		expired := jb.status.Status != StatusRunning && finished != nil && finished.Before(cutoff)
+		jb.mu.Unlock()
+		if expired {
+			delete(j.jobs, id)
+		}
+	}
+}
+
+// Start begins copying in the background
+func (j *Jobs) Start(source, target Endpoint, opts Options) JobStatus {
+	j.prune()
+	jb := &job{
+		source: source,
+		target: target,
+		opts:   opts,
+		owner:  j,
+		status: JobStatus{
+			ID:     uuid.NewString(),
+			Source: source.String(),
+			Target: target.String(),
+			source: source,
+			target: target,
+		},
+	}
+
+	j.mu.Lock()
+	j.jobs[jb.status.ID] = jb
+	j.mu.Unlock()
+
+	return jb.run()
+}
+
+// Get returns the status of a job
+func (j *Jobs) Get(id string) (JobStatus, error) {
+	jb, err := j.find(id)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	return jb.snapshot(), nil
+}
+
+// List returns the status of every job, newest first
+func (j *Jobs) List() []JobStatus {
+	j.prune()
+	j.mu.RLock()
+	statuses := make([]JobStatus, 0, len(j.jobs))
+	for _, jb := range j.jobs {
+		statuses = append(statuses, jb.snapshot())
+	}
+	j.mu.RUnlock()
+
+	sort.Slice(statuses, func(a, b int) bool {
+		return statuses[a].StartedAt.After(statuses[b].StartedAt)
+	})
+	return statuses
+}
+
+// Cancel stops a running job; its checkpoint is kept for resuming
+func (j *Jobs) Cancel(id string) (JobStatus, error) {
+	jb, err := j.find(id)
+	if err != nil {
+		return JobStatus{}, err
+	}
+
+	jb.mu.Lock()
+	if jb.status.Status == StatusRunning {
+		jb.cancel()
+	}
+	jb.mu.Unlock()
+	return jb.snapshot(), nil
+}
+
+// Resume restarts a stopped job from its last checkpoint. Source and target
+// are the job's streams on the connections of the user resuming it, which
+// replace those the job ran with before.
+func (j *Jobs) Resume(id string, source, target Endpoint) (JobStatus, error) {
+	jb, err := j.find(id)
+	if err != nil {
+		return JobStatus{}, err
+	}
+
+	// The job is marked running before the lock is released so that
+	// concurrent resumes can't both start it
+	jb.mu.Lock()
+	if jb.status.Status == StatusRunning {
+		jb.mu.Unlock()
+		return jb.snapshot(), ErrJobRunning
+	}
+	jb.source, jb.target = source, target
+	jb.status.source, jb.status.target = source, target
+	jb.opts.Resume = jb.status.Checkpoint
+	launch := jb.begin()
+	jb.mu.Unlock()
+
+	launch()
+	return jb.snapshot(), nil
+}
+
+func (j *Jobs) find(id string) (*job, error) {
+	j.prune()
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	jb, ok := j.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return jb, nil
+}
+
+// run starts the copy in a goroutine and returns the initial status
+func (jb *job) run() JobStatus {
+	jb.mu.Lock()
+	launch := jb.begin()
+	jb.mu.Unlock()
+
+	launch()
+	return jb.snapshot()
+}
+
+// begin marks the job running and returns the function starting the copy in
+// a goroutine; jb.mu must be held
+func (jb *job) begin() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	jb.cancel = cancel
+	jb.status.Status = StatusRunning
+	jb.status.Error = ""
+	jb.status.EndReason = ""
+	jb.status.StartedAt = jb.owner.now()
+	jb.status.FinishedAt = nil
+	id, source, target, opts := jb.status.ID, jb.source, jb.target, jb.opts
+
+	opts.Progress = func(p Progress) {
+		jb.mu.Lock()
+		jb.status.Progress = p
+		jb.mu.Unlock()
+	}
+	opts.Checkpoint = func(c Checkpoint) error {
+		jb.mu.Lock()
+		jb.status.Checkpoint = &c
+		jb.mu.Unlock()
+		return jb.owner.Save()
+	}
+
+	return func() {
+		go func() {
+			defer cancel()
+			result, err := Run(ctx, source, target, opts)
+			jb.finish(ctx, result, err)
+			if err := jb.owner.Save(); err != nil {
+				log.Printf("copy %s: %v", id, err)
+			}
+		}()
+	}
+}
+
+// finish records the outcome of a copy
+func (jb *job) finish(ctx context.Context, result *Result, err error) {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	now := jb.owner.now()
+	jb.status.FinishedAt = &now
+	if result != nil {
+		jb.status.Progress = result.Progress
+		jb.status.EndReason = result.EndReason
+	}
+	switch {
+	case err == nil:
+		jb.status.Status = StatusCompleted
+	case ctx.Err() != nil:
+		jb.status.Status = StatusCancelled
+	default:
+		jb.status.Status = StatusFailed
+		jb.status.Error = err.Error()
+	}
+}
+
+func (jb *job) snapshot() JobStatus {
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	status := jb.status
+	if status.Checkpoint != nil {
+		checkpoint := *status.Checkpoint
+		status.Checkpoint = &checkpoint
+	}
+	return status
+}
@@ -0,0 +1,149 @@
+package copier
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+)
+
+// Transform sets or removes one field of each copied message
+type Transform struct {
+	// Path is app.<key>, annotations.<key> or properties.<name>
+	Path   string `json:"path"`
+	Value  string `json:"value,omitempty"`
+	Delete bool   `json:"delete,omitempty"`
+}
+
+// stringProperties are the AMQP properties a transform can set
+var stringProperties = map[string]func(p *amqp.MessageProperties, value string, set bool){
+	"message_id": func(p *amqp.MessageProperties, value string, set bool) {
+		p.MessageID = nil
+		if set {
+			p.MessageID = value
+		}
+	},
+	"correlation_id": func(p *amqp.MessageProperties, value string, set bool) {
+		p.CorrelationID = nil
+		if set {
+			p.CorrelationID = value
+		}
+	},
+	"to":                func(p *amqp.MessageProperties, value string, _ bool) { p.To = value },
+	"subject":           func(p *amqp.MessageProperties, value string, _ bool) { p.Subject = value },
+	"reply_to":          func(p *amqp.MessageProperties, value string, _ bool) { p.ReplyTo = value },
+	"content_type":      func(p *amqp.MessageProperties, value string, _ bool) { p.ContentType = value },
+	"content_encoding":  func(p *amqp.MessageProperties, value string, _ bool) { p.ContentEncoding = value },
+	"group_id":          func(p *amqp.MessageProperties, value string, _ bool) { p.GroupID = value },
+	"reply_to_group_id": func(p *amqp.MessageProperties, value string, _ bool) { p.ReplyToGroupID = value },
+}
+
+// ParseTransform parses "<path>=<value>" to set a field or "-<path>" to remove it
+func ParseTransform(expr string) (Transform, error) {
+	var t Transform
+	if strings.HasPrefix(expr, "-") {
+		t.Path = strings.TrimSpace(expr[1:])
+		t.Delete = true
+	} else {
+		path, value, ok := strings.Cut(expr, "=")
+		if !ok {
+			return t, fmt.Errorf("transform %q must be <field>=<value> or -<field>", expr)
+		}
+		t.Path = strings.TrimSpace(path)
+		t.Value = value
+	}
+
+	section, key, ok := strings.Cut(t.Path, ".")
+	if !ok || key == "" {
+		return t, fmt.Errorf("transform %q must name a field such as app.<key>", expr)
+	}
+	switch section {
+	case "app", "annotations":
+	case "properties":
+		if _, known := stringProperties[key]; !known {
+			return t, fmt.Errorf("transform %q: property %q cannot be set", expr, key)
+		}
+	default:
+		return t, fmt.Errorf("transform %q: unknown section %q", expr, section)
+	}
+	return t, nil
+}
+
+// ParseTransforms parses a list of transform expressions
+func ParseTransforms(exprs []string) ([]Transform, error) {
+	transforms := make([]Transform, 0, len(exprs))
+	for _, expr := range exprs {
+		if expr == "" {
+			continue
+		}
+		t, err := ParseTransform(expr)
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, t)
+	}
+	return transforms, nil
+}
+
+// Transformed returns a copy of msg with the transforms applied. msg, which
+// may be shared with the reader, is left unchanged.
+func Transformed(msg *amqp.Message, transforms []Transform) *amqp.Message {
+	out := *msg
+	if msg.Properties != nil {
+		properties := *msg.Properties
+		out.Properties = &properties
+	}
+	if msg.ApplicationProperties != nil {
+		out.ApplicationProperties = make(map[string]interface{}, len(msg.ApplicationProperties))
+		for k, v := range msg.ApplicationProperties {
+			out.ApplicationProperties[k] = v
+		}
+	}
+	if msg.Annotations != nil {
+		out.Annotations = make(amqp.Annotations, len(msg.Annotations))
+		for k, v := range msg.Annotations {
+			out.Annotations[k] = v
+		}
+	}
+	for _, t := range transforms {
+		t.Apply(&out)
+	}
+	return &out
+}
+
+// Apply modifies msg in place
+func (t Transform) Apply(msg *amqp.Message) {
+	section, key, _ := strings.Cut(t.Path, ".")
+	switch section {
+	case "app":
+		if t.Delete {
+			delete(msg.ApplicationProperties, key)
+			return
+		}
+		if msg.ApplicationProperties == nil {
+			msg.ApplicationProperties = make(map[string]interface{})
+		}
+		msg.ApplicationProperties[key] = t.Value
+	case "annotations":
+		if t.Delete {
+			delete(msg.Annotations, key)
+			return
+		}
+		if msg.Annotations == nil {
+			msg.Annotations = make(amqp.Annotations)
+		}
+		msg.Annotations[key] = t.Value
+	case "properties":
+		if msg.Properties == nil {
+			if t.Delete {
+				return
+			}
+			msg.Properties = &amqp.MessageProperties{}
+		}
+		value := t.Value
+		if t.Delete {
+			value = ""
+		}
+		stringProperties[key](msg.Properties, value, !t.Delete)
+	}
+}
@@ -0,0 +1,138 @@
+// Package export dumps ranges of a stream to NDJSON, CSV or Parquet.
+// It is shared by the export API endpoint and the export command.
+package export
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// progressInterval is how many scanned messages pass between progress reports
+const progressInterval = 1000
+
+// errLimitReached stops a scan once enough messages were written
+var errLimitReached = errors.New("export limit reached")
+
+// Options selects the range and messages to export
+type Options struct {
+	Offset uint64
+	// EndOffset, when set, is the last offset exported
+	EndOffset *uint64
+	// From and To select a time range by broker chunk timestamps; they narrow any offset range
+	From *time.Time
+	To   *time.Time
+	// Limit stops the export after this many messages were written; zero exports the whole range
+	Limit   int
+	Filters []Filter
+	// Redact, when set, masks each message before it is filtered and written,
+	// so filters can't match what the reader may not see
+	Redact func(rabbitmq.Message) rabbitmq.Message
+	// IdleTimeout bounds how long to wait for the next message; zero uses the connection's read_timeout
+	IdleTimeout time.Duration
+	// Progress, when set, is called periodically and once at the end
+	Progress func(Progress)
+}
+
+// Progress reports how far an export has got
+type Progress struct {
+	Scanned int    `json:"scanned"`
+	Written int    `json:"written"`
+	Offset  uint64 `json:"offset"`
+}
+
+// Result summarises a finished export
+type Result struct {
+	Progress
+	EndReason string `json:"end_reason"`
+}
+
+// Run exports the selected range of a stream to w as messages are consumed
+func Run(ctx context.Context, conn *rabbitmq.Connection, vhost, streamName string, opts Options, w Writer) (*Result, error) {
+	streamOpts := rabbitmq.StreamOptions{
+		Offset:      opts.Offset,
+		EndOffset:   opts.EndOffset,
+		IdleTimeout: opts.IdleTimeout,
+	}
+
+	result := &Result{EndReason: rabbitmq.EndReasonEndOfStream}
+
+	if opts.From != nil {
+		offset, found, err := conn.OffsetForTime(ctx, vhost, streamName, *opts.From)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			// Nothing was stored after the start of the range
+			return result, w.Close()
+		}
+		if offset > streamOpts.Offset {
+			streamOpts.Offset = offset
+		}
+	}
+
+	if opts.To != nil {
+		offset, found, err := conn.OffsetForTime(ctx, vhost, streamName, *opts.To)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			if offset == 0 || offset <= streamOpts.Offset {
+				return result, w.Close()
+			}
+			end := offset - 1
+			if streamOpts.EndOffset == nil || end < *streamOpts.EndOffset {
+				streamOpts.EndOffset = &end
+			}
+		}
+	}
+
+	report := func() {
+		if opts.Progress != nil {
+			opts.Progress(result.Progress)
+		}
+	}
+
+	reason, err := conn.StreamMessages(ctx, vhost, streamName, streamOpts, func(msg rabbitmq.Message) error {
+		result.Scanned++
+		result.Offset = msg.Offset
+
+		if opts.Redact != nil {
+			msg = opts.Redact(msg)
+		}
+		if Matches(opts.Filters, msg) {
+			if err := w.Write(msg); err != nil {
+				return err
+			}
+			result.Written++
+		}
+
+		if result.Scanned%progressInterval == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			report()
+		}
+
+		if opts.Limit > 0 && result.Written >= opts.Limit {
+			return errLimitReached
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errLimitReached):
+		reason = rabbitmq.EndReasonLimit
+	case err != nil:
+		return nil, err
+	}
+
+	result.EndReason = reason
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	report()
+
+	return result, nil
+}
@@ -0,0 +1,188 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+func testMessage(offset uint64, body string) rabbitmq.Message {
+	messageID := "msg-1"
+	return rabbitmq.NewMessage(offset, &amqp.Message{
+		Data: [][]byte{[]byte(body)},
+		Properties: &amqp.MessageProperties{
+			MessageID: &messageID,
+			Subject:   "orders.created",
+		},
+		ApplicationProperties: map[string]interface{}{"tenant": "acme"},
+		Annotations:           amqp.Annotations{"x-routing-key": "orders"},
+	})
+}
+
+func TestParseColumns(t *testing.T) {
+	columns, err := ParseColumns("offset, properties.subject,body.customer.id,,app.tenant")
+	if err != nil {
+		t.Fatalf("ParseColumns failed: %v", err)
+	}
+	if len(columns) != 4 || columns[1] != "properties.subject" {
+		t.Errorf("unexpected columns: %v", columns)
+	}
+
+	for _, spec := range []string{"nonsense", "body.", "app."} {
+		if _, err := ParseColumns(spec); err == nil {
+			t.Errorf("expected error for columns %q", spec)
+		}
+	}
+}
+
+func TestProject(t *testing.T) {
+	p := newProjector(testMessage(42, `{"customer":{"id":7},"items":[{"sku":"A1"}]}`))
+
+	tests := map[string]string{
+		"offset":                    "42",
+		"properties.message_id":     "msg-1",
+		"properties.subject":        "orders.created",
+		"app.tenant":                "acme",
+		"annotations.x-routing-key": "orders",
+		"body.customer.id":          "7",
+		"body.items.0.sku":          "A1",
+		"body.customer":             `{"id":7}`,
+	}
+	for path, want := range tests {
+		got, ok := p.ProjectString(path)
+		if !ok || got != want {
+			t.Errorf("ProjectString(%q) = %q, %v; want %q", path, got, ok, want)
+		}
+	}
+
+	for _, path := range []string{"body.missing", "body.items.5.sku", "app.missing", "creation_time"} {
+		if _, ok := p.ProjectString(path); ok {
+			t.Errorf("ProjectString(%q) should not resolve", path)
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	msg := testMessage(1, `{"status":"failed","reason":"card declined"}`)
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"body.status=failed", true},
+		{"body.status=ok", false},
+		{"body.status!=ok", true},
+		{"body.reason~declined", true},
+		{"app.tenant=acme", true},
+		{"app.region!=eu", true},
+		{"app.region=eu", false},
+	}
+	for _, tt := range tests {
+		filters, err := ParseFilters([]string{tt.expr})
+		if err != nil {
+			t.Fatalf("ParseFilters(%q) failed: %v", tt.expr, err)
+		}
+		if got := Matches(filters, msg); got != tt.want {
+			t.Errorf("Matches(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"no operator", "=value", "unknown.field=1"} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("expected error for filter %q", expr)
+		}
+	}
+
+	// The leftmost operator splits the expression, values may contain others
+	for expr, want := range map[string]Filter{
+		"app.url=https://x?a!=b": {Path: "app.url", Operator: "=", Value: "https://x?a!=b"},
+		"app.url!=a=b~c":         {Path: "app.url", Operator: "!=", Value: "a=b~c"},
+		"body.note~x!=y":         {Path: "body.note", Operator: "~", Value: "x!=y"},
+	} {
+		if f, err := ParseFilter(expr); err != nil || f != want {
+			t.Errorf("ParseFilter(%q) = %+v, %v, want %+v", expr, f, err, want)
+		}
+	}
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatNDJSON, &buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(testMessage(3, "hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var record rabbitmq.ExportedMessage
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("invalid NDJSON line: %v", err)
+	}
+	if record.Offset != 3 || string(record.Body) != "hello" {
+		t.Errorf("unexpected record: %+v", record)
+	}
+	if record.Properties == nil || record.Properties.Subject != "orders.created" {
+		t.Errorf("properties were not exported: %+v", record.Properties)
+	}
+	if record.ApplicationProperties["tenant"] != "acme" {
+		t.Errorf("application properties were not exported: %v", record.ApplicationProperties)
+	}
+	if record.MessageAnnotations["x-routing-key"] != "orders" {
+		t.Errorf("annotations were not exported: %v", record.MessageAnnotations)
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatCSV, &buf, []string{"offset", "body.customer.id", "app.missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(testMessage(5, `{"customer":{"id":"c-1"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "offset,body.customer.id,app.missing\n5,c-1,\n"
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected CSV:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatParquet, &buf, []string{"offset", "properties.subject"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for offset := uint64(0); offset < 3; offset++ {
+		if err := w.Write(testMessage(offset, "x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("invalid Parquet file: %v", err)
+	}
+	if file.NumRows() != 3 {
+		t.Errorf("expected 3 rows, got %d", file.NumRows())
+	}
+	if !strings.Contains(file.Schema().String(), "properties.subject") {
+		t.Errorf("schema is missing the projected column: %s", file.Schema())
+	}
+}
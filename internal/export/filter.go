@@ -0,0 +1,81 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// Filter matches messages on a single field. Expressions have the form
+// <path>=<value>, <path>!=<value> or <path>~<substring>, using the field
+// paths understood by Project.
+type Filter struct {
+	Path     string `json:"path"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// filterOperators are listed so that != is preferred over the = it contains
+var filterOperators = []string{"!=", "~", "="}
+
+// ParseFilter parses a filter expression. The leftmost operator separates the
+// path from the value, which may itself contain operator characters.
+func ParseFilter(expr string) (Filter, error) {
+	at, op := -1, ""
+	for _, candidate := range filterOperators {
+		if i := strings.Index(expr, candidate); i >= 0 && (at < 0 || i < at) {
+			at, op = i, candidate
+		}
+	}
+	if at <= 0 {
+		return Filter{}, fmt.Errorf("invalid filter %q: expected <field>=<value>, <field>!=<value> or <field>~<text>", expr)
+	}
+
+	f := Filter{
+		Path:     strings.TrimSpace(expr[:at]),
+		Operator: op,
+		Value:    expr[at+len(op):],
+	}
+	if err := ValidatePath(f.Path); err != nil {
+		return Filter{}, err
+	}
+	return f, nil
+}
+
+// ParseFilters parses several filter expressions, all of which must match
+func ParseFilters(exprs []string) ([]Filter, error) {
+	filters := make([]Filter, 0, len(exprs))
+	for _, expr := range exprs {
+		f, err := ParseFilter(expr)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+func (f Filter) match(p *projector) bool {
+	value, ok := p.ProjectString(f.Path)
+	switch f.Operator {
+	case "=":
+		return ok && value == f.Value
+	case "!=":
+		return !ok || value != f.Value
+	case "~":
+		return ok && strings.Contains(value, f.Value)
+	}
+	return false
+}
+
+// Matches reports whether a message satisfies every filter
+func Matches(filters []Filter, msg rabbitmq.Message) bool {
+	p := newProjector(msg)
+	for _, f := range filters {
+		if !f.match(p) {
+			return false
+		}
+	}
+	return true
+}
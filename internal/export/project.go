@@ -0,0 +1,182 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// Field paths understood by Project:
+//
+//	offset, stored_at, creation_time   message metadata
+//	body                               the raw body as text
+//	properties.<name>                  a standard property as returned by the API (message_id, subject, ...)
+//	app.<key>                          an application property
+//	annotations.<key>                  a message annotation
+//	body.<path>                        a field of a JSON body, e.g. body.customer.id or body.items.0.sku
+const (
+	prefixProperties  = "properties."
+	prefixApp         = "app."
+	prefixAnnotations = "annotations."
+	prefixBody        = "body."
+)
+
+// ValidatePath checks that a field path is understood by Project
+func ValidatePath(path string) error {
+	switch path {
+	case "offset", "stored_at", "creation_time", "body":
+		return nil
+	}
+	for _, prefix := range []string{prefixProperties, prefixApp, prefixAnnotations, prefixBody} {
+		if strings.HasPrefix(path, prefix) && len(path) > len(prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown field %q", path)
+}
+
+// ParseColumns parses a comma separated list of field paths
+func ParseColumns(spec string) ([]string, error) {
+	var columns []string
+	for _, column := range strings.Split(spec, ",") {
+		column = strings.TrimSpace(column)
+		if column == "" {
+			continue
+		}
+		if err := ValidatePath(column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// Project extracts the value at path from a message. ok is false when the message
+// has no such field. body paths are resolved lazily, decoding the JSON body once
+// per message through the projector cache.
+func (p *projector) Project(path string) (interface{}, bool) {
+	msg := p.msg
+	switch path {
+	case "offset":
+		return msg.Offset, true
+	case "stored_at":
+		if msg.StoredAt == nil {
+			return nil, false
+		}
+		return *msg.StoredAt, true
+	case "creation_time":
+		if msg.CreationTime == nil {
+			return nil, false
+		}
+		return *msg.CreationTime, true
+	case "body":
+		return string(msg.Data), true
+	}
+
+	switch {
+	case strings.HasPrefix(path, prefixProperties):
+		v, ok := msg.Properties[strings.TrimPrefix(path, prefixProperties)]
+		return v, ok
+	case strings.HasPrefix(path, prefixApp):
+		return lookupMap(msg.Properties["application_properties"], strings.TrimPrefix(path, prefixApp))
+	case strings.HasPrefix(path, prefixAnnotations):
+		return lookupMap(msg.Properties["message_annotations"], strings.TrimPrefix(path, prefixAnnotations))
+	case strings.HasPrefix(path, prefixBody):
+		body, ok := p.jsonBody()
+		if !ok {
+			return nil, false
+		}
+		return lookupPath(body, strings.Split(strings.TrimPrefix(path, prefixBody), "."))
+	}
+
+	return nil, false
+}
+
+// projector resolves field paths against a single message
+type projector struct {
+	msg     rabbitmq.Message
+	decoded bool
+	body    interface{}
+	isJSON  bool
+}
+
+func newProjector(msg rabbitmq.Message) *projector {
+	return &projector{msg: msg}
+}
+
+func (p *projector) jsonBody() (interface{}, bool) {
+	if !p.decoded {
+		p.decoded = true
+		p.isJSON = json.Unmarshal(p.msg.Data, &p.body) == nil
+	}
+	return p.body, p.isJSON
+}
+
+// ProjectString renders the value at path as text, empty when missing
+func (p *projector) ProjectString(path string) (string, bool) {
+	v, ok := p.Project(path)
+	if !ok || v == nil {
+		return "", false
+	}
+	return formatValue(v), true
+}
+
+func lookupMap(m interface{}, key string) (interface{}, bool) {
+	values, ok := m.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	v, ok := values[key]
+	return v, ok
+}
+
+func lookupPath(v interface{}, parts []string) (interface{}, bool) {
+	for _, part := range parts {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			next, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// formatValue renders a projected value for CSV and Parquet columns
+func formatValue(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case *string:
+		if value == nil {
+			return ""
+		}
+		return *value
+	case []byte:
+		return string(value)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Sprintf("%v", value)
+		}
+		return string(data)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
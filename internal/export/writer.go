@@ -0,0 +1,168 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// Supported export formats
+const (
+	FormatNDJSON  = "ndjson"
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+// DefaultColumns are used for CSV and Parquet exports when no columns are chosen
+var DefaultColumns = []string{"offset", "creation_time", "properties.message_id", "properties.subject", "body"}
+
+// parquetRowGroupSize bounds how many rows a Parquet export buffers before writing a row group
+const parquetRowGroupSize = 10000
+
+// Writer encodes exported messages
+type Writer interface {
+	Write(msg rabbitmq.Message) error
+	// Flush pushes buffered output to the underlying writer where the format allows it
+	Flush() error
+	// Close flushes and finalises the output; it does not close the underlying writer
+	Close() error
+}
+
+// ContentType returns the MIME type of a format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatParquet:
+		return "application/vnd.apache.parquet"
+	default:
+		return "application/x-ndjson"
+	}
+}
+
+// NewWriter creates a writer for format. columns select the fields of CSV and
+// Parquet exports; NDJSON always writes every section of the message.
+func NewWriter(format string, w io.Writer, columns []string) (Writer, error) {
+	if len(columns) == 0 {
+		columns = DefaultColumns
+	}
+
+	switch format {
+	case FormatNDJSON:
+		bw := bufio.NewWriter(w)
+		return &ndjsonWriter{buf: bw, enc: json.NewEncoder(bw)}, nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw, columns: columns}, nil
+	case FormatParquet:
+		return newParquetWriter(w, columns), nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+type ndjsonWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(msg rabbitmq.Message) error {
+	return n.enc.Encode(msg.Export())
+}
+
+func (n *ndjsonWriter) Flush() error {
+	return n.buf.Flush()
+}
+
+func (n *ndjsonWriter) Close() error {
+	return n.buf.Flush()
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func (c *csvWriter) Write(msg rabbitmq.Message) error {
+	p := newProjector(msg)
+	record := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		record[i], _ = p.ProjectString(column)
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	return c.Flush()
+}
+
+// parquetWriter writes every column as an optional string, except offset which is an int64
+type parquetWriter struct {
+	w       *parquet.Writer
+	columns []string
+	rows    int
+}
+
+func newParquetWriter(w io.Writer, columns []string) *parquetWriter {
+	group := parquet.Group{}
+	for _, column := range columns {
+		if column == "offset" {
+			group[column] = parquet.Int(64)
+		} else {
+			group[column] = parquet.Optional(parquet.String())
+		}
+	}
+
+	return &parquetWriter{
+		w:       parquet.NewWriter(w, parquet.NewSchema("message", group)),
+		columns: columns,
+	}
+}
+
+func (pw *parquetWriter) Write(msg rabbitmq.Message) error {
+	p := newProjector(msg)
+	row := make(map[string]interface{}, len(pw.columns))
+	for _, column := range pw.columns {
+		if column == "offset" {
+			row[column] = int64(msg.Offset)
+			continue
+		}
+		if value, ok := p.ProjectString(column); ok {
+			row[column] = value
+		} else {
+			row[column] = nil
+		}
+	}
+
+	if err := pw.w.Write(row); err != nil {
+		return err
+	}
+
+	pw.rows++
+	if pw.rows%parquetRowGroupSize == 0 {
+		return pw.w.Flush()
+	}
+	return nil
+}
+
+// Flush is a no-op, row groups are only written once they are full
+func (pw *parquetWriter) Flush() error {
+	return nil
+}
+
+func (pw *parquetWriter) Close() error {
+	return pw.w.Close()
+}
@@ -0,0 +1,145 @@
+// Package metrics defines the Prometheus metrics served on /metrics: gauges
+// for the streams the viewer can see and histograms and counters for the
+// viewer's own HTTP, management API and stream protocol work.
+package metrics
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every viewer metric, plus the Go runtime and process collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequestDuration observes API requests by route template
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rmq_viewer_http_request_duration_seconds",
+		Help:    "Duration of HTTP requests served by the viewer, by route template.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+
+	// ManagementRequestDuration observes calls to the management API
+	ManagementRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rmq_viewer_management_request_duration_seconds",
+		Help:    "Duration of RabbitMQ management API calls.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"connection", "method"})
+
+	// ManagementErrors counts management API calls that failed or returned
+	// an error status other than 404
+	ManagementErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rmq_viewer_management_errors_total",
+		Help: "RabbitMQ management API calls that failed or returned an error status other than 404.",
+	}, []string{"connection"})
+
+	// EnvironmentsCreated counts stream protocol environments opened
+	EnvironmentsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rmq_viewer_stream_environments_created_total",
+		Help: "Stream protocol environments opened by the viewer.",
+	}, []string{"connection"})
+
+	// ReadDuration observes message reads; kind is "batch" or "stream" and
+	// end_reason why the read stopped, or "error"
+	ReadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rmq_viewer_stream_read_duration_seconds",
+		Help:    "Duration of message reads from streams.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"connection", "kind", "end_reason"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		ManagementRequestDuration,
+		ManagementErrors,
+		EnvironmentsCreated,
+		ReadDuration,
+	)
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// StreamSnapshot is the state of one stream at scrape time
+type StreamSnapshot struct {
+	Connection  string
+	VHost       string
+	Stream      string
+	Messages    int64
+	Bytes       int64
+	FirstOffset uint64
+	LastOffset  uint64
+	// ConsumerLag is the offset lag of each named consumer, i.e. the
+	// consumers that track their offset on the broker
+	ConsumerLag map[string]int64
+}
+
+// StreamSource returns the streams to report on a scrape. It may return
+// snapshots together with an error when only some streams could be read.
+type StreamSource func(ctx context.Context) ([]StreamSnapshot, error)
+
+var streamLabels = []string{"connection", "vhost", "stream"}
+
+var (
+	streamMessagesDesc = prometheus.NewDesc("rmq_stream_messages",
+		"Messages in the stream.", streamLabels, nil)
+	streamSizeDesc = prometheus.NewDesc("rmq_stream_size_bytes",
+		"Size of the stream as reported by the broker.", streamLabels, nil)
+	streamFirstOffsetDesc = prometheus.NewDesc("rmq_stream_first_offset",
+		"First offset still stored in the stream.", streamLabels, nil)
+	streamLastOffsetDesc = prometheus.NewDesc("rmq_stream_last_offset",
+		"Offset of the last committed chunk of the stream.", streamLabels, nil)
+	streamConsumerLagDesc = prometheus.NewDesc("rmq_stream_consumer_lag",
+		"Offsets between a named consumer and the end of the stream.", append(streamLabels, "consumer"), nil)
+)
+
+// streamCollector reads the streams from its source on every scrape
+type streamCollector struct {
+	source  StreamSource
+	timeout time.Duration
+}
+
+// RegisterStreams adds the per-stream gauges, read from source on every
+// scrape within timeout
+func RegisterStreams(source StreamSource, timeout time.Duration) {
+	Registry.MustRegister(streamCollector{source: source, timeout: timeout})
+}
+
+func (c streamCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- streamMessagesDesc
+	ch <- streamSizeDesc
+	ch <- streamFirstOffsetDesc
+	ch <- streamLastOffsetDesc
+	ch <- streamConsumerLagDesc
+}
+
+func (c streamCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	snapshots, err := c.source(ctx)
+	if err != nil {
+		log.Printf("metrics: %v", err)
+	}
+	for _, s := range snapshots {
+		labels := []string{s.Connection, s.VHost, s.Stream}
+		ch <- prometheus.MustNewConstMetric(streamMessagesDesc, prometheus.GaugeValue, float64(s.Messages), labels...)
+		ch <- prometheus.MustNewConstMetric(streamSizeDesc, prometheus.GaugeValue, float64(s.Bytes), labels...)
+		ch <- prometheus.MustNewConstMetric(streamFirstOffsetDesc, prometheus.GaugeValue, float64(s.FirstOffset), labels...)
+		ch <- prometheus.MustNewConstMetric(streamLastOffsetDesc, prometheus.GaugeValue, float64(s.LastOffset), labels...)
+		for consumer, lag := range s.ConsumerLag {
+			ch <- prometheus.MustNewConstMetric(streamConsumerLagDesc, prometheus.GaugeValue, float64(lag), append(labels, consumer)...)
+		}
+	}
+}
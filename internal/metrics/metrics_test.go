@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStreamCollector(t *testing.T) {
+	source := func(ctx context.Context) ([]StreamSnapshot, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("expected the source to get a deadline")
+		}
+		return []StreamSnapshot{{
+			Connection:  "dev",
+			VHost:       "/",
+			Stream:      "orders",
+			Messages:    1200,
+			Bytes:       64000,
+			FirstOffset: 100,
+			LastOffset:  1299,
+			ConsumerLag: map[string]int64{"billing": 25},
+		}}, errors.New("invoices: unavailable")
+	}
+
+	// Streams read before the error are still reported
+	expected := `
+# HELP rmq_stream_consumer_lag Offsets between a named consumer and the end of the stream.
+# TYPE rmq_stream_consumer_lag gauge
+rmq_stream_consumer_lag{connection="dev",consumer="billing",stream="orders",vhost="/"} 25
+# HELP rmq_stream_last_offset Offset of the last committed chunk of the stream.
+# TYPE rmq_stream_last_offset gauge
+rmq_stream_last_offset{connection="dev",stream="orders",vhost="/"} 1299
+# HELP rmq_stream_messages Messages in the stream.
+# TYPE rmq_stream_messages gauge
+rmq_stream_messages{connection="dev",stream="orders",vhost="/"} 1200
+`
+	collector := streamCollector{source: source, timeout: time.Second}
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"rmq_stream_messages", "rmq_stream_last_offset", "rmq_stream_consumer_lag"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(collector); n != 5 {
+		t.Errorf("expected 5 series, got %d", n)
+	}
+}
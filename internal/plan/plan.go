@@ -0,0 +1,585 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// Change actions
+const (
+	ActionCreate = "create"
+	// ActionUpdate changes retention through the stream's policy, keeping its messages
+	ActionUpdate = "update"
+	// ActionRecreate deletes and declares a stream again, dropping its messages
+	ActionRecreate = "recreate"
+	// ActionDelete removes a super stream partition and its messages
+	ActionDelete = "delete"
+)
+
+// Kinds of resources a change applies to
+const (
+	KindStream      = "stream"
+	KindSuperStream = "super_stream"
+	KindPartition   = "partition"
+)
+
+// policyPriority ranks plan policies above typical catch-all policies
+const policyPriority = 100
+
+// retentionKeys are the policy keys a stream's policy sets from its retention settings
+var retentionKeys = map[string]bool{"max-length-bytes": true, "max-age": true, "stream-max-segment-size-bytes": true}
+
+// NotTransactional is the note plans with changes carry
+const NotTransactional = "Changes are applied one at a time and not rolled back: a failed apply leaves the changes before it in place."
+
+// ErrDestructive is returned when applying a plan that drops messages
+// without allowing destructive changes
+var ErrDestructive = errors.New("plan contains destructive changes")
+
+// ErrConflict is returned when applying a plan whose policies another
+// policy would override
+var ErrConflict = errors.New("plan conflicts with existing policies")
+
+// Broker is the part of a connection a plan reads and changes
+type Broker interface {
+	ReadOnly() bool
+	GetStreamSettings(ctx context.Context, vhost, name string) (*rabbitmq.StreamSettings, error)
+	GetPolicy(ctx context.Context, vhost, name string) (*rabbitmq.Policy, error)
+	ListPolicies(ctx context.Context, vhost string) ([]rabbitmq.Policy, error)
+	GetSuperStream(ctx context.Context, vhost, name string) (*rabbitmq.SuperStream, error)
+	DeclareStream(ctx context.Context, vhost, name string, settings rabbitmq.StreamSettings) error
+	DeleteStream(ctx context.Context, vhost, name string) error
+	PutPolicy(ctx context.Context, vhost string, policy rabbitmq.Policy) error
+	DeletePolicy(ctx context.Context, vhost, name string) error
+	DeclareSuperStream(ctx context.Context, vhost, name string, settings rabbitmq.SuperStreamSettings) (*rabbitmq.SuperStream, error)
+	AddSuperStreamPartition(ctx context.Context, vhost, name string, partition rabbitmq.SuperStreamPartition, settings rabbitmq.StreamSettings) error
+}
+
+// Change is one step needed to reach the desired state
+type Change struct {
+	Action      string   `json:"action"`
+	Kind        string   `json:"kind"`
+	Connection  string   `json:"connection"`
+	VHost       string   `json:"vhost"`
+	Name        string   `json:"name"`
+	Details     []string `json:"details,omitempty"`
+	Destructive bool     `json:"destructive"`
+	// Conflict explains why the change can't take effect; plans with conflicts aren't applied
+	Conflict string `json:"conflict,omitempty"`
+
+	broker Broker
+	steps  []func(ctx context.Context) error
+}
+
+// String describes the change on one line
+func (c Change) String() string {
+	symbol := map[string]string{
+		ActionCreate:   "+",
+		ActionUpdate:   "~",
+		ActionRecreate: "-/+",
+		ActionDelete:   "-",
+	}[c.Action]
+	line := fmt.Sprintf("%s %s %s %s/%s/%s", symbol, c.Action, strings.ReplaceAll(c.Kind, "_", " "), c.Connection, c.VHost, c.Name)
+	if c.Destructive {
+		line += " (destructive)"
+	}
+	for _, detail := range c.Details {
+		line += "\n    " + detail
+	}
+	if c.Conflict != "" {
+		line += "\n    conflict: " + c.Conflict
+	}
+	return line
+}
+
+// Plan is the list of changes between a spec and the broker
+type Plan struct {
+	Changes     []Change `json:"changes"`
+	Destructive int      `json:"destructive"`
+	Conflicts   int      `json:"conflicts"`
+	// Note is NotTransactional when there are changes
+	Note string `json:"note,omitempty"`
+}
+
+// Connect returns the broker of a connection
+type Connect func(connectionID string) (Broker, error)
+
+// PolicyName is the name of the policy holding a stream's retention settings
+func PolicyName(stream string) string {
+	return "stream-plan:" + stream
+}
+
+// Compute compares the spec with the broker. Entries without a connection
+// use defaultConnection. Streams missing from the spec are left alone.
+func Compute(ctx context.Context, spec *Spec, defaultConnection string, connect Connect) (*Plan, error) {
+	p := &Plan{Changes: []Change{}}
+	brokers := make(map[string]Broker)
+	type vhostKey struct{ connection, vhost string }
+	policies := make(map[vhostKey][]rabbitmq.Policy)
+	// targetOf returns the target of an entry with the policies of its vhost
+	targetOf := func(connection, vhost, name string) (target, error) {
+		if connection == "" {
+			connection = defaultConnection
+		}
+		b, ok := brokers[connection]
+		if !ok {
+			var err error
+			if b, err = connect(connection); err != nil {
+				return target{}, err
+			}
+			brokers[connection] = b
+		}
+		key := vhostKey{connection, vhost}
+		if _, ok := policies[key]; !ok {
+			list, err := b.ListPolicies(ctx, vhost)
+			if err != nil {
+				return target{}, err
+			}
+			policies[key] = list
+		}
+		return target{broker: b, connection: connection, vhost: vhost, name: name, policies: policies[key]}, nil
+	}
+
+	for _, st := range spec.Streams {
+		target, err := targetOf(st.Connection, st.VHost, st.Name)
+		if err != nil {
+			return nil, err
+		}
+		b := target.broker
+		declare := func(ctx context.Context) error {
+			return b.DeclareStream(ctx, st.VHost, st.Name, st.Placement())
+		}
+		change, err := planStream(ctx, target, KindStream, st.StreamSettings, declare)
+		if err != nil {
+			return nil, fmt.Errorf("stream %s: %w", st.Name, err)
+		}
+		p.add(change)
+	}
+
+	for _, ss := range spec.SuperStreams {
+		target, err := targetOf(ss.Connection, ss.VHost, ss.Name)
+		if err != nil {
+			return nil, err
+		}
+		changes, err := planSuperStream(ctx, target, ss)
+		if err != nil {
+			return nil, fmt.Errorf("super stream %s: %w", ss.Name, err)
+		}
+		for _, change := range changes {
+			p.add(change)
+		}
+	}
+
+	if len(p.Changes) > 0 {
+		p.Note = NotTransactional
+	}
+	return p, nil
+}
+
+// Apply runs every change of the plan in order. Nothing is changed when the
+// plan has conflicts, is destructive and allowDestructive is false, or when a
+// change targets a read-only connection. Apply is not transactional: changes
+// applied before a failing one stay in place.
+func Apply(ctx context.Context, p *Plan, allowDestructive bool) error {
+	if p.Conflicts > 0 {
+		return fmt.Errorf("%w: %d change(s) would not take effect", ErrConflict, p.Conflicts)
+	}
+	if p.Destructive > 0 && !allowDestructive {
+		return fmt.Errorf("%w: %d change(s) would drop messages", ErrDestructive, p.Destructive)
+	}
+	for _, change := range p.Changes {
+		if change.broker.ReadOnly() {
+			return fmt.Errorf("connection %s: %w", change.Connection, rabbitmq.ErrReadOnly)
+		}
+	}
+
+	for i, change := range p.Changes {
+		for _, step := range change.steps {
+			if err := step(ctx); err != nil {
+				return fmt.Errorf("%s %s %s failed after %d of %d changes: %w", change.Action, change.Kind, change.Name, i, len(p.Changes), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Plan) add(change *Change) {
+	if change == nil {
+		return
+	}
+	if change.Destructive {
+		p.Destructive++
+	}
+	if change.Conflict != "" {
+		p.Conflicts++
+	}
+	p.Changes = append(p.Changes, *change)
+}
+
+// target is a stream or super stream on a broker
+type target struct {
+	broker     Broker
+	connection string
+	vhost      string
+	name       string
+	// policies are the policies of the vhost
+	policies []rabbitmq.Policy
+}
+
+func (t target) change(action, kind string) *Change {
+	return &Change{Action: action, Kind: kind, Connection: t.connection, VHost: t.vhost, Name: t.name, broker: t.broker}
+}
+
+// planStream compares one stream. declare creates the stream with its
+// placement settings; retention is kept in the stream's policy so it can
+// change later without recreating the stream.
+func planStream(ctx context.Context, t target, kind string, desired rabbitmq.StreamSettings, declare func(context.Context) error) (*Change, error) {
+	desired, base, conflict := t.withBase(desired)
+
+	current, err := t.broker.GetStreamSettings(ctx, t.vhost, t.name)
+	if errors.Is(err, rabbitmq.ErrStreamNotFound) {
+		change := t.change(ActionCreate, kind)
+		change.Details = append(describe(desired), baseDetails(base)...)
+		change.Conflict = conflict
+		change.steps = append([]func(context.Context) error{declare}, t.policySteps(desired, false, base)...)
+		return change, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var applied rabbitmq.StreamSettings
+	policy, err := t.broker.GetPolicy(ctx, t.vhost, PolicyName(t.name))
+	switch {
+	case err == nil:
+		applied = rabbitmq.StreamSettingsFromPolicy(policy.Definition)
+	case !errors.Is(err, rabbitmq.ErrStreamNotFound):
+		return nil, err
+	}
+	hasPolicy := policy != nil
+
+	var recreate, update []string
+	// diff records a changed field; a reason means it can only change by recreating the stream
+	diff := func(field string, want, have, unset interface{}, reason string) {
+		if want == have {
+			return
+		}
+		detail := fmt.Sprintf("%s: %s -> %s", field, show(have, unset), show(want, unset))
+		if reason != "" {
+			recreate = append(recreate, detail+" ("+reason+")")
+		} else {
+			update = append(update, detail)
+		}
+	}
+	const placement, argument = "only set when a stream is declared", "declared as a queue argument"
+	var none rabbitmq.ByteSize
+
+	// Placement only applies when a stream is declared; unset means any value is fine
+	if desired.InitialClusterSize > 0 {
+		diff("initial_cluster_size", desired.InitialClusterSize, current.InitialClusterSize, 0, placement)
+	}
+	if desired.LeaderLocator != "" {
+		diff("leader_locator", desired.LeaderLocator, current.LeaderLocator, "", placement)
+	}
+
+	// Retention pinned by a queue argument can only change by recreating the stream
+	if current.MaxLengthBytes > 0 {
+		diff("max_length_bytes", desired.MaxLengthBytes, current.MaxLengthBytes, none, argument)
+	} else {
+		diff("max_length_bytes", desired.MaxLengthBytes, applied.MaxLengthBytes, none, "")
+	}
+	if current.MaxAge != "" {
+		diff("max_age", desired.MaxAge, current.MaxAge, "", argument)
+	} else {
+		diff("max_age", desired.MaxAge, applied.MaxAge, "", "")
+	}
+	if current.MaxSegmentSizeBytes > 0 {
+		diff("max_segment_size_bytes", desired.MaxSegmentSizeBytes, current.MaxSegmentSizeBytes, none, argument)
+	} else {
+		diff("max_segment_size_bytes", desired.MaxSegmentSizeBytes, applied.MaxSegmentSizeBytes, none, "")
+	}
+
+	// The keys kept from the policy the stream's policy overrides
+	if want := policyDefinition(desired, base); hasPolicy && len(want) > 0 {
+		if have, want := otherKeys(policy.Definition), otherKeys(want); !reflect.DeepEqual(have, want) {
+			update = append(update, fmt.Sprintf("other policy keys: %s -> %s", showKeys(have), showKeys(want)))
+		}
+	}
+
+	switch {
+	case len(recreate) > 0:
+		change := t.change(ActionRecreate, kind)
+		change.Destructive = true
+		change.Details = append(append(recreate, update...), baseDetails(base)...)
+		change.Conflict = conflict
+		deleteStream := func(ctx context.Context) error {
+			return t.broker.DeleteStream(ctx, t.vhost, t.name)
+		}
+		change.steps = append([]func(context.Context) error{deleteStream, declare}, t.policySteps(desired, hasPolicy, base)...)
+		return change, nil
+	case len(update) > 0 || conflict != "":
+		// A conflict is reported even when the stream's policy is up to date
+		change := t.change(ActionUpdate, kind)
+		change.Details = append(update, baseDetails(base)...)
+		change.Conflict = conflict
+		change.steps = t.policySteps(desired, hasPolicy, base)
+		return change, nil
+	}
+	return nil, nil
+}
+
+// withBase returns the desired settings completed by the policy the stream's
+// policy would replace: only one policy applies to a stream, so the stream's
+// policy keeps that policy's other keys and the retention settings the spec
+// leaves unset. conflict explains why the stream's policy would not apply
+// when the other policy ranks at or above it. Streams without retention
+// settings get no policy and keep the other policy as it is.
+func (t target) withBase(desired rabbitmq.StreamSettings) (rabbitmq.StreamSettings, *rabbitmq.Policy, string) {
+	if len(desired.PolicyDefinition()) == 0 {
+		return desired, nil, ""
+	}
+	base := t.basePolicy()
+	if base == nil {
+		return desired, nil, ""
+	}
+
+	inherited := rabbitmq.StreamSettingsFromPolicy(base.Definition)
+	if desired.MaxLengthBytes == 0 {
+		desired.MaxLengthBytes = inherited.MaxLengthBytes
+	}
+	if desired.MaxAge == "" {
+		desired.MaxAge = inherited.MaxAge
+	}
+	if desired.MaxSegmentSizeBytes == 0 {
+		desired.MaxSegmentSizeBytes = inherited.MaxSegmentSizeBytes
+	}
+
+	var conflict string
+	if base.Priority >= policyPriority {
+		conflict = fmt.Sprintf("policy %s (priority %d) applies to %s instead of %s (priority %d); lower its priority or remove it from the spec",
+			base.Name, base.Priority, t.name, PolicyName(t.name), policyPriority)
+	}
+	return desired, base, conflict
+}
+
+// basePolicy returns the policy of highest priority, other than the
+// stream's own, whose pattern matches the stream
+func (t target) basePolicy() *rabbitmq.Policy {
+	var base *rabbitmq.Policy
+	for i, policy := range t.policies {
+		if policy.Name == PolicyName(t.name) {
+			continue
+		}
+		switch policy.ApplyTo {
+		case "", "all", "queues", "streams":
+		default:
+			continue
+		}
+		if pattern, err := regexp.Compile(policy.Pattern); err != nil || !pattern.MatchString(t.name) {
+			continue
+		}
+		if base == nil || policy.Priority > base.Priority {
+			base = &t.policies[i]
+		}
+	}
+	return base
+}
+
+// baseDetails describes the policy a stream's policy overrides
+func baseDetails(base *rabbitmq.Policy) []string {
+	if base == nil || base.Priority >= policyPriority {
+		return nil
+	}
+	return []string{fmt.Sprintf("overrides policy %s (priority %d), keeping its other keys", base.Name, base.Priority)}
+}
+
+// policyDefinition returns the definition of the stream's policy: the
+// desired retention and the other keys of the policy it overrides
+func policyDefinition(desired rabbitmq.StreamSettings, base *rabbitmq.Policy) map[string]interface{} {
+	definition := desired.PolicyDefinition()
+	if len(definition) == 0 || base == nil {
+		return definition
+	}
+	for key, value := range otherKeys(base.Definition) {
+		definition[key] = value
+	}
+	return definition
+}
+
+// otherKeys returns the keys of a policy definition that aren't retention settings
+func otherKeys(definition map[string]interface{}) map[string]interface{} {
+	other := make(map[string]interface{})
+	for key, value := range definition {
+		if !retentionKeys[key] {
+			other[key] = value
+		}
+	}
+	return other
+}
+
+func showKeys(definition map[string]interface{}) string {
+	if len(definition) == 0 {
+		return "(none)"
+	}
+	keys := make([]string, 0, len(definition))
+	for key := range definition {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
+
+// policySteps sets the stream's policy to the desired retention and the other
+// keys of base, deleting it when no retention is wanted
+func (t target) policySteps(desired rabbitmq.StreamSettings, hasPolicy bool, base *rabbitmq.Policy) []func(context.Context) error {
+	definition := policyDefinition(desired, base)
+	if len(definition) == 0 {
+		if !hasPolicy {
+			return nil
+		}
+		return []func(context.Context) error{func(ctx context.Context) error {
+			return t.broker.DeletePolicy(ctx, t.vhost, PolicyName(t.name))
+		}}
+	}
+
+	policy := rabbitmq.Policy{
+		Name:       PolicyName(t.name),
+		Pattern:    "^" + regexp.QuoteMeta(t.name) + "$",
+		ApplyTo:    "queues",
+		Priority:   policyPriority,
+		Definition: definition,
+	}
+	return []func(context.Context) error{func(ctx context.Context) error {
+		return t.broker.PutPolicy(ctx, t.vhost, policy)
+	}}
+}
+
+// planSuperStream compares a super stream's partitions and their settings
+func planSuperStream(ctx context.Context, t target, desired SuperStreamSpec) ([]*Change, error) {
+	partitions := desired.PartitionStreams()
+
+	current, err := t.broker.GetSuperStream(ctx, t.vhost, t.name)
+	if errors.Is(err, rabbitmq.ErrStreamNotFound) {
+		change := t.change(ActionCreate, KindSuperStream)
+		change.Details = describe(desired.StreamSettings)
+		settings := desired.SuperStreamSettings
+		settings.StreamSettings = desired.Placement()
+		change.steps = []func(context.Context) error{func(ctx context.Context) error {
+			_, err := t.broker.DeclareSuperStream(ctx, t.vhost, t.name, settings)
+			return err
+		}}
+		for _, p := range partitions {
+			change.Details = append(change.Details, fmt.Sprintf("partition %s (binding key %s)", p.Stream, p.BindingKey))
+			partition := t
+			partition.name = p.Stream
+			settings, base, conflict := partition.withBase(desired.StreamSettings)
+			change.Details = append(change.Details, baseDetails(base)...)
+			if change.Conflict == "" {
+				change.Conflict = conflict
+			}
+			change.steps = append(change.steps, partition.policySteps(settings, false, base)...)
+		}
+		return []*Change{change}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Existing partitions keep their order, new ones are appended after the last
+	orders := make(map[string]int, len(current.Partitions))
+	next := 0
+	for _, p := range current.Partitions {
+		orders[p.Stream] = p.Order
+		if p.Order >= next {
+			next = p.Order + 1
+		}
+	}
+
+	var changes []*Change
+	wanted := make(map[string]bool, len(partitions))
+	for _, p := range partitions {
+		wanted[p.Stream] = true
+		partition := t
+		partition.name = p.Stream
+		if order, ok := orders[p.Stream]; ok {
+			p.Order = order
+		} else {
+			p.Order = next
+			next++
+		}
+		// Partitions are declared and bound together, so a recreated partition keeps routing
+		declare := func(ctx context.Context) error {
+			return t.broker.AddSuperStreamPartition(ctx, t.vhost, t.name, p, desired.Placement())
+		}
+
+		change, err := planStream(ctx, partition, KindPartition, desired.StreamSettings, declare)
+		if err != nil {
+			return nil, err
+		}
+		if change != nil && change.Action == ActionCreate {
+			change.Details = append([]string{fmt.Sprintf("binding key %s", p.BindingKey)}, change.Details...)
+		}
+		changes = append(changes, change)
+	}
+
+	for _, p := range current.Partitions {
+		if wanted[p.Stream] {
+			continue
+		}
+		partition := t
+		partition.name = p.Stream
+		change := partition.change(ActionDelete, KindPartition)
+		change.Destructive = true
+		change.Details = []string{fmt.Sprintf("binding key %s is no longer defined", p.BindingKey)}
+		stream := p.Stream
+		change.steps = []func(context.Context) error{
+			func(ctx context.Context) error {
+				return t.broker.DeleteStream(ctx, t.vhost, stream)
+			},
+			func(ctx context.Context) error {
+				err := t.broker.DeletePolicy(ctx, t.vhost, PolicyName(stream))
+				if errors.Is(err, rabbitmq.ErrStreamNotFound) {
+					return nil
+				}
+				return err
+			},
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// describe lists the settings that are set
+func describe(s rabbitmq.StreamSettings) []string {
+	var details []string
+	if s.MaxLengthBytes > 0 {
+		details = append(details, fmt.Sprintf("max_length_bytes: %d", s.MaxLengthBytes))
+	}
+	if s.MaxAge != "" {
+		details = append(details, "max_age: "+s.MaxAge)
+	}
+	if s.MaxSegmentSizeBytes > 0 {
+		details = append(details, fmt.Sprintf("max_segment_size_bytes: %d", s.MaxSegmentSizeBytes))
+	}
+	if s.InitialClusterSize > 0 {
+		details = append(details, fmt.Sprintf("initial_cluster_size: %d", s.InitialClusterSize))
+	}
+	if s.LeaderLocator != "" {
+		details = append(details, "leader_locator: "+s.LeaderLocator)
+	}
+	return details
+}
+
+func show(v, unset interface{}) string {
+	if v == unset {
+		return "(default)"
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,355 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// fakeBroker keeps streams, policies and super streams of a single vhost in memory
+type fakeBroker struct {
+	readOnly     bool
+	streams      map[string]rabbitmq.StreamSettings
+	policies     map[string]rabbitmq.Policy
+	superStreams map[string][]rabbitmq.SuperStreamPartition
+	calls        []string
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{
+		streams:      make(map[string]rabbitmq.StreamSettings),
+		policies:     make(map[string]rabbitmq.Policy),
+		superStreams: make(map[string][]rabbitmq.SuperStreamPartition),
+	}
+}
+
+func (f *fakeBroker) ReadOnly() bool { return f.readOnly }
+
+func (f *fakeBroker) GetStreamSettings(_ context.Context, _, name string) (*rabbitmq.StreamSettings, error) {
+	s, ok := f.streams[name]
+	if !ok {
+		return nil, rabbitmq.ErrStreamNotFound
+	}
+	return &s, nil
+}
+
+func (f *fakeBroker) GetPolicy(_ context.Context, _, name string) (*rabbitmq.Policy, error) {
+	p, ok := f.policies[name]
+	if !ok {
+		return nil, rabbitmq.ErrStreamNotFound
+	}
+	return &p, nil
+}
+
+func (f *fakeBroker) ListPolicies(context.Context, string) ([]rabbitmq.Policy, error) {
+	policies := make([]rabbitmq.Policy, 0, len(f.policies))
+	for _, p := range f.policies {
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+func (f *fakeBroker) GetSuperStream(_ context.Context, vhost, name string) (*rabbitmq.SuperStream, error) {
+	partitions, ok := f.superStreams[name]
+	if !ok {
+		return nil, rabbitmq.ErrStreamNotFound
+	}
+	return &rabbitmq.SuperStream{Name: name, VHost: vhost, Partitions: partitions}, nil
+}
+
+func (f *fakeBroker) DeclareStream(_ context.Context, _, name string, settings rabbitmq.StreamSettings) error {
+	f.calls = append(f.calls, "declare "+name)
+	f.streams[name] = settings
+	return nil
+}
+
+func (f *fakeBroker) DeleteStream(_ context.Context, _, name string) error {
+	f.calls = append(f.calls, "delete "+name)
+	delete(f.streams, name)
+	for superStream, partitions := range f.superStreams {
+		kept := partitions[:0]
+		for _, p := range partitions {
+			if p.Stream != name {
+				kept = append(kept, p)
+			}
+		}
+		f.superStreams[superStream] = kept
+	}
+	return nil
+}
+
+func (f *fakeBroker) PutPolicy(_ context.Context, _ string, policy rabbitmq.Policy) error {
+	f.calls = append(f.calls, "policy "+policy.Name)
+	f.policies[policy.Name] = policy
+	return nil
+}
+
+func (f *fakeBroker) DeletePolicy(_ context.Context, _, name string) error {
+	f.calls = append(f.calls, "delete policy "+name)
+	delete(f.policies, name)
+	return nil
+}
+
+func (f *fakeBroker) DeclareSuperStream(ctx context.Context, vhost, name string, settings rabbitmq.SuperStreamSettings) (*rabbitmq.SuperStream, error) {
+	f.superStreams[name] = nil
+	for _, p := range settings.PartitionsOf(name) {
+		f.AddSuperStreamPartition(ctx, vhost, name, p, settings.StreamSettings)
+	}
+	return f.GetSuperStream(ctx, vhost, name)
+}
+
+func (f *fakeBroker) AddSuperStreamPartition(ctx context.Context, vhost, name string, partition rabbitmq.SuperStreamPartition, settings rabbitmq.StreamSettings) error {
+	f.DeclareStream(ctx, vhost, partition.Stream, settings)
+	f.superStreams[name] = append(f.superStreams[name], partition)
+	sort.Slice(f.superStreams[name], func(i, j int) bool {
+		return f.superStreams[name][i].Order < f.superStreams[name][j].Order
+	})
+	return nil
+}
+
+func computePlan(t *testing.T, broker *fakeBroker, yaml string) *Plan {
+	t.Helper()
+	spec, err := Parse([]byte(yaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Compute(context.Background(), spec, "dev", func(id string) (Broker, error) {
+		if id != "dev" {
+			return nil, errors.New("unknown connection " + id)
+		}
+		return broker, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func actions(p *Plan) []string {
+	var out []string
+	for _, c := range p.Changes {
+		out = append(out, c.Action+" "+c.Name)
+	}
+	return out
+}
+
+func TestParse(t *testing.T) {
+	spec, err := Parse([]byte(`
+streams:
+  - name: orders
+    max_length_bytes: 20gb
+    max_age: 7D
+    initial_cluster_size: 3
+super_streams:
+  - name: invoices
+    vhost: billing
+    binding_keys: [eu, us]
+    max_segment_size_bytes: 500mb
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orders := spec.Streams[0]
+	if orders.VHost != "/" || orders.MaxLengthBytes != 20_000_000_000 || orders.MaxAge != "7D" || orders.InitialClusterSize != 3 {
+		t.Errorf("unexpected stream: %+v", orders)
+	}
+	invoices := spec.SuperStreams[0]
+	if invoices.VHost != "billing" || len(invoices.BindingKeys) != 2 || invoices.MaxSegmentSizeBytes != 500_000_000 {
+		t.Errorf("unexpected super stream: %+v", invoices)
+	}
+
+	invalid := map[string]string{
+		"missing name":       "streams:\n  - max_age: 7D\n",
+		"invalid max_age":    "streams:\n  - name: orders\n    max_age: a week\n",
+		"duplicate stream":   "streams:\n  - name: orders\n  - name: orders\n",
+		"partition conflict": "streams:\n  - name: invoices-0\nsuper_streams:\n  - name: invoices\n    partitions: 2\n",
+		"no partitions":      "super_streams:\n  - name: invoices\n",
+	}
+	for name, doc := range invalid {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPlan_CreateThenNoChanges(t *testing.T) {
+	broker := newFakeBroker()
+	spec := `
+streams:
+  - name: orders
+    max_age: 7D
+    initial_cluster_size: 3
+  - name: audit
+super_streams:
+  - name: invoices
+    partitions: 2
+    max_length_bytes: 1gb
+`
+	p := computePlan(t, broker, spec)
+	if got := strings.Join(actions(p), ", "); got != "create orders, create audit, create invoices" {
+		t.Fatalf("unexpected plan: %s", got)
+	}
+	if err := Apply(context.Background(), p, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retention goes to a policy, placement to the declaration
+	if broker.streams["orders"] != (rabbitmq.StreamSettings{InitialClusterSize: 3}) {
+		t.Errorf("expected only placement arguments, got %+v", broker.streams["orders"])
+	}
+	if broker.policies[PolicyName("orders")].Definition["max-age"] != "7D" {
+		t.Errorf("expected a retention policy, got %+v", broker.policies[PolicyName("orders")])
+	}
+	if _, ok := broker.policies[PolicyName("audit")]; ok {
+		t.Error("expected no policy for a stream without retention")
+	}
+	if _, ok := broker.policies[PolicyName("invoices-1")]; !ok || len(broker.superStreams["invoices"]) != 2 {
+		t.Errorf("expected two partitions with policies, got %v", broker.superStreams["invoices"])
+	}
+
+	if p := computePlan(t, broker, spec); len(p.Changes) != 0 {
+		t.Errorf("expected no changes after apply, got %v", actions(p))
+	}
+}
+
+func TestPlan_RetentionUpdateKeepsMessages(t *testing.T) {
+	broker := newFakeBroker()
+	Apply(context.Background(), computePlan(t, broker, "streams:\n  - name: orders\n    max_age: 7D\n"), false)
+	broker.calls = nil
+
+	p := computePlan(t, broker, "streams:\n  - name: orders\n    max_age: 14D\n")
+	if len(p.Changes) != 1 || p.Changes[0].Action != ActionUpdate || p.Destructive != 0 {
+		t.Fatalf("expected a non-destructive update, got %+v", p)
+	}
+	if p.Changes[0].Details[0] != "max_age: 7D -> 14D" {
+		t.Errorf("unexpected details: %v", p.Changes[0].Details)
+	}
+	if err := Apply(context.Background(), p, false); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(broker.calls, ", ") != "policy "+PolicyName("orders") {
+		t.Errorf("expected only the policy to change, got %v", broker.calls)
+	}
+
+	p = computePlan(t, broker, "streams:\n  - name: orders\n")
+	Apply(context.Background(), p, false)
+	if _, ok := broker.policies[PolicyName("orders")]; ok {
+		t.Error("expected the policy to be removed once no retention is defined")
+	}
+}
+
+func TestPlan_DestructiveChangesNeedFlag(t *testing.T) {
+	broker := newFakeBroker()
+	// Declared elsewhere with retention pinned as a queue argument
+	broker.streams["orders"] = rabbitmq.StreamSettings{MaxLengthBytes: 2_000_000_000}
+
+	p := computePlan(t, broker, "streams:\n  - name: orders\n    max_length_bytes: 5gb\n")
+	if len(p.Changes) != 1 || p.Changes[0].Action != ActionRecreate || p.Destructive != 1 {
+		t.Fatalf("expected a destructive recreate, got %+v", p)
+	}
+	if err := Apply(context.Background(), p, false); !errors.Is(err, ErrDestructive) {
+		t.Fatalf("expected ErrDestructive, got %v", err)
+	}
+	if len(broker.calls) != 0 {
+		t.Fatalf("expected nothing to change, got %v", broker.calls)
+	}
+
+	if err := Apply(context.Background(), p, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(broker.calls, ", "); got != "delete orders, declare orders, policy "+PolicyName("orders") {
+		t.Errorf("unexpected calls: %s", got)
+	}
+}
+
+func TestPlan_SuperStreamPartitions(t *testing.T) {
+	broker := newFakeBroker()
+	Apply(context.Background(), computePlan(t, broker, "super_streams:\n  - name: invoices\n    binding_keys: [eu, us]\n"), false)
+
+	p := computePlan(t, broker, "super_streams:\n  - name: invoices\n    binding_keys: [eu, apac]\n")
+	if got := strings.Join(actions(p), ", "); got != "create invoices-apac, delete invoices-us" {
+		t.Fatalf("unexpected plan: %s", got)
+	}
+	if p.Destructive != 1 {
+		t.Errorf("expected removing a partition to be destructive, got %d", p.Destructive)
+	}
+	if err := Apply(context.Background(), p, true); err != nil {
+		t.Fatal(err)
+	}
+
+	partitions := broker.superStreams["invoices"]
+	if len(partitions) != 2 || partitions[0].Stream != "invoices-eu" || partitions[1].Stream != "invoices-apac" || partitions[1].Order != 2 {
+		t.Errorf("unexpected partitions: %+v", partitions)
+	}
+}
+
+func TestPlan_ExistingPolicies(t *testing.T) {
+	broker := newFakeBroker()
+	broker.policies["catch-all"] = rabbitmq.Policy{
+		Name: "catch-all", Pattern: ".*", ApplyTo: "queues", Priority: 1,
+		Definition: map[string]interface{}{"max-age": "30D", "queue-leader-locator": "balanced"},
+	}
+
+	// The stream's policy keeps the keys of the policy it overrides
+	p := computePlan(t, broker, "streams:\n  - name: orders\n    max_length_bytes: 5gb\n")
+	if len(p.Changes) != 1 || p.Conflicts != 0 || p.Note != NotTransactional {
+		t.Fatalf("expected one change without conflicts, got %+v", p)
+	}
+	if details := strings.Join(p.Changes[0].Details, "; "); !strings.Contains(details, "overrides policy catch-all (priority 1)") {
+		t.Errorf("expected the overridden policy to be reported, got %s", details)
+	}
+	if err := Apply(context.Background(), p, false); err != nil {
+		t.Fatal(err)
+	}
+	definition := broker.policies[PolicyName("orders")].Definition
+	if definition["max-length-bytes"] != int64(5_000_000_000) || definition["max-age"] != "30D" || definition["queue-leader-locator"] != "balanced" {
+		t.Errorf("expected the catch-all keys to be merged, got %v", definition)
+	}
+	if p := computePlan(t, broker, "streams:\n  - name: orders\n    max_length_bytes: 5gb\n"); len(p.Changes) != 0 {
+		t.Errorf("expected no changes after apply, got %v", actions(p))
+	}
+
+	// Changed keys of the overridden policy are carried over
+	broker.policies["catch-all"].Definition["queue-leader-locator"] = "client-local"
+	if p := computePlan(t, broker, "streams:\n  - name: orders\n    max_length_bytes: 5gb\n"); len(p.Changes) != 1 || p.Changes[0].Action != ActionUpdate {
+		t.Errorf("expected an update for the changed key, got %+v", p)
+	}
+
+	// A policy ranking at or above the stream's policy is a conflict
+	broker.policies["pinned"] = rabbitmq.Policy{Name: "pinned", Pattern: "^orders$", ApplyTo: "all", Priority: 200,
+		Definition: map[string]interface{}{"max-age": "1D"}}
+	broker.calls = nil
+	p = computePlan(t, broker, "streams:\n  - name: orders\n    max_length_bytes: 5gb\n")
+	if p.Conflicts != 1 || !strings.Contains(p.Changes[0].Conflict, "policy pinned (priority 200)") {
+		t.Fatalf("expected a conflict with the pinned policy, got %+v", p)
+	}
+	if err := Apply(context.Background(), p, true); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+	if len(broker.calls) != 0 {
+		t.Errorf("expected nothing to change, got %v", broker.calls)
+	}
+
+	// Streams without retention get no policy, so there is nothing to conflict with
+	if p := computePlan(t, broker, "streams:\n  - name: orders\n"); p.Conflicts != 0 {
+		t.Errorf("expected no conflict without retention, got %+v", p)
+	}
+}
+
+func TestApply_ReadOnly(t *testing.T) {
+	broker := newFakeBroker()
+	broker.readOnly = true
+
+	p := computePlan(t, broker, "streams:\n  - name: orders\n")
+	if err := Apply(context.Background(), p, true); !errors.Is(err, rabbitmq.ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+	if len(broker.calls) != 0 {
+		t.Errorf("expected nothing to change, got %v", broker.calls)
+	}
+}
@@ -0,0 +1,114 @@
+// Package plan compares streams described in a YAML file with what the
+// management API reports and applies the difference. It is shared by the
+// plan and apply API endpoints and the streams command.
+package plan
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is the desired state of a set of streams
+type Spec struct {
+	Streams      []StreamSpec      `yaml:"streams" json:"streams"`
+	SuperStreams []SuperStreamSpec `yaml:"super_streams" json:"super_streams"`
+}
+
+// StreamSpec describes one stream
+type StreamSpec struct {
+	Name string `yaml:"name" json:"name"`
+	// VHost defaults to "/"
+	VHost string `yaml:"vhost" json:"vhost"`
+	// Connection defaults to the connection the plan is run against
+	Connection              string `yaml:"connection" json:"connection,omitempty"`
+	rabbitmq.StreamSettings `yaml:",inline"`
+}
+
+// SuperStreamSpec describes one super stream; its settings apply to every partition
+type SuperStreamSpec struct {
+	Name                         string `yaml:"name" json:"name"`
+	VHost                        string `yaml:"vhost" json:"vhost"`
+	Connection                   string `yaml:"connection" json:"connection,omitempty"`
+	rabbitmq.SuperStreamSettings `yaml:",inline"`
+}
+
+// Load reads a spec file
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stream definitions: %w", err)
+	}
+	spec, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// Parse decodes and validates a YAML spec, filling in default vhosts
+func Parse(data []byte) (*Spec, error) {
+	var spec Spec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse stream definitions: %w", err)
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// Validate checks every entry and that no stream is described twice
+func (s *Spec) Validate() error {
+	seen := make(map[string]bool)
+	claim := func(connection, vhost, name string) error {
+		key := connection + "\x00" + vhost + "\x00" + name
+		if seen[key] {
+			return fmt.Errorf("stream %s in vhost %s is defined more than once", name, vhost)
+		}
+		seen[key] = true
+		return nil
+	}
+
+	for i := range s.Streams {
+		st := &s.Streams[i]
+		if st.VHost == "" {
+			st.VHost = "/"
+		}
+		if st.Name == "" {
+			return fmt.Errorf("streams[%d]: name is required", i)
+		}
+		if err := st.Validate(); err != nil {
+			return fmt.Errorf("stream %s: %w", st.Name, err)
+		}
+		if err := claim(st.Connection, st.VHost, st.Name); err != nil {
+			return err
+		}
+	}
+
+	for i := range s.SuperStreams {
+		ss := &s.SuperStreams[i]
+		if ss.VHost == "" {
+			ss.VHost = "/"
+		}
+		if ss.Name == "" {
+			return fmt.Errorf("super_streams[%d]: name is required", i)
+		}
+		if err := ss.Validate(); err != nil {
+			return fmt.Errorf("super stream %s: %w", ss.Name, err)
+		}
+		for _, p := range ss.PartitionStreams() {
+			if err := claim(ss.Connection, ss.VHost, p.Stream); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PartitionStreams returns the partition streams the super stream should have
+func (s SuperStreamSpec) PartitionStreams() []rabbitmq.SuperStreamPartition {
+	return s.SuperStreamSettings.PartitionsOf(s.Name)
+}
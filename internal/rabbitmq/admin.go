@@ -0,0 +1,599 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrStreamExists is returned when declaring a stream or super stream that already exists
+var ErrStreamExists = errors.New("stream already exists")
+
+// ErrStreamNotFound is returned when a stream or super stream does not exist
+var ErrStreamNotFound = errors.New("stream not found")
+
+// Leader locator strategies accepted by RabbitMQ for new streams
+var leaderLocators = map[string]bool{
+	"client-local":  true,
+	"balanced":      true,
+	"least-leaders": true,
+	"random":        true,
+}
+
+// maxAgePattern matches the x-max-age format, e.g. 7D, 12h or 30m
+var maxAgePattern = regexp.MustCompile(`^[0-9]+(Y|M|D|h|m|s)$`)
+
+// ByteSize is a size in bytes. It is written as a number of bytes or as a
+// string with a kb, mb, gb or tb suffix (powers of 1000), e.g. "20gb".
+type ByteSize int64
+
+// ParseByteSize parses a byte count with an optional kb, mb, gb or tb suffix
+func ParseByteSize(s string) (ByteSize, error) {
+	text := strings.ToLower(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12} {
+		if strings.HasSuffix(text, suffix) {
+			text, multiplier = strings.TrimSpace(strings.TrimSuffix(text, suffix)), m
+			break
+		}
+	}
+	text = strings.TrimSuffix(text, "b")
+
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("byte size %q is too large", s)
+	}
+	return ByteSize(n * multiplier), nil
+}
+
+// UnmarshalJSON accepts a number of bytes or a size string
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		text = string(data)
+	}
+	size, err := ParseByteSize(text)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// UnmarshalYAML accepts a number of bytes or a size string
+func (b *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var text string
+	if err := unmarshal(&text); err != nil {
+		return err
+	}
+	size, err := ParseByteSize(text)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// StreamSettings are the settings a stream is declared with. Zero values
+// leave the broker's defaults in place.
+type StreamSettings struct {
+	MaxLengthBytes ByteSize `json:"max_length_bytes,omitempty" yaml:"max_length_bytes"`
+	// MaxAge uses RabbitMQ's format: a number followed by Y, M, D, h, m or s
+	MaxAge              string   `json:"max_age,omitempty" yaml:"max_age"`
+	MaxSegmentSizeBytes ByteSize `json:"max_segment_size_bytes,omitempty" yaml:"max_segment_size_bytes"`
+	InitialClusterSize  int      `json:"initial_cluster_size,omitempty" yaml:"initial_cluster_size"`
+	// LeaderLocator is client-local, balanced, least-leaders or random
+	LeaderLocator string `json:"leader_locator,omitempty" yaml:"leader_locator"`
+}
+
+// Validate checks the settings before they are sent to the broker
+func (s StreamSettings) Validate() error {
+	if s.MaxLengthBytes < 0 || s.MaxSegmentSizeBytes < 0 {
+		return errors.New("byte sizes must not be negative")
+	}
+	if s.MaxAge != "" && !maxAgePattern.MatchString(s.MaxAge) {
+		return fmt.Errorf("invalid max_age %q: use a number followed by Y, M, D, h, m or s", s.MaxAge)
+	}
+	if s.InitialClusterSize < 0 {
+		return errors.New("initial_cluster_size must not be negative")
+	}
+	if s.LeaderLocator != "" && !leaderLocators[s.LeaderLocator] {
+		return fmt.Errorf("invalid leader_locator %q: use client-local, balanced, least-leaders or random", s.LeaderLocator)
+	}
+	return nil
+}
+
+// Arguments returns the queue arguments declaring a stream with these settings
+func (s StreamSettings) Arguments() map[string]interface{} {
+	args := map[string]interface{}{"x-queue-type": "stream"}
+	if s.MaxLengthBytes > 0 {
+		args["x-max-length-bytes"] = int64(s.MaxLengthBytes)
+	}
+	if s.MaxAge != "" {
+		args["x-max-age"] = s.MaxAge
+	}
+	if s.MaxSegmentSizeBytes > 0 {
+		args["x-stream-max-segment-size-bytes"] = int64(s.MaxSegmentSizeBytes)
+	}
+	if s.InitialClusterSize > 0 {
+		args["x-initial-cluster-size"] = s.InitialClusterSize
+	}
+	if s.LeaderLocator != "" {
+		args["x-queue-leader-locator"] = s.LeaderLocator
+	}
+	return args
+}
+
+// streamSettingsFromArguments reads the settings back from queue arguments
+func streamSettingsFromArguments(args map[string]interface{}) StreamSettings {
+	var s StreamSettings
+	s.MaxLengthBytes = ByteSize(intArgument(args["x-max-length-bytes"]))
+	s.MaxAge, _ = args["x-max-age"].(string)
+	s.MaxSegmentSizeBytes = ByteSize(intArgument(args["x-stream-max-segment-size-bytes"]))
+	s.InitialClusterSize = int(intArgument(args["x-initial-cluster-size"]))
+	s.LeaderLocator, _ = args["x-queue-leader-locator"].(string)
+	return s
+}
+
+func intArgument(v interface{}) int64 {
+	switch value := v.(type) {
+	case int64:
+		return value
+	case int:
+		return int64(value)
+	case float64:
+		return int64(value)
+	case json.Number:
+		n, _ := value.Int64()
+		return n
+	case string:
+		n, _ := strconv.ParseInt(value, 10, 64)
+		return n
+	}
+	return 0
+}
+
+// SuperStreamSettings declares a super stream: a direct exchange routing to
+// one partition stream per binding key. Set either Partitions, which creates
+// partitions bound with keys "0" to "<n-1>", or BindingKeys.
+type SuperStreamSettings struct {
+	Partitions     int      `json:"partitions,omitempty" yaml:"partitions"`
+	BindingKeys    []string `json:"binding_keys,omitempty" yaml:"binding_keys"`
+	StreamSettings `yaml:",inline"`
+}
+
+// Validate checks the partitioning and the partition stream settings
+func (s SuperStreamSettings) Validate() error {
+	switch {
+	case s.Partitions > 0 && len(s.BindingKeys) > 0:
+		return errors.New("set either partitions or binding_keys, not both")
+	case s.Partitions <= 0 && len(s.BindingKeys) == 0:
+		return errors.New("partitions or binding_keys is required")
+	}
+	seen := make(map[string]bool, len(s.BindingKeys))
+	for _, key := range s.BindingKeys {
+		if key == "" || seen[key] {
+			return fmt.Errorf("binding keys must be unique and not empty, got %q", key)
+		}
+		seen[key] = true
+	}
+	return s.StreamSettings.Validate()
+}
+
+// SuperStreamPartition is one partition stream of a super stream
+type SuperStreamPartition struct {
+	Stream     string `json:"stream"`
+	BindingKey string `json:"binding_key"`
+	Order      int    `json:"order"`
+}
+
+// PartitionsOf returns the partition streams of a super stream called name
+func (s SuperStreamSettings) PartitionsOf(name string) []SuperStreamPartition {
+	keys := s.BindingKeys
+	if len(keys) == 0 {
+		keys = make([]string, s.Partitions)
+		for i := range keys {
+			keys[i] = strconv.Itoa(i)
+		}
+	}
+	partitions := make([]SuperStreamPartition, len(keys))
+	for i, key := range keys {
+		partitions[i] = SuperStreamPartition{Stream: name + "-" + key, BindingKey: key, Order: i}
+	}
+	return partitions
+}
+
+// SuperStream describes a super stream and its partitions
+type SuperStream struct {
+	Name         string                 `json:"name"`
+	ConnectionID string                 `json:"connection_id"`
+	VHost        string                 `json:"vhost"`
+	Partitions   []SuperStreamPartition `json:"partitions"`
+}
+
+// GetStreamSettings returns the settings a stream was declared with
+func (c *Connection) GetStreamSettings(ctx context.Context, vhost, streamName string) (*StreamSettings, error) {
+	var queue struct {
+		Type      string                 `json:"type"`
+		Arguments map[string]interface{} `json:"arguments"`
+	}
+	err := c.management(ctx, http.MethodGet, queuePath(vhost, streamName), nil, &queue)
+	if err != nil {
+		return nil, err
+	}
+	if queue.Type != "stream" {
+		return nil, fmt.Errorf("%w: %s is a %s queue", ErrStreamNotFound, streamName, queue.Type)
+	}
+
+	settings := streamSettingsFromArguments(queue.Arguments)
+	return &settings, nil
+}
+
+// DeclareStream creates a stream with the given settings
+func (c *Connection) DeclareStream(ctx context.Context, vhost, streamName string, settings StreamSettings) error {
+	if c.Config.IsReadOnly() {
+		return ErrReadOnly
+	}
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if err := c.ensureQueueAbsent(ctx, vhost, streamName); err != nil {
+		return err
+	}
+	return c.declareStream(ctx, vhost, streamName, settings)
+}
+
+func (c *Connection) declareStream(ctx context.Context, vhost, streamName string, settings StreamSettings) error {
+	body := map[string]interface{}{
+		"durable":     true,
+		"auto_delete": false,
+		"arguments":   settings.Arguments(),
+	}
+	if err := c.management(ctx, http.MethodPut, queuePath(vhost, streamName), body, nil); err != nil {
+		return fmt.Errorf("failed to declare stream %s: %w", streamName, err)
+	}
+	return nil
+}
+
+// DeleteStream deletes a stream and every message in it
+func (c *Connection) DeleteStream(ctx context.Context, vhost, streamName string) error {
+	if c.Config.IsReadOnly() {
+		return ErrReadOnly
+	}
+	if _, err := c.GetStreamSettings(ctx, vhost, streamName); err != nil {
+		return err
+	}
+	return c.management(ctx, http.MethodDelete, queuePath(vhost, streamName), nil, nil)
+}
+
+// GetSuperStream returns a super stream's partitions in partition order
+func (c *Connection) GetSuperStream(ctx context.Context, vhost, name string) (*SuperStream, error) {
+	var exchange struct {
+		Arguments map[string]interface{} `json:"arguments"`
+	}
+	if err := c.management(ctx, http.MethodGet, exchangePath(vhost, name), nil, &exchange); err != nil {
+		return nil, err
+	}
+	if superStream, _ := exchange.Arguments["x-super-stream"].(bool); !superStream {
+		return nil, fmt.Errorf("%w: exchange %s is not a super stream", ErrStreamNotFound, name)
+	}
+
+	var bindings []struct {
+		Destination     string                 `json:"destination"`
+		DestinationType string                 `json:"destination_type"`
+		RoutingKey      string                 `json:"routing_key"`
+		Arguments       map[string]interface{} `json:"arguments"`
+	}
+	if err := c.management(ctx, http.MethodGet, exchangePath(vhost, name)+"/bindings/source", nil, &bindings); err != nil {
+		return nil, err
+	}
+
+	result := &SuperStream{Name: name, ConnectionID: c.ID, VHost: vhost, Partitions: []SuperStreamPartition{}}
+	for _, b := range bindings {
+		if b.DestinationType != "queue" {
+			continue
+		}
+		result.Partitions = append(result.Partitions, SuperStreamPartition{
+			Stream:     b.Destination,
+			BindingKey: b.RoutingKey,
+			Order:      int(intArgument(b.Arguments["x-stream-partition-order"])),
+		})
+	}
+	sort.Slice(result.Partitions, func(i, j int) bool {
+		return result.Partitions[i].Order < result.Partitions[j].Order
+	})
+	return result, nil
+}
+
+// DeclareSuperStream creates a super stream exchange and its partition streams.
+// Nothing is created when the exchange or any partition already exists, and
+// what was created is deleted again when a later step fails. The error names
+// anything that could not be deleted.
+func (c *Connection) DeclareSuperStream(ctx context.Context, vhost, name string, settings SuperStreamSettings) (*SuperStream, error) {
+	if c.Config.IsReadOnly() {
+		return nil, ErrReadOnly
+	}
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+
+	err := c.management(ctx, http.MethodGet, exchangePath(vhost, name), nil, nil)
+	if err == nil {
+		return nil, fmt.Errorf("%w: exchange %s", ErrStreamExists, name)
+	}
+	if !errors.Is(err, ErrStreamNotFound) {
+		return nil, err
+	}
+	partitions := settings.PartitionsOf(name)
+	for _, p := range partitions {
+		if err := c.ensureQueueAbsent(ctx, vhost, p.Stream); err != nil {
+			return nil, err
+		}
+	}
+
+	exchange := map[string]interface{}{
+		"type":        "direct",
+		"durable":     true,
+		"auto_delete": false,
+		"arguments":   map[string]interface{}{"x-super-stream": true},
+	}
+	if err := c.management(ctx, http.MethodPut, exchangePath(vhost, name), exchange, nil); err != nil {
+		return nil, fmt.Errorf("failed to declare super stream exchange %s: %w", name, err)
+	}
+	var created []string
+	for _, p := range partitions {
+		if err := c.declareStream(ctx, vhost, p.Stream, settings.StreamSettings); err != nil {
+			return nil, c.undeclareSuperStream(ctx, vhost, name, created, err)
+		}
+		created = append(created, p.Stream)
+		if err := c.bindPartition(ctx, vhost, name, p); err != nil {
+			return nil, c.undeclareSuperStream(ctx, vhost, name, created, err)
+		}
+	}
+
+	return &SuperStream{Name: name, ConnectionID: c.ID, VHost: vhost, Partitions: partitions}, nil
+}
+
+// undeclareSuperStream deletes the partitions and exchange of a super stream
+// whose declaration failed with cause, even when ctx was cancelled, and
+// returns cause along with anything left behind
+func (c *Connection) undeclareSuperStream(ctx context.Context, vhost, name string, partitions []string, cause error) error {
+	ctx = context.WithoutCancel(ctx)
+	var left []string
+	for _, stream := range partitions {
+		err := c.management(ctx, http.MethodDelete, queuePath(vhost, stream), nil, nil)
+		if err != nil && !errors.Is(err, ErrStreamNotFound) {
+			left = append(left, "stream "+stream)
+		}
+	}
+	// Deleting the exchange removes its bindings
+	err := c.management(ctx, http.MethodDelete, exchangePath(vhost, name), nil, nil)
+	if err != nil && !errors.Is(err, ErrStreamNotFound) {
+		left = append(left, "exchange "+name)
+	}
+	if len(left) > 0 {
+		return fmt.Errorf("%w (failed to remove %s)", cause, strings.Join(left, ", "))
+	}
+	return cause
+}
+
+// DeleteSuperStream deletes a super stream's partition streams and its exchange
+func (c *Connection) DeleteSuperStream(ctx context.Context, vhost, name string) error {
+	if c.Config.IsReadOnly() {
+		return ErrReadOnly
+	}
+	superStream, err := c.GetSuperStream(ctx, vhost, name)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range superStream.Partitions {
+		err := c.management(ctx, http.MethodDelete, queuePath(vhost, p.Stream), nil, nil)
+		if err != nil && !errors.Is(err, ErrStreamNotFound) {
+			return fmt.Errorf("failed to delete partition %s: %w", p.Stream, err)
+		}
+	}
+	return c.management(ctx, http.MethodDelete, exchangePath(vhost, name), nil, nil)
+}
+
+// ensureQueueAbsent returns ErrStreamExists when a queue or stream called name exists
+func (c *Connection) ensureQueueAbsent(ctx context.Context, vhost, name string) error {
+	err := c.management(ctx, http.MethodGet, queuePath(vhost, name), nil, nil)
+	if err == nil {
+		return fmt.Errorf("%w: %s", ErrStreamExists, name)
+	}
+	if errors.Is(err, ErrStreamNotFound) {
+		return nil
+	}
+	return err
+}
+
+// management sends a request to the management API. A JSON body is sent
+// when body is set and the response is decoded into out when it is set.
+// A 404 response is reported as ErrStreamNotFound and a refusal as ErrAccessDenied.
+func (c *Connection) management(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.Config.ManagementURL()+path, reader)
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to query management API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrStreamNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return managementError(resp)
+	}
+
+	if out == nil {
+		return nil
+	}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func queuePath(vhost, name string) string {
+	return fmt.Sprintf("/api/queues/%s/%s", url.PathEscape(vhost), url.PathEscape(name))
+}
+
+func exchangePath(vhost, name string) string {
+	return fmt.Sprintf("/api/exchanges/%s/%s", url.PathEscape(vhost), url.PathEscape(name))
+}
+
+// Retention returns the settings that can also be applied by a policy and
+// so can change after the stream was declared
+func (s StreamSettings) Retention() StreamSettings {
+	return StreamSettings{MaxLengthBytes: s.MaxLengthBytes, MaxAge: s.MaxAge, MaxSegmentSizeBytes: s.MaxSegmentSizeBytes}
+}
+
+// Placement returns the settings that only take effect when the stream is declared
+func (s StreamSettings) Placement() StreamSettings {
+	return StreamSettings{InitialClusterSize: s.InitialClusterSize, LeaderLocator: s.LeaderLocator}
+}
+
+// PolicyDefinition returns the policy keys applying the retention settings
+func (s StreamSettings) PolicyDefinition() map[string]interface{} {
+	definition := make(map[string]interface{})
+	if s.MaxLengthBytes > 0 {
+		definition["max-length-bytes"] = int64(s.MaxLengthBytes)
+	}
+	if s.MaxAge != "" {
+		definition["max-age"] = s.MaxAge
+	}
+	if s.MaxSegmentSizeBytes > 0 {
+		definition["stream-max-segment-size-bytes"] = int64(s.MaxSegmentSizeBytes)
+	}
+	return definition
+}
+
+// StreamSettingsFromPolicy reads the retention settings of a policy definition
+func StreamSettingsFromPolicy(definition map[string]interface{}) StreamSettings {
+	var s StreamSettings
+	s.MaxLengthBytes = ByteSize(intArgument(definition["max-length-bytes"]))
+	s.MaxAge, _ = definition["max-age"].(string)
+	s.MaxSegmentSizeBytes = ByteSize(intArgument(definition["stream-max-segment-size-bytes"]))
+	return s
+}
+
+// Policy is a management API policy
+type Policy struct {
+	Name       string                 `json:"name"`
+	Pattern    string                 `json:"pattern"`
+	ApplyTo    string                 `json:"apply-to"`
+	Priority   int                    `json:"priority"`
+	Definition map[string]interface{} `json:"definition"`
+}
+
+// GetPolicy returns a policy of a vhost
+func (c *Connection) GetPolicy(ctx context.Context, vhost, name string) (*Policy, error) {
+	var policy Policy
+	if err := c.management(ctx, http.MethodGet, policyPath(vhost, name), nil, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// ListPolicies returns the policies of a vhost
+func (c *Connection) ListPolicies(ctx context.Context, vhost string) ([]Policy, error) {
+	var policies []Policy
+	if err := c.management(ctx, http.MethodGet, "/api/policies/"+url.PathEscape(vhost), nil, &policies); err != nil {
+		return nil, fmt.Errorf("failed to list policies: %w", err)
+	}
+	return policies, nil
+}
+
+// PutPolicy creates or replaces a policy of a vhost
+func (c *Connection) PutPolicy(ctx context.Context, vhost string, policy Policy) error {
+	if c.Config.IsReadOnly() {
+		return ErrReadOnly
+	}
+	body := map[string]interface{}{
+		"pattern":    policy.Pattern,
+		"apply-to":   policy.ApplyTo,
+		"priority":   policy.Priority,
+		"definition": policy.Definition,
+	}
+	if err := c.management(ctx, http.MethodPut, policyPath(vhost, policy.Name), body, nil); err != nil {
+		return fmt.Errorf("failed to set policy %s: %w", policy.Name, err)
+	}
+	return nil
+}
+
+// DeletePolicy deletes a policy of a vhost
+func (c *Connection) DeletePolicy(ctx context.Context, vhost, name string) error {
+	if c.Config.IsReadOnly() {
+		return ErrReadOnly
+	}
+	return c.management(ctx, http.MethodDelete, policyPath(vhost, name), nil, nil)
+}
+
+// AddSuperStreamPartition declares a partition stream and binds it to an
+// existing super stream
+func (c *Connection) AddSuperStreamPartition(ctx context.Context, vhost, name string, partition SuperStreamPartition, settings StreamSettings) error {
+	if c.Config.IsReadOnly() {
+		return ErrReadOnly
+	}
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if err := c.declareStream(ctx, vhost, partition.Stream, settings); err != nil {
+		return err
+	}
+	return c.bindPartition(ctx, vhost, name, partition)
+}
+
+func (c *Connection) bindPartition(ctx context.Context, vhost, name string, p SuperStreamPartition) error {
+	binding := map[string]interface{}{
+		"routing_key": p.BindingKey,
+		"arguments":   map[string]interface{}{"x-stream-partition-order": p.Order},
+	}
+	path := fmt.Sprintf("/api/bindings/%s/e/%s/q/%s", url.PathEscape(vhost), url.PathEscape(name), url.PathEscape(p.Stream))
+	if err := c.management(ctx, http.MethodPost, path, binding, nil); err != nil {
+		return fmt.Errorf("failed to bind partition %s: %w", p.Stream, err)
+	}
+	return nil
+}
+
+// ReadOnly reports whether the connection refuses every write
+func (c *Connection) ReadOnly() bool {
+	return c.Config.IsReadOnly()
+}
+
+func policyPath(vhost, name string) string {
+	return fmt.Sprintf("/api/policies/%s/%s", url.PathEscape(vhost), url.PathEscape(name))
+}
@@ -3,32 +3,52 @@ package rabbitmq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"sync"
 	"time"
 
-	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
 	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/stream"
 	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/metrics"
 )
 
+// ErrMessageNotFound is returned when no message exists at the requested offset
+var ErrMessageNotFound = errors.New("message not found")
+
 // Manager manages multiple RabbitMQ connections
 type Manager struct {
 	connections map[string]*Connection
 	configs     []config.ConnectionConfig
 	mu          sync.RWMutex
+
+	// sessions hold the connections of users logged in with their own credentials
+	sessionsMu sync.Mutex
+	sessions   map[string]*Session
+	now        func() time.Time
 }
 
 // Connection represents a RabbitMQ connection
 type Connection struct {
-	ID          string
-	Name        string
-	Config      config.ConnectionConfig
+	ID     string
+	Name   string
+	Config config.ConnectionConfig
+	// Environment is nil for connections using their users' credentials
 	Environment *stream.Environment
 	httpClient  *http.Client
+	credentials Credentials
+}
+
+// Credentials authenticate calls to the broker
+type Credentials struct {
+	Username string
+	Password string
+	// Token is an OAuth 2 access token for the broker's OAuth 2 plugin. It is
+	// sent to the management API as a bearer token and to the stream
+	// protocol as the password.
+	Token string
 }
 
 // NewManager creates a new RabbitMQ connection manager
@@ -36,6 +56,8 @@ func NewManager(configs []config.ConnectionConfig) *Manager {
 	return &Manager{
 		connections: make(map[string]*Connection),
 		configs:     configs,
+		sessions:    make(map[string]*Session),
+		now:         time.Now,
 	}
 }
 
@@ -45,6 +67,13 @@ func (m *Manager) Connect(ctx context.Context) error {
 	defer m.mu.Unlock()
 
 	for _, cfg := range m.configs {
+		// Connections using their users' credentials share no environment;
+		// each session opens its own as the user
+		if cfg.UserCredentials() {
+			m.connections[cfg.ID] = NewConnection(cfg)
+			continue
+		}
+
 		vhost := cfg.VHost
 		if vhost == "" {
 			vhost = "/"
@@ -61,14 +90,10 @@ func (m *Manager) Connect(ctx context.Context) error {
 		if err != nil {
 			return fmt.Errorf("failed to create environment for %s: %w", cfg.ID, err)
 		}
+		metrics.EnvironmentsCreated.WithLabelValues(cfg.ID).Inc()
 
-		conn := &Connection{
-			ID:          cfg.ID,
-			Name:        cfg.Name,
-			Config:      cfg,
-			Environment: env,
-			httpClient:  &http.Client{Timeout: 10 * time.Second},
-		}
+		conn := NewConnection(cfg)
+		conn.Environment = env
 
 		m.connections[cfg.ID] = conn
 	}
@@ -76,6 +101,39 @@ func (m *Manager) Connect(ctx context.Context) error {
 	return nil
 }
 
+// NewConnection creates a connection for standalone tools. It does not open a
+// stream environment up front; reads and writes open one per vhost as needed.
+func NewConnection(cfg config.ConnectionConfig) *Connection {
+	return &Connection{
+		ID:          cfg.ID,
+		Name:        cfg.Name,
+		Config:      cfg,
+		credentials: Credentials{Username: cfg.Username, Password: cfg.Password},
+		httpClient: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: instrumentedTransport{connection: cfg.ID, next: http.DefaultTransport},
+		},
+	}
+}
+
+// WithCredentials returns a copy of the connection whose management API calls
+// and stream environments authenticate with other credentials
+func (c *Connection) WithCredentials(creds Credentials) *Connection {
+	conn := *c
+	conn.Environment = nil
+	conn.credentials = creds
+	return &conn
+}
+
+// authorize adds the connection's credentials to a management API request
+func (c *Connection) authorize(req *http.Request) {
+	if c.credentials.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.credentials.Token)
+		return
+	}
+	req.SetBasicAuth(c.credentials.Username, c.credentials.Password)
+}
+
 // Close closes all connections
 func (m *Manager) Close() error {
 	m.mu.Lock()
@@ -83,6 +141,9 @@ func (m *Manager) Close() error {
 
 	var errs []error
 	for _, conn := range m.connections {
+		if conn.Environment == nil {
+			continue
+		}
 		if err := conn.Environment.Close(); err != nil {
 			errs = append(errs, err)
 		}
@@ -113,14 +174,44 @@ func (m *Manager) ListConnections() []config.ConnectionConfig {
 	return m.configs
 }
 
-// ListVHosts returns all vhosts across all connections with their streams
+// DefaultVHost returns the connection's configured vhost, falling back to "/"
+func (c *Connection) DefaultVHost() string {
+	if c.Config.VHost == "" {
+		return "/"
+	}
+	return c.Config.VHost
+}
+
+// ListVHosts returns all vhosts across all connections with their streams.
+// Connections using their users' credentials are listed with their username
+// and password, and skipped when they have none.
 func (m *Manager) ListVHosts(ctx context.Context) ([]VHost, error) {
+	return listVHosts(ctx, m.serviceConnections())
+}
+
+// ListStreams returns all streams across all connections, like ListVHosts
+func (m *Manager) ListStreams(ctx context.Context) ([]Stream, error) {
+	return listStreams(ctx, m.serviceConnections())
+}
+
+// serviceConnections returns the connections that can be used without a user
+func (m *Manager) serviceConnections() []*Connection {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	var connections []*Connection
+	for _, conn := range m.connections {
+		if conn.Config.HasServiceAccount() {
+			connections = append(connections, conn)
+		}
+	}
+	return connections
+}
+
+func listVHosts(ctx context.Context, connections []*Connection) ([]VHost, error) {
 	var allVHosts []VHost
 
-	for _, conn := range m.connections {
+	for _, conn := range connections {
 		vhosts, err := conn.ListVHosts(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to list vhosts for %s: %w", conn.ID, err)
@@ -131,14 +222,10 @@ func (m *Manager) ListVHosts(ctx context.Context) ([]VHost, error) {
 	return allVHosts, nil
 }
 
-// ListStreams returns all streams across all connections
-func (m *Manager) ListStreams(ctx context.Context) ([]Stream, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
+func listStreams(ctx context.Context, connections []*Connection) ([]Stream, error) {
 	var allStreams []Stream
 
-	for _, conn := range m.connections {
+	for _, conn := range connections {
 		streams, err := conn.ListStreams(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to list streams for %s: %w", conn.ID, err)
@@ -159,7 +246,7 @@ func (c *Connection) ListVHosts(ctx context.Context) ([]VHost, error) {
 		return nil, err
 	}
 	
-	req.SetBasicAuth(c.Config.Username, c.Config.Password)
+	c.authorize(req)
 	
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -168,8 +255,7 @@ func (c *Connection) ListVHosts(ctx context.Context) ([]VHost, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("management API returned %d: %s", resp.StatusCode, string(body))
+		return nil, managementError(resp)
 	}
 
 	var apiVHosts []struct {
@@ -207,7 +293,7 @@ func (c *Connection) ListStreamsInVHost(ctx context.Context, vhost string) ([]St
 		return nil, err
 	}
 	
-	req.SetBasicAuth(c.Config.Username, c.Config.Password)
+	c.authorize(req)
 	
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -216,8 +302,7 @@ func (c *Connection) ListStreamsInVHost(ctx context.Context, vhost string) ([]St
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("management API returned %d: %s", resp.StatusCode, string(body))
+		return nil, managementError(resp)
 	}
 
 	var apiQueues []struct {
@@ -259,7 +344,7 @@ func (c *Connection) ListStreams(ctx context.Context) ([]Stream, error) {
 		return nil, err
 	}
 	
-	req.SetBasicAuth(c.Config.Username, c.Config.Password)
+	c.authorize(req)
 	
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -268,8 +353,7 @@ func (c *Connection) ListStreams(ctx context.Context) ([]Stream, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("management API returned %d: %s", resp.StatusCode, string(body))
+		return nil, managementError(resp)
 	}
 
 	var apiQueues []struct {
@@ -306,334 +390,91 @@ func (c *Connection) GetStreamStats(ctx context.Context, streamName string) (*St
 	return c.GetStreamStatsForVHost(ctx, vhost, streamName)
 }
 
-// GetStreamStatsForVHost returns statistics for a stream in a specific vhost
+// GetStreamStatsForVHost returns statistics for a stream in a specific vhost.
+// Size is the message_bytes the broker reports for the stream; streams have
+// no backing_queue_status, so it stays 0 on brokers that don't report it.
+// Offsets that can't be read over the stream protocol fail the call rather
+// than read as 0, which samples and alerts would take for a truncation.
 func (c *Connection) GetStreamStatsForVHost(ctx context.Context, vhost, streamName string) (*StreamStats, error) {
-	// Query stream metadata (streams are queues with type="stream")
-	apiURL := fmt.Sprintf("%s/api/queues/%s/%s", 
-		c.Config.ManagementURL(), 
-		url.PathEscape(vhost), 
-		url.PathEscape(streamName))
-	
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	info, err := c.getQueueInfo(ctx, vhost, streamName)
 	if err != nil {
 		return nil, err
 	}
-	
-	req.SetBasicAuth(c.Config.Username, c.Config.Password)
-	
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query management API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("management API returned %d: %s", resp.StatusCode, string(body))
-	}
-
-	var streamInfo struct {
-		Name      string `json:"name"`
-		Messages  int64  `json:"messages"`
-		Backing   struct {
-			Size int64 `json:"size"`
-		} `json:"backing_queue_status"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&streamInfo); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
-	}
 
 	// Get first and last offset using stream protocol
 	firstOffset, lastOffset, err := c.getStreamOffsetsForVHost(vhost, streamName)
 	if err != nil {
-		// If we can't get offsets, return what we have
-		firstOffset = 0
-		lastOffset = 0
+		return nil, fmt.Errorf("failed to read offsets: %w", err)
+	}
+
+	var size int64
+	if info.MessageBytes != nil {
+		size = *info.MessageBytes
 	}
 
 	return &StreamStats{
 		Name:         streamName,
-		MessageCount: streamInfo.Messages,
-		Size:         streamInfo.Backing.Size,
+		MessageCount: info.Messages,
+		Size:         size,
 		FirstOffset:  firstOffset,
 		LastOffset:   lastOffset,
 	}, nil
 }
 
-// getStreamOffsets retrieves the first and last offsets for a stream (using connection's default vhost)
-func (c *Connection) getStreamOffsets(streamName string) (uint64, uint64, error) {
-	// Query first offset
-	firstOffset, err := c.Environment.QueryOffset(streamName, "first")
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to query first offset: %w", err)
-	}
-
-	// Query last offset
-	lastOffset, err := c.Environment.QueryOffset(streamName, "last")
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to query last offset: %w", err)
-	}
-
-	return uint64(firstOffset), uint64(lastOffset), nil
-}
-
-// getStreamOffsetsForVHost retrieves the first and last offsets for a stream in a specific vhost
-func (c *Connection) getStreamOffsetsForVHost(vhost, streamName string) (uint64, uint64, error) {
-	// Create a new environment for this vhost
+// newEnvironment creates a stream environment bound to a specific vhost
+func (c *Connection) newEnvironment(vhost string) (*stream.Environment, error) {
 	env, err := stream.NewEnvironment(
 		stream.NewEnvironmentOptions().
 			SetHost(c.Config.Host).
 			SetPort(c.Config.StreamPort).
-			SetUser(c.Config.Username).
-			SetPassword(c.Config.Password).
+			SetUser(c.credentials.Username).
+			SetPassword(c.credentials.streamPassword()).
 			SetVHost(vhost),
 	)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to create environment for vhost %s: %w", vhost, err)
+		return nil, fmt.Errorf("failed to create environment for vhost %s: %w", vhost, err)
 	}
-	defer env.Close()
+	metrics.EnvironmentsCreated.WithLabelValues(c.ID).Inc()
+	return env, nil
+}
 
-	// Query first offset
-	firstOffset, err := env.QueryOffset(streamName, "first")
+// getStreamOffsetsForVHost retrieves the first and last offsets for a stream in a specific vhost
+func (c *Connection) getStreamOffsetsForVHost(vhost, streamName string) (uint64, uint64, error) {
+	env, err := c.newEnvironment(vhost)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to query first offset: %w", err)
+		return 0, 0, err
 	}
+	defer env.Close()
 
-	// Query last offset
-	lastOffset, err := env.QueryOffset(streamName, "last")
+	first, committed, err := streamBounds(env, streamName)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to query last offset: %w", err)
+		return 0, 0, err
 	}
-
-	return uint64(firstOffset), uint64(lastOffset), nil
-}
-
-// ReadMessages reads messages from a stream starting at the given offset (using connection's default vhost)
-func (c *Connection) ReadMessages(ctx context.Context, streamName string, offset uint64, limit int) (*MessageBatch, error) {
-	vhost := c.Config.VHost
-	if vhost == "" {
-		vhost = "/"
+	if first < 0 {
+		return 0, 0, nil
 	}
-	return c.ReadMessagesFromVHost(ctx, vhost, streamName, offset, limit)
-}
 
-// ReadMessagesFromVHost reads messages from a stream in a specific vhost starting at the given offset
-func (c *Connection) ReadMessagesFromVHost(ctx context.Context, vhost, streamName string, offset uint64, limit int) (*MessageBatch, error) {
-	if limit <= 0 {
-		limit = 10
-	}
-	if limit > 500 {
-		limit = 500
-	}
+	return uint64(first), uint64(committed), nil
+}
 
-	// Create a new environment for this vhost
-	env, err := stream.NewEnvironment(
-		stream.NewEnvironmentOptions().
-			SetHost(c.Config.Host).
-			SetPort(c.Config.StreamPort).
-			SetUser(c.Config.Username).
-			SetPassword(c.Config.Password).
-			SetVHost(vhost),
-	)
+// streamBounds returns the first offset and committed chunk ID of a stream.
+// The committed chunk ID is the offset of the first message in the last chunk
+// confirmed by the stream members; both are -1 when the stream is empty.
+func streamBounds(env *stream.Environment, streamName string) (int64, int64, error) {
+	stats, err := env.StreamStats(streamName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create environment for vhost %s: %w", vhost, err)
+		return 0, 0, fmt.Errorf("failed to query stream stats: %w", err)
 	}
-	defer env.Close()
-
-	messages := make([]Message, 0, limit)
-	mu := sync.Mutex{}
-	done := make(chan bool)
-	errChan := make(chan error, 1)
-
-	consumer, err := env.NewConsumer(
-		streamName,
-		func(consumerContext stream.ConsumerContext, message *amqp.Message) {
-			mu.Lock()
-			defer mu.Unlock()
-
-			if len(messages) >= limit {
-				return
-			}
-
-			// Extract AMQP properties
-			props := make(map[string]interface{})
-			
-			// AMQP Message Properties
-			if message.Properties != nil {
-				if message.Properties.MessageID != nil {
-					props["message_id"] = message.Properties.MessageID
-				}
-				if message.Properties.CorrelationID != nil {
-					props["correlation_id"] = message.Properties.CorrelationID
-				}
-				if message.Properties.ContentType != "" {
-					props["content_type"] = message.Properties.ContentType
-				}
-				if message.Properties.ContentEncoding != "" {
-					props["content_encoding"] = message.Properties.ContentEncoding
-				}
-				if message.Properties.ReplyTo != "" {
-					props["reply_to"] = message.Properties.ReplyTo
-				}
-				if message.Properties.Subject != "" {
-					props["subject"] = message.Properties.Subject
-				}
-				if message.Properties.To != "" {
-					props["to"] = message.Properties.To
-				}
-				if len(message.Properties.UserID) > 0 {
-					props["user_id"] = string(message.Properties.UserID)
-				}
-				if message.Properties.GroupID != "" {
-					props["group_id"] = message.Properties.GroupID
-				}
-				if message.Properties.ReplyToGroupID != "" {
-					props["reply_to_group_id"] = message.Properties.ReplyToGroupID
-				}
-				if message.Properties.GroupSequence != 0 {
-					props["group_sequence"] = message.Properties.GroupSequence
-				}
-				if !message.Properties.CreationTime.IsZero() {
-					props["creation_time"] = message.Properties.CreationTime
-				}
-				if !message.Properties.AbsoluteExpiryTime.IsZero() {
-					props["absolute_expiry_time"] = message.Properties.AbsoluteExpiryTime
-				}
-			}
-
-			// AMQP Header (message header fields)
-			if message.Header != nil {
-				if message.Header.Durable {
-					props["durable"] = message.Header.Durable
-				}
-				if message.Header.Priority != 0 {
-					props["priority"] = message.Header.Priority
-				}
-				if message.Header.TTL != 0 {
-					props["ttl"] = message.Header.TTL
-				}
-				if message.Header.FirstAcquirer {
-					props["first_acquirer"] = message.Header.FirstAcquirer
-				}
-				if message.Header.DeliveryCount != 0 {
-					props["delivery_count"] = message.Header.DeliveryCount
-				}
-			}
-
-			// Message Annotations (used by brokers and infrastructure)
-			if message.Annotations != nil && len(message.Annotations) > 0 {
-				annotations := make(map[string]interface{})
-				for k, v := range message.Annotations {
-					keyStr := fmt.Sprintf("%v", k)
-					annotations[keyStr] = v
-					
-					// Extract routing key if present in annotations
-					if keyStr == "x-routing-key" || keyStr == "routing-key" {
-						props["routing_key"] = v
-					}
-				}
-				props["message_annotations"] = annotations
-			}
-
-			// Delivery Annotations (used by delivery infrastructure)
-			if message.DeliveryAnnotations != nil && len(message.DeliveryAnnotations) > 0 {
-				deliveryAnnotations := make(map[string]interface{})
-				for k, v := range message.DeliveryAnnotations {
-					keyStr := fmt.Sprintf("%v", k)
-					deliveryAnnotations[keyStr] = v
-					
-					// Extract routing key if present in delivery annotations
-					if keyStr == "x-routing-key" || keyStr == "routing-key" {
-						props["routing_key"] = v
-					}
-				}
-				props["delivery_annotations"] = deliveryAnnotations
-			}
-
-			// Application Properties (custom key-value pairs set by the application)
-			if message.ApplicationProperties != nil {
-				appProps := make(map[string]interface{})
-				for k, v := range message.ApplicationProperties {
-					appProps[k] = v
-				}
-				if len(appProps) > 0 {
-					props["application_properties"] = appProps
-				}
-			}
-
-			// Footer (used for signatures, checksums, etc.)
-			if message.Footer != nil && len(message.Footer) > 0 {
-				footer := make(map[string]interface{})
-				for k, v := range message.Footer {
-					footer[fmt.Sprintf("%v", k)] = v
-				}
-				props["footer"] = footer
-			}
-
-		// Use creation time if available, otherwise use current time
-		timestamp := time.Now()
-		if message.Properties != nil && !message.Properties.CreationTime.IsZero() {
-			timestamp = message.Properties.CreationTime
-		}
-
-		msg := Message{
-			Offset:     uint64(consumerContext.Consumer.GetOffset()),
-			Timestamp:  timestamp,
-			Data:       message.GetData(),
-			Properties: props,
-		}
-
-			messages = append(messages, msg)
-
-			if len(messages) >= limit {
-				select {
-				case done <- true:
-				default:
-				}
-			}
-		},
-		stream.NewConsumerOptions().
-			SetOffset(stream.OffsetSpecification{}.Offset(int64(offset))),
-	)
 
+	first, err := stats.FirstOffset()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create consumer: %w", err)
+		return -1, -1, nil
 	}
 
-	// Wait for messages or timeout
-	select {
-	case <-done:
-		// Got all messages
-	case err := <-errChan:
-		consumer.Close()
-		return nil, err
-	case <-time.After(5 * time.Second):
-		// Timeout - return what we have
-	case <-ctx.Done():
-		consumer.Close()
-		return nil, ctx.Err()
-	}
-
-	consumer.Close()
-
-	mu.Lock()
-	defer mu.Unlock()
-
-	if len(messages) == 0 {
-		return &MessageBatch{
-			Messages:     []Message{},
-			StartOffset:  offset,
-			EndOffset:    offset,
-			HasMore:      false,
-		}, nil
+	committed, err := stats.CommittedChunkId()
+	if err != nil {
+		return -1, -1, nil
 	}
 
-	return &MessageBatch{
-		Messages:     messages,
-		StartOffset:  messages[0].Offset,
-		EndOffset:    messages[len(messages)-1].Offset,
-		HasMore:      len(messages) == limit,
-	}, nil
+	return first, committed, nil
 }
-
@@ -1,9 +1,25 @@
 package rabbitmq
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/stream"
 
 	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/metrics"
 )
 
 func TestNewManager(t *testing.T) {
@@ -207,3 +223,870 @@ func TestMessage(t *testing.T) {
 	}
 }
 
+
+func TestNewMessage_CreationTime(t *testing.T) {
+	msg := NewMessage(7, &amqp.Message{Data: [][]byte{[]byte("no properties")}})
+	if msg.CreationTime != nil {
+		t.Errorf("Expected no creation time, got %v", msg.CreationTime)
+	}
+
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	amqpMsg := &amqp.Message{Data: [][]byte{[]byte("with properties")}}
+	amqpMsg.Properties = &amqp.MessageProperties{CreationTime: created}
+
+	msg = NewMessage(8, amqpMsg)
+	if msg.CreationTime == nil || !msg.CreationTime.Equal(created) {
+		t.Fatalf("Expected creation time %v, got %v", created, msg.CreationTime)
+	}
+	if msg.Properties["creation_time"] != created {
+		t.Errorf("Expected creation_time property %v, got %v", created, msg.Properties["creation_time"])
+	}
+}
+
+func TestMessage_SetStoredAt(t *testing.T) {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	msg := Message{Offset: 1, CreationTime: &created}
+
+	msg.SetStoredAt(created.Add(250 * time.Millisecond))
+
+	if msg.StoredAt == nil {
+		t.Fatal("Expected StoredAt to be set")
+	}
+	if msg.PublishLatencyMs == nil || *msg.PublishLatencyMs != 250 {
+		t.Errorf("Expected publish latency 250ms, got %v", msg.PublishLatencyMs)
+	}
+
+	noCreation := Message{Offset: 2}
+	noCreation.SetStoredAt(created)
+	if noCreation.PublishLatencyMs != nil {
+		t.Errorf("Expected no publish latency without creation time, got %v", *noCreation.PublishLatencyMs)
+	}
+}
+
+// fakeScanner returns a scanner over messages at offsets 0 to n-1 whose last
+// committed chunk starts at committedChunk
+func fakeScanner(n int, committedChunk int64, idleTimeout time.Duration) *scanner {
+	return &scanner{
+		committedChunk: committedChunk,
+		idleTimeout:    idleTimeout,
+		subscribe: func(_ stream.OffsetSpecification, handle func(Message)) (func() error, error) {
+			quit := make(chan struct{})
+			go func() {
+				for i := 0; i < n; i++ {
+					select {
+					case <-quit:
+						return
+					default:
+					}
+					handle(Message{Offset: uint64(i)})
+				}
+			}()
+			return func() error { close(quit); return nil }, nil
+		},
+	}
+}
+
+func TestStream_SlowVisitor(t *testing.T) {
+	// The visitor blocks longer than both the end-of-stream grace and the idle
+	// timeout, as when writing to a client reading slowly
+	s := fakeScanner(10, 5, 2*endOfStreamGrace)
+	var offsets []uint64
+	reason, err := s.stream(context.Background(), StreamOptions{}, func(msg Message) error {
+		if msg.Offset == 2 || msg.Offset == 7 {
+			time.Sleep(3 * endOfStreamGrace)
+		}
+		offsets = append(offsets, msg.Offset)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != EndReasonEndOfStream || len(offsets) != 10 {
+		t.Errorf("Expected all 10 messages and end_of_stream, got %v (%s)", offsets, reason)
+	}
+}
+
+func TestStream_EndOffset(t *testing.T) {
+	end := uint64(6)
+	var offsets []uint64
+	reason, err := fakeScanner(10, 8, time.Second).stream(context.Background(), StreamOptions{EndOffset: &end}, func(msg Message) error {
+		offsets = append(offsets, msg.Offset)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != EndReasonEndOffset || len(offsets) != 7 || offsets[6] != end {
+		t.Errorf("Expected offsets 0 to 6 and end_offset, got %v (%s)", offsets, reason)
+	}
+
+	reason, _ = fakeScanner(10, 8, time.Second).stream(context.Background(), StreamOptions{Count: 3, EndOffset: &end}, func(Message) error { return nil })
+	if reason != EndReasonLimit {
+		t.Errorf("Expected the count to end the read first, got %s", reason)
+	}
+}
+
+func TestCursor_RoundTrip(t *testing.T) {
+	for _, cursor := range []Cursor{{Offset: 0}, {Offset: 12345, Backward: true}, {Offset: OffsetEnd, Backward: true}} {
+		decoded, err := DecodeCursor(cursor.Encode())
+		if err != nil {
+			t.Fatalf("DecodeCursor(%+v) failed: %v", cursor, err)
+		}
+		if decoded != cursor {
+			t.Errorf("Expected %+v, got %+v", cursor, decoded)
+		}
+	}
+
+	if _, err := DecodeCursor("not a cursor!"); err == nil {
+		t.Error("Expected error decoding invalid cursor, got nil")
+	}
+}
+
+func TestMessageBatch_SetCursors(t *testing.T) {
+	batch := &MessageBatch{
+		Messages:    []Message{{Offset: 100}, {Offset: 101}},
+		StartOffset: 100,
+		EndOffset:   101,
+	}
+	batch.setCursors()
+
+	next, err := DecodeCursor(batch.NextCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Offset != 102 || next.Backward {
+		t.Errorf("Expected forward next cursor at 102, got %+v", next)
+	}
+
+	prev, err := DecodeCursor(batch.PrevCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev.Offset != 100 || !prev.Backward {
+		t.Errorf("Expected backward prev cursor at 100, got %+v", prev)
+	}
+
+	atStart := &MessageBatch{Messages: []Message{{Offset: 0}}, AtStart: true}
+	atStart.setCursors()
+	if atStart.PrevCursor != "" {
+		t.Errorf("Expected no prev cursor at the start of the stream, got %q", atStart.PrevCursor)
+	}
+}
+
+func TestExportedMessage_RoundTrip(t *testing.T) {
+	created := time.Date(2025, 10, 16, 12, 0, 0, 0, time.UTC)
+	messageID := amqp.UUID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	original := &amqp.Message{
+		Data:   [][]byte{[]byte(`{"id":1}`)},
+		Header: &amqp.MessageHeader{Durable: true, Priority: 7, TTL: 30 * time.Second},
+		Properties: &amqp.MessageProperties{
+			MessageID:     messageID,
+			CorrelationID: uint64(42),
+			ContentType:   "application/json",
+			CreationTime:  created,
+			Subject:       "orders.created",
+		},
+		ApplicationProperties: map[string]interface{}{"counter": int64(9), "ratio": 0.5, "tenant": "acme"},
+		Annotations:           amqp.Annotations{"x-routing-key": "orders"},
+		Footer:                amqp.Annotations{"x-checksum": "abc"},
+	}
+
+	msg := NewMessage(5, original)
+	stored := created.Add(time.Second)
+	msg.SetStoredAt(stored)
+	line, err := json.Marshal(msg.Export())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := DecodeExportedMessage(line)
+	if err != nil {
+		t.Fatalf("DecodeExportedMessage failed: %v", err)
+	}
+	restored := exported.AMQPMessage()
+
+	// The broker timestamp takes precedence over the creation time
+	if timestamp, ok := exported.Timestamp(); !ok || !timestamp.Equal(stored) {
+		t.Errorf("expected the stored_at timestamp %v, got %v", stored, timestamp)
+	}
+
+	if exported.Offset != 5 || string(restored.GetData()) != `{"id":1}` {
+		t.Errorf("unexpected offset or body: %d %q", exported.Offset, restored.GetData())
+	}
+	if restored.Header == nil || !restored.Header.Durable || restored.Header.Priority != 7 || restored.Header.TTL != 30*time.Second {
+		t.Errorf("header not restored: %+v", restored.Header)
+	}
+	p := restored.Properties
+	if p == nil || p.MessageID != messageID || p.CorrelationID != uint64(42) || p.Subject != "orders.created" || !p.CreationTime.Equal(created) {
+		t.Errorf("properties not restored: %+v", p)
+	}
+	if !strings.Contains(string(line), `"message_id":{"uuid":"01020304-0506-0708-090a-0b0c0d0e0f10"}`) {
+		t.Errorf("expected the UUID to be exported as such: %s", line)
+	}
+	if restored.ApplicationProperties["counter"] != int64(9) || restored.ApplicationProperties["ratio"] != 0.5 || restored.ApplicationProperties["tenant"] != "acme" {
+		t.Errorf("application properties not restored: %v", restored.ApplicationProperties)
+	}
+	if restored.Annotations["x-routing-key"] != "orders" || restored.Footer["x-checksum"] != "abc" {
+		t.Errorf("annotations not restored: %v %v", restored.Annotations, restored.Footer)
+	}
+
+	// The restored message must encode as valid AMQP
+	if _, err := (&outgoingMessage{message: restored}).MarshalBinary(); err != nil {
+		t.Errorf("restored message does not encode: %v", err)
+	}
+
+	// Binary IDs stay binary rather than becoming base64 strings
+	binary := &amqp.Message{Data: [][]byte{nil}, Properties: &amqp.MessageProperties{MessageID: []byte{0, 1, 0xff}, CorrelationID: "c-1"}}
+	line, err = json.Marshal(NewMessage(6, binary).Export())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err = DecodeExportedMessage(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = exported.AMQPMessage().Properties
+	if id, ok := p.MessageID.([]byte); !ok || string(id) != "\x00\x01\xff" || p.CorrelationID != "c-1" {
+		t.Errorf("expected a binary message ID and a string correlation ID, got %#v, %#v", p.MessageID, p.CorrelationID)
+	}
+
+	// UUIDs of older exports are arrays of bytes
+	exported, err = DecodeExportedMessage([]byte(`{"offset":7,"properties":{"message_id":[1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16]},"body":""}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := exported.AMQPMessage().Properties.MessageID; id != messageID {
+		t.Errorf("expected the UUID of an older export, got %#v", id)
+	}
+}
+
+func TestExportedMessage_TypedValues(t *testing.T) {
+	created := time.Date(2025, 10, 16, 12, 0, 0, 123000000, time.UTC)
+	original := &amqp.Message{
+		Data: [][]byte{[]byte("x")},
+		ApplicationProperties: map[string]interface{}{
+			"blob":    []byte{0, 1, 0xff},
+			"seen":    created,
+			"count":   uint32(7),
+			"flags":   uint8(3),
+			"level":   int16(-2),
+			"weight":  2.0,
+			"nested":  map[string]interface{}{"at": created},
+			"counter": int64(9),
+		},
+		Annotations: amqp.Annotations{"x-opt-delivery-time": created, "x-opt-partition": uint64(4)},
+	}
+
+	line, err := json.Marshal(NewMessage(1, original).Export())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := DecodeExportedMessage(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := exported.AMQPMessage()
+
+	app := restored.ApplicationProperties
+	if blob, ok := app["blob"].([]byte); !ok || string(blob) != "\x00\x01\xff" {
+		t.Errorf("expected a binary property, got %#v", app["blob"])
+	}
+	if seen, ok := app["seen"].(time.Time); !ok || !seen.Equal(created) {
+		t.Errorf("expected a timestamp property, got %#v", app["seen"])
+	}
+	if app["count"] != uint32(7) || app["flags"] != uint8(3) || app["level"] != int16(-2) || app["weight"] != 2.0 || app["counter"] != int64(9) {
+		t.Errorf("expected the numeric types to be kept, got %#v", app)
+	}
+	if nested, ok := app["nested"].(map[string]interface{}); !ok || nested["at"] != created {
+		t.Errorf("expected nested values to be restored, got %#v", app["nested"])
+	}
+	if at, ok := restored.Annotations["x-opt-delivery-time"].(time.Time); !ok || !at.Equal(created) || restored.Annotations["x-opt-partition"] != uint64(4) {
+		t.Errorf("expected typed annotations, got %#v", restored.Annotations)
+	}
+	if !strings.Contains(string(line), `"count":{"uint":7}`) {
+		t.Errorf("expected the uint to be tagged: %s", line)
+	}
+
+	if _, err := (&outgoingMessage{message: restored}).MarshalBinary(); err != nil {
+		t.Errorf("restored message does not encode: %v", err)
+	}
+}
+
+func TestPublish_ReadOnly(t *testing.T) {
+	conn := NewConnection(config.ConnectionConfig{ID: "prod", Host: "localhost"})
+
+	if _, err := conn.NewPublisher("/", "orders", PublisherOptions{}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("NewPublisher: expected ErrReadOnly by default, got %v", err)
+	}
+	if _, err := conn.PublishMessages(context.Background(), "/", "orders", nil); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("PublishMessages: expected ErrReadOnly by default, got %v", err)
+	}
+	if _, err := conn.EnsureStream("/", "orders", nil); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("EnsureStream: expected ErrReadOnly by default, got %v", err)
+	}
+	if err := conn.DeclareStream(context.Background(), "/", "orders", StreamSettings{}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("DeclareStream: expected ErrReadOnly by default, got %v", err)
+	}
+	if err := conn.DeleteStream(context.Background(), "/", "orders"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("DeleteStream: expected ErrReadOnly by default, got %v", err)
+	}
+	if _, err := conn.DeclareSuperStream(context.Background(), "/", "orders", SuperStreamSettings{Partitions: 3}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("DeclareSuperStream: expected ErrReadOnly by default, got %v", err)
+	}
+}
+
+// fakeManagement is a minimal management API keeping queues, exchanges and
+// bindings in memory, keyed by their escaped resource path. lists holds
+// read-only collections such as the stream plugin's publishers.
+type fakeManagement struct {
+	mu        sync.Mutex
+	resources map[string]map[string]interface{}
+	bindings  map[string][]map[string]interface{}
+	lists     map[string]string
+	// fail answers requests with the method and path, such as "POST /api/bindings/...", with 500
+	fail map[string]bool
+}
+
+func newFakeManagement(t *testing.T) (*fakeManagement, *Connection) {
+	fake := &fakeManagement{
+		resources: make(map[string]map[string]interface{}),
+		bindings:  make(map[string][]map[string]interface{}),
+		lists:     make(map[string]string),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	writable := false
+	conn := NewConnection(config.ConnectionConfig{ID: "dev", Host: u.Hostname(), HTTPPort: port, ReadOnly: &writable})
+	return fake, conn
+}
+
+func (f *fakeManagement) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := r.URL.EscapedPath()
+	if f.fail[r.Method+" "+path] {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var body map[string]interface{}
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	// POST /api/bindings/{vhost}/e/{exchange}/q/{queue}
+	if r.Method == http.MethodPost && strings.HasPrefix(path, "/api/bindings/") {
+		parts := strings.Split(strings.TrimPrefix(path, "/api/bindings/"), "/")
+		exchange := "/api/exchanges/" + parts[0] + "/" + parts[2]
+		queue, _ := url.PathUnescape(parts[4])
+		body["destination"] = queue
+		body["destination_type"] = "queue"
+		f.bindings[exchange] = append(f.bindings[exchange], body)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if exchange, ok := strings.CutSuffix(path, "/bindings/source"); ok {
+		json.NewEncoder(w).Encode(f.bindings[exchange])
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if strings.HasPrefix(path, "/api/queues/") {
+			body["type"] = "stream"
+		}
+		f.resources[path] = body
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		if list, ok := f.lists[path]; ok {
+			w.Write([]byte(list))
+			return
+		}
+		resource, ok := f.resources[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(resource)
+	case http.MethodDelete:
+		if _, ok := f.resources[path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.resources, path)
+		delete(f.bindings, path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestGetStreamStats_OffsetsUnavailable(t *testing.T) {
+	fake, conn := newFakeManagement(t)
+	fake.resources["/api/queues/%2F/orders"] = map[string]interface{}{"type": "stream", "messages": 900}
+
+	// Nothing listens on the stream port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Config.Host = "127.0.0.1"
+	conn.Config.StreamPort = ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	if stats, err := conn.GetStreamStatsForVHost(context.Background(), "/", "orders"); err == nil {
+		t.Errorf("expected an error instead of zero offsets, got %+v", stats)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]ByteSize{
+		"0":      0,
+		"1024":   1024,
+		"500b":   500,
+		"20gb":   20_000_000_000,
+		"100 MB": 100_000_000,
+		"1tb":    1_000_000_000_000,
+	}
+	for input, want := range tests {
+		got, err := ParseByteSize(input)
+		if err != nil || got != want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "gb", "-1", "10xb", "9223372036854775807kb", "10000000tb"} {
+		if _, err := ParseByteSize(input); err == nil {
+			t.Errorf("ParseByteSize(%q): expected an error", input)
+		}
+	}
+
+	var settings StreamSettings
+	if err := json.Unmarshal([]byte(`{"max_length_bytes": "2gb", "max_segment_size_bytes": 500000000}`), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.MaxLengthBytes != 2_000_000_000 || settings.MaxSegmentSizeBytes != 500_000_000 {
+		t.Errorf("unexpected settings: %+v", settings)
+	}
+}
+
+func TestStreamSettings_Validate(t *testing.T) {
+	valid := StreamSettings{MaxLengthBytes: 1000, MaxAge: "7D", InitialClusterSize: 3, LeaderLocator: "balanced"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected valid settings, got %v", err)
+	}
+	for _, invalid := range []StreamSettings{
+		{MaxAge: "7 days"},
+		{LeaderLocator: "nearest"},
+		{InitialClusterSize: -1},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", invalid)
+		}
+	}
+}
+
+func TestDeclareAndDeleteStream(t *testing.T) {
+	ctx := context.Background()
+	_, conn := newFakeManagement(t)
+
+	settings := StreamSettings{MaxLengthBytes: 2_000_000_000, MaxAge: "7D", MaxSegmentSizeBytes: 100_000_000, InitialClusterSize: 3, LeaderLocator: "balanced"}
+	if err := conn.DeclareStream(ctx, "/", "orders", settings); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.DeclareStream(ctx, "/", "orders", settings); !errors.Is(err, ErrStreamExists) {
+		t.Errorf("expected ErrStreamExists, got %v", err)
+	}
+
+	got, err := conn.GetStreamSettings(ctx, "/", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != settings {
+		t.Errorf("settings were not read back: got %+v, want %+v", *got, settings)
+	}
+
+	if err := conn.DeleteStream(ctx, "/", "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.DeleteStream(ctx, "/", "orders"); !errors.Is(err, ErrStreamNotFound) {
+		t.Errorf("expected ErrStreamNotFound, got %v", err)
+	}
+}
+
+func TestDeclareSuperStream(t *testing.T) {
+	ctx := context.Background()
+	fake, conn := newFakeManagement(t)
+
+	if _, err := conn.DeclareSuperStream(ctx, "/", "invoices", SuperStreamSettings{}); err == nil {
+		t.Error("expected an error without partitions or binding keys")
+	}
+
+	created, err := conn.DeclareSuperStream(ctx, "/", "invoices", SuperStreamSettings{
+		BindingKeys:    []string{"eu", "us", "apac"},
+		StreamSettings: StreamSettings{MaxAge: "30D"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created.Partitions) != 3 || created.Partitions[2].Stream != "invoices-apac" {
+		t.Errorf("unexpected partitions: %+v", created.Partitions)
+	}
+
+	superStream, err := conn.GetSuperStream(ctx, "/", "invoices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"eu", "us", "apac"} {
+		p := superStream.Partitions[i]
+		if p.BindingKey != key || p.Order != i || p.Stream != "invoices-"+key {
+			t.Errorf("partition %d: unexpected %+v", i, p)
+		}
+	}
+	partition, err := conn.GetStreamSettings(ctx, "/", "invoices-eu")
+	if err != nil || partition.MaxAge != "30D" {
+		t.Errorf("expected partition settings to be applied, got %+v, %v", partition, err)
+	}
+
+	if _, err := conn.DeclareSuperStream(ctx, "/", "invoices", SuperStreamSettings{Partitions: 2}); !errors.Is(err, ErrStreamExists) {
+		t.Errorf("expected ErrStreamExists, got %v", err)
+	}
+
+	if err := conn.DeleteSuperStream(ctx, "/", "invoices"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.resources) != 0 {
+		t.Errorf("expected the exchange and partitions to be deleted, left %v", fake.resources)
+	}
+
+	// A failure part way removes what was created
+	fake.fail = map[string]bool{"PUT /api/queues/%2F/invoices-us": true}
+	if _, err := conn.DeclareSuperStream(ctx, "/", "invoices", SuperStreamSettings{BindingKeys: []string{"eu", "us", "apac"}}); err == nil {
+		t.Fatal("expected the failed partition to fail the declaration")
+	}
+	if len(fake.resources) != 0 || len(fake.bindings["/api/exchanges/%2F/invoices"]) != 0 {
+		t.Errorf("expected the declaration to be rolled back, left %v", fake.resources)
+	}
+
+	// What can't be removed is reported
+	fake.fail["DELETE /api/queues/%2F/invoices-eu"] = true
+	_, err = conn.DeclareSuperStream(ctx, "/", "invoices", SuperStreamSettings{BindingKeys: []string{"eu", "us", "apac"}})
+	if err == nil || !strings.Contains(err.Error(), "failed to remove stream invoices-eu") {
+		t.Errorf("expected the partition left behind to be reported, got %v", err)
+	}
+}
+
+func TestDescribeStream(t *testing.T) {
+	fake, conn := newFakeManagement(t)
+	var queue map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"name": "orders", "type": "stream", "state": "running",
+		"arguments": {"x-queue-type": "stream", "x-max-length-bytes": 20000000000, "x-max-age": "7D"},
+		"policy": "stream-plan:orders", "operator_policy": "limits",
+		"effective_policy_definition": {"max-age": "14D"},
+		"leader": "rabbit@b", "members": ["rabbit@c", "rabbit@a", "rabbit@b"], "online": ["rabbit@b", "rabbit@a"],
+		"messages": 1200, "memory": 52000, "segments": 3, "consumers": 2,
+		"message_stats": {"publish_details": {"rate": 12.5}, "deliver_get_details": {"rate": 4}}
+	}`), &queue)
+	fake.resources["/api/queues/%2F/orders"] = queue
+	fake.resources["/api/queues/%2F/jobs"] = map[string]interface{}{"type": "classic", "effective_policy_definition": []interface{}{}}
+
+	d, err := conn.DescribeStream(context.Background(), "/", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Settings.MaxLengthBytes != 20_000_000_000 || d.Settings.MaxAge != "7D" {
+		t.Errorf("unexpected settings: %+v", d.Settings)
+	}
+	if d.Policy != "stream-plan:orders" || d.OperatorPolicy != "limits" || d.EffectivePolicyDefinition["max-age"] != "14D" {
+		t.Errorf("unexpected policies: %q, %q, %v", d.Policy, d.OperatorPolicy, d.EffectivePolicyDefinition)
+	}
+	if d.Leader != "rabbit@b" || len(d.Members) != 3 || len(d.Offline) != 1 || d.Offline[0] != "rabbit@c" {
+		t.Errorf("unexpected replicas: leader %s, members %v, offline %v", d.Leader, d.Members, d.Offline)
+	}
+	if d.Memory != 52000 || d.Segments == nil || *d.Segments != 3 || d.MessageBytes != nil {
+		t.Errorf("unexpected footprint: memory %d, segments %v, message bytes %v", d.Memory, d.Segments, d.MessageBytes)
+	}
+	if d.PublishRate != 12.5 || d.DeliverRate != 4 || d.Messages != 1200 || d.Consumers != 2 {
+		t.Errorf("unexpected rates: %+v", d)
+	}
+
+	if _, err := conn.DescribeStream(context.Background(), "/", "jobs"); !errors.Is(err, ErrStreamNotFound) {
+		t.Errorf("expected ErrStreamNotFound for a classic queue, got %v", err)
+	}
+	if _, err := conn.DescribeStream(context.Background(), "/", "missing"); !errors.Is(err, ErrStreamNotFound) {
+		t.Errorf("expected ErrStreamNotFound, got %v", err)
+	}
+}
+
+func TestListStreamPublishersAndConsumers(t *testing.T) {
+	fake, conn := newFakeManagement(t)
+	ctx := context.Background()
+
+	if _, err := conn.ListStreamPublishers(ctx, "/", "orders"); err == nil || errors.Is(err, ErrStreamNotFound) {
+		t.Errorf("expected a plugin error without the stream management plugin, got %v", err)
+	}
+
+	fake.lists["/api/stream/connections/%2F"] = `[
+		{"name": "10.0.0.5:51234 -> 10.0.0.2:5552", "user": "orders-svc", "node": "rabbit@a", "peer_host": "10.0.0.5", "peer_port": 51234,
+		 "client_properties": {"connection_name": "orders-producer", "product": "RabbitMQ Stream", "version": "1.6.0"}}
+	]`
+	fake.lists["/api/stream/publishers/%2F"] = `[
+		{"queue": {"name": "orders", "vhost": "/"}, "publisher_id": 1, "reference": "orders-1",
+		 "messages_published": 120, "messages_confirmed": 118, "messages_errored": 2,
+		 "connection_details": {"name": "10.0.0.5:51234 -> 10.0.0.2:5552"}},
+		{"queue": {"name": "invoices", "vhost": "/"}, "publisher_id": 0,
+		 "connection_details": {"name": "10.0.0.5:51234 -> 10.0.0.2:5552"}}
+	]`
+	fake.lists["/api/stream/consumers/%2F"] = `[
+		{"queue": {"name": "orders", "vhost": "/"}, "subscription_id": 3, "offset": 4000, "offset_lag": 25, "credits": 10,
+		 "messages_consumed": 4000, "active": false, "activity_status": "waiting", "properties": {"name": "billing"},
+		 "connection_details": {"name": "10.0.0.9:40000 -> 10.0.0.2:5552", "peer_host": "10.0.0.9", "peer_port": 40000}}
+	]`
+
+	publishers, err := conn.ListStreamPublishers(ctx, "/", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(publishers) != 1 {
+		t.Fatalf("expected one publisher on orders, got %+v", publishers)
+	}
+	p := publishers[0]
+	if p.ID != 1 || p.Reference != "orders-1" || p.Published != 120 || p.Confirmed != 118 || p.Errored != 2 {
+		t.Errorf("unexpected publisher: %+v", p)
+	}
+	if p.Connection.ClientName != "orders-producer" || p.Connection.User != "orders-svc" {
+		t.Errorf("expected the client-provided connection name, got %+v", p.Connection)
+	}
+
+	consumers, err := conn.ListStreamConsumers(ctx, "/", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(consumers) != 1 {
+		t.Fatalf("expected one consumer, got %+v", consumers)
+	}
+	c := consumers[0]
+	if c.SubscriptionID != 3 || c.Offset != 4000 || c.OffsetLag != 25 || c.Credits != 10 || c.Consumed != 4000 || c.Active {
+		t.Errorf("unexpected consumer: %+v", c)
+	}
+	if c.Properties["name"] != "billing" || c.Connection.Host != "10.0.0.9" || c.Connection.ClientName != "" {
+		t.Errorf("expected connection details for a connection that is gone, got %+v", c)
+	}
+}
+
+func TestConsumerLags(t *testing.T) {
+	consumer := func(name string, active bool, lag int64) StreamConsumer {
+		return StreamConsumer{Stream: "orders", Active: active, OffsetLag: lag, Properties: map[string]string{"name": name}}
+	}
+
+	// A waiting single active consumer listed after the active one doesn't replace it
+	lags := ConsumerLags([]StreamConsumer{
+		consumer("billing", true, 5),
+		consumer("billing", false, 900),
+		consumer("shipping", false, 300),
+		consumer("shipping", true, 10),
+		consumer("audit", false, 40),
+		consumer("audit", false, 70),
+		{Stream: "orders", OffsetLag: 1000},
+	})
+	expected := map[string]int64{"billing": 5, "shipping": 10, "audit": 70}
+	if len(lags) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, lags)
+	}
+	for name, lag := range expected {
+		if lags[name] != lag {
+			t.Errorf("%s: expected lag %d, got %d", name, lag, lags[name])
+		}
+	}
+}
+
+func TestManagementMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/broken") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	conn := NewConnection(config.ConnectionConfig{ID: "instrumented", Host: u.Hostname(), HTTPPort: port})
+	errorsBefore := testutil.ToFloat64(metrics.ManagementErrors.WithLabelValues("instrumented"))
+
+	ctx := context.Background()
+	if err := conn.management(ctx, http.MethodGet, "/api/queues/%2F/missing", nil, nil); !errors.Is(err, ErrStreamNotFound) {
+		t.Errorf("expected ErrStreamNotFound, got %v", err)
+	}
+	if err := conn.management(ctx, http.MethodGet, "/api/queues/%2F/broken", nil, nil); err == nil {
+		t.Error("expected an error for a 500")
+	}
+
+	// Only the 500 counts as an error; both calls are timed
+	if n := testutil.ToFloat64(metrics.ManagementErrors.WithLabelValues("instrumented")) - errorsBefore; n != 1 {
+		t.Errorf("expected one management error, got %v", n)
+	}
+	if n := testutil.CollectAndCount(metrics.ManagementRequestDuration, "rmq_viewer_management_request_duration_seconds"); n < 1 {
+		t.Error("expected management calls to be timed")
+	}
+}
+
+func TestSessions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := ""
+		if username, password, ok := r.BasicAuth(); ok && username == "alice" && password == "secret" {
+			user = "alice"
+		} else if r.Header.Get("Authorization") == "Bearer token" {
+			user = "svc"
+		}
+		switch {
+		case user == "" || (user == "svc" && r.URL.Path != "/api/whoami"):
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/api/whoami" && user == "alice":
+			w.Write([]byte(`{"name":"alice","tags":"monitoring,management"}`))
+		case r.URL.Path == "/api/whoami":
+			w.Write([]byte(`{"name":"svc","tags":["management"]}`))
+		default:
+			w.Write([]byte(`[{"name":"orders","vhost":"/","type":"stream"}]`))
+		}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	manager := NewManager([]config.ConnectionConfig{{ID: "prod", Host: u.Hostname(), HTTPPort: port, Credentials: config.CredentialsUser}})
+	if err := manager.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	manager.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, err := manager.OpenSession(ctx, Credentials{Username: "alice", Password: "wrong"}, time.Hour); !errors.Is(err, ErrCredentialsRejected) {
+		t.Errorf("expected ErrCredentialsRejected, got %v", err)
+	}
+
+	session, err := manager.OpenSession(ctx, Credentials{Username: "alice", Password: "secret"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.User != "alice" || strings.Join(session.Tags, ",") != "monitoring,management" {
+		t.Errorf("expected alice with her tags, got %s %v", session.User, session.Tags)
+	}
+
+	// The user's connection acts as them; without a session there is none
+	conn, err := manager.ConnectionFor(session.ID, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streams, err := conn.ListStreams(ctx); err != nil || len(streams) != 1 {
+		t.Errorf("expected alice to see one stream, got %v, %v", streams, err)
+	}
+	if _, err := manager.ConnectionFor("", "prod"); !errors.Is(err, ErrNoSession) {
+		t.Errorf("expected ErrNoSession without a session, got %v", err)
+	}
+	if streams, _ := manager.ListStreamsFor(ctx, session.ID); len(streams) != 1 {
+		t.Errorf("expected the session to list one stream, got %v", streams)
+	}
+	if streams, _ := manager.ListStreams(ctx); len(streams) != 0 {
+		t.Errorf("expected connections without a username to be skipped, got %v", streams)
+	}
+
+	// OAuth 2 tokens are sent as bearer tokens; the broker's refusals are access errors
+	tokenSession, err := manager.OpenSession(ctx, Credentials{Token: "token"}, time.Hour)
+	if err != nil || tokenSession.User != "svc" {
+		t.Fatalf("expected a session for svc, got %v, %v", tokenSession, err)
+	}
+	tokenConn, _ := manager.ConnectionFor(tokenSession.ID, "prod")
+	if _, err := tokenConn.ListStreams(ctx); !IsAccessDenied(err) {
+		t.Errorf("expected an access error, got %v", err)
+	}
+
+	// Sessions end when closed or expired
+	manager.CloseSession(tokenSession.ID)
+	if _, ok := manager.Session(tokenSession.ID); ok {
+		t.Error("expected the closed session to be gone")
+	}
+	now = now.Add(time.Hour)
+	if _, err := manager.ConnectionFor(session.ID, "prod"); !errors.Is(err, ErrNoSession) {
+		t.Errorf("expected ErrNoSession once expired, got %v", err)
+	}
+}
+
+func TestFirst_SlowFirstChunk(t *testing.T) {
+	// The broker takes longer than the end-of-stream grace to deliver the first chunk
+	s := &scanner{
+		committedChunk: 0,
+		idleTimeout:    10 * endOfStreamGrace,
+		subscribe: func(_ stream.OffsetSpecification, handle func(Message)) (func() error, error) {
+			go func() {
+				time.Sleep(3 * endOfStreamGrace)
+				handle(Message{Offset: 42})
+			}()
+			return func() error { return nil }, nil
+		},
+	}
+	offset, found, err := s.first(context.Background(), stream.OffsetSpecification{}.First())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || offset != 42 {
+		t.Errorf("Expected offset 42 to be found, got %d (found %v)", offset, found)
+	}
+
+	// Nothing delivered within the idle timeout means nothing to find
+	s = fakeScanner(0, 0, 2*endOfStreamGrace)
+	if _, found, err := s.first(context.Background(), stream.OffsetSpecification{}.First()); err != nil || found {
+		t.Errorf("Expected nothing to be found, got found %v (%v)", found, err)
+	}
+}
+
+func TestOffsetMatcher(t *testing.T) {
+	message := func(body string) *amqp.Message {
+		return &amqp.Message{
+			Data:                  [][]byte{[]byte(body)},
+			Properties:            &amqp.MessageProperties{MessageID: body, ContentType: "text/plain"},
+			ApplicationProperties: map[string]interface{}{"tenant": "acme", "region": "eu", "attempt": 1},
+		}
+	}
+	// roundTrip returns a message as it is read back from the stream
+	roundTrip := func(offset uint64, msg *amqp.Message) Message {
+		data, err := msg.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded amqp.Message
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		return NewMessage(offset, &decoded)
+	}
+
+	sent := []*amqp.Message{message("a"), message("b"), message("b")}
+	matcher, err := newOffsetMatcher(sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent[0].Annotations != nil {
+		t.Error("expected the sent messages to be left as they are")
+	}
+
+	// Other producers' messages, including a copy of a later message, are skipped
+	read := []Message{
+		roundTrip(10, message("b")),
+		roundTrip(11, message("a")),
+		roundTrip(12, message("other")),
+		roundTrip(13, message("b")),
+		roundTrip(14, message("b")),
+	}
+	for i, msg := range read {
+		if done := matcher.match(msg); done != (i == len(read)-1) {
+			t.Fatalf("match at offset %d reported done=%v", msg.Offset, done)
+		}
+	}
+	want := []uint64{11, 13, 14}
+	if len(matcher.offsets) != len(want) || matcher.offsets[0] != want[0] || matcher.offsets[1] != want[1] || matcher.offsets[2] != want[2] {
+		t.Errorf("expected offsets %v, got %v", want, matcher.offsets)
+	}
+}
@@ -0,0 +1,260 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// StreamClient is the stream protocol connection a publisher or consumer uses
+type StreamClient struct {
+	// Name is the broker's name for the connection, e.g. "10.0.0.5:51234 -> 10.0.0.2:5552"
+	Name string `json:"name"`
+	// ClientName is the connection_name the client provided, if any
+	ClientName string `json:"client_name,omitempty"`
+	User       string `json:"user,omitempty"`
+	Host       string `json:"host,omitempty"`
+	Port       int    `json:"port,omitempty"`
+	Node       string `json:"node,omitempty"`
+	Product    string `json:"product,omitempty"`
+	Version    string `json:"version,omitempty"`
+}
+
+// StreamPublisher is a producer currently connected to a stream
+type StreamPublisher struct {
+	ID int `json:"id"`
+	// Reference is the producer name used for deduplication, if any
+	Reference  string       `json:"reference,omitempty"`
+	Connection StreamClient `json:"connection"`
+	Published  int64        `json:"published"`
+	Confirmed  int64        `json:"confirmed"`
+	Errored    int64        `json:"errored"`
+}
+
+// StreamConsumer is a subscription currently reading from a stream
+type StreamConsumer struct {
+	Stream         string       `json:"stream"`
+	SubscriptionID int          `json:"subscription_id"`
+	Connection     StreamClient `json:"connection"`
+	// Offset is the offset of the last chunk delivered, OffsetLag how far it is behind the stream's end
+	Offset    int64 `json:"offset"`
+	OffsetLag int64 `json:"offset_lag"`
+	Credits   int64 `json:"credits"`
+	Consumed  int64 `json:"consumed"`
+	// Active is false for a single active consumer waiting its turn
+	Active         bool              `json:"active"`
+	ActivityStatus string            `json:"activity_status,omitempty"`
+	Properties     map[string]string `json:"properties,omitempty"`
+}
+
+// streamClientInfo holds the fields publishers and consumers share in the management API
+type streamClientInfo struct {
+	Queue struct {
+		Name string `json:"name"`
+	} `json:"queue"`
+	ConnectionDetails struct {
+		Name     string `json:"name"`
+		Node     string `json:"node"`
+		PeerHost string `json:"peer_host"`
+		PeerPort int    `json:"peer_port"`
+	} `json:"connection_details"`
+}
+
+// ListStreamPublishers returns the producers connected to a stream
+func (c *Connection) ListStreamPublishers(ctx context.Context, vhost, streamName string) ([]StreamPublisher, error) {
+	var publishers []struct {
+		streamClientInfo
+		PublisherID       int    `json:"publisher_id"`
+		Reference         string `json:"reference"`
+		MessagesPublished int64  `json:"messages_published"`
+		MessagesConfirmed int64  `json:"messages_confirmed"`
+		MessagesErrored   int64  `json:"messages_errored"`
+	}
+	if err := c.streamManagement(ctx, "/api/stream/publishers/"+url.PathEscape(vhost), &publishers); err != nil {
+		return nil, fmt.Errorf("failed to list publishers: %w", err)
+	}
+	clients, err := c.streamClients(ctx, vhost)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []StreamPublisher{}
+	for _, p := range publishers {
+		if p.Queue.Name != streamName {
+			continue
+		}
+		result = append(result, StreamPublisher{
+			ID:         p.PublisherID,
+			Reference:  p.Reference,
+			Connection: p.client(clients),
+			Published:  p.MessagesPublished,
+			Confirmed:  p.MessagesConfirmed,
+			Errored:    p.MessagesErrored,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Connection.Name != result[j].Connection.Name {
+			return result[i].Connection.Name < result[j].Connection.Name
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// ListStreamConsumers returns the subscriptions reading from a stream
+func (c *Connection) ListStreamConsumers(ctx context.Context, vhost, streamName string) ([]StreamConsumer, error) {
+	consumers, err := c.ListConsumers(ctx, vhost)
+	if err != nil {
+		return nil, err
+	}
+	result := []StreamConsumer{}
+	for _, consumer := range consumers {
+		if consumer.Stream == streamName {
+			result = append(result, consumer)
+		}
+	}
+	return result, nil
+}
+
+// ListConsumers returns the subscriptions reading from any stream of a vhost
+func (c *Connection) ListConsumers(ctx context.Context, vhost string) ([]StreamConsumer, error) {
+	var consumers []struct {
+		streamClientInfo
+		SubscriptionID   int                    `json:"subscription_id"`
+		Offset           int64                  `json:"offset"`
+		OffsetLag        int64                  `json:"offset_lag"`
+		Credits          int64                  `json:"credits"`
+		MessagesConsumed int64                  `json:"messages_consumed"`
+		Active           *bool                  `json:"active"`
+		ActivityStatus   string                 `json:"activity_status"`
+		Properties       map[string]interface{} `json:"properties"`
+	}
+	if err := c.streamManagement(ctx, "/api/stream/consumers/"+url.PathEscape(vhost), &consumers); err != nil {
+		return nil, fmt.Errorf("failed to list consumers: %w", err)
+	}
+	clients, err := c.streamClients(ctx, vhost)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []StreamConsumer{}
+	for _, sub := range consumers {
+		consumer := StreamConsumer{
+			Stream:         sub.Queue.Name,
+			SubscriptionID: sub.SubscriptionID,
+			Connection:     sub.client(clients),
+			Offset:         sub.Offset,
+			OffsetLag:      sub.OffsetLag,
+			Credits:        sub.Credits,
+			Consumed:       sub.MessagesConsumed,
+			// Brokers before single active consumer support report no activity, so every consumer is active
+			Active:         sub.Active == nil || *sub.Active,
+			ActivityStatus: sub.ActivityStatus,
+		}
+		if len(sub.Properties) > 0 {
+			consumer.Properties = make(map[string]string, len(sub.Properties))
+			for k, v := range sub.Properties {
+				consumer.Properties[k] = fmt.Sprint(v)
+			}
+		}
+		result = append(result, consumer)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Stream != result[j].Stream {
+			return result[i].Stream < result[j].Stream
+		}
+		if result[i].Connection.Name != result[j].Connection.Name {
+			return result[i].Connection.Name < result[j].Connection.Name
+		}
+		return result[i].SubscriptionID < result[j].SubscriptionID
+	})
+	return result, nil
+}
+
+// ConsumerLags returns the lag of each named consumer among the subscriptions.
+// Of several subscriptions sharing a name, such as single active consumers, an
+// active one counts over those waiting; among equals the largest lag counts.
+func ConsumerLags(consumers []StreamConsumer) map[string]int64 {
+	type entry struct {
+		lag    int64
+		active bool
+	}
+	entries := make(map[string]entry)
+	for _, c := range consumers {
+		name := c.Properties["name"]
+		if name == "" {
+			continue
+		}
+		e, seen := entries[name]
+		if !seen || (c.Active && !e.active) || (c.Active == e.active && c.OffsetLag > e.lag) {
+			entries[name] = entry{lag: c.OffsetLag, active: c.Active}
+		}
+	}
+
+	lags := make(map[string]int64, len(entries))
+	for name, e := range entries {
+		lags[name] = e.lag
+	}
+	return lags
+}
+
+// streamClients returns the stream protocol connections of a vhost by name
+func (c *Connection) streamClients(ctx context.Context, vhost string) (map[string]StreamClient, error) {
+	var connections []struct {
+		Name             string `json:"name"`
+		User             string `json:"user"`
+		Node             string `json:"node"`
+		PeerHost         string `json:"peer_host"`
+		PeerPort         int    `json:"peer_port"`
+		ClientProperties struct {
+			ConnectionName string `json:"connection_name"`
+			Product        string `json:"product"`
+			Version        string `json:"version"`
+		} `json:"client_properties"`
+	}
+	if err := c.streamManagement(ctx, "/api/stream/connections/"+url.PathEscape(vhost), &connections); err != nil {
+		return nil, fmt.Errorf("failed to list stream connections: %w", err)
+	}
+
+	clients := make(map[string]StreamClient, len(connections))
+	for _, conn := range connections {
+		clients[conn.Name] = StreamClient{
+			Name:       conn.Name,
+			ClientName: conn.ClientProperties.ConnectionName,
+			User:       conn.User,
+			Host:       conn.PeerHost,
+			Port:       conn.PeerPort,
+			Node:       conn.Node,
+			Product:    conn.ClientProperties.Product,
+			Version:    conn.ClientProperties.Version,
+		}
+	}
+	return clients, nil
+}
+
+// streamManagement reads a stream management plugin resource. The plugin's
+// endpoints only 404 when it isn't enabled, which is not a missing stream.
+func (c *Connection) streamManagement(ctx context.Context, path string, out interface{}) error {
+	err := c.management(ctx, http.MethodGet, path, nil, out)
+	if errors.Is(err, ErrStreamNotFound) {
+		return errors.New("the rabbitmq_stream_management plugin is not enabled")
+	}
+	return err
+}
+
+// client returns the connection of a publisher or consumer, falling back to
+// its connection details when the connection closed between the two requests
+func (info streamClientInfo) client(clients map[string]StreamClient) StreamClient {
+	if client, ok := clients[info.ConnectionDetails.Name]; ok {
+		return client
+	}
+	return StreamClient{
+		Name: info.ConnectionDetails.Name,
+		Host: info.ConnectionDetails.PeerHost,
+		Port: info.ConnectionDetails.PeerPort,
+		Node: info.ConnectionDetails.Node,
+	}
+}
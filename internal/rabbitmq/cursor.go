@@ -0,0 +1,52 @@
+package rabbitmq
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Cursor is a position in a stream that pagination continues from
+type Cursor struct {
+	Offset   uint64 `json:"o"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// Encode returns the opaque string form of the cursor
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ReadOptions returns read options continuing from the cursor
+func (c Cursor) ReadOptions(limit int) ReadOptions {
+	return ReadOptions{Offset: c.Offset, Limit: limit, Backward: c.Backward}
+}
+
+// DecodeCursor parses a cursor produced by Cursor.Encode
+func DecodeCursor(s string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	return c, nil
+}
+
+// setCursors fills in the cursors for the pages after and before this batch
+func (b *MessageBatch) setCursors() {
+	next := b.StartOffset
+	if len(b.Messages) > 0 {
+		next = b.EndOffset + 1
+	}
+	b.NextCursor = Cursor{Offset: next}.Encode()
+
+	if !b.AtStart {
+		b.PrevCursor = Cursor{Offset: b.StartOffset, Backward: true}.Encode()
+	}
+}
@@ -0,0 +1,147 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// StreamDescription is everything the management API reports about a stream
+type StreamDescription struct {
+	Name         string `json:"name"`
+	ConnectionID string `json:"connection_id"`
+	VHost        string `json:"vhost"`
+	State        string `json:"state,omitempty"`
+
+	// Arguments are the queue arguments the stream was declared with
+	Arguments map[string]interface{} `json:"arguments"`
+	// Settings are the stream settings read from Arguments
+	Settings StreamSettings `json:"settings"`
+
+	// Policy and OperatorPolicy name the policies matching the stream;
+	// EffectivePolicyDefinition is the result of applying both
+	Policy                    string                 `json:"policy,omitempty"`
+	OperatorPolicy            string                 `json:"operator_policy,omitempty"`
+	EffectivePolicyDefinition map[string]interface{} `json:"effective_policy_definition"`
+
+	Leader   string   `json:"leader"`
+	Members  []string `json:"members"`
+	Online   []string `json:"online"`
+	Offline  []string `json:"offline"`
+	Messages int64    `json:"messages"`
+
+	// Memory is the memory used by the stream's processes, in bytes
+	Memory int64 `json:"memory"`
+	// MessageBytes and Segments describe the on-disk footprint; they are nil
+	// when the broker doesn't report them
+	MessageBytes *int64 `json:"message_bytes,omitempty"`
+	Segments     *int64 `json:"segments,omitempty"`
+
+	Consumers int64 `json:"consumers"`
+	// PublishRate and DeliverRate are messages per second over the management API's sample window
+	PublishRate float64 `json:"publish_rate"`
+	DeliverRate float64 `json:"deliver_rate"`
+}
+
+// queueInfo is the part of the management API's queue object the viewer reads
+type queueInfo struct {
+	Name      string                 `json:"name"`
+	Type      string                 `json:"type"`
+	State     string                 `json:"state"`
+	Arguments map[string]interface{} `json:"arguments"`
+
+	Policy         string `json:"policy"`
+	OperatorPolicy string `json:"operator_policy"`
+	// Brokers encode an empty definition as [] rather than {}
+	EffectivePolicyDefinition interface{} `json:"effective_policy_definition"`
+
+	Leader  string   `json:"leader"`
+	Node    string   `json:"node"`
+	Members []string `json:"members"`
+	Online  []string `json:"online"`
+
+	Messages     int64  `json:"messages"`
+	Memory       int64  `json:"memory"`
+	MessageBytes *int64 `json:"message_bytes"`
+	Segments     *int64 `json:"segments"`
+	Consumers    int64  `json:"consumers"`
+
+	MessageStats struct {
+		PublishDetails    struct{ Rate float64 } `json:"publish_details"`
+		DeliverGetDetails struct{ Rate float64 } `json:"deliver_get_details"`
+	} `json:"message_stats"`
+}
+
+// getQueueInfo reads a stream from the management API
+func (c *Connection) getQueueInfo(ctx context.Context, vhost, streamName string) (*queueInfo, error) {
+	var info queueInfo
+	if err := c.management(ctx, http.MethodGet, queuePath(vhost, streamName), nil, &info); err != nil {
+		return nil, err
+	}
+	if info.Type != "stream" {
+		return nil, fmt.Errorf("%w: %s is a %s queue", ErrStreamNotFound, streamName, info.Type)
+	}
+	return &info, nil
+}
+
+// DescribeStream returns a stream's arguments, policies, replicas, footprint and rates
+func (c *Connection) DescribeStream(ctx context.Context, vhost, streamName string) (*StreamDescription, error) {
+	info, err := c.getQueueInfo(ctx, vhost, streamName)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &StreamDescription{
+		Name:                      streamName,
+		ConnectionID:              c.ID,
+		VHost:                     vhost,
+		State:                     info.State,
+		Arguments:                 info.Arguments,
+		Settings:                  streamSettingsFromArguments(info.Arguments),
+		Policy:                    info.Policy,
+		OperatorPolicy:            info.OperatorPolicy,
+		EffectivePolicyDefinition: map[string]interface{}{},
+		Leader:                    info.Leader,
+		Members:                   info.Members,
+		Online:                    info.Online,
+		Offline:                   []string{},
+		Messages:                  info.Messages,
+		Memory:                    info.Memory,
+		MessageBytes:              info.MessageBytes,
+		Segments:                  info.Segments,
+		Consumers:                 info.Consumers,
+		PublishRate:               info.MessageStats.PublishDetails.Rate,
+		DeliverRate:               info.MessageStats.DeliverGetDetails.Rate,
+	}
+	if definition, ok := info.EffectivePolicyDefinition.(map[string]interface{}); ok {
+		d.EffectivePolicyDefinition = definition
+	}
+	if d.Arguments == nil {
+		d.Arguments = map[string]interface{}{}
+	}
+	if d.Leader == "" {
+		d.Leader = info.Node
+	}
+	if d.Members == nil {
+		d.Members = []string{}
+	}
+	if d.Online == nil {
+		d.Online = []string{}
+	}
+
+	online := make(map[string]bool, len(d.Online))
+	for _, member := range d.Online {
+		online[member] = true
+	}
+	for _, member := range d.Members {
+		if !online[member] {
+			d.Offline = append(d.Offline, member)
+		}
+	}
+	sort.Strings(d.Members)
+	sort.Strings(d.Online)
+	sort.Strings(d.Offline)
+
+	return d, nil
+}
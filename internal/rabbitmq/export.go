@@ -0,0 +1,409 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+)
+
+// ExportedMessage is the full-fidelity record of a message used by NDJSON exports.
+// Unlike Message it keeps every AMQP section separately so it can be republished.
+type ExportedMessage struct {
+	Offset                uint64                 `json:"offset"`
+	StoredAt              *time.Time             `json:"stored_at,omitempty"`
+	Header                *ExportedHeader        `json:"header,omitempty"`
+	Properties            *ExportedProperties    `json:"properties,omitempty"`
+	ApplicationProperties map[string]interface{} `json:"application_properties,omitempty"`
+	MessageAnnotations    map[string]interface{} `json:"message_annotations,omitempty"`
+	DeliveryAnnotations   map[string]interface{} `json:"delivery_annotations,omitempty"`
+	Footer                map[string]interface{} `json:"footer,omitempty"`
+	// Body is base64 encoded in JSON
+	Body []byte `json:"body"`
+}
+
+// ExportedHeader mirrors the AMQP header section
+type ExportedHeader struct {
+	Durable       bool   `json:"durable,omitempty"`
+	Priority      uint8  `json:"priority,omitempty"`
+	TTLMs         int64  `json:"ttl_ms,omitempty"`
+	FirstAcquirer bool   `json:"first_acquirer,omitempty"`
+	DeliveryCount uint32 `json:"delivery_count,omitempty"`
+}
+
+// ExportedProperties mirrors the AMQP properties section
+type ExportedProperties struct {
+	MessageID          interface{} `json:"message_id,omitempty"`
+	UserID             []byte      `json:"user_id,omitempty"`
+	To                 string      `json:"to,omitempty"`
+	Subject            string      `json:"subject,omitempty"`
+	ReplyTo            string      `json:"reply_to,omitempty"`
+	CorrelationID      interface{} `json:"correlation_id,omitempty"`
+	ContentType        string      `json:"content_type,omitempty"`
+	ContentEncoding    string      `json:"content_encoding,omitempty"`
+	AbsoluteExpiryTime *time.Time  `json:"absolute_expiry_time,omitempty"`
+	CreationTime       *time.Time  `json:"creation_time,omitempty"`
+	GroupID            string      `json:"group_id,omitempty"`
+	GroupSequence      uint32      `json:"group_sequence,omitempty"`
+	ReplyToGroupID     string      `json:"reply_to_group_id,omitempty"`
+}
+
+// Export returns the full-fidelity record of the message
+func (m Message) Export() ExportedMessage {
+	exported := ExportedMessage{
+		Offset:   m.Offset,
+		StoredAt: m.StoredAt,
+		Body:     m.Data,
+	}
+
+	raw := m.raw
+	if raw == nil {
+		return exported
+	}
+
+	if h := raw.Header; h != nil {
+		exported.Header = &ExportedHeader{
+			Durable:       h.Durable,
+			Priority:      h.Priority,
+			TTLMs:         h.TTL.Milliseconds(),
+			FirstAcquirer: h.FirstAcquirer,
+			DeliveryCount: h.DeliveryCount,
+		}
+	}
+
+	if p := raw.Properties; p != nil {
+		exported.Properties = &ExportedProperties{
+			MessageID:       exportedID(p.MessageID),
+			UserID:          p.UserID,
+			To:              p.To,
+			Subject:         p.Subject,
+			ReplyTo:         p.ReplyTo,
+			CorrelationID:   exportedID(p.CorrelationID),
+			ContentType:     p.ContentType,
+			ContentEncoding: p.ContentEncoding,
+			GroupID:         p.GroupID,
+			GroupSequence:   p.GroupSequence,
+			ReplyToGroupID:  p.ReplyToGroupID,
+		}
+		if !p.AbsoluteExpiryTime.IsZero() {
+			expiry := p.AbsoluteExpiryTime
+			exported.Properties.AbsoluteExpiryTime = &expiry
+		}
+		if !p.CreationTime.IsZero() {
+			created := p.CreationTime
+			exported.Properties.CreationTime = &created
+		}
+	}
+
+	if len(raw.ApplicationProperties) > 0 {
+		exported.ApplicationProperties = exportedMap(raw.ApplicationProperties)
+	}
+	exported.MessageAnnotations = stringKeys(raw.Annotations)
+	exported.DeliveryAnnotations = stringKeys(raw.DeliveryAnnotations)
+	exported.Footer = stringKeys(raw.Footer)
+
+	return exported
+}
+
+// stringKeys converts an AMQP annotations map into a JSON-friendly map
+func stringKeys(annotations map[interface{}]interface{}) map[string]interface{} {
+	if len(annotations) == 0 {
+		return nil
+	}
+	out := make(map[string]interface{}, len(annotations))
+	for k, v := range annotations {
+		out[fmt.Sprintf("%v", k)] = exportedValue(v)
+	}
+	return out
+}
+
+func exportedMap(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = exportedValue(v)
+	}
+	return out
+}
+
+// exportedValue converts an application property or annotation value to its
+// exported form. Strings, longs, booleans and fractional doubles are kept as
+// JSON values; other AMQP types become {"<type>": <value>}, with binaries in
+// base64 and timestamps in RFC 3339, so that amqpValue restores their type.
+func exportedValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case []byte:
+		return map[string]interface{}{"binary": base64.StdEncoding.EncodeToString(value)}
+	case amqp.UUID:
+		return map[string]interface{}{"uuid": value.String()}
+	case time.Time:
+		return map[string]interface{}{"timestamp": value.UTC().Format(time.RFC3339Nano)}
+	case uint8:
+		return map[string]interface{}{"ubyte": value}
+	case uint16:
+		return map[string]interface{}{"ushort": value}
+	case uint32:
+		return map[string]interface{}{"uint": value}
+	case uint64:
+		return map[string]interface{}{"ulong": value}
+	case int8:
+		return map[string]interface{}{"byte": value}
+	case int16:
+		return map[string]interface{}{"short": value}
+	case int32:
+		return map[string]interface{}{"int": value}
+	case float32:
+		return map[string]interface{}{"float": value}
+	case float64:
+		// Whole doubles would otherwise be restored as longs
+		if value == math.Trunc(value) {
+			return map[string]interface{}{"double": value}
+		}
+		return v
+	case map[string]interface{}:
+		return exportedMap(value)
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, item := range value {
+			out[i] = exportedValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// DecodeExportedMessage parses one NDJSON export line. Numbers keep their
+// integer type and type-tagged values are restored by AMQPMessage, so that
+// application properties and annotations are republished with their AMQP type.
+func DecodeExportedMessage(line []byte) (ExportedMessage, error) {
+	var exported ExportedMessage
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&exported); err != nil {
+		return exported, err
+	}
+	return exported, nil
+}
+
+// Timestamp returns the best known time the message was written: the broker
+// timestamp when exported, otherwise the producer's creation time
+func (e ExportedMessage) Timestamp() (time.Time, bool) {
+	if e.StoredAt != nil {
+		return *e.StoredAt, true
+	}
+	if e.Properties != nil && e.Properties.CreationTime != nil {
+		return *e.Properties.CreationTime, true
+	}
+	return time.Time{}, false
+}
+
+// AMQPMessage rebuilds the AMQP message from its exported record
+func (e ExportedMessage) AMQPMessage() *amqp.Message {
+	msg := &amqp.Message{
+		Data:                  [][]byte{e.Body},
+		ApplicationProperties: amqpMap(e.ApplicationProperties),
+		Annotations:           annotations(e.MessageAnnotations),
+		DeliveryAnnotations:   annotations(e.DeliveryAnnotations),
+		Footer:                annotations(e.Footer),
+	}
+
+	if h := e.Header; h != nil {
+		msg.Header = &amqp.MessageHeader{
+			Durable:       h.Durable,
+			Priority:      h.Priority,
+			TTL:           time.Duration(h.TTLMs) * time.Millisecond,
+			FirstAcquirer: h.FirstAcquirer,
+			DeliveryCount: h.DeliveryCount,
+		}
+	}
+
+	if p := e.Properties; p != nil {
+		msg.Properties = &amqp.MessageProperties{
+			MessageID:       messageID(p.MessageID),
+			UserID:          p.UserID,
+			To:              p.To,
+			Subject:         p.Subject,
+			ReplyTo:         p.ReplyTo,
+			CorrelationID:   messageID(p.CorrelationID),
+			ContentType:     p.ContentType,
+			ContentEncoding: p.ContentEncoding,
+			GroupID:         p.GroupID,
+			GroupSequence:   p.GroupSequence,
+			ReplyToGroupID:  p.ReplyToGroupID,
+		}
+		if p.AbsoluteExpiryTime != nil {
+			msg.Properties.AbsoluteExpiryTime = *p.AbsoluteExpiryTime
+		}
+		if p.CreationTime != nil {
+			msg.Properties.CreationTime = *p.CreationTime
+		}
+	}
+
+	return msg
+}
+
+// annotations converts an exported annotations map back to AMQP, where
+// string keys are encoded as symbols
+func annotations(m map[string]interface{}) amqp.Annotations {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(amqp.Annotations, len(m))
+	for k, v := range m {
+		out[k] = amqpValue(v)
+	}
+	return out
+}
+
+func amqpMap(m map[string]interface{}) map[string]interface{} {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = amqpValue(v)
+	}
+	return out
+}
+
+// amqpValue restores JSON-decoded values to the types the AMQP encoder expects
+func amqpValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		if restored, ok := taggedValue(value); ok {
+			return restored
+		}
+		return amqpMap(value)
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, item := range value {
+			out[i] = amqpValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// exportedID converts a message or correlation ID to its exported form.
+// Strings and ulongs are kept as JSON strings and numbers; binary IDs become
+// {"binary": "<base64>"} and UUIDs {"uuid": "<uuid>"}, so that each is
+// restored with its AMQP type rather than as a string or array.
+func exportedID(v interface{}) interface{} {
+	switch value := v.(type) {
+	case []byte:
+		return map[string]interface{}{"binary": base64.StdEncoding.EncodeToString(value)}
+	case amqp.UUID:
+		return map[string]interface{}{"uuid": value.String()}
+	default:
+		return v
+	}
+}
+
+// messageID restores a message or correlation ID from its exported form.
+// Numeric IDs are AMQP ulongs; UUIDs of older exports are arrays of 16 bytes.
+func messageID(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if u, err := strconv.ParseUint(value.String(), 10, 64); err == nil {
+			return u
+		}
+		return value.String()
+	case map[string]interface{}:
+		switch id, _ := taggedValue(value); id.(type) {
+		case []byte, amqp.UUID:
+			return id
+		}
+		return nil
+	case []interface{}:
+		if len(value) != len(amqp.UUID{}) {
+			return nil
+		}
+		var id amqp.UUID
+		for i, item := range value {
+			n, ok := item.(json.Number)
+			if !ok {
+				return nil
+			}
+			b, err := n.Int64()
+			if err != nil || b < 0 || b > math.MaxUint8 {
+				return nil
+			}
+			id[i] = byte(b)
+		}
+		return id
+	default:
+		return v
+	}
+}
+
+// taggedValue restores a {"<type>": <value>} object written by exportedValue
+// or exportedID. ok is false for any other object.
+func taggedValue(m map[string]interface{}) (interface{}, bool) {
+	if len(m) != 1 {
+		return nil, false
+	}
+	for tag, value := range m {
+		s, _ := value.(string)
+		if n, isNumber := value.(json.Number); isNumber {
+			s = n.String()
+		}
+		v, err := parseTagged(tag, s)
+		if err != nil {
+			return nil, false
+		}
+		return v, true
+	}
+	return nil, false
+}
+
+func parseTagged(tag, s string) (interface{}, error) {
+	switch tag {
+	case "binary":
+		return base64.StdEncoding.DecodeString(s)
+	case "uuid":
+		id, err := uuid.Parse(s)
+		return amqp.UUID(id), err
+	case "timestamp":
+		return time.Parse(time.RFC3339Nano, s)
+	case "ubyte":
+		u, err := strconv.ParseUint(s, 10, 8)
+		return uint8(u), err
+	case "ushort":
+		u, err := strconv.ParseUint(s, 10, 16)
+		return uint16(u), err
+	case "uint":
+		u, err := strconv.ParseUint(s, 10, 32)
+		return uint32(u), err
+	case "ulong":
+		return strconv.ParseUint(s, 10, 64)
+	case "byte":
+		i, err := strconv.ParseInt(s, 10, 8)
+		return int8(i), err
+	case "short":
+		i, err := strconv.ParseInt(s, 10, 16)
+		return int16(i), err
+	case "int":
+		i, err := strconv.ParseInt(s, 10, 32)
+		return int32(i), err
+	case "float":
+		f, err := strconv.ParseFloat(s, 32)
+		return float32(f), err
+	case "double":
+		return strconv.ParseFloat(s, 64)
+	default:
+		return nil, fmt.Errorf("unknown type %q", tag)
+	}
+}
@@ -0,0 +1,34 @@
+package rabbitmq
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/metrics"
+)
+
+// instrumentedTransport records the latency and errors of management API calls
+type instrumentedTransport struct {
+	connection string
+	next       http.RoundTripper
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	metrics.ManagementRequestDuration.WithLabelValues(t.connection, req.Method).Observe(time.Since(start).Seconds())
+
+	// 404 answers existence checks, so it isn't counted as an error
+	if err != nil || (resp.StatusCode >= 400 && resp.StatusCode != http.StatusNotFound) {
+		metrics.ManagementErrors.WithLabelValues(t.connection).Inc()
+	}
+	return resp, err
+}
+
+// observeRead records how long a read took and why it ended
+func (c *Connection) observeRead(kind string, start time.Time, endReason string, err error) {
+	if err != nil {
+		endReason = "error"
+	}
+	metrics.ReadDuration.WithLabelValues(c.ID, kind, endReason).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,188 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+)
+
+// NewMessage converts an AMQP message delivered at the given offset into a Message
+func NewMessage(offset uint64, message *amqp.Message) Message {
+	// Extract AMQP properties
+	props := make(map[string]interface{})
+
+	// AMQP Message Properties
+	if message.Properties != nil {
+		if message.Properties.MessageID != nil {
+			props["message_id"] = message.Properties.MessageID
+		}
+		if message.Properties.CorrelationID != nil {
+			props["correlation_id"] = message.Properties.CorrelationID
+		}
+		if message.Properties.ContentType != "" {
+			props["content_type"] = message.Properties.ContentType
+		}
+		if message.Properties.ContentEncoding != "" {
+			props["content_encoding"] = message.Properties.ContentEncoding
+		}
+		if message.Properties.ReplyTo != "" {
+			props["reply_to"] = message.Properties.ReplyTo
+		}
+		if message.Properties.Subject != "" {
+			props["subject"] = message.Properties.Subject
+		}
+		if message.Properties.To != "" {
+			props["to"] = message.Properties.To
+		}
+		if len(message.Properties.UserID) > 0 {
+			props["user_id"] = string(message.Properties.UserID)
+		}
+		if message.Properties.GroupID != "" {
+			props["group_id"] = message.Properties.GroupID
+		}
+		if message.Properties.ReplyToGroupID != "" {
+			props["reply_to_group_id"] = message.Properties.ReplyToGroupID
+		}
+		if message.Properties.GroupSequence != 0 {
+			props["group_sequence"] = message.Properties.GroupSequence
+		}
+		if !message.Properties.CreationTime.IsZero() {
+			props["creation_time"] = message.Properties.CreationTime
+		}
+		if !message.Properties.AbsoluteExpiryTime.IsZero() {
+			props["absolute_expiry_time"] = message.Properties.AbsoluteExpiryTime
+		}
+	}
+
+	// AMQP Header (message header fields)
+	if message.Header != nil {
+		if message.Header.Durable {
+			props["durable"] = message.Header.Durable
+		}
+		if message.Header.Priority != 0 {
+			props["priority"] = message.Header.Priority
+		}
+		if message.Header.TTL != 0 {
+			props["ttl"] = message.Header.TTL
+		}
+		if message.Header.FirstAcquirer {
+			props["first_acquirer"] = message.Header.FirstAcquirer
+		}
+		if message.Header.DeliveryCount != 0 {
+			props["delivery_count"] = message.Header.DeliveryCount
+		}
+	}
+
+	// Message Annotations (used by brokers and infrastructure)
+	if message.Annotations != nil && len(message.Annotations) > 0 {
+		annotations := make(map[string]interface{})
+		for k, v := range message.Annotations {
+			keyStr := fmt.Sprintf("%v", k)
+			annotations[keyStr] = v
+
+			// Extract routing key if present in annotations
+			if keyStr == "x-routing-key" || keyStr == "routing-key" {
+				props["routing_key"] = v
+			}
+		}
+		props["message_annotations"] = annotations
+	}
+
+	// Delivery Annotations (used by delivery infrastructure)
+	if message.DeliveryAnnotations != nil && len(message.DeliveryAnnotations) > 0 {
+		deliveryAnnotations := make(map[string]interface{})
+		for k, v := range message.DeliveryAnnotations {
+			keyStr := fmt.Sprintf("%v", k)
+			deliveryAnnotations[keyStr] = v
+
+			// Extract routing key if present in delivery annotations
+			if keyStr == "x-routing-key" || keyStr == "routing-key" {
+				props["routing_key"] = v
+			}
+		}
+		props["delivery_annotations"] = deliveryAnnotations
+	}
+
+	// Application Properties (custom key-value pairs set by the application)
+	if message.ApplicationProperties != nil {
+		appProps := make(map[string]interface{})
+		for k, v := range message.ApplicationProperties {
+			appProps[k] = v
+		}
+		if len(appProps) > 0 {
+			props["application_properties"] = appProps
+		}
+	}
+
+	// Footer (used for signatures, checksums, etc.)
+	if message.Footer != nil && len(message.Footer) > 0 {
+		footer := make(map[string]interface{})
+		for k, v := range message.Footer {
+			footer[fmt.Sprintf("%v", k)] = v
+		}
+		props["footer"] = footer
+	}
+
+	msg := Message{
+		Offset:     offset,
+		Data:       message.GetData(),
+		Properties: props,
+		raw:        message,
+	}
+
+	// Only report a creation time when the producer actually set one
+	if message.Properties != nil && !message.Properties.CreationTime.IsZero() {
+		creationTime := message.Properties.CreationTime
+		msg.CreationTime = &creationTime
+	}
+
+	return msg
+}
+
+// SetStoredAt records the broker-assigned chunk timestamp and derives the
+// publish latency when the producer also set a creation time
+func (m *Message) SetStoredAt(storedAt time.Time) {
+	if storedAt.IsZero() {
+		return
+	}
+	m.StoredAt = &storedAt
+
+	if m.CreationTime != nil {
+		latency := storedAt.Sub(*m.CreationTime).Milliseconds()
+		m.PublishLatencyMs = &latency
+	}
+}
+
+// AMQP returns the decoded AMQP message so it can be republished with every
+// section intact. Messages not read from a stream only carry their body.
+func (m Message) AMQP() *amqp.Message {
+	if m.raw != nil {
+		return m.raw
+	}
+	return &amqp.Message{Data: [][]byte{m.Data}}
+}
+
+// WithRedactions returns a copy of the message with its body and application
+// properties replaced by masked versions, in its export as well
+func (m Message) WithRedactions(data []byte, appProps map[string]interface{}) Message {
+	props := make(map[string]interface{}, len(m.Properties))
+	for k, v := range m.Properties {
+		props[k] = v
+	}
+	if len(appProps) > 0 {
+		props["application_properties"] = appProps
+	} else {
+		delete(props, "application_properties")
+	}
+
+	m.Data = data
+	m.Properties = props
+	m.Redacted = true
+	if m.raw != nil {
+		raw := *m.raw
+		raw.ApplicationProperties = appProps
+		m.raw = &raw
+	}
+	return m
+}
@@ -0,0 +1,358 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/stream"
+)
+
+// ErrReadOnly is returned when publishing to a connection configured as read_only
+var ErrReadOnly = errors.New("connection is read-only")
+
+// ErrOffsetsUnknown is returned by PublishMessages when the broker confirmed
+// the messages but their offsets could not be found
+var ErrOffsetsUnknown = errors.New("messages were published but their offsets are unknown")
+
+// Annotations linking a republished message back to the message it was copied from
+const (
+	SourceStreamAnnotation = "x-source-stream"
+	SourceOffsetAnnotation = "x-source-offset"
+)
+
+// errOffsetsFound stops the offset lookup once every published message was seen
+var errOffsetsFound = errors.New("all offsets found")
+
+// PublisherOptions configures a Publisher
+type PublisherOptions struct {
+	// Name enables broker-side deduplication: messages carrying a publishing ID
+	// not greater than the last one stored for this name are dropped.
+	Name string
+}
+
+// PublishStats counts the messages handed to a Publisher and their outcome
+type PublishStats struct {
+	Sent      int `json:"sent"`
+	Confirmed int `json:"confirmed"`
+	Failed    int `json:"failed"`
+}
+
+// Publisher sends messages to a single stream and tracks their confirmations
+type Publisher struct {
+	env      *stream.Environment
+	producer *stream.Producer
+
+	mu      sync.Mutex
+	stats   PublishStats
+	lastErr error
+	settled chan struct{}
+}
+
+// NewPublisher opens a producer on a stream in a specific vhost
+func (c *Connection) NewPublisher(vhost, streamName string, opts PublisherOptions) (*Publisher, error) {
+	if c.Config.IsReadOnly() {
+		return nil, ErrReadOnly
+	}
+
+	env, err := c.newEnvironment(vhost)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := newPublisher(env, streamName, opts)
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+	p.env = env
+	return p, nil
+}
+
+// newPublisher opens a producer on an existing environment. The environment
+// is only closed with the publisher when p.env is set.
+func newPublisher(env *stream.Environment, streamName string, opts PublisherOptions) (*Publisher, error) {
+	producerOpts := stream.NewProducerOptions()
+	if opts.Name != "" {
+		producerOpts.SetProducerName(opts.Name)
+	}
+	producer, err := env.NewProducer(streamName, producerOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create producer: %w", err)
+	}
+
+	p := &Publisher{
+		producer: producer,
+		settled:  make(chan struct{}, 1),
+	}
+	go p.trackConfirmations(producer.NotifyPublishConfirmation())
+	return p, nil
+}
+
+// trackConfirmations counts confirmations until the producer is closed
+func (p *Publisher) trackConfirmations(confirms stream.ChannelPublishConfirm) {
+	for statuses := range confirms {
+		p.mu.Lock()
+		for _, status := range statuses {
+			if status.IsConfirmed() {
+				p.stats.Confirmed++
+			} else {
+				p.stats.Failed++
+				if err := status.GetError(); err != nil {
+					p.lastErr = err
+				}
+			}
+		}
+		p.mu.Unlock()
+
+		select {
+		case p.settled <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Send publishes a message. A non-negative publishingID is used for
+// deduplication when the publisher has a name.
+func (p *Publisher) Send(msg *amqp.Message, publishingID int64) error {
+	out := &outgoingMessage{message: msg}
+	if publishingID >= 0 {
+		out.SetPublishingId(publishingID)
+	}
+
+	if err := p.producer.Send(out); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	p.mu.Lock()
+	p.stats.Sent++
+	p.mu.Unlock()
+	return nil
+}
+
+// LastPublishingID returns the last publishing ID stored by the broker for
+// the publisher's name
+func (p *Publisher) LastPublishingID() (int64, error) {
+	return p.producer.GetLastPublishingId()
+}
+
+// Wait blocks until every sent message has been confirmed or rejected
+func (p *Publisher) Wait(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		stats, lastErr := p.stats, p.lastErr
+		p.mu.Unlock()
+
+		if stats.Confirmed+stats.Failed >= stats.Sent {
+			if stats.Failed > 0 {
+				return fmt.Errorf("%d of %d messages were not confirmed: %v", stats.Failed, stats.Sent, lastErr)
+			}
+			return nil
+		}
+
+		select {
+		case <-p.settled:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Stats returns the current publishing counters
+func (p *Publisher) Stats() PublishStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// Close closes the producer and its environment
+func (p *Publisher) Close() error {
+	err := p.producer.Close()
+	if p.env == nil {
+		return err
+	}
+	if closeErr := p.env.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// PublishMessages publishes messages to a stream in order, waits for the
+// broker to confirm them and returns the offset assigned to each message.
+// Confirms do not carry offsets, so the messages are read back and matched by
+// content. When they can't all be found, the error wraps ErrOffsetsUnknown:
+// the messages were still published and must not be sent again.
+func (c *Connection) PublishMessages(ctx context.Context, vhost, streamName string, messages []*amqp.Message) ([]uint64, error) {
+	if c.Config.IsReadOnly() {
+		return nil, ErrReadOnly
+	}
+
+	env, err := c.newEnvironment(vhost)
+	if err != nil {
+		return nil, err
+	}
+	defer env.Close()
+
+	// New messages land at or after the current last chunk
+	_, committedChunk, err := streamBounds(env, streamName)
+	if err != nil {
+		return nil, err
+	}
+	var start uint64
+	if committedChunk > 0 {
+		start = uint64(committedChunk)
+	}
+
+	publisher, err := newPublisher(env, streamName, PublisherOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer publisher.Close()
+
+	matcher, err := newOffsetMatcher(messages)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range messages {
+		if err := publisher.Send(msg, -1); err != nil {
+			return nil, err
+		}
+	}
+	if err := publisher.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	_, err = c.StreamMessages(ctx, vhost, streamName, StreamOptions{Offset: start}, func(msg Message) error {
+		if matcher.match(msg) {
+			return errOffsetsFound
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errOffsetsFound) {
+		return nil, fmt.Errorf("%w: %v", ErrOffsetsUnknown, err)
+	}
+	if missing := len(messages) - len(matcher.offsets); missing > 0 {
+		return nil, fmt.Errorf("%w: %d of them were not found", ErrOffsetsUnknown, missing)
+	}
+	return matcher.offsets, nil
+}
+
+// offsetMatcher finds the offsets of published messages among the messages
+// read back from the stream. A producer's messages are stored in the order
+// they were sent, so each message read is compared with the next one to find.
+// Messages are compared as decoded from their encoding, the form they are
+// read back in; an identical message published concurrently by someone else
+// may be taken for one of them.
+type offsetMatcher struct {
+	expected []*amqp.Message
+	offsets  []uint64
+}
+
+func newOffsetMatcher(messages []*amqp.Message) (*offsetMatcher, error) {
+	m := &offsetMatcher{expected: make([]*amqp.Message, len(messages))}
+	for i, msg := range messages {
+		data, err := msg.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		var decoded amqp.Message
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		m.expected[i] = &decoded
+	}
+	return m, nil
+}
+
+// match records the offset of msg if it is the next message to find and
+// reports whether every offset was found
+func (m *offsetMatcher) match(msg Message) bool {
+	if next := len(m.offsets); next < len(m.expected) && reflect.DeepEqual(msg.AMQP(), m.expected[next]) {
+		m.offsets = append(m.offsets, msg.Offset)
+	}
+	return len(m.offsets) == len(m.expected)
+}
+
+// EnsureStream declares a stream in a specific vhost unless it already
+// exists and reports whether it was created
+func (c *Connection) EnsureStream(vhost, streamName string, opts *stream.StreamOptions) (bool, error) {
+	if c.Config.IsReadOnly() {
+		return false, ErrReadOnly
+	}
+
+	env, err := c.newEnvironment(vhost)
+	if err != nil {
+		return false, err
+	}
+	defer env.Close()
+
+	exists, err := env.StreamExists(streamName)
+	if err != nil {
+		return false, fmt.Errorf("failed to check stream existence: %w", err)
+	}
+	if exists {
+		return false, nil
+	}
+
+	if err := env.DeclareStream(streamName, opts); err != nil {
+		return false, fmt.Errorf("failed to create stream: %w", err)
+	}
+	return true, nil
+}
+
+// outgoingMessage adapts a full *amqp.Message to the stream client's
+// StreamMessage interface. Unlike amqp.AMQP10 it keeps the header,
+// delivery annotations and footer sections.
+type outgoingMessage struct {
+	message         *amqp.Message
+	publishingID    int64
+	hasPublishingID bool
+}
+
+func (m *outgoingMessage) MarshalBinary() ([]byte, error) {
+	return m.message.MarshalBinary()
+}
+
+func (m *outgoingMessage) UnmarshalBinary(data []byte) error {
+	return m.message.UnmarshalBinary(data)
+}
+
+func (m *outgoingMessage) SetPublishingId(id int64) {
+	m.publishingID = id
+	m.hasPublishingID = true
+}
+
+func (m *outgoingMessage) GetPublishingId() int64 {
+	return m.publishingID
+}
+
+func (m *outgoingMessage) HasPublishingId() bool {
+	return m.hasPublishingID
+}
+
+func (m *outgoingMessage) GetData() [][]byte {
+	return m.message.Data
+}
+
+func (m *outgoingMessage) GetMessageProperties() *amqp.MessageProperties {
+	return m.message.Properties
+}
+
+func (m *outgoingMessage) GetMessageAnnotations() amqp.Annotations {
+	return m.message.Annotations
+}
+
+func (m *outgoingMessage) GetApplicationProperties() map[string]any {
+	return m.message.ApplicationProperties
+}
+
+func (m *outgoingMessage) GetMessageHeader() *amqp.MessageHeader {
+	return m.message.Header
+}
+
+func (m *outgoingMessage) GetAMQPValue() any {
+	return m.message.Value
+}
@@ -0,0 +1,455 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/stream"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+)
+
+// OffsetEnd can be passed as ReadOptions.Offset for a backward read to get the newest messages
+const OffsetEnd uint64 = math.MaxUint64
+
+// maxBackwardWindows caps how many times a backward read widens its search window
+const maxBackwardWindows = 8
+
+// ReadMessages reads messages from a stream starting at the given offset (using connection's default vhost)
+func (c *Connection) ReadMessages(ctx context.Context, streamName string, offset uint64, limit int) (*MessageBatch, error) {
+	return c.ReadMessagesFromVHost(ctx, c.DefaultVHost(), streamName, offset, limit)
+}
+
+// ReadMessagesFromVHost reads messages from a stream in a specific vhost starting at the given offset
+func (c *Connection) ReadMessagesFromVHost(ctx context.Context, vhost, streamName string, offset uint64, limit int) (*MessageBatch, error) {
+	return c.ReadMessagesWithOptions(ctx, vhost, streamName, ReadOptions{Offset: offset, Limit: limit})
+}
+
+// ReadMessagesWithOptions reads a batch of messages from a stream in a specific vhost.
+// A forward read returns as soon as the limit is reached, the end of the stream has
+// been delivered, or no message arrived within the idle timeout. A backward read
+// returns the messages immediately before the offset.
+func (c *Connection) ReadMessagesWithOptions(ctx context.Context, vhost, streamName string, opts ReadOptions) (batch *MessageBatch, err error) {
+	defer func(start time.Time) {
+		var reason string
+		if batch != nil {
+			reason = batch.EndReason
+		}
+		c.observeRead("batch", start, reason, err)
+	}(time.Now())
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+	if limit > 500 {
+		limit = 500
+	}
+
+	idleTimeout := opts.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = c.readTimeout()
+	}
+
+	env, err := c.newEnvironment(vhost)
+	if err != nil {
+		return nil, err
+	}
+	defer env.Close()
+
+	firstOffset, committedChunk, err := streamBounds(env, streamName)
+	if err != nil {
+		return nil, err
+	}
+	if firstOffset < 0 {
+		// Nothing has been written to the stream yet
+		return emptyBatch(opts.Offset, EndReasonEndOfStream, true, true), nil
+	}
+
+	s := &scanner{
+		env:            env,
+		streamName:     streamName,
+		committedChunk: committedChunk,
+		idleTimeout:    idleTimeout,
+	}
+
+	if opts.Backward {
+		batch, err = s.readBackward(ctx, uint64(firstOffset), opts.Offset, limit)
+	} else {
+		batch, err = s.readForward(ctx, uint64(firstOffset), opts.Offset, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	batch.setCursors()
+	return batch, nil
+}
+
+// StreamMessages hands messages to visit as they are consumed, buffering no
+// more than scanBuffer of them, until opts.Count messages were delivered,
+// opts.EndOffset was reached, the end of the stream was reached or the idle
+// timeout expired. It returns which of these ended the read; an error returned
+// by visit aborts the read and is returned as is.
+func (c *Connection) StreamMessages(ctx context.Context, vhost, streamName string, opts StreamOptions, visit func(Message) error) (reason string, err error) {
+	defer func(start time.Time) { c.observeRead("stream", start, reason, err) }(time.Now())
+
+	idleTimeout := opts.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = c.readTimeout()
+	}
+
+	env, err := c.newEnvironment(vhost)
+	if err != nil {
+		return "", err
+	}
+	defer env.Close()
+
+	firstOffset, committedChunk, err := streamBounds(env, streamName)
+	if err != nil {
+		return "", err
+	}
+	if firstOffset < 0 {
+		return EndReasonEndOfStream, nil
+	}
+
+	s := &scanner{
+		env:            env,
+		streamName:     streamName,
+		committedChunk: committedChunk,
+		idleTimeout:    idleTimeout,
+	}
+	return s.stream(ctx, opts, visit)
+}
+
+// stream is StreamMessages on an open scanner
+func (s *scanner) stream(ctx context.Context, opts StreamOptions, visit func(Message) error) (string, error) {
+	delivered := 0
+	reachedEnd := false
+	var visitErr error
+	reason, err := s.scan(ctx, opts.Offset, func(msg Message) bool {
+		if opts.EndOffset != nil && msg.Offset > *opts.EndOffset {
+			reachedEnd = true
+			return false
+		}
+		if visitErr = visit(msg); visitErr != nil {
+			return false
+		}
+		delivered++
+		if opts.EndOffset != nil && msg.Offset >= *opts.EndOffset {
+			reachedEnd = true
+			return false
+		}
+		return opts.Count <= 0 || delivered < opts.Count
+	})
+	if visitErr != nil {
+		return "", visitErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if reachedEnd {
+		return EndReasonEndOffset, nil
+	}
+	return reason, nil
+}
+
+// readForward reads up to limit messages starting at offset
+func (s *scanner) readForward(ctx context.Context, firstOffset, offset uint64, limit int) (*MessageBatch, error) {
+	messages := make([]Message, 0, limit)
+	reason, err := s.scan(ctx, offset, func(msg Message) bool {
+		messages = append(messages, msg)
+		return len(messages) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(messages) == 0 {
+		return emptyBatch(offset, reason, offset <= firstOffset, reason == EndReasonEndOfStream), nil
+	}
+
+	atEnd := reason == EndReasonEndOfStream
+	return &MessageBatch{
+		Messages:    messages,
+		StartOffset: messages[0].Offset,
+		EndOffset:   messages[len(messages)-1].Offset,
+		HasMore:     !atEnd && len(messages) == limit,
+		EndReason:   reason,
+		AtStart:     messages[0].Offset <= firstOffset,
+		AtEnd:       atEnd,
+	}, nil
+}
+
+// readBackward reads the last limit messages stored before offset. Offsets are
+// not guaranteed to be contiguous, so the search window is widened until enough
+// messages were found or the start of the stream was reached.
+func (s *scanner) readBackward(ctx context.Context, firstOffset, before uint64, limit int) (*MessageBatch, error) {
+	fromEnd := before == OffsetEnd
+	if !fromEnd && before <= firstOffset {
+		return emptyBatch(firstOffset, EndReasonStartOfStream, true, false), nil
+	}
+
+	// Reading towards the end of the stream starts from the last committed chunk,
+	// which may itself hold more than limit messages
+	upper := before
+	if fromEnd {
+		upper = uint64(s.committedChunk) + 1
+	}
+
+	window := uint64(limit)
+	var messages []Message
+	reason := EndReasonLimit
+	for i := 0; i < maxBackwardWindows; i++ {
+		start := firstOffset
+		if upper > firstOffset+window {
+			start = upper - window
+		}
+
+		messages = messages[:0]
+		scanReason, err := s.scan(ctx, start, func(msg Message) bool {
+			if !fromEnd && msg.Offset >= before {
+				return false
+			}
+			messages = append(messages, msg)
+			// Only the newest limit messages are kept
+			if len(messages) > limit {
+				messages = messages[1:]
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if scanReason == EndReasonTimeout {
+			reason = EndReasonTimeout
+		}
+
+		if len(messages) >= limit || start == firstOffset {
+			break
+		}
+		window *= 2
+	}
+
+	if len(messages) == 0 {
+		return emptyBatch(firstOffset, reason, true, fromEnd), nil
+	}
+
+	atStart := messages[0].Offset <= firstOffset
+	if atStart && len(messages) < limit {
+		reason = EndReasonStartOfStream
+	}
+
+	return &MessageBatch{
+		Messages:    messages,
+		StartOffset: messages[0].Offset,
+		EndOffset:   messages[len(messages)-1].Offset,
+		HasMore:     !atStart,
+		EndReason:   reason,
+		AtStart:     atStart,
+		AtEnd:       fromEnd,
+	}, nil
+}
+
+// scanner consumes a stream from a given offset, handing every message to a visitor
+type scanner struct {
+	env            *stream.Environment
+	streamName     string
+	committedChunk int64
+	idleTimeout    time.Duration
+	// subscribe starts delivering the messages from start to handle and
+	// returns a function stopping it; nil consumes the stream through env
+	subscribe func(start stream.OffsetSpecification, handle func(Message)) (func() error, error)
+}
+
+// scan delivers messages starting at offset to visit until it returns false, the
+// end of the stream has been delivered, or no message arrived within the idle
+// timeout. It reports which of these ended the scan.
+func (s *scanner) scan(ctx context.Context, offset uint64, visit func(Message) bool) (string, error) {
+	return s.scanFrom(ctx, stream.OffsetSpecification{}.Offset(int64(offset)), int64(offset) >= s.committedChunk, visit)
+}
+
+// consume starts a consumer handing every message to handle
+func (s *scanner) consume(start stream.OffsetSpecification, handle func(Message)) (func() error, error) {
+	if s.subscribe != nil {
+		return s.subscribe(start, handle)
+	}
+	consumer, err := s.env.NewConsumer(
+		s.streamName,
+		func(consumerContext stream.ConsumerContext, message *amqp.Message) {
+			msg := NewMessage(uint64(consumerContext.Consumer.GetOffset()), message)
+			msg.SetStoredAt(consumerContext.GetChunkTimestamp())
+			handle(msg)
+		},
+		stream.NewConsumerOptions().SetOffset(start),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return consumer.Close, nil
+}
+
+// scanFrom is scan for an arbitrary start specification. inLastChunk reports
+// whether the start is already known to be within the last committed chunk.
+//
+// The consumer hands messages over through a bounded buffer and visit runs on
+// the caller's goroutine, so a visitor writing to a slow client holds the
+// consumer back rather than losing messages. The idle and end-of-stream
+// timers only run while waiting for the broker, never while visit does.
+func (s *scanner) scanFrom(ctx context.Context, start stream.OffsetSpecification, inLastChunk bool, visit func(Message) bool) (string, error) {
+	deliveries := make(chan Message, scanBuffer)
+	quit := make(chan struct{})
+
+	stop, err := s.consume(start, func(msg Message) {
+		select {
+		case deliveries <- msg:
+		case <-quit:
+		}
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create consumer: %w", err)
+	}
+	// Release a consumer blocked on the buffer before closing it, it may still be mid-chunk
+	defer func() {
+		close(quit)
+		stop()
+	}()
+
+	idle := time.NewTimer(s.idleTimeout)
+	defer idle.Stop()
+
+	// Once the committed chunk is being delivered the remaining messages arrive
+	// back to back, so a short quiet period means the end of the stream was reached
+	var grace *time.Timer
+	var endOfStream <-chan time.Time
+	startGrace := func() {
+		if grace == nil {
+			grace = time.NewTimer(endOfStreamGrace)
+			endOfStream = grace.C
+		} else {
+			grace.Reset(endOfStreamGrace)
+		}
+	}
+	defer func() {
+		if grace != nil {
+			grace.Stop()
+		}
+	}()
+	if inLastChunk {
+		startGrace()
+	}
+
+	for {
+		select {
+		case msg := <-deliveries:
+			if !visit(msg) {
+				return EndReasonLimit, nil
+			}
+			// Timers restart once visit returned, however long it took
+			idle.Reset(s.idleTimeout)
+			if endOfStream != nil || int64(msg.Offset) >= s.committedChunk {
+				startGrace()
+			}
+		case <-endOfStream:
+			return EndReasonEndOfStream, nil
+		case <-idle.C:
+			return EndReasonTimeout, nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// OffsetForTime returns the first offset of the first chunk the broker stored at or
+// after t. Chunks are timestamped as a whole, so messages of the preceding chunk may
+// be slightly newer than t. found is false when nothing was stored after t.
+func (c *Connection) OffsetForTime(ctx context.Context, vhost, streamName string, t time.Time) (offset uint64, found bool, err error) {
+	if t.After(time.Now()) {
+		return 0, false, nil
+	}
+
+	env, err := c.newEnvironment(vhost)
+	if err != nil {
+		return 0, false, err
+	}
+	defer env.Close()
+
+	firstOffset, committedChunk, err := streamBounds(env, streamName)
+	if err != nil {
+		return 0, false, err
+	}
+	if firstOffset < 0 {
+		return 0, false, nil
+	}
+
+	s := &scanner{
+		env:            env,
+		streamName:     streamName,
+		committedChunk: committedChunk,
+		idleTimeout:    c.readTimeout(),
+	}
+	return s.first(ctx, stream.OffsetSpecification{}.Timestamp(t.UnixMilli()))
+}
+
+// first returns the offset of the first message delivered from start. The broker
+// may take a while to locate the chunk, so only the idle timeout bounds the wait,
+// and nothing arriving within it means there is nothing to deliver.
+func (s *scanner) first(ctx context.Context, start stream.OffsetSpecification) (offset uint64, found bool, err error) {
+	_, err = s.scanFrom(ctx, start, false, func(msg Message) bool {
+		offset = msg.Offset
+		found = true
+		return false
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return offset, found, nil
+}
+
+// readTimeout returns the configured idle timeout for reads on this connection
+func (c *Connection) readTimeout() time.Duration {
+	if c.Config.ReadTimeout > 0 {
+		return c.Config.ReadTimeout
+	}
+	return config.DefaultReadTimeout
+}
+
+// MaxReadTimeout returns the longest timeout a read on this connection may request
+func (c *Connection) MaxReadTimeout() time.Duration {
+	if c.Config.MaxReadTimeout > 0 {
+		return c.Config.MaxReadTimeout
+	}
+	return config.DefaultMaxReadTimeout
+}
+
+// emptyBatch returns a batch without messages positioned at offset
+func emptyBatch(offset uint64, reason string, atStart, atEnd bool) *MessageBatch {
+	return &MessageBatch{
+		Messages:    []Message{},
+		StartOffset: offset,
+		EndOffset:   offset,
+		HasMore:     false,
+		EndReason:   reason,
+		AtStart:     atStart,
+		AtEnd:       atEnd,
+	}
+}
+
+// ReadMessageFromVHost reads exactly the message stored at the given offset.
+// It returns ErrMessageNotFound when the offset has been truncated or not yet written.
+func (c *Connection) ReadMessageFromVHost(ctx context.Context, vhost, streamName string, offset uint64) (*Message, error) {
+	batch, err := c.ReadMessagesFromVHost(ctx, vhost, streamName, offset, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// The broker delivers from the first available offset when the requested one
+	// was truncated, so only an exact match counts
+	if len(batch.Messages) == 0 || batch.Messages[0].Offset != offset {
+		return nil, fmt.Errorf("%w at offset %d", ErrMessageNotFound, offset)
+	}
+
+	return &batch.Messages[0], nil
+}
@@ -0,0 +1,246 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/stream"
+)
+
+var (
+	// ErrAccessDenied is returned when the broker refuses a call with the
+	// connection's credentials
+	ErrAccessDenied = errors.New("access denied by the broker")
+	// ErrCredentialsRejected is returned when no connection accepts a user's credentials
+	ErrCredentialsRejected = errors.New("the broker rejected the credentials")
+	// ErrNoSession is returned for connections using their users' credentials
+	// when the user has no session with them
+	ErrNoSession = errors.New("this connection uses your own RabbitMQ credentials, log in with them")
+)
+
+// IsAccessDenied reports whether the broker refused a management or stream
+// protocol call for lack of permissions or valid credentials
+func IsAccessDenied(err error) bool {
+	return errors.Is(err, ErrAccessDenied) ||
+		errors.Is(err, stream.AuthenticationFailure) ||
+		errors.Is(err, stream.VirtualHostAccessFailure) ||
+		errors.Is(err, stream.CodeAccessRefused)
+}
+
+// managementError reports an unsuccessful management API response
+func managementError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	err := fmt.Errorf("management API returned %d: %s", resp.StatusCode, string(body))
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%w: %v", ErrAccessDenied, err)
+	}
+	return err
+}
+
+// streamPassword is the password stream connections authenticate with; the
+// OAuth 2 plugin takes the access token in its place
+func (c Credentials) streamPassword() string {
+	if c.Token != "" {
+		return c.Token
+	}
+	return c.Password
+}
+
+// BrokerUser is the broker's view of the user a connection authenticates as
+type BrokerUser struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// WhoAmI returns the user the connection's credentials authenticate as
+func (c *Connection) WhoAmI(ctx context.Context) (*BrokerUser, error) {
+	var who struct {
+		Name string      `json:"name"`
+		Tags interface{} `json:"tags"`
+	}
+	if err := c.management(ctx, http.MethodGet, "/api/whoami", nil, &who); err != nil {
+		return nil, err
+	}
+
+	user := &BrokerUser{Name: who.Name}
+	// Brokers before 3.10 report the tags as one comma-separated string
+	switch tags := who.Tags.(type) {
+	case string:
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				user.Tags = append(user.Tags, tag)
+			}
+		}
+	case []interface{}:
+		for _, tag := range tags {
+			if s, ok := tag.(string); ok && s != "" {
+				user.Tags = append(user.Tags, s)
+			}
+		}
+	}
+	return user, nil
+}
+
+// Session holds the connections of a user logged in with their own broker
+// credentials. Its connections share nothing with other users': every
+// management call and stream environment authenticates as the user, so the
+// broker's permissions decide what they see and its logs name them.
+type Session struct {
+	ID string
+	// User and Tags are the broker's name and tags for the user
+	User    string
+	Tags    []string
+	Expires time.Time
+
+	// connections are those that accepted the credentials, by ID
+	connections map[string]*Connection
+}
+
+// Connections returns the IDs of the connections that accepted the credentials
+func (s *Session) Connections() []string {
+	ids := make([]string, 0, len(s.connections))
+	for id := range s.connections {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// OpenSession checks a user's credentials against every connection using its
+// users' credentials and keeps the connections that accept them for ttl.
+// It fails with ErrCredentialsRejected when none does.
+func (m *Manager) OpenSession(ctx context.Context, creds Credentials, ttl time.Duration) (*Session, error) {
+	m.mu.RLock()
+	var connections []*Connection
+	for _, cfg := range m.configs {
+		if conn, ok := m.connections[cfg.ID]; ok && cfg.UserCredentials() {
+			connections = append(connections, conn)
+		}
+	}
+	m.mu.RUnlock()
+	if len(connections) == 0 {
+		return nil, errors.New("no connection uses its users' credentials")
+	}
+
+	session := &Session{
+		ID:          uuid.NewString(),
+		Expires:     m.now().Add(ttl),
+		connections: make(map[string]*Connection),
+	}
+	var firstErr error
+	for _, conn := range connections {
+		userConn := conn.WithCredentials(creds)
+		who, err := userConn.WhoAmI(ctx)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", conn.ID, err)
+			}
+			continue
+		}
+		if session.User == "" {
+			session.User, session.Tags = who.Name, who.Tags
+		}
+		session.connections[conn.ID] = userConn
+	}
+	if len(session.connections) == 0 {
+		if IsAccessDenied(firstErr) {
+			return nil, fmt.Errorf("%w: %v", ErrCredentialsRejected, firstErr)
+		}
+		return nil, firstErr
+	}
+
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+	now := m.now()
+	for id, s := range m.sessions {
+		if !now.Before(s.Expires) {
+			delete(m.sessions, id)
+		}
+	}
+	m.sessions[session.ID] = session
+	return session, nil
+}
+
+// Session returns an unexpired session by ID
+func (m *Manager) Session(id string) (*Session, bool) {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	if !m.now().Before(s.Expires) {
+		delete(m.sessions, id)
+		return nil, false
+	}
+	return s, true
+}
+
+// CloseSession ends a session; its connections stop being used at once
+func (m *Manager) CloseSession(id string) {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+	delete(m.sessions, id)
+}
+
+// ConnectionFor returns the connection a user's calls go through: the user's
+// own for connections using their users' credentials, the shared one for the
+// others. session is the ID of the user's session, if any.
+func (m *Manager) ConnectionFor(session, id string) (*Connection, error) {
+	conn, err := m.GetConnection(id)
+	if err != nil || !conn.Config.UserCredentials() {
+		return conn, err
+	}
+	if session == "" {
+		return nil, ErrNoSession
+	}
+	s, ok := m.Session(session)
+	if !ok {
+		return nil, ErrNoSession
+	}
+	userConn, ok := s.connections[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s rejected your credentials when you logged in", ErrAccessDenied, id)
+	}
+	return userConn, nil
+}
+
+// connectionsFor returns the connections a user's listings cover: the shared
+// ones and those of the user's session
+func (m *Manager) connectionsFor(session string) []*Connection {
+	var s *Session
+	if session != "" {
+		s, _ = m.Session(session)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var connections []*Connection
+	for _, conn := range m.connections {
+		if !conn.Config.UserCredentials() {
+			connections = append(connections, conn)
+		} else if s != nil && s.connections[conn.ID] != nil {
+			connections = append(connections, s.connections[conn.ID])
+		}
+	}
+	return connections
+}
+
+// ListVHostsFor returns the vhosts and streams a user sees across all
+// connections; session is the ID of the user's session, if any
+func (m *Manager) ListVHostsFor(ctx context.Context, session string) ([]VHost, error) {
+	return listVHosts(ctx, m.connectionsFor(session))
+}
+
+// ListStreamsFor returns the streams a user sees across all connections
+func (m *Manager) ListStreamsFor(ctx context.Context, session string) ([]Stream, error) {
+	return listStreams(ctx, m.connectionsFor(session))
+}
@@ -1,6 +1,10 @@
 package rabbitmq
 
-import "time"
+import (
+	"time"
+
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+)
 
 // VHost represents a virtual host
 type VHost struct {
@@ -27,17 +31,71 @@ type StreamStats struct {
 
 // Message represents a message from a stream
 type Message struct {
-	Offset     uint64                 `json:"offset"`
-	Timestamp  time.Time              `json:"timestamp"`
-	Data       []byte                 `json:"data"`
-	Properties map[string]interface{} `json:"properties"`
+	Offset uint64 `json:"offset"`
+	// CreationTime is the AMQP creation-time property, only set when the producer provided it
+	CreationTime *time.Time `json:"creation_time,omitempty"`
+	// StoredAt is the chunk timestamp the broker assigned when the message was written
+	StoredAt *time.Time `json:"stored_at,omitempty"`
+	// PublishLatencyMs is StoredAt minus CreationTime, when both are known
+	PublishLatencyMs *int64                 `json:"publish_latency_ms,omitempty"`
+	Data             []byte                 `json:"data"`
+	Properties       map[string]interface{} `json:"properties"`
+	// Redacted reports that parts of the message were masked before it was returned
+	Redacted bool `json:"redacted,omitempty"`
+
+	// raw is the decoded AMQP message, kept for exports that need every section
+	raw *amqp.Message
 }
 
+// Reasons a batch read stopped, reported in MessageBatch.EndReason
+const (
+	EndReasonLimit         = "limit"
+	EndReasonEndOfStream   = "end_of_stream"
+	EndReasonStartOfStream = "start_of_stream"
+	EndReasonTimeout       = "timeout"
+	// EndReasonEndOffset streamed reads delivered the requested end offset
+	EndReasonEndOffset = "end_offset"
+)
+
+// endOfStreamGrace is how long to wait for more messages once the last committed chunk is being delivered
+const endOfStreamGrace = 250 * time.Millisecond
+
+// scanBuffer is how many consumed messages wait for a slow visitor before the
+// consumer is held back
+const scanBuffer = 256
+
 // MessageBatch represents a batch of messages with metadata
 type MessageBatch struct {
-	Messages   []Message `json:"messages"`
+	Messages    []Message `json:"messages"`
 	StartOffset uint64    `json:"start_offset"`
 	EndOffset   uint64    `json:"end_offset"`
 	HasMore     bool      `json:"has_more"`
+	EndReason   string    `json:"end_reason"`
+	// AtStart and AtEnd report whether the batch touches the stream's first or last message
+	AtStart bool `json:"at_start"`
+	AtEnd   bool `json:"at_end"`
+	// NextCursor continues after this batch; PrevCursor pages backward and is empty at the start of the stream
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// ReadOptions controls how a batch of messages is read from a stream
+type ReadOptions struct {
+	Offset uint64
+	Limit  int
+	// IdleTimeout bounds how long to wait for the next message; zero uses the connection's read_timeout
+	IdleTimeout time.Duration
+	// Backward returns the Limit messages immediately before Offset instead of from it
+	Backward bool
 }
 
+// StreamOptions controls an unbuffered read of an arbitrarily large range
+type StreamOptions struct {
+	Offset uint64
+	// Count stops the read after this many messages; zero reads until the end of the stream
+	Count int
+	// EndOffset, when set, is the last offset delivered
+	EndOffset *uint64
+	// IdleTimeout bounds how long to wait for the next message; zero uses the connection's read_timeout
+	IdleTimeout time.Duration
+}
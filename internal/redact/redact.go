@@ -0,0 +1,309 @@
+// Package redact masks sensitive parts of messages before the viewer returns
+// them: fields of JSON bodies selected by JSON paths, application properties
+// and regular expression matches in text. Rules apply to streams matching
+// their connection, vhost and stream patterns.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// Redactor holds the compiled redaction rules
+type Redactor struct {
+	mask  string
+	rules []*rule
+}
+
+type rule struct {
+	cfg      config.RedactionRule
+	paths    [][]segment
+	patterns []*regexp.Regexp
+}
+
+// segment is one step of a JSON path: a key, an array index, any child (*),
+// optionally at any depth (..)
+type segment struct {
+	key     string
+	index   int
+	any     bool
+	descend bool
+}
+
+// New compiles the redaction rules
+func New(cfg config.RedactionConfig) (*Redactor, error) {
+	r := &Redactor{mask: cfg.Mask}
+	if r.mask == "" {
+		r.mask = config.DefaultRedactionMask
+	}
+	for _, rc := range cfg.Rules {
+		compiled := &rule{cfg: rc}
+		for _, p := range rc.JSONPaths {
+			segments, err := parsePath(p)
+			if err != nil {
+				return nil, fmt.Errorf("redaction rule '%s': %w", rc.Name, err)
+			}
+			compiled.paths = append(compiled.paths, segments)
+		}
+		for _, pattern := range rc.Patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("redaction rule '%s': %w", rc.Name, err)
+			}
+			compiled.patterns = append(compiled.patterns, re)
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+// Masker masks the messages of one stream. A nil Masker returns messages unchanged.
+type Masker struct {
+	mask  string
+	rules []*rule
+}
+
+// For returns the masker of a stream, or nil when no rule applies to it
+func (r *Redactor) For(connection, vhost, stream string) *Masker {
+	if r == nil {
+		return nil
+	}
+	var rules []*rule
+	for _, rl := range r.rules {
+		if config.MatchPattern(rl.cfg.Connection, connection) && config.MatchPattern(rl.cfg.VHost, vhost) &&
+			config.MatchPattern(rl.cfg.Stream, stream) {
+			rules = append(rules, rl)
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	return &Masker{mask: r.mask, rules: rules}
+}
+
+// Apply returns the message with every matched field masked. Messages nothing
+// matched in are returned as they are.
+func (m *Masker) Apply(msg rabbitmq.Message) rabbitmq.Message {
+	if m == nil {
+		return msg
+	}
+	data, bodyChanged := m.body(msg.Data)
+	appProps, propsChanged := m.appProperties(msg.Properties["application_properties"])
+	if !bodyChanged && !propsChanged {
+		return msg
+	}
+	return msg.WithRedactions(data, appProps)
+}
+
+// body masks JSON paths and patterns in JSON bodies, and patterns in other text bodies
+func (m *Masker) body(data []byte) ([]byte, bool) {
+	if len(data) == 0 {
+		return data, false
+	}
+
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err == nil && !dec.More() {
+		changed := false
+		for _, rl := range m.rules {
+			for _, segments := range rl.paths {
+				var c bool
+				doc, c = maskPath(doc, segments, m.mask)
+				changed = changed || c
+			}
+			for _, re := range rl.patterns {
+				var c bool
+				doc, c = maskStrings(doc, re, m.mask)
+				changed = changed || c
+			}
+		}
+		if !changed {
+			return data, false
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(doc); err != nil {
+			// Never return the original of a body something was masked in
+			return []byte(m.mask), true
+		}
+		return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), true
+	}
+
+	if !utf8.Valid(data) {
+		return data, false
+	}
+	text := string(data)
+	for _, rl := range m.rules {
+		for _, re := range rl.patterns {
+			text = re.ReplaceAllLiteralString(text, m.mask)
+		}
+	}
+	if text == string(data) {
+		return data, false
+	}
+	return []byte(text), true
+}
+
+// appProperties masks the values of matching application property keys
+func (m *Masker) appProperties(value interface{}) (map[string]interface{}, bool) {
+	props, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	masked := make(map[string]interface{}, len(props))
+	changed := false
+	for key, v := range props {
+		masked[key] = v
+		for _, rl := range m.rules {
+			for _, pattern := range rl.cfg.AppProperties {
+				if matched, _ := path.Match(pattern, key); matched {
+					masked[key] = m.mask
+					changed = true
+				}
+			}
+		}
+	}
+	return masked, changed
+}
+
+// parsePath parses JSON paths such as $.customer.email, $.items[*].card,
+// $.items[0].sku, $['first name'] and $..email
+func parsePath(p string) ([]segment, error) {
+	if !strings.HasPrefix(p, "$") {
+		return nil, fmt.Errorf("json path '%s' must start with $", p)
+	}
+	rest := p[1:]
+	var segments []segment
+	for rest != "" {
+		seg := segment{index: -1}
+		switch {
+		case strings.HasPrefix(rest, "..") || rest[0] == '.':
+			if strings.HasPrefix(rest, "..") {
+				seg.descend = true
+				rest = rest[2:]
+			} else {
+				rest = rest[1:]
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			seg.key, rest = rest[:end], rest[end:]
+			if seg.key == "" {
+				return nil, fmt.Errorf("json path '%s': empty field name", p)
+			}
+			seg.any = seg.key == "*"
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("json path '%s': unclosed [", p)
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				seg.any = true
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				seg.key = inner[1 : len(inner)-1]
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil || i < 0 {
+					return nil, fmt.Errorf("json path '%s': invalid index [%s]", p, inner)
+				}
+				seg.index = i
+			}
+		default:
+			return nil, fmt.Errorf("json path '%s': expected . or [ at '%s'", p, rest)
+		}
+		segments = append(segments, seg)
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("json path '%s' selects no field", p)
+	}
+	return segments, nil
+}
+
+// matchesKey reports whether a segment selects an object key
+func (s segment) matchesKey(key string) bool {
+	return s.any || (s.index < 0 && s.key == key)
+}
+
+// matchesIndex reports whether a segment selects an array element; .0 selects
+// the first element like [0] does
+func (s segment) matchesIndex(i int) bool {
+	return s.any || s.index == i || (s.index < 0 && s.key == strconv.Itoa(i))
+}
+
+// maskPath replaces the values selected by segments with the mask and reports
+// whether any was
+func maskPath(v interface{}, segments []segment, mask string) (interface{}, bool) {
+	if len(segments) == 0 {
+		return mask, true
+	}
+	seg, next := segments[0], segments[1:]
+
+	changed := false
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for key, child := range node {
+			var c bool
+			if seg.matchesKey(key) {
+				child, c = maskPath(child, next, mask)
+				node[key] = child
+				changed = changed || c
+			}
+			if seg.descend {
+				node[key], c = maskPath(child, segments, mask)
+				changed = changed || c
+			}
+		}
+	case []interface{}:
+		for i, child := range node {
+			var c bool
+			if seg.matchesIndex(i) {
+				child, c = maskPath(child, next, mask)
+				node[i] = child
+				changed = changed || c
+			}
+			if seg.descend {
+				node[i], c = maskPath(child, segments, mask)
+				changed = changed || c
+			}
+		}
+	}
+	return v, changed
+}
+
+// maskStrings replaces pattern matches in every string value of a JSON document
+func maskStrings(v interface{}, re *regexp.Regexp, mask string) (interface{}, bool) {
+	changed := false
+	switch node := v.(type) {
+	case string:
+		masked := re.ReplaceAllLiteralString(node, mask)
+		return masked, masked != node
+	case map[string]interface{}:
+		for key, child := range node {
+			var c bool
+			node[key], c = maskStrings(child, re, mask)
+			changed = changed || c
+		}
+	case []interface{}:
+		for i, child := range node {
+			var c bool
+			node[i], c = maskStrings(child, re, mask)
+			changed = changed || c
+		}
+	}
+	return v, changed
+}
@@ -0,0 +1,114 @@
+package redact
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+func testMessage(body string, appProps map[string]interface{}) rabbitmq.Message {
+	return rabbitmq.NewMessage(7, &amqp.Message{Data: [][]byte{[]byte(body)}, ApplicationProperties: appProps})
+}
+
+func newTestRedactor(t *testing.T, rules ...config.RedactionRule) *Redactor {
+	t.Helper()
+	r, err := New(config.RedactionConfig{Mask: "***", Rules: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestApply_JSONPaths(t *testing.T) {
+	r := newTestRedactor(t, config.RedactionRule{
+		Name:      "pii",
+		JSONPaths: []string{"$.customer.email", "$.items[*].card", "$..ssn", "$['full name']"},
+	})
+	m := r.For("prod", "/", "orders")
+
+	body := `{"customer":{"email":"a@example.com","id":42},"items":[{"card":"4111","sku":"x"},{"sku":"y"}],` +
+		`"nested":{"deep":{"ssn":"123-45-6789"}},"full name":"Ada <Lovelace>","total":12.50}`
+	got := m.Apply(testMessage(body, nil))
+	want := `{"customer":{"email":"***","id":42},"full name":"***","items":[{"card":"***","sku":"x"},{"sku":"y"}],` +
+		`"nested":{"deep":{"ssn":"***"}},"total":12.50}`
+	if string(got.Data) != want || !got.Redacted {
+		t.Errorf("unexpected masked body:\n got %s\nwant %s", got.Data, want)
+	}
+
+	// Messages without matches are returned untouched
+	untouched := `{"customer": {"id": 42}}`
+	if got := m.Apply(testMessage(untouched, nil)); string(got.Data) != untouched || got.Redacted {
+		t.Errorf("expected the body to be left alone, got %s", got.Data)
+	}
+}
+
+func TestApply_PatternsAndProperties(t *testing.T) {
+	r := newTestRedactor(t, config.RedactionRule{
+		Name:          "emails",
+		Stream:        "orders*",
+		AppProperties: []string{"customer_*", "token"},
+		Patterns:      []string{`[\w.+-]+@[\w-]+\.[\w.]+`},
+	})
+	if r.For("prod", "/", "payments") != nil {
+		t.Fatal("expected no masker for a stream the rule doesn't match")
+	}
+	m := r.For("prod", "/", "orders-eu")
+
+	msg := testMessage("shipped to jane@example.com today", map[string]interface{}{
+		"customer_email": "jane@example.com", "token": "s3cret", "region": "eu",
+	})
+	got := m.Apply(msg)
+	if string(got.Data) != "shipped to *** today" {
+		t.Errorf("unexpected masked text: %s", got.Data)
+	}
+	props := got.Properties["application_properties"].(map[string]interface{})
+	if props["customer_email"] != "***" || props["token"] != "***" || props["region"] != "eu" {
+		t.Errorf("unexpected masked properties: %v", props)
+	}
+
+	// Exports carry the masked sections too, and the original is left intact
+	exported := got.Export()
+	if exported.ApplicationProperties["token"] != "***" || !strings.Contains(string(exported.Body), "***") {
+		t.Errorf("expected the export to be masked, got %+v", exported)
+	}
+	if msg.Export().ApplicationProperties["token"] != "s3cret" {
+		t.Error("expected the original message to be left intact")
+	}
+
+	// Patterns apply to the string values of JSON bodies
+	got = m.Apply(testMessage(`{"note":"mail jane@example.com"}`, nil))
+	if string(got.Data) != `{"note":"mail ***"}` {
+		t.Errorf("unexpected masked JSON: %s", got.Data)
+	}
+
+	// Binary bodies are left as they are
+	binary := string([]byte{0xff, 0xfe, 'a', '@', 'b', '.', 'c'})
+	if got := m.Apply(testMessage(binary, nil)); string(got.Data) != binary {
+		t.Errorf("expected the binary body to be left alone, got %q", got.Data)
+	}
+
+	var nilMasker *Masker
+	if got := nilMasker.Apply(msg); string(got.Data) != string(msg.Data) {
+		t.Error("expected a nil masker to return the message unchanged")
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	for _, p := range []string{"$.a", "$.items[0].sku", "$.items.0.sku", "$..email", "$.*", "$[\"a b\"]"} {
+		if _, err := parsePath(p); err != nil {
+			t.Errorf("%s: %v", p, err)
+		}
+	}
+	for _, p := range []string{"$", "a.b", "$.", "$.items[", "$.items[-1]", "$x"} {
+		if _, err := parsePath(p); err == nil {
+			t.Errorf("%s: expected an error", p)
+		}
+	}
+	if _, err := New(config.RedactionConfig{Rules: []config.RedactionRule{{Name: "bad", JSONPaths: []string{"$.a["}}}}); err == nil {
+		t.Error("expected New to reject an invalid path")
+	}
+}
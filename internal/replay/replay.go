@@ -0,0 +1,274 @@
+// Package replay republishes messages from an NDJSON export into a stream.
+// It is shared by the replay API endpoint and the replay command.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// progressInterval is how many read messages pass between progress reports
+const progressInterval = 1000
+
+// Options selects which exported messages are replayed and how fast
+type Options struct {
+	// FromOffset and ToOffset, when set, bound the source offsets replayed (inclusive)
+	FromOffset *uint64
+	ToOffset   *uint64
+	// From and To select messages by their exported timestamp, stored_at or
+	// else creation_time; messages with neither are skipped. To is exclusive.
+	From *time.Time
+	To   *time.Time
+	// Rate caps the messages published per second; zero is unlimited
+	Rate float64
+	// OriginalPace reproduces the gaps between the exported timestamps, divided
+	// by Speed; messages without one are published without waiting
+	OriginalPace bool
+	Speed        float64
+	// ProducerName enables deduplication: each message is published with its
+	// source offset + 1 as publishing ID, so re-running a replay with the same
+	// name skips what was already published
+	ProducerName string
+	// Progress, when set, is called periodically and once at the end
+	Progress func(Progress)
+}
+
+// Validate checks that the options are consistent
+func (o Options) Validate() error {
+	if o.FromOffset != nil && o.ToOffset != nil && *o.ToOffset < *o.FromOffset {
+		return errors.New("to offset must not be before from offset")
+	}
+	if o.From != nil && o.To != nil && !o.To.After(*o.From) {
+		return errors.New("to must be after from")
+	}
+	if o.Rate < 0 {
+		return errors.New("rate must not be negative")
+	}
+	if o.Speed < 0 {
+		return errors.New("speed must not be negative")
+	}
+	return nil
+}
+
+// Progress reports how far a replay has got
+type Progress struct {
+	Read      int    `json:"read"`
+	Published int    `json:"published"`
+	Skipped   int    `json:"skipped"`
+	Offset    uint64 `json:"offset"`
+}
+
+// Result summarises a finished replay
+type Result struct {
+	Progress
+	Confirmed int `json:"confirmed"`
+	Failed    int `json:"failed"`
+}
+
+// sender is the part of rabbitmq.Publisher a replay needs
+type sender interface {
+	Send(msg *amqp.Message, publishingID int64) error
+	LastPublishingID() (int64, error)
+	Wait(ctx context.Context) error
+	Stats() rabbitmq.PublishStats
+}
+
+// Run replays the NDJSON export read from r into a stream and waits for the
+// broker to confirm every published message
+func Run(ctx context.Context, r io.Reader, conn *rabbitmq.Connection, vhost, streamName string, opts Options) (*Result, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	publisher, err := conn.NewPublisher(vhost, streamName, rabbitmq.PublisherOptions{Name: opts.ProducerName})
+	if err != nil {
+		return nil, err
+	}
+	defer publisher.Close()
+
+	return replay(ctx, r, publisher, opts)
+}
+
+func replay(ctx context.Context, r io.Reader, s sender, opts Options) (*Result, error) {
+	result := &Result{}
+	report := func() {
+		if opts.Progress != nil {
+			opts.Progress(result.Progress)
+		}
+	}
+
+	var lastPublished int64 = -1
+	if opts.ProducerName != "" {
+		last, err := s.LastPublishingID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to query last publishing ID: %w", err)
+		}
+		lastPublished = last
+	}
+
+	p := newPacer(opts)
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return result, readErr
+		}
+
+		if len(bytes.TrimSpace(data)) > 0 {
+			msg, err := rabbitmq.DecodeExportedMessage(data)
+			if err != nil {
+				return result, fmt.Errorf("line %d: %w", line, err)
+			}
+
+			done, err := replayMessage(ctx, s, opts, p, lastPublished, msg, result)
+			if err != nil {
+				return result, err
+			}
+			if done {
+				break
+			}
+			if result.Read%progressInterval == 0 {
+				report()
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	err := s.Wait(ctx)
+	stats := s.Stats()
+	result.Confirmed = stats.Confirmed
+	result.Failed = stats.Failed
+	report()
+	return result, err
+}
+
+// replayMessage publishes one exported message if it is selected. It reports
+// done once the export has moved past the selected offset range.
+func replayMessage(ctx context.Context, s sender, opts Options, p *pacer, lastPublished int64, msg rabbitmq.ExportedMessage, result *Result) (bool, error) {
+	if opts.ToOffset != nil && msg.Offset > *opts.ToOffset {
+		// Exports are ordered by offset
+		return true, nil
+	}
+	result.Read++
+	result.Offset = msg.Offset
+
+	timestamp, hasTimestamp := msg.Timestamp()
+	if opts.FromOffset != nil && msg.Offset < *opts.FromOffset ||
+		(opts.From != nil || opts.To != nil) && !hasTimestamp ||
+		opts.From != nil && timestamp.Before(*opts.From) ||
+		opts.To != nil && !timestamp.Before(*opts.To) {
+		return false, nil
+	}
+
+	publishingID := int64(-1)
+	if opts.ProducerName != "" {
+		publishingID = int64(msg.Offset) + 1
+		if publishingID <= lastPublished {
+			result.Skipped++
+			return false, nil
+		}
+	}
+
+	if err := p.wait(ctx, timestamp, hasTimestamp); err != nil {
+		return false, err
+	}
+	if err := s.Send(msg.AMQPMessage(), publishingID); err != nil {
+		return false, fmt.Errorf("offset %d: %w", msg.Offset, err)
+	}
+	result.Published++
+	return false, nil
+}
+
+// pacer spaces out publishes to honour the rate limit and original pace
+type pacer struct {
+	interval     time.Duration
+	originalPace bool
+	speed        float64
+	sleep        func(ctx context.Context, d time.Duration) error
+	now          func() time.Time
+
+	started    bool
+	firstSent  time.Time
+	firstStamp time.Time
+	lastSent   time.Time
+}
+
+func newPacer(opts Options) *pacer {
+	p := &pacer{
+		originalPace: opts.OriginalPace,
+		speed:        opts.Speed,
+		sleep:        sleep,
+		now:          time.Now,
+	}
+	if p.speed == 0 {
+		p.speed = 1
+	}
+	if opts.Rate > 0 {
+		p.interval = time.Duration(float64(time.Second) / opts.Rate)
+	}
+	return p
+}
+
+// wait blocks until the next message may be published
+func (p *pacer) wait(ctx context.Context, timestamp time.Time, hasTimestamp bool) error {
+	now := p.now()
+	if !p.started {
+		p.started = true
+		p.lastSent = now
+		p.anchor(now, timestamp, hasTimestamp)
+		return nil
+	}
+
+	next := now
+	if p.interval > 0 {
+		if due := p.lastSent.Add(p.interval); due.After(next) {
+			next = due
+		}
+	}
+	if p.originalPace && hasTimestamp && !p.anchor(now, timestamp, hasTimestamp) {
+		gap := time.Duration(float64(timestamp.Sub(p.firstStamp)) / p.speed)
+		if due := p.firstSent.Add(gap); due.After(next) {
+			next = due
+		}
+	}
+
+	if next.After(now) {
+		if err := p.sleep(ctx, next.Sub(now)); err != nil {
+			return err
+		}
+	}
+	p.lastSent = next
+	return nil
+}
+
+// anchor records the first timestamped message original-pace gaps are
+// measured from. It reports whether this message became the anchor.
+func (p *pacer) anchor(now, timestamp time.Time, hasTimestamp bool) bool {
+	if !hasTimestamp || !p.firstStamp.IsZero() {
+		return false
+	}
+	p.firstSent, p.firstStamp = now, timestamp
+	return true
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
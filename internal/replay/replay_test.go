@@ -0,0 +1,175 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// fakeSender records published messages and confirms them immediately
+type fakeSender struct {
+	lastPublishingID int64
+	sent             []*amqp.Message
+	publishingIDs    []int64
+}
+
+func (f *fakeSender) Send(msg *amqp.Message, publishingID int64) error {
+	f.sent = append(f.sent, msg)
+	f.publishingIDs = append(f.publishingIDs, publishingID)
+	return nil
+}
+
+func (f *fakeSender) LastPublishingID() (int64, error) { return f.lastPublishingID, nil }
+
+func (f *fakeSender) Wait(ctx context.Context) error { return nil }
+
+func (f *fakeSender) Stats() rabbitmq.PublishStats {
+	return rabbitmq.PublishStats{Sent: len(f.sent), Confirmed: len(f.sent)}
+}
+
+// exportLines builds an NDJSON export of messages one second apart
+func exportLines(t *testing.T, offsets ...uint64) string {
+	t.Helper()
+	base := time.Date(2025, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	var lines []string
+	for _, offset := range offsets {
+		created := base.Add(time.Duration(offset) * time.Second)
+		data, err := json.Marshal(rabbitmq.ExportedMessage{
+			Offset:                offset,
+			Properties:            &rabbitmq.ExportedProperties{Subject: "s", CreationTime: &created},
+			ApplicationProperties: map[string]interface{}{"counter": offset},
+			Body:                  []byte("body"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(data))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestReplay_SubRanges(t *testing.T) {
+	from, to := uint64(2), uint64(6)
+	start := time.Date(2025, 10, 16, 12, 0, 3, 0, time.UTC)
+
+	s := &fakeSender{}
+	result, err := replay(context.Background(), strings.NewReader(exportLines(t, 0, 1, 2, 3, 4, 5, 6, 7, 8)), s, Options{
+		FromOffset: &from,
+		ToOffset:   &to,
+		From:       &start,
+	})
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	if result.Published != 4 || len(s.sent) != 4 {
+		t.Fatalf("expected offsets 3-6 to be published, got %d", result.Published)
+	}
+	if got := s.sent[0].ApplicationProperties["counter"]; got != int64(3) {
+		t.Errorf("expected application property counter=int64(3), got %T %v", got, got)
+	}
+	if s.publishingIDs[0] != -1 {
+		t.Errorf("expected no publishing ID without a producer name, got %d", s.publishingIDs[0])
+	}
+	if result.Confirmed != 4 {
+		t.Errorf("expected 4 confirmed messages, got %d", result.Confirmed)
+	}
+}
+
+func TestReplay_Deduplication(t *testing.T) {
+	// Offsets 0-2 were published by a previous run
+	s := &fakeSender{lastPublishingID: 3}
+	result, err := replay(context.Background(), strings.NewReader(exportLines(t, 0, 1, 2, 3, 4)), s, Options{ProducerName: "incident-42"})
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	if result.Skipped != 3 || result.Published != 2 {
+		t.Errorf("expected 3 skipped and 2 published, got %+v", result.Progress)
+	}
+	if len(s.publishingIDs) != 2 || s.publishingIDs[0] != 4 || s.publishingIDs[1] != 5 {
+		t.Errorf("expected publishing IDs [4 5], got %v", s.publishingIDs)
+	}
+}
+
+func TestReplay_InvalidLine(t *testing.T) {
+	input := exportLines(t, 0) + "not json\n"
+	if _, err := replay(context.Background(), strings.NewReader(input), &fakeSender{}, Options{}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error for line 2, got %v", err)
+	}
+}
+
+func TestPacer(t *testing.T) {
+	base := time.Date(2025, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		opts  Options
+		gaps  []time.Duration // between original timestamps
+		slept []time.Duration
+	}{
+		{
+			name:  "unpaced",
+			gaps:  []time.Duration{time.Second, time.Second},
+			slept: nil,
+		},
+		{
+			name:  "rate limit",
+			opts:  Options{Rate: 4},
+			gaps:  []time.Duration{0, 0},
+			slept: []time.Duration{250 * time.Millisecond, 250 * time.Millisecond},
+		},
+		{
+			name:  "original pace at double speed",
+			opts:  Options{OriginalPace: true, Speed: 2},
+			gaps:  []time.Duration{time.Second, 3 * time.Second},
+			slept: []time.Duration{500 * time.Millisecond, 1500 * time.Millisecond},
+		},
+		{
+			name:  "rate limit slower than original pace",
+			opts:  Options{OriginalPace: true, Rate: 1},
+			gaps:  []time.Duration{100 * time.Millisecond, 100 * time.Millisecond},
+			slept: []time.Duration{time.Second, time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPacer(tt.opts)
+			clock := base
+			var slept []time.Duration
+			p.now = func() time.Time { return clock }
+			p.sleep = func(ctx context.Context, d time.Duration) error {
+				slept = append(slept, d)
+				clock = clock.Add(d)
+				return nil
+			}
+
+			stamp := base
+			if err := p.wait(context.Background(), stamp, true); err != nil {
+				t.Fatal(err)
+			}
+			for _, gap := range tt.gaps {
+				stamp = stamp.Add(gap)
+				if err := p.wait(context.Background(), stamp, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if len(slept) != len(tt.slept) {
+				t.Fatalf("expected sleeps %v, got %v", tt.slept, slept)
+			}
+			for i := range slept {
+				if slept[i] != tt.slept[i] {
+					t.Errorf("sleep %d: expected %v, got %v", i, tt.slept[i], slept[i])
+				}
+			}
+		})
+	}
+}
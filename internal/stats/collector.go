@@ -0,0 +1,313 @@
+// Package stats samples stream statistics in the background and keeps a
+// rolling time series per stream, from which it derives publish, growth and
+// truncation rates.
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// Sample is one snapshot of a stream's stats
+type Sample struct {
+	Time        time.Time `json:"time"`
+	Messages    int64     `json:"messages"`
+	Bytes       int64     `json:"bytes"`
+	FirstOffset uint64    `json:"first_offset"`
+	LastOffset  uint64    `json:"last_offset"`
+}
+
+// StreamKey identifies a sampled stream
+type StreamKey struct {
+	Connection string `json:"connection_id"`
+	VHost      string `json:"vhost"`
+	Name       string `json:"name"`
+}
+
+func (k StreamKey) String() string {
+	return fmt.Sprintf("%s/%s/%s", k.Connection, k.VHost, k.Name)
+}
+
+// Source lists streams and reads their stats
+type Source interface {
+	ListStreams(ctx context.Context) ([]rabbitmq.Stream, error)
+	StreamStats(ctx context.Context, stream rabbitmq.Stream) (*rabbitmq.StreamStats, error)
+}
+
+// managerSource reads stats through the connections of a manager
+type managerSource struct {
+	manager *rabbitmq.Manager
+}
+
+// NewManagerSource returns a Source sampling every stream of the manager's connections
+func NewManagerSource(manager *rabbitmq.Manager) Source {
+	return managerSource{manager: manager}
+}
+
+func (s managerSource) ListStreams(ctx context.Context) ([]rabbitmq.Stream, error) {
+	return s.manager.ListStreams(ctx)
+}
+
+func (s managerSource) StreamStats(ctx context.Context, stream rabbitmq.Stream) (*rabbitmq.StreamStats, error) {
+	conn, err := s.manager.GetConnection(stream.ConnectionID)
+	if err != nil {
+		return nil, err
+	}
+	return conn.GetStreamStatsForVHost(ctx, stream.VHost, stream.Name)
+}
+
+// Collector samples the selected streams at an interval and keeps their
+// samples for the configured retention
+type Collector struct {
+	source Source
+	cfg    config.StatsConfig
+	now    func() time.Time
+
+	mu     sync.RWMutex
+	series map[StreamKey][]Sample
+}
+
+// persistedSeries is the file format of a stream's samples
+type persistedSeries struct {
+	StreamKey
+	Samples []Sample `json:"samples"`
+}
+
+// NewCollector creates a collector, loading the samples persisted in
+// cfg.File when it exists
+func NewCollector(source Source, cfg config.StatsConfig) (*Collector, error) {
+	c := &Collector{
+		source: source,
+		cfg:    cfg,
+		now:    time.Now,
+		series: make(map[StreamKey][]Sample),
+	}
+	if cfg.File == "" {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(cfg.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats file: %w", err)
+	}
+	var persisted []persistedSeries
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return nil, fmt.Errorf("failed to parse stats file %s: %w", cfg.File, err)
+	}
+	for _, s := range persisted {
+		c.series[s.StreamKey] = s.Samples
+	}
+	c.prune()
+	return c, nil
+}
+
+// Interval returns the time between samples
+func (c *Collector) Interval() time.Duration {
+	return c.cfg.Interval
+}
+
+// Selected reports whether a stream is sampled
+func (c *Collector) Selected(key StreamKey) bool {
+	if len(c.cfg.Streams) == 0 {
+		return true
+	}
+	for _, sel := range c.cfg.Streams {
+		if sel.Matches(key.Connection, key.VHost, key.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run samples until ctx is done, then saves the samples one last time
+func (c *Collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.Collect(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("stats: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			if err := c.Save(); err != nil {
+				log.Printf("stats: %v", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect takes one sample of every selected stream, drops samples past the
+// retention and saves the series. Streams whose stats can't be read are
+// skipped; the last error is returned after sampling the others.
+func (c *Collector) Collect(ctx context.Context) error {
+	streams, err := c.source.ListStreams(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list streams: %w", err)
+	}
+
+	var lastErr error
+	for _, stream := range streams {
+		key := StreamKey{Connection: stream.ConnectionID, VHost: stream.VHost, Name: stream.Name}
+		if !c.Selected(key) {
+			continue
+		}
+		stats, err := c.source.StreamStats(ctx, stream)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to sample %s: %w", key, err)
+			continue
+		}
+		c.add(key, Sample{
+			Time:        c.now(),
+			Messages:    stats.MessageCount,
+			Bytes:       stats.Size,
+			FirstOffset: stats.FirstOffset,
+			LastOffset:  stats.LastOffset,
+		})
+	}
+
+	c.prune()
+	if err := c.Save(); err != nil {
+		return err
+	}
+	return lastErr
+}
+
+func (c *Collector) add(key StreamKey, sample Sample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.series[key] = append(c.series[key], sample)
+}
+
+// prune drops samples older than the retention and series left empty
+func (c *Collector) prune() {
+	cutoff := c.now().Add(-c.cfg.Retention)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, samples := range c.series {
+		i := sort.Search(len(samples), func(i int) bool { return !samples[i].Time.Before(cutoff) })
+		if i == len(samples) {
+			delete(c.series, key)
+			continue
+		}
+		if i > 0 {
+			c.series[key] = append([]Sample(nil), samples[i:]...)
+		}
+	}
+}
+
+// Save atomically writes every series to the configured file; it does
+// nothing without one
+func (c *Collector) Save() error {
+	if c.cfg.File == "" {
+		return nil
+	}
+
+	c.mu.RLock()
+	persisted := make([]persistedSeries, 0, len(c.series))
+	for key, samples := range c.series {
+		persisted = append(persisted, persistedSeries{StreamKey: key, Samples: samples})
+	}
+	data, err := json.Marshal(persisted)
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.cfg.File), filepath.Base(c.cfg.File)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write stats file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write stats file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write stats file: %w", err)
+	}
+	return os.Rename(tmp.Name(), c.cfg.File)
+}
+
+// Samples returns a stream's samples taken within window of now
+func (c *Collector) Samples(key StreamKey, window time.Duration) []Sample {
+	cutoff := c.now().Add(-window)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	samples := c.series[key]
+	i := sort.Search(len(samples), func(i int) bool { return !samples[i].Time.Before(cutoff) })
+	return append([]Sample{}, samples[i:]...)
+}
+
+// Latest returns the newest sample of every stream
+func (c *Collector) Latest() map[StreamKey]Sample {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	latest := make(map[StreamKey]Sample, len(c.series))
+	for key, samples := range c.series {
+		latest[key] = samples[len(samples)-1]
+	}
+	return latest
+}
+
+// Rates describes how a stream changed over a window
+type Rates struct {
+	Window string `json:"window"`
+	// Samples is the number of samples in the window; rates need at least two
+	Samples int        `json:"samples"`
+	From    *time.Time `json:"from,omitempty"`
+	To      *time.Time `json:"to,omitempty"`
+	// MessagesPerSec is the rate the last offset advanced, i.e. messages published
+	MessagesPerSec float64 `json:"messages_per_sec"`
+	// BytesPerSec is the net change of the stream's size; it drops when segments are truncated
+	BytesPerSec float64 `json:"bytes_per_sec"`
+	// FirstOffsetMoved is how many offsets retention truncated from the start of the stream
+	FirstOffsetMoved uint64  `json:"first_offset_moved"`
+	TruncatedPerSec  float64 `json:"truncated_per_sec"`
+}
+
+// Rates compares the first and last samples of a stream within window
+func (c *Collector) Rates(key StreamKey, window time.Duration) Rates {
+	samples := c.Samples(key, window)
+	r := Rates{Window: window.String(), Samples: len(samples)}
+	if len(samples) == 0 {
+		return r
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+	r.From, r.To = &first.Time, &last.Time
+	seconds := last.Time.Sub(first.Time).Seconds()
+	if seconds <= 0 {
+		return r
+	}
+
+	// Offsets go backwards when a stream is deleted and declared again
+	if last.LastOffset >= first.LastOffset {
+		r.MessagesPerSec = float64(last.LastOffset-first.LastOffset) / seconds
+	}
+	if last.FirstOffset >= first.FirstOffset {
+		r.FirstOffsetMoved = last.FirstOffset - first.FirstOffset
+		r.TruncatedPerSec = float64(r.FirstOffsetMoved) / seconds
+	}
+	r.BytesPerSec = float64(last.Bytes-first.Bytes) / seconds
+	return r
+}
@@ -0,0 +1,143 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/config"
+	"github.com/zaeem.arshad/rmq-stream-viewer/internal/rabbitmq"
+)
+
+// fakeSource serves stats that tests update between samples
+type fakeSource struct {
+	stats map[string]*rabbitmq.StreamStats
+}
+
+func (f *fakeSource) ListStreams(context.Context) ([]rabbitmq.Stream, error) {
+	var streams []rabbitmq.Stream
+	for name := range f.stats {
+		streams = append(streams, rabbitmq.Stream{Name: name, ConnectionID: "dev", VHost: "/"})
+	}
+	return streams, nil
+}
+
+func (f *fakeSource) StreamStats(_ context.Context, stream rabbitmq.Stream) (*rabbitmq.StreamStats, error) {
+	stats, ok := f.stats[stream.Name]
+	if !ok || stats == nil {
+		return nil, errors.New("unavailable")
+	}
+	copied := *stats
+	return &copied, nil
+}
+
+// clock is a settable time source
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newTestCollector(t *testing.T, source Source, cfg config.StatsConfig) (*Collector, *clock) {
+	t.Helper()
+	if cfg.Retention == 0 {
+		cfg.Retention = time.Hour
+	}
+	c, err := NewCollector(source, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := &clock{t: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)}
+	c.now = clk.now
+	return c, clk
+}
+
+func TestCollector_Rates(t *testing.T) {
+	source := &fakeSource{stats: map[string]*rabbitmq.StreamStats{
+		"orders": {MessageCount: 1000, Size: 100_000, FirstOffset: 0, LastOffset: 999},
+	}}
+	c, clk := newTestCollector(t, source, config.StatsConfig{Interval: 10 * time.Second})
+	key := StreamKey{Connection: "dev", VHost: "/", Name: "orders"}
+
+	if err := c.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if r := c.Rates(key, time.Minute); r.Samples != 1 || r.MessagesPerSec != 0 {
+		t.Errorf("expected no rate from a single sample, got %+v", r)
+	}
+
+	// 60s later: 600 messages published, 200 truncated, size grew by 30kB net
+	clk.t = clk.t.Add(time.Minute)
+	source.stats["orders"] = &rabbitmq.StreamStats{MessageCount: 1400, Size: 130_000, FirstOffset: 200, LastOffset: 1599}
+	if err := c.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := c.Rates(key, 5*time.Minute)
+	if r.Samples != 2 || r.MessagesPerSec != 10 || r.BytesPerSec != 500 {
+		t.Errorf("unexpected rates: %+v", r)
+	}
+	if r.FirstOffsetMoved != 200 || r.TruncatedPerSec != 200.0/60 {
+		t.Errorf("unexpected truncation: %+v", r)
+	}
+	if r.Window != "5m0s" {
+		t.Errorf("unexpected window: %s", r.Window)
+	}
+
+	// The window only covers the last sample
+	if r := c.Rates(key, 30*time.Second); r.Samples != 1 {
+		t.Errorf("expected one sample in a 30s window, got %d", r.Samples)
+	}
+}
+
+func TestCollector_SelectionAndRetention(t *testing.T) {
+	source := &fakeSource{stats: map[string]*rabbitmq.StreamStats{
+		"orders-eu": {LastOffset: 1},
+		"orders-us": nil, // stats unavailable
+		"invoices":  {LastOffset: 1},
+	}}
+	c, clk := newTestCollector(t, source, config.StatsConfig{
+		Interval:  time.Second,
+		Retention: 10 * time.Minute,
+		Streams:   []config.StreamSelector{{Name: "orders-*"}},
+	})
+
+	if err := c.Collect(context.Background()); err == nil {
+		t.Error("expected the unavailable stream to be reported")
+	}
+	if len(c.Samples(StreamKey{"dev", "/", "orders-eu"}, time.Hour)) != 1 {
+		t.Error("expected orders-eu to be sampled")
+	}
+	if c.Selected(StreamKey{"dev", "/", "invoices"}) || len(c.Samples(StreamKey{"dev", "/", "invoices"}, time.Hour)) != 0 {
+		t.Error("expected invoices not to be sampled")
+	}
+
+	clk.t = clk.t.Add(11 * time.Minute)
+	c.prune()
+	if len(c.series) != 0 {
+		t.Errorf("expected samples past the retention to be dropped, got %v", c.series)
+	}
+}
+
+func TestCollector_Persistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "stats.json")
+	source := &fakeSource{stats: map[string]*rabbitmq.StreamStats{"orders": {LastOffset: 10}}}
+	cfg := config.StatsConfig{Interval: time.Second, Retention: 24 * time.Hour, File: file}
+
+	c, err := NewCollector(source, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewCollector(source, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := reloaded.Samples(StreamKey{"dev", "/", "orders"}, time.Hour)
+	if len(samples) != 1 || samples[0].LastOffset != 10 {
+		t.Errorf("expected the persisted sample, got %+v", samples)
+	}
+}
@@ -1,19 +1,42 @@
-import { useState } from 'react';
-import { Sun, Moon, Database, BarChart3, MessageSquare } from 'lucide-react';
+import { useState, useEffect } from 'react';
+import { Sun, Moon, Database, BarChart3, MessageSquare, LogOut, Loader2 } from 'lucide-react';
 import { ThemeProvider, useTheme } from './context/ThemeContext';
 import Sidebar from './components/Sidebar';
 import StreamDetails from './components/StreamDetails';
 import MessageBrowser from './components/MessageBrowser';
+import Login from './components/Login';
+import { api } from './services/api';
 
-function AppContent() {
+// parseMessageLink reads a shareable message link (see api.messageLink) from the page URL
+function parseMessageLink() {
+  const params = new URLSearchParams(window.location.search);
+  const offset = parseInt(params.get('offset'), 10);
+  if (!params.get('connection') || !params.get('stream') || isNaN(offset)) {
+    return null;
+  }
+  return {
+    stream: {
+      connection_id: params.get('connection'),
+      vhost: params.get('vhost') || '/',
+      name: params.get('stream'),
+    },
+    offset,
+  };
+}
+
+function AppContent({ user, onLogout }) {
   const { theme, toggleTheme } = useTheme();
-  const [selectedStream, setSelectedStream] = useState(null);
-  const [view, setView] = useState('details');
+  const [messageLink] = useState(parseMessageLink);
+  const [selectedStream, setSelectedStream] = useState(messageLink?.stream ?? null);
+  const [view, setView] = useState(messageLink ? 'messages' : 'details');
+  const [linkedOffset, setLinkedOffset] = useState(messageLink?.offset ?? null);
   const [sidebarCollapsed, setSidebarCollapsed] = useState(false);
+  const [sidebarRefresh, setSidebarRefresh] = useState(0);
 
   const handleStreamSelect = (stream) => {
     setSelectedStream(stream);
     setView('details');
+    setLinkedOffset(null);
   };
 
   return (
@@ -64,6 +87,21 @@ function AppContent() {
               </div>
             )}
 
+            {user && (
+              <div className="flex items-center gap-2 text-sm text-gray-600 dark:text-gray-400">
+                <span className="hidden md:inline">{user.name}</span>
+                {user.method !== 'basic' && user.method !== 'proxy' && (
+                  <button
+                    onClick={onLogout}
+                    className="p-2.5 rounded-lg bg-gray-100 dark:bg-gray-800 hover:bg-gray-200 dark:hover:bg-gray-700 transition-colors"
+                    title="Sign out"
+                  >
+                    <LogOut className="w-5 h-5" />
+                  </button>
+                )}
+              </div>
+            )}
+
             <button
               onClick={toggleTheme}
               className="p-2.5 rounded-lg bg-gray-100 dark:bg-gray-800 hover:bg-gray-200 dark:hover:bg-gray-700 transition-colors"
@@ -86,6 +124,7 @@ function AppContent() {
           selectedStream={selectedStream}
           isCollapsed={sidebarCollapsed}
           onToggleCollapse={() => setSidebarCollapsed(!sidebarCollapsed)}
+          refreshKey={sidebarRefresh}
         />
 
         <main className="flex-1 overflow-hidden">
@@ -125,9 +164,15 @@ function AppContent() {
               </div>
             </div>
           ) : view === 'details' ? (
-            <StreamDetails stream={selectedStream} />
+            <StreamDetails
+              stream={selectedStream}
+              onDeleted={() => {
+                setSelectedStream(null);
+                setSidebarRefresh((n) => n + 1);
+              }}
+            />
           ) : (
-            <MessageBrowser stream={selectedStream} />
+            <MessageBrowser stream={selectedStream} linkedOffset={linkedOffset} />
           )}
         </main>
       </div>
@@ -135,10 +180,42 @@ function AppContent() {
   );
 }
 
+// AuthGate shows the login page until the user is logged in, when the server requires logins
+function AuthGate() {
+  const [session, setSession] = useState(null);
+  const [error, setError] = useState(null);
+
+  useEffect(() => {
+    api.getSession().then(setSession).catch((err) => setError(err.message));
+  }, []);
+
+  const handleLogout = async () => {
+    await api.logout();
+    setSession({ ...session, user: null });
+  };
+
+  if (error) {
+    return (
+      <div className="h-screen flex items-center justify-center text-sm text-red-600 dark:text-red-400">{error}</div>
+    );
+  }
+  if (!session) {
+    return (
+      <div className="h-screen flex items-center justify-center">
+        <Loader2 className="w-6 h-6 animate-spin text-gray-400" />
+      </div>
+    );
+  }
+  if (session.enabled && !session.user) {
+    return <Login methods={session.methods || []} onLogin={setSession} />;
+  }
+  return <AppContent user={session.user} onLogout={handleLogout} />;
+}
+
 function App() {
   return (
     <ThemeProvider>
-      <AppContent />
+      <AuthGate />
     </ThemeProvider>
   );
 }
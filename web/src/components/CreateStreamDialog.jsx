@@ -0,0 +1,196 @@
+import { useState } from 'react';
+import { X, Plus, Loader2, AlertCircle } from 'lucide-react';
+import { api } from '../services/api';
+
+const LEADER_LOCATORS = ['', 'client-local', 'balanced', 'least-leaders', 'random'];
+
+export default function CreateStreamDialog({ connectionId, vhost, onCreated, onClose }) {
+  const [kind, setKind] = useState('stream');
+  const [form, setForm] = useState({
+    name: '',
+    max_length_bytes: '',
+    max_age: '',
+    max_segment_size_bytes: '',
+    initial_cluster_size: '',
+    leader_locator: '',
+    partitions: '3',
+    binding_keys: '',
+  });
+  const [saving, setSaving] = useState(false);
+  const [error, setError] = useState(null);
+
+  const update = (name) => (e) => setForm({ ...form, [name]: e.target.value });
+
+  // Empty fields are left out so the broker defaults apply
+  const buildSettings = () => {
+    const settings = { name: form.name.trim() };
+    for (const key of ['max_length_bytes', 'max_age', 'max_segment_size_bytes', 'leader_locator']) {
+      if (form[key].trim()) {
+        settings[key] = form[key].trim();
+      }
+    }
+    if (form.initial_cluster_size.trim()) {
+      settings.initial_cluster_size = parseInt(form.initial_cluster_size, 10);
+    }
+    if (kind === 'superstream') {
+      const keys = form.binding_keys.split(',').map((k) => k.trim()).filter(Boolean);
+      if (keys.length > 0) {
+        settings.binding_keys = keys;
+      } else {
+        settings.partitions = parseInt(form.partitions, 10);
+      }
+    }
+    return settings;
+  };
+
+  const handleCreate = async () => {
+    try {
+      setSaving(true);
+      setError(null);
+      const settings = buildSettings();
+      if (kind === 'superstream') {
+        await api.declareSuperStream(connectionId, vhost, settings);
+      } else {
+        await api.declareStream(connectionId, vhost, settings);
+      }
+      onCreated();
+    } catch (err) {
+      setError(err.message);
+    } finally {
+      setSaving(false);
+    }
+  };
+
+  const inputClass =
+    'w-full px-3 py-2 text-sm bg-white dark:bg-gray-800 border border-gray-300 dark:border-gray-700 rounded-lg text-gray-900 dark:text-gray-100 focus:outline-none focus:ring-2 focus:ring-blue-500';
+  const labelClass = 'block text-xs font-medium text-gray-600 dark:text-gray-400 mb-1';
+
+  return (
+    <div className="fixed inset-0 z-50 flex items-center justify-center bg-black/50 p-4">
+      <div className="bg-white dark:bg-gray-900 rounded-xl border border-gray-200 dark:border-gray-800 shadow-xl w-full max-w-lg">
+        <div className="flex items-center justify-between px-6 py-4 border-b border-gray-200 dark:border-gray-800">
+          <h3 className="text-lg font-semibold text-gray-900 dark:text-gray-100">
+            New Stream in <span className="font-mono">{vhost}</span>
+          </h3>
+          <button
+            onClick={onClose}
+            className="p-1 rounded-lg text-gray-500 hover:bg-gray-100 dark:hover:bg-gray-800"
+            title="Close"
+          >
+            <X className="w-5 h-5" />
+          </button>
+        </div>
+
+        <div className="p-6 space-y-4">
+          <div className="flex gap-2 bg-gray-100 dark:bg-gray-800 rounded-lg p-1">
+            {[
+              ['stream', 'Stream'],
+              ['superstream', 'Super Stream'],
+            ].map(([value, label]) => (
+              <button
+                key={value}
+                onClick={() => setKind(value)}
+                className={`flex-1 px-3 py-1.5 rounded-md text-sm font-medium transition-all ${
+                  kind === value
+                    ? 'bg-white dark:bg-gray-700 text-blue-600 dark:text-blue-400 shadow-sm'
+                    : 'text-gray-600 dark:text-gray-400'
+                }`}
+              >
+                {label}
+              </button>
+            ))}
+          </div>
+
+          <div>
+            <label className={labelClass}>Name</label>
+            <input className={inputClass} value={form.name} onChange={update('name')} autoFocus />
+          </div>
+
+          {kind === 'superstream' && (
+            <div className="grid grid-cols-2 gap-3">
+              <div>
+                <label className={labelClass}>Partitions</label>
+                <input
+                  className={inputClass}
+                  type="number"
+                  min="1"
+                  value={form.partitions}
+                  onChange={update('partitions')}
+                  disabled={form.binding_keys.trim() !== ''}
+                />
+              </div>
+              <div>
+                <label className={labelClass}>Binding Keys (comma separated)</label>
+                <input className={inputClass} value={form.binding_keys} onChange={update('binding_keys')} placeholder="eu, us, apac" />
+              </div>
+            </div>
+          )}
+
+          <div className="grid grid-cols-2 gap-3">
+            <div>
+              <label className={labelClass}>Max Length</label>
+              <input className={inputClass} value={form.max_length_bytes} onChange={update('max_length_bytes')} placeholder="20gb" />
+            </div>
+            <div>
+              <label className={labelClass}>Max Age</label>
+              <input className={inputClass} value={form.max_age} onChange={update('max_age')} placeholder="7D" />
+            </div>
+            <div>
+              <label className={labelClass}>Max Segment Size</label>
+              <input
+                className={inputClass}
+                value={form.max_segment_size_bytes}
+                onChange={update('max_segment_size_bytes')}
+                placeholder="500mb"
+              />
+            </div>
+            <div>
+              <label className={labelClass}>Initial Cluster Size</label>
+              <input
+                className={inputClass}
+                type="number"
+                min="1"
+                value={form.initial_cluster_size}
+                onChange={update('initial_cluster_size')}
+              />
+            </div>
+            <div className="col-span-2">
+              <label className={labelClass}>Leader Locator</label>
+              <select className={inputClass} value={form.leader_locator} onChange={update('leader_locator')}>
+                {LEADER_LOCATORS.map((locator) => (
+                  <option key={locator} value={locator}>
+                    {locator || 'Broker default'}
+                  </option>
+                ))}
+              </select>
+            </div>
+          </div>
+
+          {error && (
+            <div className="flex items-start gap-2 p-3 bg-red-50 dark:bg-red-900/20 border border-red-200 dark:border-red-800 rounded-lg text-sm text-red-700 dark:text-red-400">
+              <AlertCircle className="w-4 h-4 mt-0.5 flex-shrink-0" />
+              {error}
+            </div>
+          )}
+        </div>
+
+        <div className="flex items-center justify-end gap-2 px-6 py-4 border-t border-gray-200 dark:border-gray-800">
+          <button
+            onClick={onClose}
+            className="px-4 py-2 text-sm bg-gray-200 dark:bg-gray-700 hover:bg-gray-300 dark:hover:bg-gray-600 rounded-lg text-gray-700 dark:text-gray-300 font-medium transition-colors"
+          >
+            Cancel
+          </button>
+          <button
+            onClick={handleCreate}
+            disabled={saving || !form.name.trim()}
+            className="px-4 py-2 text-sm bg-blue-600 hover:bg-blue-700 disabled:opacity-50 rounded-lg text-white font-medium transition-colors inline-flex items-center gap-2"
+          >
+            {saving ? <Loader2 className="w-4 h-4 animate-spin" /> : <Plus className="w-4 h-4" />}
+            Create
+          </button>
+        </div>
+      </div>
+    </div>
+  );
+}
@@ -0,0 +1,99 @@
+import { useState } from 'react';
+import { Database, LogIn, Loader2, AlertCircle } from 'lucide-react';
+import { api } from '../services/api';
+
+export default function Login({ methods, onLogin }) {
+  const [username, setUsername] = useState('');
+  const [password, setPassword] = useState('');
+  const [submitting, setSubmitting] = useState(false);
+  const [error, setError] = useState(null);
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    try {
+      setSubmitting(true);
+      setError(null);
+      const session = await api.login(username, password);
+      onLogin(session);
+    } catch (err) {
+      setError(err.message);
+    } finally {
+      setSubmitting(false);
+    }
+  };
+
+  const inputClass =
+    'w-full px-3 py-2 text-sm bg-white dark:bg-gray-800 border border-gray-300 dark:border-gray-700 rounded-lg text-gray-900 dark:text-gray-100 focus:outline-none focus:ring-2 focus:ring-blue-500';
+  const labelClass = 'block text-xs font-medium text-gray-600 dark:text-gray-400 mb-1';
+
+  return (
+    <div className="h-screen flex items-center justify-center bg-gray-50 dark:bg-gray-950 p-4">
+      <div className="bg-white dark:bg-gray-900 rounded-xl border border-gray-200 dark:border-gray-800 shadow-sm w-full max-w-sm p-6">
+        <div className="flex items-center gap-3 mb-6">
+          <div className="w-10 h-10 bg-gradient-to-br from-blue-500 to-purple-600 rounded-xl flex items-center justify-center shadow-lg">
+            <Database className="w-6 h-6 text-white" />
+          </div>
+          <h1 className="text-lg font-bold text-gray-900 dark:text-gray-100">RabbitMQ Stream Viewer</h1>
+        </div>
+
+        {error && (
+          <div className="flex items-start gap-2 mb-4 text-sm text-red-600 dark:text-red-400">
+            <AlertCircle className="w-4 h-4 mt-0.5 flex-shrink-0" />
+            {error}
+          </div>
+        )}
+
+        {methods.includes('password') && (
+          <form onSubmit={handleSubmit} className="space-y-4">
+            <div>
+              <label className={labelClass}>Username</label>
+              <input
+                className={inputClass}
+                value={username}
+                onChange={(e) => setUsername(e.target.value)}
+                autoComplete="username"
+                autoFocus
+              />
+            </div>
+            <div>
+              <label className={labelClass}>Password</label>
+              <input
+                type="password"
+                className={inputClass}
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                autoComplete="current-password"
+              />
+            </div>
+            <button
+              type="submit"
+              disabled={submitting || !username}
+              className="w-full flex items-center justify-center gap-2 px-4 py-2 text-sm font-medium text-white bg-blue-600 hover:bg-blue-700 disabled:opacity-50 rounded-lg"
+            >
+              {submitting ? <Loader2 className="w-4 h-4 animate-spin" /> : <LogIn className="w-4 h-4" />}
+              Sign in
+            </button>
+          </form>
+        )}
+
+        {methods.includes('oidc') && (
+          <a
+            href={api.oidcLoginUrl()}
+            className={`w-full flex items-center justify-center gap-2 px-4 py-2 text-sm font-medium rounded-lg border border-gray-300 dark:border-gray-700 text-gray-700 dark:text-gray-200 hover:bg-gray-100 dark:hover:bg-gray-800 ${
+              methods.includes('password') ? 'mt-3' : ''
+            }`}
+          >
+            <LogIn className="w-4 h-4" />
+            Sign in with single sign-on
+          </a>
+        )}
+
+        {!methods.includes('password') && !methods.includes('oidc') && (
+          <p className="text-sm text-gray-600 dark:text-gray-400">
+            Sign in through your organization's proxy to use the viewer.
+          </p>
+        )}
+      </div>
+    </div>
+  );
+}
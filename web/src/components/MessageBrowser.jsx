@@ -15,35 +15,56 @@ import {
 import { api } from '../services/api';
 import MessageViewer from './MessageViewer';
 
-export default function MessageBrowser({ stream }) {
+export default function MessageBrowser({ stream, linkedOffset = null }) {
   const [messages, setMessages] = useState([]);
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState(null);
-  const [currentOffset, setCurrentOffset] = useState(0);
+  // page describes the requested page: a cursor from the previous response, or an offset and direction
+  const [page, setPage] = useState(null);
+  const [batch, setBatch] = useState(null);
   const [limit, setLimit] = useState(100);
   const [expandedMessageOffset, setExpandedMessageOffset] = useState(null);
   const [offsetInput, setOffsetInput] = useState('0');
   const [offsetType, setOffsetType] = useState('first');
   const [timestampInput, setTimestampInput] = useState('');
+  const [filterInput, setFilterInput] = useState('');
   const [stats, setStats] = useState(null);
+  const [linkedMessage, setLinkedMessage] = useState(null);
+  const [linkedError, setLinkedError] = useState(null);
 
   useEffect(() => {
     loadStreamStats();
   }, [stream]);
 
   useEffect(() => {
-    loadMessages();
-  }, [stream, currentOffset, limit]);
+    if (page) {
+      loadMessages();
+    }
+  }, [stream, page, limit]);
+
+  useEffect(() => {
+    setLinkedMessage(null);
+    setLinkedError(null);
+    if (linkedOffset === null) {
+      return;
+    }
+    api
+      .getMessage(stream.connection_id, stream.vhost, stream.name, linkedOffset)
+      .then(setLinkedMessage)
+      .catch((err) => setLinkedError(err.message));
+  }, [stream, linkedOffset]);
 
   const loadStreamStats = async () => {
     try {
       const data = await api.getStreamStats(stream.connection_id, stream.vhost, stream.name);
       setStats(data);
-      // Start from first offset by default
-      setCurrentOffset(data.first_offset);
-      setOffsetInput(String(data.first_offset));
+      // Start from the linked message if there is one, otherwise the first offset
+      const startOffset = linkedOffset ?? data.first_offset;
+      setPage({ offset: startOffset });
+      setOffsetInput(String(startOffset));
     } catch (err) {
       console.error('Failed to load stream stats:', err);
+      setPage({ offset: linkedOffset ?? 0 });
     }
   };
 
@@ -51,8 +72,12 @@ export default function MessageBrowser({ stream }) {
     try {
       setLoading(true);
       setError(null);
-      const data = await api.getMessages(stream.connection_id, stream.vhost, stream.name, currentOffset, limit);
+      const data = await api.getMessages(stream.connection_id, stream.vhost, stream.name, page, limit);
+      setBatch(data);
       setMessages(data.messages || []);
+      if (data.messages?.length > 0) {
+        setOffsetInput(String(data.start_offset));
+      }
     } catch (err) {
       setError(err.message);
     } finally {
@@ -61,54 +86,41 @@ export default function MessageBrowser({ stream }) {
   };
 
   const handleJumpToFirst = () => {
-    if (stats) {
-      setCurrentOffset(stats.first_offset);
-      setOffsetInput(String(stats.first_offset));
-    }
+    setPage({ offset: stats?.first_offset ?? 0 });
   };
 
   const handleJumpToLast = () => {
-    if (stats) {
-      const offset = Math.max(0, stats.last_offset - limit + 1);
-      setCurrentOffset(offset);
-      setOffsetInput(String(offset));
-    }
+    setPage({ direction: 'backward' });
   };
 
   const handlePrevious = () => {
-    const newOffset = Math.max(stats?.first_offset || 0, currentOffset - limit);
-    setCurrentOffset(newOffset);
-    setOffsetInput(String(newOffset));
+    if (batch?.prev_cursor) {
+      setPage({ cursor: batch.prev_cursor });
+    }
   };
 
   const handleNext = () => {
-    const newOffset = currentOffset + limit;
-    if (!stats || newOffset <= stats.last_offset) {
-      setCurrentOffset(newOffset);
-      setOffsetInput(String(newOffset));
+    if (batch?.next_cursor && !batch.at_end) {
+      setPage({ cursor: batch.next_cursor });
     }
   };
 
   const handleOffsetSubmit = (e) => {
     e.preventDefault();
     
-    if (offsetType === 'first' && stats) {
-      setCurrentOffset(stats.first_offset);
-      setOffsetInput(String(stats.first_offset));
-    } else if (offsetType === 'last' && stats) {
-      setCurrentOffset(Math.max(stats.first_offset, stats.last_offset - limit + 1));
-      setOffsetInput(String(stats.last_offset));
+    if (offsetType === 'first') {
+      handleJumpToFirst();
+    } else if (offsetType === 'last') {
+      handleJumpToLast();
     } else if (offsetType === 'offset') {
       const offset = parseInt(offsetInput);
       if (!isNaN(offset) && offset >= 0) {
-        setCurrentOffset(offset);
+        setPage({ offset });
       }
     } else if (offsetType === 'timestamp') {
       // For timestamp, we'd need backend support - for now, just use first offset
       // TODO: Implement timestamp-based offset lookup
-      if (stats) {
-        setCurrentOffset(stats.first_offset);
-      }
+      handleJumpToFirst();
     }
   };
 
@@ -123,7 +135,7 @@ export default function MessageBrowser({ stream }) {
   useEffect(() => {
     window.addEventListener('keydown', handleKeyDown);
     return () => window.removeEventListener('keydown', handleKeyDown);
-  }, [currentOffset, limit, stats]);
+  }, [batch]);
 
   return (
     <div className="flex-1 flex flex-col bg-gray-50 dark:bg-gray-950">
@@ -142,7 +154,7 @@ export default function MessageBrowser({ stream }) {
             </button>
             <button
               onClick={handlePrevious}
-              disabled={loading || !stats || currentOffset <= (stats?.first_offset || 0)}
+              disabled={loading || !batch?.prev_cursor}
               className="px-3 py-2 bg-gray-100 dark:bg-gray-800 hover:bg-gray-200 dark:hover:bg-gray-700 disabled:opacity-50 disabled:cursor-not-allowed rounded-lg text-gray-700 dark:text-gray-300 text-sm font-medium transition-colors inline-flex items-center gap-2"
               title="Previous page"
             >
@@ -151,7 +163,7 @@ export default function MessageBrowser({ stream }) {
             </button>
             <button
               onClick={handleNext}
-              disabled={loading || (stats && currentOffset >= stats.last_offset)}
+              disabled={loading || !batch || batch.at_end}
               className="px-3 py-2 bg-gray-100 dark:bg-gray-800 hover:bg-gray-200 dark:hover:bg-gray-700 disabled:opacity-50 disabled:cursor-not-allowed rounded-lg text-gray-700 dark:text-gray-300 text-sm font-medium transition-colors inline-flex items-center gap-2"
               title="Next page"
             >
@@ -160,7 +172,7 @@ export default function MessageBrowser({ stream }) {
             </button>
             <button
               onClick={handleJumpToLast}
-              disabled={loading || !stats}
+              disabled={loading}
               className="px-3 py-2 bg-gray-100 dark:bg-gray-800 hover:bg-gray-200 dark:hover:bg-gray-700 disabled:opacity-50 disabled:cursor-not-allowed rounded-lg text-gray-700 dark:text-gray-300 text-sm font-medium transition-colors inline-flex items-center gap-2"
               title="Jump to last messages"
             >
@@ -237,6 +249,35 @@ export default function MessageBrowser({ stream }) {
           >
             <RefreshCw className={`w-5 h-5 text-gray-700 dark:text-gray-300 ${loading ? 'animate-spin' : ''}`} />
           </button>
+
+          <input
+            type="text"
+            value={filterInput}
+            onChange={(e) => setFilterInput(e.target.value)}
+            className="w-56 px-3 py-2 bg-white dark:bg-gray-800 border border-gray-300 dark:border-gray-700 rounded-lg text-gray-900 dark:text-gray-100 text-sm focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+            placeholder="Export filter, e.g. body.status=failed"
+            title="Only export messages matching every filter; separate several with ;"
+          />
+
+          <select
+            value=""
+            onChange={(e) => {
+              if (e.target.value) {
+                window.location.href = api.exportUrl(stream, e.target.value, {
+                  offset: batch?.start_offset,
+                  filter: filterInput.split(';').map((f) => f.trim()).filter(Boolean),
+                });
+              }
+            }}
+            disabled={!stats}
+            className="px-3 py-2 bg-white dark:bg-gray-800 border border-gray-300 dark:border-gray-700 rounded-lg text-gray-900 dark:text-gray-100 text-sm focus:ring-2 focus:ring-blue-500 focus:border-transparent disabled:opacity-50"
+            title="Export the messages matching the filter from the current page to the end of the stream"
+          >
+            <option value="">Export…</option>
+            <option value="ndjson">NDJSON</option>
+            <option value="csv">CSV</option>
+            <option value="parquet">Parquet</option>
+          </select>
         </div>
 
         {stats && (
@@ -250,10 +291,32 @@ export default function MessageBrowser({ stream }) {
       {/* Messages List */}
       <div className="flex-1 overflow-y-auto bg-gray-50 dark:bg-gray-950">
         <div className="max-w-7xl mx-auto p-4">
-          <div className="mb-4">
+          {linkedOffset !== null && (
+            <div className="mb-6">
+              <h3 className="mb-2 text-sm font-semibold text-gray-900 dark:text-gray-100">
+                Linked message at offset {linkedOffset.toLocaleString()}
+              </h3>
+              {linkedError ? (
+                <p className="text-sm text-red-700 dark:text-red-300">{linkedError}</p>
+              ) : linkedMessage ? (
+                <div className="bg-white dark:bg-gray-900 rounded-lg border-2 border-blue-500 overflow-hidden shadow-sm">
+                  <MessageViewer message={linkedMessage} stream={stream} compact={true} />
+                </div>
+              ) : (
+                <Loader2 className="w-5 h-5 text-blue-500 animate-spin" />
+              )}
+            </div>
+          )}
+          <div className="mb-4 flex items-center gap-3">
             <h3 className="text-sm font-semibold text-gray-900 dark:text-gray-100">
               Messages {messages.length > 0 && `(${messages.length})`}
             </h3>
+            {batch?.at_start && (
+              <span className="text-xs text-gray-500 dark:text-gray-400">Start of stream</span>
+            )}
+            {batch?.at_end && (
+              <span className="text-xs text-gray-500 dark:text-gray-400">End of stream</span>
+            )}
           </div>
           <div className="space-y-2">
             {loading ? (
@@ -315,7 +378,9 @@ export default function MessageBrowser({ stream }) {
                             <div>
                               <span className="text-xs font-medium text-gray-500 dark:text-gray-400">Timestamp:</span>
                               <p className="text-sm text-gray-900 dark:text-gray-100">
-                                {new Date(msg.timestamp).toLocaleString()}
+                                {msg.stored_at || msg.creation_time
+                                  ? new Date(msg.stored_at || msg.creation_time).toLocaleString()
+                                  : '-'}
                               </p>
                             </div>
                             <div>
@@ -337,7 +402,7 @@ export default function MessageBrowser({ stream }) {
                     {/* Expanded Details */}
                     {isExpanded && (
                       <div className="border-t border-gray-200 dark:border-gray-800">
-                        <MessageViewer message={msg} compact={true} />
+                        <MessageViewer message={msg} stream={stream} compact={true} />
                       </div>
                     )}
                   </div>
@@ -1,9 +1,13 @@
 import { useState } from 'react';
-import { Copy, Check, FileJson, Tag, Clock, HardDrive } from 'lucide-react';
+import { Copy, Check, FileJson, Tag, Clock, HardDrive, Link, Download, Pencil, EyeOff } from 'lucide-react';
+import { api } from '../services/api';
+import RepublishDialog from './RepublishDialog';
 
-export default function MessageViewer({ message, compact = false }) {
+export default function MessageViewer({ message, stream, compact = false }) {
   const [copiedProps, setCopiedProps] = useState(false);
   const [copiedContent, setCopiedContent] = useState(false);
+  const [copiedLink, setCopiedLink] = useState(false);
+  const [republishing, setRepublishing] = useState(false);
 
   const copyToClipboard = async (text, type = 'properties') => {
     try {
@@ -11,6 +15,9 @@ export default function MessageViewer({ message, compact = false }) {
       if (type === 'properties') {
         setCopiedProps(true);
         setTimeout(() => setCopiedProps(false), 2000);
+      } else if (type === 'link') {
+        setCopiedLink(true);
+        setTimeout(() => setCopiedLink(false), 2000);
       } else {
         setCopiedContent(true);
         setTimeout(() => setCopiedContent(false), 2000);
@@ -125,6 +132,15 @@ export default function MessageViewer({ message, compact = false }) {
                 {message.offset.toLocaleString()}
               </span>
             </div>
+            {message.redacted && (
+              <div
+                className="flex items-center gap-2 text-amber-600 dark:text-amber-400"
+                title="Parts of this message were masked by the server's redaction rules"
+              >
+                <EyeOff className="w-4 h-4" />
+                <span className="font-medium">Redacted</span>
+              </div>
+            )}
             {message.properties?.routing_key && (
               <div className="flex items-center gap-2 text-gray-600 dark:text-gray-400">
                 <Tag className="w-4 h-4" />
@@ -202,6 +218,35 @@ export default function MessageViewer({ message, compact = false }) {
                 </span>
               )}
             </div>
+            <div className="flex items-center gap-2">
+            {stream && (
+              <>
+                <button
+                  onClick={() => copyToClipboard(api.messageLink(stream, message.offset), 'link')}
+                  className="px-3 py-1.5 text-xs bg-gray-200 dark:bg-gray-700 hover:bg-gray-300 dark:hover:bg-gray-600 rounded-lg text-gray-700 dark:text-gray-300 font-medium transition-colors inline-flex items-center gap-2"
+                  title="Copy a shareable link to this message"
+                >
+                  {copiedLink ? <Check className="w-3 h-3" /> : <Link className="w-3 h-3" />}
+                  {copiedLink ? 'Copied!' : 'Link'}
+                </button>
+                <button
+                  onClick={() => api.downloadMessage(stream, message.offset)}
+                  className="px-3 py-1.5 text-xs bg-gray-200 dark:bg-gray-700 hover:bg-gray-300 dark:hover:bg-gray-600 rounded-lg text-gray-700 dark:text-gray-300 font-medium transition-colors inline-flex items-center gap-2"
+                  title="Download the raw message body"
+                >
+                  <Download className="w-3 h-3" />
+                  Raw
+                </button>
+                <button
+                  onClick={() => setRepublishing(true)}
+                  className="px-3 py-1.5 text-xs bg-gray-200 dark:bg-gray-700 hover:bg-gray-300 dark:hover:bg-gray-600 rounded-lg text-gray-700 dark:text-gray-300 font-medium transition-colors inline-flex items-center gap-2"
+                  title="Edit a copy of this message and publish it"
+                >
+                  <Pencil className="w-3 h-3" />
+                  Edit &amp; Republish
+                </button>
+              </>
+            )}
             <button
               onClick={() => copyToClipboard(formattedData, 'content')}
               className="px-3 py-1.5 text-xs bg-gray-200 dark:bg-gray-700 hover:bg-gray-300 dark:hover:bg-gray-600 rounded-lg text-gray-700 dark:text-gray-300 font-medium transition-colors inline-flex items-center gap-2"
@@ -218,6 +263,7 @@ export default function MessageViewer({ message, compact = false }) {
                 </>
               )}
             </button>
+            </div>
           </div>
           <div className="p-6 bg-gray-50 dark:bg-gray-950">
             <pre className="text-sm text-gray-700 dark:text-gray-300 font-mono overflow-x-auto">
@@ -237,9 +283,31 @@ export default function MessageViewer({ message, compact = false }) {
                 <Clock className="w-4 h-4 text-blue-600 dark:text-blue-400" />
               </div>
               <div>
-                <p className="text-xs text-gray-500 dark:text-gray-400">Timestamp</p>
+                <p className="text-xs text-gray-500 dark:text-gray-400">Stored At</p>
                 <p className="text-sm font-medium text-gray-900 dark:text-gray-100">
-                  {new Date(message.timestamp).toLocaleString()}
+                  {message.stored_at ? new Date(message.stored_at).toLocaleString() : '-'}
+                </p>
+              </div>
+            </div>
+            <div className="flex items-center gap-3">
+              <div className="p-2 bg-blue-100 dark:bg-blue-900/30 rounded-lg">
+                <Clock className="w-4 h-4 text-blue-600 dark:text-blue-400" />
+              </div>
+              <div>
+                <p className="text-xs text-gray-500 dark:text-gray-400">Creation Time</p>
+                <p className="text-sm font-medium text-gray-900 dark:text-gray-100">
+                  {message.creation_time ? new Date(message.creation_time).toLocaleString() : '-'}
+                </p>
+              </div>
+            </div>
+            <div className="flex items-center gap-3">
+              <div className="p-2 bg-blue-100 dark:bg-blue-900/30 rounded-lg">
+                <Clock className="w-4 h-4 text-blue-600 dark:text-blue-400" />
+              </div>
+              <div>
+                <p className="text-xs text-gray-500 dark:text-gray-400">Publish Latency</p>
+                <p className="text-sm font-medium text-gray-900 dark:text-gray-100">
+                  {message.publish_latency_ms != null ? `${message.publish_latency_ms} ms` : '-'}
                 </p>
               </div>
             </div>
@@ -257,6 +325,15 @@ export default function MessageViewer({ message, compact = false }) {
           </div>
         </div>
       </div>
+
+      {republishing && (
+        <RepublishDialog
+          message={message}
+          stream={stream}
+          body={formattedData}
+          onClose={() => setRepublishing(false)}
+        />
+      )}
     </div>
   );
 }
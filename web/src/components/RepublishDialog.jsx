@@ -0,0 +1,183 @@
+import { useState } from 'react';
+import { X, Send, Loader2, AlertCircle, CheckCircle } from 'lucide-react';
+import { api } from '../services/api';
+
+// AMQP properties that can be edited; header fields shown in the viewer are kept as they are
+const EDITABLE_PROPERTIES = [
+  'message_id',
+  'correlation_id',
+  'to',
+  'subject',
+  'reply_to',
+  'content_type',
+  'content_encoding',
+  'group_id',
+  'group_sequence',
+  'reply_to_group_id',
+  'creation_time',
+  'absolute_expiry_time',
+];
+
+const toJSON = (value) => JSON.stringify(value || {}, null, 2);
+
+export default function RepublishDialog({ message, stream, body, onClose }) {
+  const editableProperties = Object.fromEntries(
+    EDITABLE_PROPERTIES.filter((key) => message.properties?.[key] != null).map((key) => [
+      key,
+      message.properties[key],
+    ])
+  );
+  const initial = {
+    body,
+    properties: toJSON(editableProperties),
+    application_properties: toJSON(message.properties?.application_properties),
+    message_annotations: toJSON(message.properties?.message_annotations),
+  };
+
+  const [target, setTarget] = useState({
+    connection_id: stream.connection_id,
+    vhost: stream.vhost,
+    stream: stream.name,
+  });
+  const [fields, setFields] = useState(initial);
+  const [publishing, setPublishing] = useState(false);
+  const [error, setError] = useState(null);
+  const [result, setResult] = useState(null);
+
+  const updateField = (name) => (e) => setFields({ ...fields, [name]: e.target.value });
+  const updateTarget = (name) => (e) => setTarget({ ...target, [name]: e.target.value });
+
+  // Only sections that were edited are sent, the others are copied from the source
+  const buildEdits = () => {
+    const edits = {};
+    if (fields.body !== initial.body) {
+      edits.body = fields.body;
+    }
+    for (const section of ['properties', 'application_properties', 'message_annotations']) {
+      if (fields[section] !== initial[section]) {
+        try {
+          edits[section] = JSON.parse(fields[section] || '{}');
+        } catch (err) {
+          throw new Error(`${section.replace(/_/g, ' ')}: ${err.message}`);
+        }
+      }
+    }
+    return edits;
+  };
+
+  const handleRepublish = async () => {
+    try {
+      setPublishing(true);
+      setError(null);
+      setResult(null);
+      const data = await api.republishMessage(stream, message.offset, {
+        target,
+        message: buildEdits(),
+      });
+      setResult(data);
+    } catch (err) {
+      setError(err.message);
+    } finally {
+      setPublishing(false);
+    }
+  };
+
+  const inputClass =
+    'w-full px-3 py-2 text-sm bg-white dark:bg-gray-800 border border-gray-300 dark:border-gray-700 rounded-lg text-gray-900 dark:text-gray-100 focus:outline-none focus:ring-2 focus:ring-blue-500';
+  const labelClass = 'block text-xs font-medium text-gray-600 dark:text-gray-400 mb-1';
+
+  return (
+    <div className="fixed inset-0 z-50 flex items-center justify-center bg-black/50 p-4">
+      <div className="bg-white dark:bg-gray-900 rounded-xl border border-gray-200 dark:border-gray-800 shadow-xl w-full max-w-3xl max-h-full flex flex-col">
+        <div className="flex items-center justify-between px-6 py-4 border-b border-gray-200 dark:border-gray-800">
+          <h3 className="text-lg font-semibold text-gray-900 dark:text-gray-100">
+            Edit &amp; Republish Offset {message.offset.toLocaleString()}
+          </h3>
+          <button
+            onClick={onClose}
+            className="p-1 rounded-lg text-gray-500 hover:bg-gray-100 dark:hover:bg-gray-800"
+            title="Close"
+          >
+            <X className="w-5 h-5" />
+          </button>
+        </div>
+
+        <div className="flex-1 overflow-y-auto p-6 space-y-4">
+          <div className="grid grid-cols-3 gap-3">
+            <div>
+              <label className={labelClass}>Target Connection</label>
+              <input className={inputClass} value={target.connection_id} onChange={updateTarget('connection_id')} />
+            </div>
+            <div>
+              <label className={labelClass}>Target VHost</label>
+              <input className={inputClass} value={target.vhost} onChange={updateTarget('vhost')} />
+            </div>
+            <div>
+              <label className={labelClass}>Target Stream</label>
+              <input className={inputClass} value={target.stream} onChange={updateTarget('stream')} />
+            </div>
+          </div>
+
+          <div>
+            <label className={labelClass}>Body</label>
+            <textarea className={`${inputClass} font-mono`} rows={10} value={fields.body} onChange={updateField('body')} />
+          </div>
+          <div>
+            <label className={labelClass}>AMQP Properties (JSON)</label>
+            <textarea className={`${inputClass} font-mono`} rows={5} value={fields.properties} onChange={updateField('properties')} />
+          </div>
+          <div>
+            <label className={labelClass}>Application Properties (JSON)</label>
+            <textarea
+              className={`${inputClass} font-mono`}
+              rows={5}
+              value={fields.application_properties}
+              onChange={updateField('application_properties')}
+            />
+          </div>
+          <div>
+            <label className={labelClass}>Message Annotations (JSON)</label>
+            <textarea
+              className={`${inputClass} font-mono`}
+              rows={4}
+              value={fields.message_annotations}
+              onChange={updateField('message_annotations')}
+            />
+          </div>
+
+          {error && (
+            <div className="flex items-start gap-2 p-3 bg-red-50 dark:bg-red-900/20 border border-red-200 dark:border-red-800 rounded-lg text-sm text-red-700 dark:text-red-400">
+              <AlertCircle className="w-4 h-4 mt-0.5 flex-shrink-0" />
+              {error}
+            </div>
+          )}
+          {result && (
+            <div className="flex items-start gap-2 p-3 bg-green-50 dark:bg-green-900/20 border border-green-200 dark:border-green-800 rounded-lg text-sm text-green-700 dark:text-green-400">
+              <CheckCircle className="w-4 h-4 mt-0.5 flex-shrink-0" />
+              {result.offset != null
+                ? `Republished to ${result.target} at offset ${result.offset.toLocaleString()}`
+                : `Republished to ${result.target}; its offset is unknown (${result.offset_error})`}
+            </div>
+          )}
+        </div>
+
+        <div className="flex items-center justify-end gap-2 px-6 py-4 border-t border-gray-200 dark:border-gray-800">
+          <button
+            onClick={onClose}
+            className="px-4 py-2 text-sm bg-gray-200 dark:bg-gray-700 hover:bg-gray-300 dark:hover:bg-gray-600 rounded-lg text-gray-700 dark:text-gray-300 font-medium transition-colors"
+          >
+            Close
+          </button>
+          <button
+            onClick={handleRepublish}
+            disabled={publishing}
+            className="px-4 py-2 text-sm bg-blue-600 hover:bg-blue-700 disabled:opacity-50 rounded-lg text-white font-medium transition-colors inline-flex items-center gap-2"
+          >
+            {publishing ? <Loader2 className="w-4 h-4 animate-spin" /> : <Send className="w-4 h-4" />}
+            Republish
+          </button>
+        </div>
+      </div>
+    </div>
+  );
+}
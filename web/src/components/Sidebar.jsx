@@ -1,30 +1,32 @@
 import { useState, useEffect } from 'react';
-import { RefreshCw, ChevronRight, ChevronLeft, Database, Folder, AlertCircle, Loader2 } from 'lucide-react';
+import { RefreshCw, ChevronRight, ChevronLeft, Database, Folder, AlertCircle, Loader2, Plus } from 'lucide-react';
+import { api } from '../services/api';
+import CreateStreamDialog from './CreateStreamDialog';
 
-export default function Sidebar({ onStreamSelect, selectedStream, isCollapsed, onToggleCollapse }) {
+export default function Sidebar({ onStreamSelect, selectedStream, isCollapsed, onToggleCollapse, refreshKey }) {
   const [vhosts, setVHosts] = useState([]);
+  const [writable, setWritable] = useState({});
+  const [creatingIn, setCreatingIn] = useState(null);
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState(null);
   const [expandedVHosts, setExpandedVHosts] = useState({});
 
   useEffect(() => {
     loadData();
-  }, []);
+  }, [refreshKey]);
 
   const loadData = async () => {
     try {
       setLoading(true);
       setError(null);
 
-      const vhostsResponse = await fetch('/api/vhosts');
-
-      if (!vhostsResponse.ok) {
-        throw new Error('Failed to fetch vhosts');
-      }
-
-      const vhosts = await vhostsResponse.json();
+      const vhosts = await api.getVHosts();
       setVHosts(vhosts);
 
+      // Streams can only be created on connections configured with read_only: false
+      const connections = await api.getConnections();
+      setWritable(Object.fromEntries(connections.map((c) => [c.id, c.read_only === false])));
+
       // Auto-expand vhosts that have streams
       const expanded = {};
       vhosts.forEach(vhost => {
@@ -127,25 +129,36 @@ export default function Sidebar({ onStreamSelect, selectedStream, isCollapsed, o
 
               return (
                 <div key={vhostKey} className="mb-1">
-                  <button
-                    onClick={() => toggleVHost(vhostKey)}
-                    className="w-full px-4 py-3 flex items-center justify-between text-left hover:bg-gray-50 dark:hover:bg-gray-800 transition-colors group"
-                  >
-                    <div className="flex items-center gap-3 flex-1 min-w-0">
-                      <ChevronRight
-                        className={`w-4 h-4 text-gray-400 transition-transform flex-shrink-0 ${
-                          isExpanded ? 'rotate-90' : ''
-                        }`}
-                      />
-                      <Database className="w-4 h-4 text-gray-500 dark:text-gray-400 flex-shrink-0" />
-                      <span className="font-medium text-gray-900 dark:text-gray-100 truncate">
-                        {vhost.name}
+                  <div className="flex items-center hover:bg-gray-50 dark:hover:bg-gray-800 transition-colors">
+                    <button
+                      onClick={() => toggleVHost(vhostKey)}
+                      className="flex-1 min-w-0 px-4 py-3 flex items-center justify-between text-left group"
+                    >
+                      <div className="flex items-center gap-3 flex-1 min-w-0">
+                        <ChevronRight
+                          className={`w-4 h-4 text-gray-400 transition-transform flex-shrink-0 ${
+                            isExpanded ? 'rotate-90' : ''
+                          }`}
+                        />
+                        <Database className="w-4 h-4 text-gray-500 dark:text-gray-400 flex-shrink-0" />
+                        <span className="font-medium text-gray-900 dark:text-gray-100 truncate">
+                          {vhost.name}
+                        </span>
+                      </div>
+                      <span className="text-xs font-medium text-gray-500 dark:text-gray-400 bg-gray-100 dark:bg-gray-800 px-2 py-1 rounded-full ml-2">
+                        {streamCount}
                       </span>
-                    </div>
-                    <span className="text-xs font-medium text-gray-500 dark:text-gray-400 bg-gray-100 dark:bg-gray-800 px-2 py-1 rounded-full ml-2">
-                      {streamCount}
-                    </span>
-                  </button>
+                    </button>
+                    {writable[vhost.connection_id] && (
+                      <button
+                        onClick={() => setCreatingIn(vhost)}
+                        className="p-1.5 mr-2 text-gray-500 dark:text-gray-400 hover:text-blue-600 dark:hover:text-blue-400 rounded-lg transition-colors"
+                        title="New stream"
+                      >
+                        <Plus className="w-4 h-4" />
+                      </button>
+                    )}
+                  </div>
 
                   {isExpanded && (
                     <div className="ml-4 border-l-2 border-gray-200 dark:border-gray-800">
@@ -200,6 +213,18 @@ export default function Sidebar({ onStreamSelect, selectedStream, isCollapsed, o
           </div>
         )}
       </div>
+
+      {creatingIn && (
+        <CreateStreamDialog
+          connectionId={creatingIn.connection_id}
+          vhost={creatingIn.name}
+          onCreated={() => {
+            setCreatingIn(null);
+            loadData();
+          }}
+          onClose={() => setCreatingIn(null)}
+        />
+      )}
     </div>
   );
 }
@@ -0,0 +1,151 @@
+import { useState, useEffect } from 'react';
+import { Send, Inbox, AlertCircle } from 'lucide-react';
+import { api } from '../services/api';
+
+// connectionLabel prefers the name the client gave its connection
+const connectionLabel = (connection) => connection.client_name || connection.name;
+
+export default function StreamClients({ stream, autoRefresh }) {
+  const [publishers, setPublishers] = useState(null);
+  const [consumers, setConsumers] = useState(null);
+  const [error, setError] = useState(null);
+
+  useEffect(() => {
+    setPublishers(null);
+    setConsumers(null);
+    load();
+
+    if (autoRefresh) {
+      const interval = setInterval(load, 5000);
+      return () => clearInterval(interval);
+    }
+  }, [stream, autoRefresh]);
+
+  const load = async () => {
+    try {
+      const [p, c] = await Promise.all([api.getStreamPublishers(stream), api.getStreamConsumers(stream)]);
+      setPublishers(p);
+      setConsumers(c);
+      setError(null);
+    } catch (err) {
+      setError(err.message);
+    }
+  };
+
+  const formatNumber = (num) => num.toLocaleString();
+
+  const cardClass = 'mt-6 bg-white dark:bg-gray-900 rounded-xl p-6 border border-gray-200 dark:border-gray-800 shadow-sm';
+  const thClass = 'text-left font-medium text-gray-500 dark:text-gray-400 pb-2 pr-4';
+  const tdClass = 'py-2 pr-4 text-gray-900 dark:text-gray-100';
+
+  if (error) {
+    return (
+      <div className={cardClass}>
+        <div className="flex items-start gap-2 text-sm text-gray-600 dark:text-gray-400">
+          <AlertCircle className="w-4 h-4 mt-0.5 flex-shrink-0" />
+          Publishers and consumers unavailable: {error}
+        </div>
+      </div>
+    );
+  }
+
+  if (!publishers || !consumers) {
+    return null;
+  }
+
+  return (
+    <>
+      <div className={cardClass}>
+        <div className="flex items-center gap-2 mb-4">
+          <Send className="w-5 h-5 text-gray-500 dark:text-gray-400" />
+          <h3 className="text-sm font-semibold text-gray-900 dark:text-gray-100 uppercase tracking-wide">
+            Publishers ({publishers.length})
+          </h3>
+        </div>
+        {publishers.length === 0 ? (
+          <p className="text-sm text-amber-700 dark:text-amber-400">No publisher is connected to this stream.</p>
+        ) : (
+          <div className="overflow-x-auto">
+            <table className="w-full text-sm">
+              <thead>
+                <tr>
+                  <th className={thClass}>Connection</th>
+                  <th className={thClass}>User</th>
+                  <th className={thClass}>ID</th>
+                  <th className={thClass}>Reference</th>
+                  <th className={thClass}>Published</th>
+                  <th className={thClass}>Confirmed</th>
+                  <th className={thClass}>Errored</th>
+                </tr>
+              </thead>
+              <tbody className="divide-y divide-gray-100 dark:divide-gray-800">
+                {publishers.map((p) => (
+                  <tr key={`${p.connection.name}-${p.id}`}>
+                    <td className={`${tdClass} font-mono`} title={p.connection.name}>
+                      {connectionLabel(p.connection)}
+                    </td>
+                    <td className={tdClass}>{p.connection.user || '-'}</td>
+                    <td className={`${tdClass} font-mono`}>{p.id}</td>
+                    <td className={`${tdClass} font-mono`}>{p.reference || '-'}</td>
+                    <td className={tdClass}>{formatNumber(p.published)}</td>
+                    <td className={tdClass}>{formatNumber(p.confirmed)}</td>
+                    <td className={`${tdClass} ${p.errored > 0 ? 'text-red-600 dark:text-red-400' : ''}`}>
+                      {formatNumber(p.errored)}
+                    </td>
+                  </tr>
+                ))}
+              </tbody>
+            </table>
+          </div>
+        )}
+      </div>
+
+      <div className={cardClass}>
+        <div className="flex items-center gap-2 mb-4">
+          <Inbox className="w-5 h-5 text-gray-500 dark:text-gray-400" />
+          <h3 className="text-sm font-semibold text-gray-900 dark:text-gray-100 uppercase tracking-wide">
+            Consumers ({consumers.length})
+          </h3>
+        </div>
+        {consumers.length === 0 ? (
+          <p className="text-sm text-gray-500 dark:text-gray-400">No consumer is reading from this stream.</p>
+        ) : (
+          <div className="overflow-x-auto">
+            <table className="w-full text-sm">
+              <thead>
+                <tr>
+                  <th className={thClass}>Connection</th>
+                  <th className={thClass}>User</th>
+                  <th className={thClass}>Subscription</th>
+                  <th className={thClass}>Offset</th>
+                  <th className={thClass}>Lag</th>
+                  <th className={thClass}>Credit</th>
+                  <th className={thClass}>Consumed</th>
+                  <th className={thClass}>Status</th>
+                </tr>
+              </thead>
+              <tbody className="divide-y divide-gray-100 dark:divide-gray-800">
+                {consumers.map((c) => (
+                  <tr key={`${c.connection.name}-${c.subscription_id}`}>
+                    <td className={`${tdClass} font-mono`} title={c.connection.name}>
+                      {connectionLabel(c.connection)}
+                    </td>
+                    <td className={tdClass}>{c.connection.user || '-'}</td>
+                    <td className={`${tdClass} font-mono`}>
+                      {c.properties?.name ? `${c.subscription_id} (${c.properties.name})` : c.subscription_id}
+                    </td>
+                    <td className={`${tdClass} font-mono`}>{formatNumber(c.offset)}</td>
+                    <td className={tdClass}>{formatNumber(c.offset_lag)}</td>
+                    <td className={tdClass}>{formatNumber(c.credits)}</td>
+                    <td className={tdClass}>{formatNumber(c.consumed)}</td>
+                    <td className={tdClass}>{c.activity_status || (c.active ? 'active' : 'inactive')}</td>
+                  </tr>
+                ))}
+              </tbody>
+            </table>
+          </div>
+        )}
+      </div>
+    </>
+  );
+}
@@ -1,12 +1,46 @@
 import { useState, useEffect } from 'react';
-import { RefreshCw, Mail, HardDrive, ArrowUp, ArrowDown, ToggleLeft, AlertCircle, Loader2 } from 'lucide-react';
+import { RefreshCw, Mail, HardDrive, ArrowUp, ArrowDown, ToggleLeft, AlertCircle, Loader2, Settings, Trash2, Server, Activity } from 'lucide-react';
 import { api } from '../services/api';
+import StreamClients from './StreamClients';
 
-export default function StreamDetails({ stream }) {
+export default function StreamDetails({ stream, onDeleted }) {
   const [stats, setStats] = useState(null);
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState(null);
   const [autoRefresh, setAutoRefresh] = useState(true);
+  const [settings, setSettings] = useState(null);
+  const [description, setDescription] = useState(null);
+  const [rates, setRates] = useState(null);
+  const [writable, setWritable] = useState(false);
+  const [confirmName, setConfirmName] = useState('');
+  const [deleting, setDeleting] = useState(false);
+  const [deleteError, setDeleteError] = useState(null);
+
+  useEffect(() => {
+    setSettings(null);
+    setDescription(null);
+    setRates(null);
+    setConfirmName('');
+    setDeleteError(null);
+    api.getStreamSettings(stream).then(setSettings).catch(() => setSettings(null));
+    api
+      .getConnections()
+      .then((connections) => setWritable(connections.some((c) => c.id === stream.connection_id && c.read_only === false)))
+      .catch(() => setWritable(false));
+  }, [stream]);
+
+  const handleDelete = async () => {
+    try {
+      setDeleting(true);
+      setDeleteError(null);
+      await api.deleteStream(stream, confirmName);
+      onDeleted?.();
+    } catch (err) {
+      setDeleteError(err.message);
+    } finally {
+      setDeleting(false);
+    }
+  };
 
   useEffect(() => {
     loadStats();
@@ -23,6 +57,9 @@ export default function StreamDetails({ stream }) {
       setError(null);
       const data = await api.getStreamStats(stream.connection_id, stream.vhost, stream.name);
       setStats(data);
+      // Replicas and rates change, so the description refreshes with the stats
+      api.describeStream(stream).then(setDescription).catch(() => setDescription(null));
+      api.getStreamRates(stream).then(setRates).catch(() => setRates(null));
     } catch (err) {
       setError(err.message);
     } finally {
@@ -179,6 +216,155 @@ export default function StreamDetails({ stream }) {
             </div>
           </div>
         )}
+
+        {rates && (
+          <div className="mt-6 bg-white dark:bg-gray-900 rounded-xl p-6 border border-gray-200 dark:border-gray-800 shadow-sm">
+            <div className="flex items-center gap-2 mb-4">
+              <Activity className="w-5 h-5 text-gray-500 dark:text-gray-400" />
+              <h3 className="text-sm font-semibold text-gray-900 dark:text-gray-100 uppercase tracking-wide">
+                Rates
+              </h3>
+              <span className="text-xs text-gray-500 dark:text-gray-400">sampled every {rates.interval}</span>
+            </div>
+            <table className="w-full text-sm">
+              <thead>
+                <tr className="text-left text-gray-500 dark:text-gray-400">
+                  <th className="font-medium pb-2">Window</th>
+                  <th className="font-medium pb-2">Messages/s</th>
+                  <th className="font-medium pb-2">Bytes/s</th>
+                  <th className="font-medium pb-2">Truncated</th>
+                </tr>
+              </thead>
+              <tbody className="text-gray-900 dark:text-gray-100">
+                {rates.windows.map((w) => (
+                  <tr key={w.window}>
+                    <td className="py-1 font-mono">{w.window}</td>
+                    {w.samples < 2 ? (
+                      <td className="py-1 text-gray-500 dark:text-gray-400" colSpan={3}>
+                        collecting samples...
+                      </td>
+                    ) : (
+                      <>
+                        <td className="py-1 font-mono">{w.messages_per_sec.toFixed(1)}</td>
+                        <td className="py-1 font-mono">
+                          {w.bytes_per_sec < 0 ? '-' : ''}
+                          {formatBytes(Math.abs(Math.round(w.bytes_per_sec)))}/s
+                        </td>
+                        <td className="py-1 font-mono">{formatNumber(w.first_offset_moved)} offsets</td>
+                      </>
+                    )}
+                  </tr>
+                ))}
+              </tbody>
+            </table>
+          </div>
+        )}
+
+        {settings && (
+          <div className="mt-6 bg-white dark:bg-gray-900 rounded-xl p-6 border border-gray-200 dark:border-gray-800 shadow-sm">
+            <div className="flex items-center gap-2 mb-4">
+              <Settings className="w-5 h-5 text-gray-500 dark:text-gray-400" />
+              <h3 className="text-sm font-semibold text-gray-900 dark:text-gray-100 uppercase tracking-wide">
+                Settings
+              </h3>
+            </div>
+            <dl className="grid grid-cols-2 lg:grid-cols-5 gap-4 text-sm">
+              {[
+                ['Max Length', settings.max_length_bytes ? formatBytes(settings.max_length_bytes) : null],
+                ['Max Age', settings.max_age],
+                ['Max Segment Size', settings.max_segment_size_bytes ? formatBytes(settings.max_segment_size_bytes) : null],
+                ['Initial Cluster Size', settings.initial_cluster_size],
+                ['Leader Locator', settings.leader_locator],
+              ].map(([label, value]) => (
+                <div key={label}>
+                  <dt className="text-gray-500 dark:text-gray-400">{label}</dt>
+                  <dd className="font-mono text-gray-900 dark:text-gray-100">{value || 'default'}</dd>
+                </div>
+              ))}
+            </dl>
+          </div>
+        )}
+
+        {description && (
+          <div className="mt-6 bg-white dark:bg-gray-900 rounded-xl p-6 border border-gray-200 dark:border-gray-800 shadow-sm">
+            <div className="flex items-center gap-2 mb-4">
+              <Server className="w-5 h-5 text-gray-500 dark:text-gray-400" />
+              <h3 className="text-sm font-semibold text-gray-900 dark:text-gray-100 uppercase tracking-wide">
+                Cluster
+              </h3>
+            </div>
+            <dl className="grid grid-cols-2 lg:grid-cols-5 gap-4 text-sm">
+              {[
+                ['State', description.state],
+                ['Leader', description.leader],
+                ['Online Replicas', description.online.length ? description.online.join(', ') : null],
+                ['Offline Replicas', description.offline.length ? description.offline.join(', ') : 'none'],
+                ['Consumers', formatNumber(description.consumers)],
+                ['Memory', formatBytes(description.memory)],
+                ['Message Bytes', description.message_bytes != null ? formatBytes(description.message_bytes) : null],
+                ['Segments', description.segments != null ? formatNumber(description.segments) : null],
+                ['Publish Rate', `${description.publish_rate.toFixed(1)}/s`],
+                ['Deliver Rate', `${description.deliver_rate.toFixed(1)}/s`],
+                ['Policy', description.policy],
+                ['Operator Policy', description.operator_policy],
+              ].map(([label, value]) => (
+                <div key={label}>
+                  <dt className="text-gray-500 dark:text-gray-400">{label}</dt>
+                  <dd
+                    className={`font-mono break-all ${
+                      label === 'Offline Replicas' && description.offline.length
+                        ? 'text-red-600 dark:text-red-400'
+                        : 'text-gray-900 dark:text-gray-100'
+                    }`}
+                  >
+                    {value || '-'}
+                  </dd>
+                </div>
+              ))}
+            </dl>
+            {Object.keys(description.effective_policy_definition).length > 0 && (
+              <div className="mt-4 text-sm">
+                <p className="text-gray-500 dark:text-gray-400 mb-1">Effective Policy Definition</p>
+                <pre className="font-mono text-xs bg-gray-50 dark:bg-gray-800 rounded-lg p-3 text-gray-900 dark:text-gray-100 overflow-x-auto">
+                  {JSON.stringify(description.effective_policy_definition, null, 2)}
+                </pre>
+              </div>
+            )}
+          </div>
+        )}
+
+        <StreamClients stream={stream} autoRefresh={autoRefresh} />
+
+        {writable && (
+          <div className="mt-6 bg-white dark:bg-gray-900 rounded-xl p-6 border border-red-200 dark:border-red-900 shadow-sm">
+            <div className="flex items-center gap-2 mb-2">
+              <Trash2 className="w-5 h-5 text-red-600 dark:text-red-400" />
+              <h3 className="text-sm font-semibold text-red-700 dark:text-red-400 uppercase tracking-wide">
+                Delete Stream
+              </h3>
+            </div>
+            <p className="text-sm text-gray-600 dark:text-gray-400 mb-3">
+              Deleting removes every message in the stream. Type <span className="font-mono">{stream.name}</span> to confirm.
+            </p>
+            <div className="flex items-center gap-2">
+              <input
+                value={confirmName}
+                onChange={(e) => setConfirmName(e.target.value)}
+                className="flex-1 px-3 py-2 text-sm bg-white dark:bg-gray-800 border border-gray-300 dark:border-gray-700 rounded-lg text-gray-900 dark:text-gray-100 font-mono"
+                placeholder={stream.name}
+              />
+              <button
+                onClick={handleDelete}
+                disabled={deleting || confirmName !== stream.name}
+                className="px-4 py-2 text-sm bg-red-600 hover:bg-red-700 disabled:opacity-50 rounded-lg text-white font-medium transition-colors inline-flex items-center gap-2"
+              >
+                {deleting ? <Loader2 className="w-4 h-4 animate-spin" /> : <Trash2 className="w-4 h-4" />}
+                Delete
+              </button>
+            </div>
+            {deleteError && <p className="mt-2 text-sm text-red-700 dark:text-red-400">{deleteError}</p>}
+          </div>
+        )}
       </div>
     </div>
   );
@@ -1,6 +1,55 @@
-const API_BASE = '/api';
+// BASE_PATH is the path the viewer is served under, e.g. /rmq; the server
+// injects it into index.html so that one build works under any path
+const BASE_PATH = document.querySelector('meta[name="rmq-viewer-base-path"]')?.content ?? '';
+const API_BASE = `${BASE_PATH}/api`;
+
+// adminRequest sends a stream administration request and surfaces the server's error details
+async function adminRequest(method, path, body) {
+  const response = await fetch(`${API_BASE}${path}`, {
+    method,
+    headers: body ? { 'Content-Type': 'application/json' } : undefined,
+    body: body ? JSON.stringify(body) : undefined,
+  });
+  const result = await response.json();
+  if (!response.ok) {
+    throw new Error(result.details || result.error || `Request failed with ${response.status}`);
+  }
+  return result;
+}
 
 export const api = {
+  // getSession reports whether login is required, how to log in and who is logged in
+  async getSession() {
+    const response = await fetch(`${BASE_PATH}/auth/session`);
+    if (!response.ok) {
+      throw new Error('Failed to fetch session');
+    }
+    return response.json();
+  },
+
+  async login(username, password) {
+    const response = await fetch(`${BASE_PATH}/auth/login`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ username, password }),
+    });
+    const result = await response.json();
+    if (!response.ok) {
+      throw new Error(result.details || result.error || 'Login failed');
+    }
+    return result;
+  },
+
+  async logout() {
+    await fetch(`${BASE_PATH}/auth/logout`, { method: 'POST' });
+  },
+
+  // oidcLoginUrl starts an OIDC login that returns to the current page
+  oidcLoginUrl() {
+    const params = new URLSearchParams({ redirect: window.location.pathname + window.location.search });
+    return `${BASE_PATH}/auth/oidc/login?${params}`;
+  },
+
   async getConnections() {
     const response = await fetch(`${API_BASE}/connections`);
     if (!response.ok) {
@@ -25,6 +74,78 @@ export const api = {
     return response.json();
   },
 
+  async getStreamSettings(stream) {
+    const response = await fetch(
+      `${API_BASE}/streams/${encodeURIComponent(stream.connection_id)}/${encodeURIComponent(stream.vhost)}/${encodeURIComponent(stream.name)}/settings`
+    );
+    if (!response.ok) {
+      throw new Error('Failed to fetch stream settings');
+    }
+    return response.json();
+  },
+
+  async describeStream(stream) {
+    const response = await fetch(
+      `${API_BASE}/streams/${encodeURIComponent(stream.connection_id)}/${encodeURIComponent(stream.vhost)}/${encodeURIComponent(stream.name)}/describe`
+    );
+    if (!response.ok) {
+      throw new Error('Failed to describe stream');
+    }
+    return response.json();
+  },
+
+  // Returns null when the stats collector is disabled or doesn't sample the stream
+  async getStreamRates(stream) {
+    const response = await fetch(
+      `${API_BASE}/streams/${encodeURIComponent(stream.connection_id)}/${encodeURIComponent(stream.vhost)}/${encodeURIComponent(stream.name)}/rates`
+    );
+    if (response.status === 503 || response.status === 404) {
+      return null;
+    }
+    if (!response.ok) {
+      throw new Error('Failed to fetch stream rates');
+    }
+    return response.json();
+  },
+
+  async getStreamClients(stream, kind) {
+    const response = await fetch(
+      `${API_BASE}/streams/${encodeURIComponent(stream.connection_id)}/${encodeURIComponent(stream.vhost)}/${encodeURIComponent(stream.name)}/${kind}`
+    );
+    if (!response.ok) {
+      const error = await response.json().catch(() => ({}));
+      throw new Error(error.details || `Failed to list ${kind}`);
+    }
+    return response.json();
+  },
+
+  async getStreamPublishers(stream) {
+    return this.getStreamClients(stream, 'publishers');
+  },
+
+  async getStreamConsumers(stream) {
+    return this.getStreamClients(stream, 'consumers');
+  },
+
+  // settings is { name, max_length_bytes, max_age, max_segment_size_bytes, initial_cluster_size, leader_locator }
+  async declareStream(connectionId, vhost, settings) {
+    return adminRequest('POST', `/streams/${encodeURIComponent(connectionId)}/${encodeURIComponent(vhost)}`, settings);
+  },
+
+  // settings adds partitions or binding_keys to the stream settings
+  async declareSuperStream(connectionId, vhost, settings) {
+    return adminRequest('POST', `/superstreams/${encodeURIComponent(connectionId)}/${encodeURIComponent(vhost)}`, settings);
+  },
+
+  // confirm must repeat the stream name
+  async deleteStream(stream, confirm) {
+    const params = new URLSearchParams({ confirm });
+    return adminRequest(
+      'DELETE',
+      `/streams/${encodeURIComponent(stream.connection_id)}/${encodeURIComponent(stream.vhost)}/${encodeURIComponent(stream.name)}?${params}`
+    );
+  },
+
   async getStreamStats(connectionId, vhost, streamName) {
     const response = await fetch(
       `${API_BASE}/streams/${encodeURIComponent(connectionId)}/${encodeURIComponent(vhost)}/${encodeURIComponent(streamName)}/stats`
@@ -35,14 +156,111 @@ export const api = {
     return response.json();
   },
 
-  async getMessages(connectionId, vhost, streamName, offset = 0, limit = 100) {
+  // page is either { cursor } from a previous response or { offset, direction }
+  async getMessages(connectionId, vhost, streamName, page = {}, limit = 100) {
+    const params = new URLSearchParams({ limit: String(limit) });
+    if (page.cursor) {
+      params.set('cursor', page.cursor);
+    } else {
+      if (page.offset !== undefined) {
+        params.set('offset', String(page.offset));
+      }
+      if (page.direction) {
+        params.set('direction', page.direction);
+      }
+    }
     const response = await fetch(
-      `${API_BASE}/streams/${encodeURIComponent(connectionId)}/${encodeURIComponent(vhost)}/${encodeURIComponent(streamName)}/messages?offset=${offset}&limit=${limit}`
+      `${API_BASE}/streams/${encodeURIComponent(connectionId)}/${encodeURIComponent(vhost)}/${encodeURIComponent(streamName)}/messages?${params}`
     );
     if (!response.ok) {
       throw new Error('Failed to fetch messages');
     }
     return response.json();
   },
-};
 
+  async getMessage(connectionId, vhost, streamName, offset) {
+    const response = await fetch(
+      `${API_BASE}/streams/${encodeURIComponent(connectionId)}/${encodeURIComponent(vhost)}/${encodeURIComponent(streamName)}/messages/${offset}`
+    );
+    if (!response.ok) {
+      throw new Error('Failed to fetch message');
+    }
+    return response.json();
+  },
+
+  // messages is an array of { body, content_type, properties, application_properties, ... }
+  async publishMessages(stream, messages) {
+    const response = await fetch(
+      `${API_BASE}/streams/${encodeURIComponent(stream.connection_id)}/${encodeURIComponent(stream.vhost)}/${encodeURIComponent(stream.name)}/messages`,
+      {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(messages),
+      }
+    );
+    const result = await response.json();
+    if (!response.ok) {
+      throw new Error(result.details || result.error || 'Failed to publish messages');
+    }
+    return result;
+  },
+
+  // request is { target: { connection_id, vhost, stream }, message: { body, properties, ... } };
+  // sections left out of message keep the source message's values
+  async republishMessage(stream, offset, request) {
+    const response = await fetch(
+      `${API_BASE}/streams/${encodeURIComponent(stream.connection_id)}/${encodeURIComponent(stream.vhost)}/${encodeURIComponent(stream.name)}/messages/${offset}/republish`,
+      {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(request),
+      }
+    );
+    const result = await response.json();
+    if (!response.ok) {
+      throw new Error(result.details || result.error || 'Failed to republish message');
+    }
+    return result;
+  },
+
+  async downloadMessage(stream, offset) {
+    const response = await fetch(
+      `${API_BASE}/streams/${encodeURIComponent(stream.connection_id)}/${encodeURIComponent(stream.vhost)}/${encodeURIComponent(stream.name)}/messages/${offset}`,
+      { headers: { Accept: 'application/octet-stream' } }
+    );
+    if (!response.ok) {
+      throw new Error('Failed to download message');
+    }
+    const url = URL.createObjectURL(await response.blob());
+    const link = document.createElement('a');
+    link.href = url;
+    link.download = `${stream.name}-${offset}.bin`;
+    link.click();
+    URL.revokeObjectURL(url);
+  },
+
+  // exportUrl returns the download URL for an export of the current range.
+  // Array options, such as filters, repeat the parameter.
+  exportUrl(stream, format, options = {}) {
+    const params = new URLSearchParams({ format });
+    Object.entries(options).forEach(([key, value]) => {
+      if (Array.isArray(value)) {
+        value.forEach((item) => params.append(key, String(item)));
+      } else if (value !== undefined && value !== null && value !== '') {
+        params.set(key, String(value));
+      }
+    });
+    return `${API_BASE}/streams/${encodeURIComponent(stream.connection_id)}/${encodeURIComponent(stream.vhost)}/${encodeURIComponent(stream.name)}/export?${params}`;
+  },
+
+  // messageLink builds a shareable URL that opens the viewer on a single message
+  messageLink(stream, offset) {
+    const params = new URLSearchParams({
+      connection: stream.connection_id,
+      vhost: stream.vhost,
+      stream: stream.name,
+      offset: String(offset),
+    });
+    return `${window.location.origin}${window.location.pathname}?${params}`;
+  },
+};
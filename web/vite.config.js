@@ -4,6 +4,8 @@ import react from '@vitejs/plugin-react'
 // https://vite.dev/config/
 export default defineConfig({
   plugins: [react()],
+  // Relative asset URLs, so the build works under any server.base_path
+  base: './',
   server: {
     proxy: {
       '/api': {